@@ -0,0 +1,159 @@
+package rosedb
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rosedblabs/wal"
+)
+
+// accessTracker records the last access time and the access count of keys,
+// it is enabled by Options.TrackAccess.
+type accessTracker struct {
+	mu    sync.RWMutex
+	stats map[string]*accessStat
+}
+
+type accessStat struct {
+	lastAccess int64 // unix nano
+	hits       uint64
+}
+
+func newAccessTracker() *accessTracker {
+	return &accessTracker{stats: make(map[string]*accessStat)}
+}
+
+// get returns the stat of the key, creates it if not exists.
+func (t *accessTracker) get(key []byte) *accessStat {
+	t.mu.RLock()
+	stat, ok := t.stats[string(key)]
+	t.mu.RUnlock()
+	if ok {
+		return stat
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if stat, ok = t.stats[string(key)]; !ok {
+		stat = &accessStat{}
+		t.stats[string(key)] = stat
+	}
+	return stat
+}
+
+// hit is called when the key is read.
+func (t *accessTracker) hit(key []byte, now int64) {
+	stat := t.get(key)
+	atomic.StoreInt64(&stat.lastAccess, now)
+	atomic.AddUint64(&stat.hits, 1)
+}
+
+// written is called when the key is written, it only updates the last access time.
+func (t *accessTracker) written(key []byte, now int64) {
+	atomic.StoreInt64(&t.get(key).lastAccess, now)
+}
+
+func (t *accessTracker) remove(key []byte) {
+	t.mu.Lock()
+	delete(t.stats, string(key))
+	t.mu.Unlock()
+}
+
+// load returns the last access time and the access count of the key,
+// they are zero if the key is not accessed since the database is opened.
+func (t *accessTracker) load(key []byte) (int64, uint64) {
+	t.mu.RLock()
+	stat, ok := t.stats[string(key)]
+	t.mu.RUnlock()
+	if !ok {
+		return 0, 0
+	}
+	return atomic.LoadInt64(&stat.lastAccess), atomic.LoadUint64(&stat.hits)
+}
+
+// AccessStats returns the last access time and the access count(hits) of the key.
+// Reading a key increases the hits and updates the last access time,
+// writing a key only updates the last access time.
+//
+// The stats are only kept in memory, the last access time is zero
+// and the hits is 0 if the key is not accessed since the database is opened.
+// It returns ErrTrackAccessDisabled if Options.TrackAccess is false.
+func (db *DB) AccessStats(key []byte) (time.Time, uint64, error) {
+	if db.accessTracker == nil {
+		return time.Time{}, 0, ErrTrackAccessDisabled
+	}
+	exist, err := db.Exist(key)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if !exist {
+		return time.Time{}, 0, ErrKeyNotFound
+	}
+
+	lastAccess, hits := db.accessTracker.load(key)
+	if lastAccess == 0 {
+		return time.Time{}, hits, nil
+	}
+	return time.Unix(0, lastAccess), hits, nil
+}
+
+// LeastRecentlyUsed returns at most n keys with the earliest last access time,
+// which can be used to find the cold keys.
+// It returns ErrTrackAccessDisabled if Options.TrackAccess is false.
+func (db *DB) LeastRecentlyUsed(n int) ([][]byte, error) {
+	return db.coldKeys(n, func(a, b *accessStat) bool {
+		return a.lastAccess < b.lastAccess
+	})
+}
+
+// LeastFrequentlyUsed returns at most n keys with the least access count,
+// which can be used to find the cold keys.
+// It returns ErrTrackAccessDisabled if Options.TrackAccess is false.
+func (db *DB) LeastFrequentlyUsed(n int) ([][]byte, error) {
+	return db.coldKeys(n, func(a, b *accessStat) bool {
+		if a.hits != b.hits {
+			return a.hits < b.hits
+		}
+		return a.lastAccess < b.lastAccess
+	})
+}
+
+func (db *DB) coldKeys(n int, less func(a, b *accessStat) bool) ([][]byte, error) {
+	if db.accessTracker == nil {
+		return nil, ErrTrackAccessDisabled
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	type keyStat struct {
+		key  []byte
+		stat *accessStat
+	}
+	keyStats := make([]keyStat, 0, db.index.Size())
+	db.index.Ascend(func(key []byte, _ *wal.ChunkPosition) (bool, error) {
+		if isStructKey(key) {
+			return true, nil
+		}
+		lastAccess, hits := db.accessTracker.load(key)
+		keyStats = append(keyStats, keyStat{key: key, stat: &accessStat{lastAccess: lastAccess, hits: hits}})
+		return true, nil
+	})
+	sort.SliceStable(keyStats, func(i, j int) bool {
+		return less(keyStats[i].stat, keyStats[j].stat)
+	})
+
+	if n > len(keyStats) {
+		n = len(keyStats)
+	}
+	keys := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		keys[i] = keyStats[i].key
+	}
+	return keys, nil
+}
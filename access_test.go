@@ -0,0 +1,86 @@
+package rosedb
+
+import (
+	"testing"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_AccessStats(t *testing.T) {
+	options := DefaultOptions
+	options.TrackAccess = true
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 10; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err = db.Get(utils.GetTestKey(5))
+		assert.Nil(t, err)
+	}
+	lastAccess, hits, err := db.AccessStats(utils.GetTestKey(5))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), hits)
+	assert.False(t, lastAccess.IsZero())
+
+	lastAccess, hits, err = db.AccessStats(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), hits)
+	assert.False(t, lastAccess.IsZero())
+
+	_, _, err = db.AccessStats(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// the deleted key has no stats
+	err = db.Delete(utils.GetTestKey(5))
+	assert.Nil(t, err)
+	_, _, err = db.AccessStats(utils.GetTestKey(5))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_AccessStats_ColdKeys(t *testing.T) {
+	options := DefaultOptions
+	options.TrackAccess = true
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 5; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 5; i++ {
+		for j := 0; j <= i; j++ {
+			_, err = db.Get(utils.GetTestKey(4 - i))
+			assert.Nil(t, err)
+		}
+	}
+
+	// key 4 is read first and least
+	keys, err := db.LeastRecentlyUsed(2)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{utils.GetTestKey(4), utils.GetTestKey(3)}, keys)
+	keys, err = db.LeastFrequentlyUsed(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(keys))
+	assert.Equal(t, utils.GetTestKey(4), keys[0])
+	assert.Equal(t, utils.GetTestKey(0), keys[4])
+}
+
+func TestDB_AccessStats_Disabled(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	err = db.Put(utils.GetTestKey(1), utils.RandomValue(10))
+	assert.Nil(t, err)
+	_, _, err = db.AccessStats(utils.GetTestKey(1))
+	assert.Equal(t, ErrTrackAccessDisabled, err)
+	_, err = db.LeastRecentlyUsed(1)
+	assert.Equal(t, ErrTrackAccessDisabled, err)
+}
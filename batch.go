@@ -1,14 +1,25 @@
 package rosedb
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/bwmarrin/snowflake"
 	"github.com/rosedblabs/wal"
 )
 
+// maxBatchIdSize is the max size of the batch id in decimal, which is the key of the batch finished record.
+const maxBatchIdSize = 19
+
+// chunkHeaderSize is the size of the header of each chunk written to the wal, it is only used to estimate
+// the size of the batch, since the wal does not export it.
+const chunkHeaderSize = 7
+
 // Batch is a batch operations of the database.
 // If readonly is true, you can only get data from the batch by Get method.
 // An error will be returned if you try to use Put or Delete method.
@@ -18,6 +29,7 @@ import (
 //
 // Batch is not a transaction, it does not guarantee isolation.
 // But it can guarantee atomicity, consistency and durability(if the Sync options is true).
+// The concurrent batches detect the conflicts with other writers instead, see BatchOptions.Concurrent.
 //
 // You must call Commit method to commit the batch, otherwise the DB will be locked.
 type Batch struct {
@@ -27,7 +39,23 @@ type Batch struct {
 	mu            sync.RWMutex
 	committed     bool // whether the batch has been committed
 	rollbacked    bool // whether the batch has been rollbacked
-	batchId       *snowflake.Node
+	failed        bool // whether the batch failed to commit
+	locked        bool // whether the batch holds the lock of the database
+	keepTTL       bool // whether the expiry times written keep the base ttls of the keys, see slidingKeys
+
+	// the conflict detection of the concurrent batch, see BatchOptions.Concurrent
+	startSeq uint64              // the write sequence of the database when the batch is created
+	tracking bool                // whether the batch is registered as a running concurrent batch
+	readsMu  sync.Mutex          // guards reads, the reads hold mu in different modes
+	reads    map[string]struct{} // the keys read from the committed data
+}
+
+// KV is a key/value pair written by a committed batch, see BatchOptions.OnCommit.
+type KV struct {
+	Key   []byte
+	Value []byte
+	// Deleted indicates whether the key is deleted, the Value is nil if true.
+	Deleted bool
 }
 
 // NewBatch creates a new Batch instance.
@@ -40,24 +68,14 @@ func (db *DB) NewBatch(options BatchOptions) *Batch {
 	}
 	if !options.ReadOnly {
 		batch.pendingWrites = make(map[string]*LogRecord)
-		node, err := snowflake.NewNode(1)
-		if err != nil {
-			panic(fmt.Sprintf("snowflake.NewNode(1) failed: %v", err))
-		}
-		batch.batchId = node
 	}
 	batch.lock()
 	return batch
 }
 
 func makeBatch() interface{} {
-	node, err := snowflake.NewNode(1)
-	if err != nil {
-		panic(fmt.Sprintf("snowflake.NewNode(1) failed: %v", err))
-	}
 	return &Batch{
 		options: DefaultBatchOptions,
-		batchId: node,
 	}
 }
 
@@ -69,27 +87,72 @@ func (b *Batch) init(rdonly, sync bool, db *DB) *Batch {
 	return b
 }
 
+// withPendingWrites prepares the pendingWrites of the pooled batch for writing,
+// the map kept by reset is reused, so the single writes do not allocate it each time.
 func (b *Batch) withPendingWrites() *Batch {
-	b.pendingWrites = make(map[string]*LogRecord)
+	if b.pendingWrites == nil {
+		b.pendingWrites = make(map[string]*LogRecord)
+	}
 	return b
 }
 
 func (b *Batch) reset() {
 	b.db = nil
-	b.pendingWrites = nil
+	// clear the map instead of dropping it, it will be reused by withPendingWrites.
+	for key := range b.pendingWrites {
+		delete(b.pendingWrites, key)
+	}
 	b.committed = false
 	b.rollbacked = false
+	b.failed = false
+	b.locked = false
+	b.keepTTL = false
+	b.startSeq = 0
+	b.tracking = false
+	b.reads = nil
+}
+
+// readCommitted reports whether the batch only holds the read lock during each read operation.
+func (b *Batch) readCommitted() bool {
+	return (b.options.ReadOnly && b.options.ReadCommitted) || b.concurrent()
+}
+
+// concurrent reports whether the batch for writing only holds the write lock when committing,
+// see BatchOptions.Concurrent.
+func (b *Batch) concurrent() bool {
+	return !b.options.ReadOnly && b.options.Concurrent
 }
 
 func (b *Batch) lock() {
+	if b.concurrent() {
+		// register the batch, so the keys written by others are recorded until it ends
+		b.db.mu.Lock()
+		b.startSeq = b.db.versions.begin()
+		b.db.mu.Unlock()
+		b.tracking = true
+		b.reads = make(map[string]struct{})
+		return
+	}
+	if b.readCommitted() {
+		return
+	}
 	if b.options.ReadOnly {
 		b.db.mu.RLock()
 	} else {
+		// wait before locking, so the merge reclaiming the space is not blocked by the stalled writer.
+		b.db.waitWriteStall()
 		b.db.mu.Lock()
 	}
+	b.locked = true
 }
 
+// unlock releases the lock of the database if the batch holds it,
+// so it is safe to be called multiple times.
 func (b *Batch) unlock() {
+	if !b.locked {
+		return
+	}
+	b.locked = false
 	if b.options.ReadOnly {
 		b.db.mu.RUnlock()
 	} else {
@@ -97,17 +160,94 @@ func (b *Batch) unlock() {
 	}
 }
 
+// purgeExpired removes the expired key from the index if the batch holds the write lock of the database.
+// The read only batches and the concurrent batches only hold the read lock, and the index is shared with other readers,
+// so the expired key is kept, it will be removed by the next write of it or merge.
+func (b *Batch) purgeExpired(key []byte) {
+	if b.locked && !b.options.ReadOnly {
+		b.db.deleteIndex(key)
+	}
+}
+
+// trackRead records the key read from the committed data by the concurrent batch,
+// Commit fails if it is written by others after the batch is created.
+func (b *Batch) trackRead(key []byte) {
+	if !b.tracking {
+		return
+	}
+	b.readsMu.Lock()
+	b.reads[string(key)] = struct{}{}
+	b.readsMu.Unlock()
+}
+
+// checkConflict returns ErrConflict if any key read or written by the concurrent batch
+// has been written by others since the batch was created, it must be called with the database locked.
+func (b *Batch) checkConflict() error {
+	for key := range b.pendingWrites {
+		if b.db.versions.writtenSince([]byte(key), b.startSeq) {
+			return ErrConflict
+		}
+	}
+	b.readsMu.Lock()
+	defer b.readsMu.Unlock()
+	for key := range b.reads {
+		if b.db.versions.writtenSince([]byte(key), b.startSeq) {
+			return ErrConflict
+		}
+	}
+	return nil
+}
+
+// lockConcurrent locks the database for the concurrent batch to commit or roll back,
+// the lock is released by unlock.
+func (b *Batch) lockConcurrent() {
+	if !b.concurrent() || b.locked {
+		return
+	}
+	b.db.mu.Lock()
+	b.locked = true
+}
+
+// untrack unregisters the concurrent batch when it ends, it must be called with the database locked,
+// and it is safe to be called multiple times.
+func (b *Batch) untrack() {
+	if !b.tracking {
+		return
+	}
+	b.tracking = false
+	b.db.versions.end()
+}
+
 // Put adds a key-value pair to the batch for writing.
+// The value is written with BatchOptions.DefaultTTL or Options.DefaultTTL if it is set.
 func (b *Batch) Put(key []byte, value []byte) error {
+	if isStructKey(key) {
+		return ErrReservedKey
+	}
+	return b.put(key, value)
+}
+
+// put is like Put, but the keys of the data structures can be written.
+func (b *Batch) put(key []byte, value []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if b.readCommitted() {
+		b.db.mu.RLock()
+		defer b.db.mu.RUnlock()
+	}
 	if b.db.closed {
 		return ErrDBClosed
 	}
 	if b.options.ReadOnly {
 		return ErrReadOnlyBatch
 	}
+	if b.db.keyTooLarge(key) {
+		return ErrKeyTooLarge
+	}
+	if b.db.options.MaxValueSize > 0 && int64(len(value)) > b.db.options.MaxValueSize {
+		return ErrValueTooLarge
+	}
 
 	b.mu.Lock()
 	// write to pendingWrites
@@ -115,7 +255,7 @@ func (b *Batch) Put(key []byte, value []byte) error {
 		Key:    key,
 		Value:  value,
 		Type:   LogRecordNormal,
-		Expire: 0,
+		Expire: b.defaultExpire(),
 	}
 	b.mu.Unlock()
 
@@ -123,16 +263,40 @@ func (b *Batch) Put(key []byte, value []byte) error {
 }
 
 // PutWithTTL adds a key-value pair with ttl to the batch for writing.
+// If ttl is NoTTL, the value will never expire.
 func (b *Batch) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if isStructKey(key) {
+		return ErrReservedKey
+	}
+	return b.putWithTTL(key, value, ttl)
+}
+
+// putWithTTL is like PutWithTTL, but the keys of the data structures can be written.
+func (b *Batch) putWithTTL(key []byte, value []byte, ttl time.Duration) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if b.readCommitted() {
+		b.db.mu.RLock()
+		defer b.db.mu.RUnlock()
+	}
 	if b.db.closed {
 		return ErrDBClosed
 	}
 	if b.options.ReadOnly {
 		return ErrReadOnlyBatch
 	}
+	if b.db.keyTooLarge(key) {
+		return ErrKeyTooLarge
+	}
+	if b.db.options.MaxValueSize > 0 && int64(len(value)) > b.db.options.MaxValueSize {
+		return ErrValueTooLarge
+	}
+
+	var expire int64
+	if ttl != NoTTL {
+		expire = b.db.now().Add(ttl).UnixNano()
+	}
 
 	b.mu.Lock()
 	// write to pendingWrites
@@ -140,7 +304,7 @@ func (b *Batch) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
 		Key:    key,
 		Value:  value,
 		Type:   LogRecordNormal,
-		Expire: time.Now().Add(ttl).UnixNano(),
+		Expire: expire,
 	}
 	b.mu.Unlock()
 
@@ -152,11 +316,187 @@ func (b *Batch) Get(key []byte) ([]byte, error) {
 	if len(key) == 0 {
 		return nil, ErrKeyIsEmpty
 	}
+	if b.readCommitted() {
+		b.db.mu.RLock()
+		defer b.db.mu.RUnlock()
+	}
 	if b.db.closed {
 		return nil, ErrDBClosed
 	}
 
-	now := time.Now().UnixNano()
+	record, err := b.getRecord(key)
+	if err != nil {
+		return nil, err
+	}
+	b.db.slideExpire(key, record.Expire)
+	return record.Value, nil
+}
+
+// GetRange retrieves the bytes of the value in [start, end) from the batch, like value[start:end].
+// The negative start or end counts from the end of the value, -1 is the last byte,
+// and they are clamped to the bounds of the value, so an empty slice is returned if the range is empty.
+func (b *Batch) GetRange(key []byte, start, end int) ([]byte, error) {
+	value, err := b.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end = clampRange(start, len(value)), clampRange(end, len(value))
+	if start >= end {
+		return []byte{}, nil
+	}
+	// copy the range, so the whole value is not retained by the caller.
+	data := make([]byte, end-start)
+	copy(data, value[start:end])
+	return data, nil
+}
+
+// SetRange overwrites the value of the key with data from offset in the batch, and returns the new length of the value.
+// If offset exceeds the length of the value, the gap is filled with zero bytes,
+// and the value is regarded as empty if the key does not exist.
+// The ttl of the key is kept, and it returns ErrNegativeOffset if offset is negative,
+// or ErrValueTooLarge if the new value exceeds Options.MaxValueSize, or Options.SegmentSize if it is not set.
+func (b *Batch) SetRange(key []byte, offset int, data []byte) (int, error) {
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+	if b.readCommitted() {
+		b.db.mu.RLock()
+		defer b.db.mu.RUnlock()
+	}
+	if b.db.closed {
+		return 0, ErrDBClosed
+	}
+	if b.options.ReadOnly {
+		return 0, ErrReadOnlyBatch
+	}
+	if offset < 0 {
+		return 0, ErrNegativeOffset
+	}
+	// the huge offset is rejected before the value is extended to it
+	if int64(offset) > b.db.valueSizeLimit()-int64(len(data)) {
+		return 0, ErrValueTooLarge
+	}
+	return b.modifyValue(key, offset+len(data), func(value []byte) {
+		copy(value[offset:], data)
+	})
+}
+
+// defaultExpire returns the expiry time of the value put to the batch without a ttl,
+// see BatchOptions.DefaultTTL and Options.DefaultTTL.
+func (b *Batch) defaultExpire() int64 {
+	if b.options.DefaultTTL <= 0 {
+		return b.db.defaultExpire()
+	}
+	return b.db.now().Add(b.options.DefaultTTL).UnixNano()
+}
+
+// modifyValue copies the value of the key, which is extended to at least minSize bytes with zero bytes,
+// and writes it back with the same ttl after modified by fn, it returns the new length of the value.
+// The value is regarded as empty if the key does not exist, and it is written with the default ttl of the batch.
+// It returns ErrReservedKey or ErrKeyTooLarge if the key can't be written, like Put,
+// and ErrValueTooLarge if minSize exceeds Options.MaxValueSize, or Options.SegmentSize if it is not set.
+func (b *Batch) modifyValue(key []byte, minSize int, fn func(value []byte)) (int, error) {
+	if isStructKey(key) {
+		return 0, ErrReservedKey
+	}
+	if b.db.keyTooLarge(key) {
+		return 0, ErrKeyTooLarge
+	}
+	var value []byte
+	var expire int64
+	record, err := b.getRecord(key)
+	if err == nil {
+		value, expire = record.Value, record.Expire
+	} else if err == ErrKeyNotFound {
+		expire = b.defaultExpire()
+	} else {
+		return 0, err
+	}
+	size := len(value)
+	if minSize > size {
+		size = minSize
+	}
+	if int64(size) > b.db.valueSizeLimit() {
+		return 0, ErrValueTooLarge
+	}
+
+	// the value may be the one put to the batch by the caller, so it is not modified in place.
+	newValue := make([]byte, size)
+	copy(newValue, value)
+	fn(newValue)
+
+	b.mu.Lock()
+	b.pendingWrites[string(key)] = &LogRecord{
+		Key:    key,
+		Value:  newValue,
+		Type:   LogRecordNormal,
+		Expire: expire,
+	}
+	b.mu.Unlock()
+
+	return size, nil
+}
+
+// clampRange converts the negative index counting from the end of the value with size bytes
+// to the positive one, and clamps it to [0, size].
+func clampRange(index, size int) int {
+	if index < 0 {
+		index += size
+	}
+	if index < 0 {
+		return 0
+	}
+	if index > size {
+		return size
+	}
+	return index
+}
+
+// MGet retrieves the values of the keys from the batch, the pending writes of the batch
+// are seen over the committed data, like Get.
+// The values are in the same order as the keys, and the value is nil if the key
+// does not exist, or it is deleted or expired.
+func (b *Batch) MGet(keys [][]byte) ([][]byte, error) {
+	if b.readCommitted() {
+		b.db.mu.RLock()
+		defer b.db.mu.RUnlock()
+	}
+	if b.db.closed {
+		return nil, ErrDBClosed
+	}
+
+	now := b.db.now().UnixNano()
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		if len(key) == 0 {
+			return nil, ErrKeyIsEmpty
+		}
+		if record := b.pendingWrites[string(key)]; record != nil {
+			if record.Type != LogRecordDeleted && !record.IsExpired(now) {
+				values[i] = record.Value
+			}
+			continue
+		}
+		record, err := b.getCommittedRecord(key, now)
+		if err == ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[i] = record.Value
+	}
+	return values, nil
+}
+
+// getRecord returns the valid record of the key from pendingWrites or the data files.
+// If the value of the record is stored in the value log, it will be loaded,
+// and a normal record will be returned.
+func (b *Batch) getRecord(key []byte) (*LogRecord, error) {
+	now := b.db.now().UnixNano()
 	// get from pendingWrites
 	if b.pendingWrites != nil {
 		b.mu.RLock()
@@ -166,16 +506,24 @@ func (b *Batch) Get(key []byte) ([]byte, error) {
 				return nil, ErrKeyNotFound
 			}
 			b.mu.RUnlock()
-			return record.Value, nil
+			return record, nil
 		}
 		b.mu.RUnlock()
 	}
+	return b.getCommittedRecord(key, now)
+}
 
-	// get from data file
+// getCommittedRecord returns the valid record of the key from the data files,
+// the value stored in the value log will be loaded.
+func (b *Batch) getCommittedRecord(key []byte, now int64) (*LogRecord, error) {
+	b.trackRead(key)
 	chunkPosition := b.db.index.Get(key)
 	if chunkPosition == nil {
 		return nil, ErrKeyNotFound
 	}
+	if b.db.keyLRU != nil {
+		b.db.keyLRU.touch(key)
+	}
 	chunk, err := b.db.dataFiles.Read(chunkPosition)
 	if err != nil {
 		return nil, err
@@ -183,21 +531,77 @@ func (b *Batch) Get(key []byte) ([]byte, error) {
 
 	// check if the record is deleted or expired
 	record := decodeLogRecord(chunk)
+	// the deleted data can not exist in the index, unless the index is corrupted.
 	if record.Type == LogRecordDeleted {
-		panic("Deleted data cannot exist in the index")
+		return nil, fmt.Errorf("%w: the deleted record of key %q is at segment %d block %d offset %d",
+			ErrCorruptedIndex, key, chunkPosition.SegmentId, chunkPosition.BlockNumber, chunkPosition.ChunkOffset)
 	}
 	if record.IsExpired(now) {
-		b.db.index.Delete(record.Key)
+		b.purgeExpired(record.Key)
 		return nil, ErrKeyNotFound
 	}
-	return record.Value, nil
+	if b.db.accessTracker != nil {
+		b.db.accessTracker.hit(key, now)
+	}
+	if record.Value, err = b.db.loadValue(record); err != nil {
+		return nil, err
+	}
+	record.Type, record.codecs = LogRecordNormal, 0
+	return record, nil
+}
+
+// GetOrPut returns the value of the key if it exists,
+// otherwise it calls fn to compute a value, adds it to the batch for writing and returns it.
+// fn is only called when the key is not found.
+func (b *Batch) GetOrPut(key []byte, fn func() ([]byte, error)) ([]byte, error) {
+	return b.GetOrPutWithTTL(key, fn, 0)
+}
+
+// GetOrPutWithTTL is like GetOrPut, but the computed value will be written with the ttl.
+// If ttl is 0, the value is written with the default ttl of the batch, and if ttl is NoTTL, it will never expire.
+func (b *Batch) GetOrPutWithTTL(key []byte, fn func() ([]byte, error), ttl time.Duration) ([]byte, error) {
+	if b.options.ReadOnly {
+		return nil, ErrReadOnlyBatch
+	}
+
+	value, err := b.Get(key)
+	if err != ErrKeyNotFound {
+		return value, err
+	}
+
+	// the key does not exist, compute the value and write it to pendingWrites
+	value, err = fn()
+	if err != nil {
+		return nil, err
+	}
+	if ttl > 0 || ttl == NoTTL {
+		err = b.PutWithTTL(key, value, ttl)
+	} else {
+		err = b.Put(key, value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
 }
 
 // Delete marks a key for deletion in the batch.
 func (b *Batch) Delete(key []byte) error {
+	if isStructKey(key) {
+		return ErrReservedKey
+	}
+	return b.delete(key)
+}
+
+// delete is like Delete, but the keys of the data structures can be deleted.
+func (b *Batch) delete(key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if b.readCommitted() {
+		b.db.mu.RLock()
+		defer b.db.mu.RUnlock()
+	}
 	if b.db.closed {
 		return ErrDBClosed
 	}
@@ -205,6 +609,8 @@ func (b *Batch) Delete(key []byte) error {
 		return ErrReadOnlyBatch
 	}
 
+	// whether the key is written depends on its existence
+	b.trackRead(key)
 	b.mu.Lock()
 	if position := b.db.index.Get(key); position != nil {
 		// write to pendingWrites if the key exists
@@ -220,16 +626,101 @@ func (b *Batch) Delete(key []byte) error {
 	return nil
 }
 
+// Copy copies the value and the remaining ttl of src to dst in the batch.
+// It returns ErrKeyNotFound if src does not exist,
+// and ErrKeyExists if dst exists and overwrite is false.
+func (b *Batch) Copy(src, dst []byte, overwrite bool) error {
+	if len(src) == 0 || len(dst) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if b.readCommitted() {
+		b.db.mu.RLock()
+		defer b.db.mu.RUnlock()
+	}
+	if b.db.closed {
+		return ErrDBClosed
+	}
+	if b.options.ReadOnly {
+		return ErrReadOnlyBatch
+	}
+	if isStructKey(dst) {
+		return ErrReservedKey
+	}
+	if b.db.keyTooLarge(dst) {
+		return ErrKeyTooLarge
+	}
+
+	record, err := b.getRecord(src)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(src, dst) {
+		return nil
+	}
+	if !overwrite {
+		exist, err := b.exist(dst)
+		if err != nil {
+			return err
+		}
+		if exist {
+			return ErrKeyExists
+		}
+	}
+
+	b.mu.Lock()
+	// the expiry time is copied, so dst has the same remaining ttl as src
+	b.pendingWrites[string(dst)] = &LogRecord{
+		Key:    dst,
+		Value:  record.Value,
+		Type:   LogRecordNormal,
+		Expire: record.Expire,
+	}
+	b.mu.Unlock()
+
+	return nil
+}
+
+// RenameKey renames oldKey to newKey in the batch, the value and ttl of oldKey will be kept.
+// It returns ErrKeyNotFound if oldKey does not exist,
+// and ErrKeyExists if newKey exists and overwrite is false.
+func (b *Batch) RenameKey(oldKey, newKey []byte, overwrite bool) error {
+	if err := b.Copy(oldKey, newKey, overwrite); err != nil {
+		return err
+	}
+	if bytes.Equal(oldKey, newKey) {
+		return nil
+	}
+	return b.Delete(oldKey)
+}
+
+// Move moves the key to destPrefix+key in the batch, the value and ttl of the key will be kept,
+// which is useful to emulate multiple databases by the key prefixes.
+// It returns ErrKeyNotFound if the key does not exist,
+// and ErrKeyExists if the destination key exists.
+func (b *Batch) Move(key, destPrefix []byte) error {
+	destKey := make([]byte, 0, len(destPrefix)+len(key))
+	destKey = append(append(destKey, destPrefix...), key...)
+	return b.RenameKey(key, destKey, false)
+}
+
 // Exist checks if the key exists in the database.
 func (b *Batch) Exist(key []byte) (bool, error) {
 	if len(key) == 0 {
 		return false, ErrKeyIsEmpty
 	}
+	if b.readCommitted() {
+		b.db.mu.RLock()
+		defer b.db.mu.RUnlock()
+	}
 	if b.db.closed {
 		return false, ErrDBClosed
 	}
+	return b.exist(key)
+}
 
-	now := time.Now().UnixNano()
+// exist checks if the key exists in the batch, like Exist but without locking the database.
+func (b *Batch) exist(key []byte) (bool, error) {
+	now := b.db.now().UnixNano()
 	// check if the key exists in pendingWrites
 	if b.pendingWrites != nil {
 		b.mu.RLock()
@@ -241,20 +732,22 @@ func (b *Batch) Exist(key []byte) (bool, error) {
 	}
 
 	// check if the key exists in index
+	b.trackRead(key)
 	position := b.db.index.Get(key)
 	if position == nil {
 		return false, nil
 	}
 
-	// check if the record is deleted or expired
+	// check if the record is deleted or expired,
+	// only the header is decoded, the value is not copied.
 	chunk, err := b.db.dataFiles.Read(position)
 	if err != nil {
 		return false, err
 	}
 
-	record := decodeLogRecord(chunk)
-	if record.Type == LogRecordDeleted || record.IsExpired(now) {
-		b.db.index.Delete(record.Key)
+	header := decodeLogRecordHeader(chunk)
+	if header.recordType == LogRecordDeleted || header.isExpired(now) {
+		b.purgeExpired(key)
 		return false, nil
 	}
 	return true, nil
@@ -265,20 +758,28 @@ func (b *Batch) Expire(key []byte, ttl time.Duration) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if b.readCommitted() {
+		b.db.mu.RLock()
+		defer b.db.mu.RUnlock()
+	}
 	if b.db.closed {
 		return ErrDBClosed
 	}
 	if b.options.ReadOnly {
 		return ErrReadOnlyBatch
 	}
+	if isStructKey(key) {
+		return ErrReservedKey
+	}
 
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	// if the key exists in pendingWrites, update the expiry time directly
 	if record := b.pendingWrites[string(key)]; record != nil {
-		record.Expire = time.Now().Add(ttl).UnixNano()
+		record.Expire = b.db.now().Add(ttl).UnixNano()
 	} else {
 		// if the key does not exist in pendingWrites, get the value from wal
+		b.trackRead(key)
 		position := b.db.index.Get(key)
 		if position == nil {
 			return ErrKeyNotFound
@@ -288,14 +789,20 @@ func (b *Batch) Expire(key []byte, ttl time.Duration) error {
 			return err
 		}
 
-		now := time.Now()
+		now := b.db.now()
 		record := decodeLogRecord(chunk)
 		// if the record is deleted or expired, we can assume that the key does not exist,
 		// and delete the key from the index
 		if record.Type == LogRecordDeleted || record.IsExpired(now.UnixNano()) {
-			b.db.index.Delete(key)
+			b.purgeExpired(key)
 			return ErrKeyNotFound
 		}
+		// the value may be stored in the value log, load it
+		// and the record will be rewritten as a normal record.
+		if record.Value, err = b.db.loadValue(record); err != nil {
+			return err
+		}
+		record.Type, record.codecs = LogRecordNormal, 0
 		// now we get the value from wal, update the expiry time
 		// and rewrite the record to pendingWrites
 		record.Expire = now.Add(ttl).UnixNano()
@@ -305,16 +812,58 @@ func (b *Batch) Expire(key []byte, ttl time.Duration) error {
 	return nil
 }
 
+// Touch marks the key as recently accessed, and if extend is greater than 0,
+// resets the ttl of the key to extend from now, which is the sliding expiration.
+// The key without ttl will not be set to expire.
+// It returns ErrKeyNotFound if the key does not exist or is expired.
+func (b *Batch) Touch(key []byte, extend time.Duration) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if b.readCommitted() {
+		b.db.mu.RLock()
+		defer b.db.mu.RUnlock()
+	}
+	if b.db.closed {
+		return ErrDBClosed
+	}
+	if b.options.ReadOnly && extend > 0 {
+		return ErrReadOnlyBatch
+	}
+	if isStructKey(key) && extend > 0 {
+		return ErrReservedKey
+	}
+
+	record, err := b.getRecord(key)
+	if err != nil {
+		return err
+	}
+	if extend <= 0 || record.Expire == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// only the expiry time is changed, the record will be rewritten
+	record.Expire = b.db.now().Add(extend).UnixNano()
+	b.pendingWrites[string(key)] = record
+	return nil
+}
+
 // TTL returns the ttl of the key.
 func (b *Batch) TTL(key []byte) (time.Duration, error) {
 	if len(key) == 0 {
 		return -1, ErrKeyIsEmpty
 	}
+	if b.readCommitted() {
+		b.db.mu.RLock()
+		defer b.db.mu.RUnlock()
+	}
 	if b.db.closed {
 		return -1, ErrDBClosed
 	}
 
-	now := time.Now()
+	now := b.db.now()
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	if b.pendingWrites != nil {
@@ -333,6 +882,7 @@ func (b *Batch) TTL(key []byte) (time.Duration, error) {
 	}
 
 	// if the key does not exist in pendingWrites, get the value from wal
+	b.trackRead(key)
 	position := b.db.index.Get(key)
 	if position == nil {
 		return -1, ErrKeyNotFound
@@ -348,7 +898,7 @@ func (b *Batch) TTL(key []byte) (time.Duration, error) {
 		return -1, ErrKeyNotFound
 	}
 	if record.IsExpired(now.UnixNano()) {
-		b.db.index.Delete(key)
+		b.purgeExpired(key)
 		return -1, ErrKeyNotFound
 	}
 
@@ -360,13 +910,157 @@ func (b *Batch) TTL(key []byte) (time.Duration, error) {
 	return -1, nil
 }
 
+// Len returns the number of the pending writes in the batch.
+func (b *Batch) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.pendingWrites)
+}
+
+// Ascend calls handleFn for each key/value pair seen by the batch in ascending order,
+// that is the committed data overlaid with the pending writes, so the keys deleted by the batch are skipped,
+// and the keys put by the batch are visited with the pending values, before the batch is committed.
+// It stops if handleFn returns false or an error, and the error is returned.
+//
+// The pending writes are copied when it starts, so handleFn can write the batch,
+// but the writes are not visited, and handleFn must not commit or rollback the batch.
+// The keys visited are not validated by BatchOptions.Concurrent, only the point reads are.
+func (b *Batch) Ascend(handleFn func(k []byte, v []byte) (bool, error)) error {
+	if b.readCommitted() {
+		b.db.mu.RLock()
+		defer b.db.mu.RUnlock()
+	}
+	if b.db.closed {
+		return ErrDBClosed
+	}
+
+	now := b.db.now().UnixNano()
+	b.mu.RLock()
+	pending := make([]*LogRecord, 0, len(b.pendingWrites))
+	for _, record := range b.pendingWrites {
+		pending = append(pending, record)
+	}
+	b.mu.RUnlock()
+	sort.Slice(pending, func(i, j int) bool {
+		return bytes.Compare(pending[i].Key, pending[j].Key) < 0
+	})
+
+	// visitPending calls handleFn for the pending record unless it is deleted or expired,
+	// or it is the record of a data structure.
+	visitPending := func(record *LogRecord) (bool, error) {
+		if record.Type == LogRecordDeleted || record.IsExpired(now) || isStructKey(record.Key) {
+			return true, nil
+		}
+		return handleFn(record.Key, record.Value)
+	}
+
+	var err error
+	cont := true
+	b.db.index.Ascend(func(key []byte, pos *wal.ChunkPosition) (bool, error) {
+		// the pending keys before the committed key
+		for len(pending) > 0 && bytes.Compare(pending[0].Key, key) < 0 {
+			if cont, err = visitPending(pending[0]); err != nil || !cont {
+				return false, err
+			}
+			pending = pending[1:]
+		}
+		// the committed key overwritten or deleted by the batch
+		if len(pending) > 0 && bytes.Equal(pending[0].Key, key) {
+			cont, err = visitPending(pending[0])
+			pending = pending[1:]
+			return err == nil && cont, err
+		}
+		if isStructKey(key) {
+			return true, nil
+		}
+
+		var chunk, value []byte
+		if chunk, err = b.db.dataFiles.Read(pos); err != nil {
+			return false, err
+		}
+		value, err = b.db.checkValue(chunk)
+		if err == ErrKeyNotFound {
+			// skip the expired key
+			err = nil
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		cont, err = handleFn(key, value)
+		return err == nil && cont, err
+	})
+	for ; err == nil && cont && len(pending) > 0; pending = pending[1:] {
+		cont, err = visitPending(pending[0])
+	}
+	return err
+}
+
+// Size returns the estimated number of bytes to be written when the batch is committed.
+// It is computed from the sizes of the pending keys and values, plus the max size of the record headers,
+// and the batch finished record, without encoding the records.
+// The values may be written to the value log instead of the data files if they are separated.
+func (b *Batch) Size() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.pendingWrites) == 0 {
+		return 0
+	}
+
+	var size int
+	for _, record := range b.pendingWrites {
+		size += chunkHeaderSize + maxLogRecordHeaderSize + len(record.Key) + len(record.Value)
+	}
+	// the key of the batch finished record is the batch id in decimal
+	return size + chunkHeaderSize + maxLogRecordHeaderSize + maxBatchIdSize
+}
+
+// deletesOnly reports whether all the pending writes of the batch delete keys.
+func (b *Batch) deletesOnly() bool {
+	for _, record := range b.pendingWrites {
+		if record.Type != LogRecordDeleted {
+			return false
+		}
+	}
+	return true
+}
+
 // Commit commits the batch, if the batch is readonly or empty, it will return directly.
 //
 // It will iterate the pendingWrites and write the data to the database,
 // then write a record to indicate the end of the batch to guarantee atomicity.
 // Finally, it will write the index.
-func (b *Batch) Commit() error {
+//
+// It returns ErrWriteStall if the data files exceed Options.MaxWALSize, unless the batch only deletes keys,
+// since the deletes make the space reclaimable by merge.
+// If it fails, for example, the data files can not be written, the lock of the database is released,
+// and the batch is marked as failed, committing it again returns ErrBatchFailed.
+// The data written partially is never visible, since the batch finished record is not written.
+func (b *Batch) Commit() (err error) {
+	var committed []KV
+	var evicted [][]byte
+	var evictErr error
+	// the hooks are called after the lock is released, so they will not block other writers.
+	defer func() {
+		if committed != nil {
+			b.options.OnCommit(committed)
+		}
+		if evicted != nil && b.db.options.OnEvict != nil {
+			for _, key := range evicted {
+				b.db.options.OnEvict(key)
+			}
+		}
+		if evictErr != nil {
+			b.db.backgroundError(BackgroundTaskEvict, evictErr)
+		}
+	}()
 	defer b.unlock()
+	if b.concurrent() && !b.locked {
+		// wait before locking, so the merge reclaiming the space is not blocked by the stalled writer.
+		b.db.waitWriteStall()
+	}
+	b.lockConcurrent()
+	defer b.untrack()
 	if b.db.closed {
 		return ErrDBClosed
 	}
@@ -385,58 +1079,297 @@ func (b *Batch) Commit() error {
 	if b.rollbacked {
 		return ErrBatchRollbacked
 	}
+	if b.failed {
+		return ErrBatchFailed
+	}
+	// the lock is released when returning, so the batch can not be committed again if it fails.
+	defer func() {
+		if err != nil {
+			b.failed = true
+		}
+	}()
 
-	batchId := b.batchId.Generate()
-	positions := make(map[string]*wal.ChunkPosition)
+	if b.db.writeStalled() && !b.deletesOnly() {
+		return ErrWriteStall
+	}
+	// the first committed one of the concurrent batches writing the same keys wins
+	if b.tracking {
+		if err := b.checkConflict(); err != nil {
+			return err
+		}
+	}
 
-	now := time.Now().UnixNano()
-	// write to wal
-	for _, record := range b.pendingWrites {
-		record.BatchId = uint64(batchId)
-		encRecord := encodeLogRecord(record)
-		pos, err := b.db.dataFiles.Write(encRecord)
-		if err != nil {
+	// the OnBeforeCommit hook can veto the commit by returning an error
+	if b.options.OnBeforeCommit != nil {
+		if err := b.options.OnBeforeCommit(); err != nil {
 			return err
 		}
-		positions[string(record.Key)] = pos
 	}
 
-	// write a record to indicate the end of the batch
-	endRecord := encodeLogRecord(&LogRecord{
-		Key:  batchId.Bytes(),
-		Type: LogRecordBatchFinished,
-	})
-	if _, err := b.db.dataFiles.Write(endRecord); err != nil {
+	now := b.db.now().UnixNano()
+	// omit the writes which would not change the committed data
+	if b.options.SkipRedundantWrites {
+		if err := b.skipRedundantWrites(now); err != nil {
+			return err
+		}
+		if len(b.pendingWrites) == 0 {
+			b.committed = true
+			return nil
+		}
+	}
+
+	records := b.pendingRecords()
+	positions := make(map[string]*wal.ChunkPosition)
+	var sizes map[string]int64
+	if b.db.keyLRU != nil {
+		sizes = make(map[string]int64, len(b.pendingWrites))
+	}
+	// write to wal
+	endPos, written, err := b.writeRecordsWithRetry(records, positions, sizes)
+	if err != nil {
 		return err
 	}
+	b.db.addUnsyncedBytes(written)
 
 	// flush wal if necessary
 	if b.options.Sync && !b.db.options.Sync {
-		if err := b.db.dataFiles.Sync(); err != nil {
+		if err := b.db.syncFiles(); err != nil {
+			return err
+		}
+	}
+	// every write is synced by the wal, but the new segment file created by it is not
+	if b.db.options.Sync {
+		if err := b.db.syncDirIfRotated(b.db.dataFiles, b.db.valueLogFiles); err != nil {
 			return err
 		}
 	}
 
-	// write to index
-	for key, record := range b.pendingWrites {
+	var applied []KV
+	if b.options.OnCommit != nil {
+		applied = make([]KV, 0, len(b.pendingWrites))
+	}
+	// write to index, the end record, the delete records and the replaced records are garbage now.
+	b.db.dataBytes += int64(endPos.ChunkSize)
+	b.db.addGarbage(endPos)
+	// the puts are applied to the index in one call, so the index is locked once for the whole batch.
+	putKeys := make([][]byte, 0, len(records))
+	putPositions := make([]*wal.ChunkPosition, 0, len(records))
+	for _, record := range records {
+		key := string(record.Key)
+		b.db.dataBytes += int64(positions[key].ChunkSize)
 		if record.Type == LogRecordDeleted || record.IsExpired(now) {
-			b.db.index.Delete(record.Key)
+			b.db.deleteIndex(record.Key)
+			b.db.addGarbage(positions[key])
 		} else {
-			b.db.index.Put(record.Key, positions[key])
+			putKeys = append(putKeys, record.Key)
+			putPositions = append(putPositions, positions[key])
+		}
+	}
+	b.db.putIndexBatch(putKeys, putPositions)
+
+	for _, record := range records {
+		key := string(record.Key)
+		b.db.versions.update(record, now)
+		if len(b.db.secondaryIndexes) > 0 {
+			b.db.updateSecondaryIndexes(record, now)
+		}
+		// the records of the data structures are not evicted, see keyLRU
+		if b.db.keyLRU != nil && !isStructKey(record.Key) {
+			if record.Type == LogRecordDeleted || record.IsExpired(now) {
+				b.db.keyLRU.remove(record.Key)
+			} else {
+				b.db.keyLRU.put(record.Key, sizes[key])
+			}
+		}
+		if b.db.accessTracker != nil {
+			if record.Type == LogRecordDeleted || record.IsExpired(now) {
+				b.db.accessTracker.remove(record.Key)
+			} else {
+				b.db.accessTracker.written(record.Key, now)
+			}
+		}
+		// the records of the data structures are not notified
+		if isStructKey(record.Key) {
+			continue
+		}
+		if b.db.slidingKeys != nil {
+			b.db.slidingKeys.written(record, now, b.keepTTL)
+		}
+		if applied != nil {
+			kv := KV{Key: record.Key, Deleted: record.Type == LogRecordDeleted}
+			if !kv.Deleted {
+				kv.Value = record.Value
+			}
+			applied = append(applied, kv)
 		}
 
 		if b.db.options.WatchQueueSize > 0 {
-			e := &Event{Key: record.Key, Value: record.Value, BatchId: record.BatchId}
+			e := &Event{Key: record.Key, BatchId: record.BatchId, TTL: -1}
 			if record.Type == LogRecordDeleted {
 				e.Action = WatchActionDelete
 			} else {
 				e.Action = WatchActionPut
+				if b.db.options.WatchIncludeValue {
+					e.Value = record.Value
+				}
+				// the ttl of the key set by PutWithTTL, Expire and Touch
+				if record.Expire > 0 {
+					e.Expire = record.Expire
+					e.TTL = time.Duration(record.Expire - now)
+				}
 			}
 			b.db.watcher.putEvent(e)
+			b.db.publish(e)
 		}
 	}
 
 	b.committed = true
+	committed = applied
+
+	// evict the least recently used keys if the total size exceeds the limit,
+	// the batch is already committed, so the failure is not returned by Commit.
+	if b.db.keyLRU != nil {
+		evicted, evictErr = b.db.evict(uint64(b.db.node.Generate()), b.pendingWrites)
+	}
+	return nil
+}
+
+// writeRecordsWithRetry is like writeRecords, but it retries at most BatchOptions.CommitRetries times
+// if the records can not be written because of the transient errors, see isTransientError.
+//
+// Each retry writes the records to the new active files with a new batch id,
+// since the active files may be written partially, and the records of the failed attempts
+// are never indexed without the batch finished record.
+func (b *Batch) writeRecordsWithRetry(records []*LogRecord, positions map[string]*wal.ChunkPosition,
+	sizes map[string]int64) (*wal.ChunkPosition, int64, error) {
+	backoff := b.options.CommitRetryBackoff
+	var err error
+	for retry := 0; ; retry++ {
+		if retry > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+			err = b.db.openNewActiveFiles()
+		}
+		if err == nil {
+			var endPos *wal.ChunkPosition
+			var written int64
+			if endPos, written, err = b.writeRecords(b.db.node.Generate(), records, positions, sizes); err == nil {
+				return endPos, written, nil
+			}
+		}
+		if retry >= b.options.CommitRetries || !isTransientError(err) {
+			return nil, 0, err
+		}
+	}
+}
+
+// isTransientError reports whether the error of writing the files may disappear by retrying,
+// they are ENOSPC and EDQUOT which may be cleared by freeing the disk space,
+// and EIO, EAGAIN and EINTR which may be caused by the temporary failure of the storage.
+func isTransientError(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.ENOSPC, syscall.EDQUOT, syscall.EIO, syscall.EAGAIN, syscall.EINTR} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeRecords writes the pending records and the batch finished record to the data files,
+// the positions of the records are saved in positions, and their sizes are saved in sizes if it is not nil,
+// the size of a record includes its value in the value log.
+// It returns the position of the batch finished record and the number of bytes written.
+func (b *Batch) writeRecords(batchId snowflake.ID, records []*LogRecord, positions map[string]*wal.ChunkPosition,
+	sizes map[string]int64) (*wal.ChunkPosition, int64, error) {
+	var written int64
+	for _, record := range records {
+		record.BatchId = uint64(batchId)
+		// the delete record expires when it can be dropped by merge, see Options.TombstoneRetention.
+		// The delete records applied from the primary database keep their expiration time.
+		if record.Type == LogRecordDeleted && record.Expire == 0 && b.db.options.TombstoneRetention > 0 {
+			record.Expire = b.db.now().Add(b.db.options.TombstoneRetention).UnixNano()
+		}
+		// the value is encoded by the codecs first, then the large value will be written to the value log,
+		// and only the pointer of it will be written to the data files.
+		dataRecord, err := b.db.encodeValue(record)
+		if err != nil {
+			return nil, 0, err
+		}
+		if dataRecord, err = b.db.separateValue(dataRecord); err != nil {
+			return nil, 0, err
+		}
+		// the encode buffer is reused by the next record, since the data files copy it on writing
+		buf, encRecord := encodeLogRecordPooled(dataRecord)
+		pos, err := b.db.dataFiles.Write(encRecord)
+		putEncodeBuffer(buf)
+		if err != nil {
+			return nil, 0, err
+		}
+		positions[string(record.Key)] = pos
+		size := int64(pos.ChunkSize)
+		if dataRecord.Type == LogRecordValuePointer {
+			size += int64(decodeValuePointer(dataRecord.Value).ChunkSize)
+		}
+		if sizes != nil {
+			sizes[string(record.Key)] = size
+		}
+		written += size
+	}
+
+	// write a record to indicate the end of the batch
+	endRecord := encodeLogRecord(&LogRecord{
+		Key:  batchId.Bytes(),
+		Type: LogRecordBatchFinished,
+	})
+	endPos, err := b.db.dataFiles.Write(endRecord)
+	if err != nil {
+		return nil, 0, err
+	}
+	return endPos, written + int64(len(endRecord)), nil
+}
+
+// pendingRecords returns the pending writes to be committed,
+// they are sorted by the keys if BatchOptions.OrderedCommit is true.
+func (b *Batch) pendingRecords() []*LogRecord {
+	records := make([]*LogRecord, 0, len(b.pendingWrites))
+	for _, record := range b.pendingWrites {
+		records = append(records, record)
+	}
+	if b.options.OrderedCommit {
+		sort.Slice(records, func(i, j int) bool {
+			return bytes.Compare(records[i].Key, records[j].Key) < 0
+		})
+	}
+	return records
+}
+
+// skipRedundantWrites removes the pending puts whose value and expiration time
+// are the same as the committed ones, see BatchOptions.SkipRedundantWrites.
+func (b *Batch) skipRedundantWrites(now int64) error {
+	for key, record := range b.pendingWrites {
+		if record.Type != LogRecordNormal {
+			continue
+		}
+		position := b.db.index.Get(record.Key)
+		if position == nil {
+			continue
+		}
+		chunk, err := b.db.dataFiles.Read(position)
+		if err != nil {
+			return err
+		}
+		committed := decodeLogRecord(chunk)
+		if committed.IsExpired(now) || committed.Expire != record.Expire {
+			continue
+		}
+		value, err := b.db.loadValue(committed)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(value, record.Value) {
+			delete(b.pendingWrites, key)
+		}
+	}
 	return nil
 }
 
@@ -444,6 +1377,8 @@ func (b *Batch) Commit() error {
 // the discard operation will clear the buffered data and release the lock.
 func (b *Batch) Rollback() error {
 	defer b.unlock()
+	b.lockConcurrent()
+	defer b.untrack()
 
 	if b.db.closed {
 		return ErrDBClosed
@@ -464,3 +1399,23 @@ func (b *Batch) Rollback() error {
 	b.rollbacked = true
 	return nil
 }
+
+// Discard discards the batch if it is not committed or rollbacked, and releases the lock of the database.
+// Unlike Rollback, it never returns an error, and it is safe to be called multiple times,
+// or after the batch is committed or rollbacked, so the batch can always be cleaned up by
+//
+//	defer batch.Discard()
+func (b *Batch) Discard() {
+	defer b.unlock()
+	b.lockConcurrent()
+	defer b.untrack()
+
+	if b.committed || b.rollbacked {
+		return
+	}
+	if !b.options.ReadOnly {
+		// clear pendingWrites
+		b.pendingWrites = nil
+	}
+	b.rollbacked = true
+}
@@ -1,8 +1,15 @@
 package rosedb
 
 import (
+	"errors"
+	"math/rand"
 	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/rosedblabs/rosedb/v2/utils"
 	"github.com/stretchr/testify/assert"
@@ -198,3 +205,746 @@ func TestBatch_Rollback(t *testing.T) {
 	assert.Equal(t, ErrKeyNotFound, err)
 	assert.Empty(t, resp)
 }
+
+func TestBatch_GetOrPut(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	err = db.Put([]byte("exist"), []byte("val-1"))
+	assert.Nil(t, err)
+
+	var called int
+	fn := func() ([]byte, error) {
+		called++
+		return []byte("val-2"), nil
+	}
+
+	batch := db.NewBatch(DefaultBatchOptions)
+	val, err := batch.GetOrPut([]byte("exist"), fn)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("val-1"), val)
+	assert.Equal(t, 0, called)
+
+	val, err = batch.GetOrPut([]byte("not-exist"), fn)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("val-2"), val)
+	assert.Equal(t, 1, called)
+
+	val, err = batch.GetOrPutWithTTL([]byte("not-exist"), fn, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("val-2"), val)
+	assert.Equal(t, 1, called)
+	err = batch.Commit()
+	assert.Nil(t, err)
+
+	val, err = db.Get([]byte("not-exist"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("val-2"), val)
+
+	batch2 := db.NewBatch(DefaultBatchOptions)
+	val, err = batch2.GetOrPutWithTTL([]byte("ttl-key"), fn, time.Millisecond*100)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("val-2"), val)
+	err = batch2.Commit()
+	assert.Nil(t, err)
+
+	time.Sleep(time.Millisecond * 200)
+	_, err = db.Get([]byte("ttl-key"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestBatch_ReadCommitted(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	err = db.Put([]byte("name"), []byte("rosedb-1"))
+	assert.Nil(t, err)
+
+	batch := db.NewBatch(BatchOptions{ReadOnly: true, ReadCommitted: true})
+	val, err := batch.Get([]byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("rosedb-1"), val)
+
+	// the writers will not be blocked by the read committed batch
+	err = db.Put([]byte("name"), []byte("rosedb-2"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("age"), []byte("10"))
+	assert.Nil(t, err)
+
+	val, err = batch.Get([]byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("rosedb-2"), val)
+	exist, err := batch.Exist([]byte("age"))
+	assert.Nil(t, err)
+	assert.True(t, exist)
+	err = batch.Commit()
+	assert.Nil(t, err)
+}
+
+func TestBatch_CommitHooks(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	err = db.Put([]byte("deleted"), []byte("val"))
+	assert.Nil(t, err)
+
+	// veto the commit
+	vetoErr := errors.New("veto")
+	batch1 := db.NewBatch(BatchOptions{
+		OnBeforeCommit: func() error { return vetoErr },
+		OnCommit:       func(committed []KV) { t.Fatal("should not be called") },
+	})
+	err = batch1.Put([]byte("key"), []byte("val"))
+	assert.Nil(t, err)
+	err = batch1.Commit()
+	assert.Equal(t, vetoErr, err)
+	assertKeyExistOrNot(t, db, []byte("key"), false)
+
+	var applied []KV
+	batch2 := db.NewBatch(BatchOptions{
+		OnCommit: func(committed []KV) {
+			// the database is not locked in the hook
+			exist, err := db.Exist([]byte("key"))
+			assert.Nil(t, err)
+			assert.True(t, exist)
+			applied = committed
+		},
+	})
+	err = batch2.Put([]byte("key"), []byte("val"))
+	assert.Nil(t, err)
+	err = batch2.Delete([]byte("deleted"))
+	assert.Nil(t, err)
+	err = batch2.Commit()
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, len(applied))
+	for _, kv := range applied {
+		if string(kv.Key) == "key" {
+			assert.Equal(t, []byte("val"), kv.Value)
+			assert.False(t, kv.Deleted)
+		} else {
+			assert.Equal(t, []byte("deleted"), kv.Key)
+			assert.Nil(t, kv.Value)
+			assert.True(t, kv.Deleted)
+		}
+	}
+}
+
+func TestBatch_SkipRedundantWrites(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, db.PutWithTTL([]byte("k2"), []byte("v2"), time.Hour))
+	pos1, pos2 := db.index.Get([]byte("k1")), db.index.Get([]byte("k2"))
+
+	var committed []KV
+	batchOptions := DefaultBatchOptions
+	batchOptions.SkipRedundantWrites = true
+	batchOptions.OnCommit = func(kvs []KV) {
+		committed = kvs
+	}
+	// all the writes are redundant, nothing is written
+	batch := db.NewBatch(batchOptions)
+	assert.Nil(t, batch.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, batch.Commit())
+	assert.Nil(t, committed)
+	assert.Equal(t, pos1, db.index.Get([]byte("k1")))
+
+	// the value or the ttl is changed
+	batch = db.NewBatch(batchOptions)
+	assert.Nil(t, batch.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, batch.Put([]byte("k2"), []byte("v2")))
+	assert.Nil(t, batch.Put([]byte("k3"), []byte("v3")))
+	assert.Nil(t, batch.Commit())
+	assert.Equal(t, 2, len(committed))
+	assert.Equal(t, pos1, db.index.Get([]byte("k1")))
+	assert.NotEqual(t, pos2, db.index.Get([]byte("k2")))
+	val, err := db.Get([]byte("k3"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), val)
+
+	// the redundant writes are written if not enabled
+	batch = db.NewBatch(DefaultBatchOptions)
+	assert.Nil(t, batch.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, batch.Commit())
+	assert.NotEqual(t, pos1, db.index.Get([]byte("k1")))
+}
+
+func TestBatch_Len_Size(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	batch := db.NewBatch(DefaultBatchOptions)
+	assert.Equal(t, 0, batch.Len())
+	assert.Equal(t, 0, batch.Size())
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, batch.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+	}
+	// the same key is written only once
+	assert.Nil(t, batch.Put(utils.GetTestKey(0), utils.RandomValue(KB)))
+	assert.Equal(t, 10, batch.Len())
+	size := batch.Size()
+	assert.True(t, size > 10*KB)
+
+	// the estimated size is not less than the encoded size
+	var encoded int
+	for _, record := range batch.pendingWrites {
+		encoded += len(encodeLogRecord(record))
+	}
+	assert.True(t, size > encoded)
+	assert.Nil(t, batch.Commit())
+}
+
+func TestBatch_Discard(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// discard an uncommitted batch
+	batch := db.NewBatch(DefaultBatchOptions)
+	assert.Nil(t, batch.Put([]byte("k1"), []byte("v1")))
+	batch.Discard()
+	batch.Discard()
+	_, err = db.Get([]byte("k1"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// discard after commit
+	func() {
+		batch := db.NewBatch(DefaultBatchOptions)
+		defer batch.Discard()
+		assert.Nil(t, batch.Put([]byte("k2"), []byte("v2")))
+		assert.Nil(t, batch.Commit())
+	}()
+	val, err := db.Get([]byte("k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+
+	// discard after rollback, and the read only batch
+	batch = db.NewBatch(DefaultBatchOptions)
+	assert.Nil(t, batch.Rollback())
+	batch.Discard()
+	assert.Equal(t, ErrBatchRollbacked, batch.Rollback())
+	roOptions := DefaultBatchOptions
+	roOptions.ReadOnly = true
+	batch = db.NewBatch(roOptions)
+	batch.Discard()
+	batch.Discard()
+
+	// the database is not locked
+	assert.Nil(t, db.Put([]byte("k3"), []byte("v3")))
+}
+
+func TestBatch_Commit_Failed(t *testing.T) {
+	options := DefaultOptions
+	options.SegmentSize = 64 * KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// the record larger than the segment size can not be written to the data files
+	batch := db.NewBatch(DefaultBatchOptions)
+	assert.Nil(t, batch.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, batch.Put([]byte("k2"), utils.RandomValue(128*KB)))
+	assert.NotNil(t, batch.Commit())
+
+	// the lock is released only once, and the batch can not be committed again
+	assert.Equal(t, ErrBatchFailed, batch.Commit())
+	assert.Nil(t, batch.Rollback())
+	batch.Discard()
+
+	// the database is not locked, and the partial writes are not visible
+	_, err = db.Get([]byte("k1"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, db.Put([]byte("k3"), []byte("v3")))
+
+	// the failed writes are discarded after reopening,
+	// they are not committed by the batch finished record of the next batch.
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	_, err = db.Get([]byte("k1"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db.Get([]byte("k3"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), val)
+}
+
+// failingCodec fails to encode the values with err for the given number of times after the first value.
+type failingCodec struct {
+	err      error
+	failures int
+	calls    int
+}
+
+func (c *failingCodec) Encode(value []byte) ([]byte, error) {
+	c.calls++
+	if c.calls > 1 && c.failures > 0 {
+		c.failures--
+		return nil, c.err
+	}
+	return value, nil
+}
+
+func (c *failingCodec) Decode(value []byte) ([]byte, error) {
+	return value, nil
+}
+
+func TestBatch_Commit_Retry(t *testing.T) {
+	codec := &failingCodec{}
+	options := DefaultOptions
+	options.ValueCodec = []Codec{codec}
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	batchOptions := DefaultBatchOptions
+	batchOptions.CommitRetries = 2
+	batchOptions.CommitRetryBackoff = time.Millisecond
+
+	// the first record is written partially, and the commit succeeds by the last retry
+	codec.err, codec.failures, codec.calls = &os.PathError{Op: "write", Err: syscall.ENOSPC}, 2, 0
+	segId := db.dataFiles.ActiveSegmentID()
+	batch := db.NewBatch(batchOptions)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, batch.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, batch.Commit())
+	assert.Equal(t, segId+2, db.dataFiles.ActiveSegmentID())
+
+	// too many failures
+	codec.err, codec.failures, codec.calls = syscall.EIO, 3, 0
+	batch = db.NewBatch(batchOptions)
+	assert.Nil(t, batch.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, batch.Put([]byte("k2"), []byte("v2")))
+	assert.Equal(t, syscall.EIO, batch.Commit())
+
+	// the error is not transient
+	codec.err, codec.failures, codec.calls = errors.New("not transient"), 1, 0
+	batch = db.NewBatch(batchOptions)
+	assert.Nil(t, batch.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, batch.Put([]byte("k2"), []byte("v2")))
+	assert.Equal(t, codec.err, batch.Commit())
+
+	check := func() {
+		assert.Equal(t, 10, db.Stat().KeysNum)
+		for i := 0; i < 10; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), val)
+		}
+		_, err = db.Get([]byte("k1"))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+	check()
+	// the records written by the failed attempts are discarded after reopening
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	check()
+}
+
+func TestBatch_ReadOnly_Expired_Concurrent(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.PutWithTTL(utils.GetTestKey(i), []byte("v"), time.Millisecond))
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	roOptions := DefaultBatchOptions
+	roOptions.ReadOnly = true
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			batch := db.NewBatch(roOptions)
+			defer batch.Discard()
+			for i := 0; i < 100; i++ {
+				_, err := batch.Get(utils.GetTestKey(i))
+				assert.Equal(t, ErrKeyNotFound, err)
+				exist, err := batch.Exist(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.False(t, exist)
+				_, err = batch.TTL(utils.GetTestKey(i))
+				assert.Equal(t, ErrKeyNotFound, err)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 100; i < 200; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("v")))
+		}
+	}()
+	wg.Wait()
+
+	// the expired keys are kept by the read only batches, and skipped when iterating
+	var count int
+	db.Ascend(func(k []byte, v []byte) (bool, error) {
+		count++
+		return true, nil
+	})
+	assert.Equal(t, 100, count)
+}
+
+func TestBatch_Get_CorruptedIndex(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// point the index to a deleted record
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+	pos, err := db.dataFiles.Write(encodeLogRecord(&LogRecord{Key: []byte("k1"), Type: LogRecordDeleted}))
+	assert.Nil(t, err)
+	db.index.Put([]byte("k1"), pos)
+
+	_, err = db.Get([]byte("k1"))
+	assert.True(t, errors.Is(err, ErrCorruptedIndex))
+	// the database is still usable
+	assert.Nil(t, db.Put([]byte("k2"), []byte("v2")))
+	val, err := db.Get([]byte("k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+}
+
+func TestBatch_MGet(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, db.Put([]byte("k2"), []byte("v2")))
+	assert.Nil(t, db.PutWithTTL([]byte("k3"), []byte("v3"), time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+
+	batch := db.NewBatch(DefaultBatchOptions)
+	defer batch.Discard()
+	assert.Nil(t, batch.Put([]byte("k1"), []byte("new")))
+	assert.Nil(t, batch.Delete([]byte("k2")))
+	assert.Nil(t, batch.Put([]byte("k4"), []byte("v4")))
+
+	keys := [][]byte{[]byte("k1"), []byte("k2"), []byte("k3"), []byte("k4"), []byte("k5")}
+	values, err := batch.MGet(keys)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("new"), nil, nil, []byte("v4"), nil}, values)
+
+	_, err = batch.MGet([][]byte{[]byte("k1"), nil})
+	assert.Equal(t, ErrKeyIsEmpty, err)
+	assert.Nil(t, batch.Commit())
+
+	// the read only batch reads the committed data
+	roOptions := DefaultBatchOptions
+	roOptions.ReadOnly = true
+	batch = db.NewBatch(roOptions)
+	values, err = batch.MGet(keys)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("new"), nil, nil, []byte("v4"), nil}, values)
+	assert.Nil(t, batch.Commit())
+}
+
+func TestBatch_BatchId_Unique(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// the pooled batches and the new batches share the snowflake node of the database
+	for i := 0; i < 1000; i++ {
+		if i%2 == 0 {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("v")))
+			continue
+		}
+		batch := db.NewBatch(DefaultBatchOptions)
+		assert.Nil(t, batch.Put(utils.GetTestKey(i), []byte("v")))
+		assert.Nil(t, batch.Commit())
+	}
+
+	// the batch ids are increasing, even if they are generated in the same millisecond
+	reader := db.dataFiles.NewReader()
+	var prev uint64
+	var batches int
+	for {
+		chunk, _, err := reader.Next()
+		if err != nil {
+			break
+		}
+		record := decodeLogRecord(chunk)
+		if record.Type == LogRecordBatchFinished {
+			continue
+		}
+		assert.True(t, record.BatchId > prev)
+		prev = record.BatchId
+		batches++
+	}
+	assert.Equal(t, 1000, batches)
+}
+
+func TestBatch_Pool_ReusePendingWrites(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	batch := makeBatch().(*Batch)
+	batch.init(false, false, db).withPendingWrites()
+	pendingWrites := reflect.ValueOf(batch.pendingWrites).Pointer()
+	assert.Nil(t, batch.Put([]byte("k1"), []byte("v1")))
+	assert.Nil(t, batch.Commit())
+	batch.reset()
+
+	// the map is cleared and reused by the next write
+	batch.init(false, false, db).withPendingWrites()
+	assert.Equal(t, pendingWrites, reflect.ValueOf(batch.pendingWrites).Pointer())
+	assert.Equal(t, 0, len(batch.pendingWrites))
+	assert.Nil(t, batch.Put([]byte("k2"), []byte("v2")))
+	assert.Nil(t, batch.Commit())
+	batch.reset()
+
+	val, err := db.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+	val, err = db.Get([]byte("k2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+}
+
+func TestBatch_DefaultTTL(t *testing.T) {
+	clock := newFakeClock()
+	options := DefaultOptions
+	options.Clock = clock
+	options.DefaultTTL = time.Hour
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	batchOptions := DefaultBatchOptions
+	batchOptions.DefaultTTL = time.Minute
+	batch := db.NewBatch(batchOptions)
+	assert.Nil(t, batch.Put([]byte("default"), []byte("value")))
+	assert.Nil(t, batch.PutWithTTL([]byte("explicit"), []byte("value"), time.Second))
+	assert.Nil(t, batch.PutWithTTL([]byte("never"), []byte("value"), NoTTL))
+	_, err = batch.GetOrPut([]byte("computed"), func() ([]byte, error) { return []byte("value"), nil })
+	assert.Nil(t, err)
+	assert.Nil(t, batch.Commit())
+
+	for key, expected := range map[string]time.Duration{
+		"default": time.Minute, "explicit": time.Second, "never": NoTTL, "computed": time.Minute,
+	} {
+		ttl, err := db.TTL([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, ttl, key)
+	}
+
+	// the database default is used if the batch has none
+	batch = db.NewBatch(DefaultBatchOptions)
+	assert.Nil(t, batch.Put([]byte("db-default"), []byte("value")))
+	assert.Nil(t, batch.Commit())
+	ttl, err := db.TTL([]byte("db-default"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Hour, ttl)
+}
+
+func TestBatch_OrderedCommit(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	var applied [][]byte
+	batchOptions := DefaultBatchOptions
+	batchOptions.OrderedCommit = true
+	batchOptions.OnCommit = func(committed []KV) {
+		for _, kv := range committed {
+			applied = append(applied, kv.Key)
+		}
+	}
+	batch := db.NewBatch(batchOptions)
+	for _, i := range rand.Perm(1000) {
+		assert.Nil(t, batch.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+	}
+	assert.Nil(t, batch.Commit())
+
+	// the records are written and applied in the order of the keys
+	var written [][]byte
+	reader := db.dataFiles.NewReader()
+	for {
+		chunk, _, err := reader.Next()
+		if err != nil {
+			break
+		}
+		if record := decodeLogRecord(chunk); record.Type != LogRecordBatchFinished {
+			written = append(written, record.Key)
+		}
+	}
+	assert.Equal(t, 1000, len(written))
+	for i := 0; i < 1000; i++ {
+		assert.Equal(t, utils.GetTestKey(i), written[i])
+		assert.Equal(t, utils.GetTestKey(i), applied[i])
+	}
+}
+
+func TestBatch_Ascend(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for _, key := range []string{"b", "d", "f", "h"} {
+		assert.Nil(t, db.Put([]byte(key), []byte("committed-"+key)))
+	}
+
+	batch := db.NewBatch(DefaultBatchOptions)
+	assert.Nil(t, batch.Put([]byte("a"), []byte("pending-a")))
+	assert.Nil(t, batch.Put([]byte("d"), []byte("pending-d")))
+	assert.Nil(t, batch.Delete([]byte("f")))
+	assert.Nil(t, batch.Put([]byte("g"), []byte("pending-g")))
+	assert.Nil(t, batch.Put([]byte("z"), []byte("pending-z")))
+
+	var keys, values []string
+	err = batch.Ascend(func(k []byte, v []byte) (bool, error) {
+		keys = append(keys, string(k))
+		values = append(values, string(v))
+		return true, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "d", "g", "h", "z"}, keys)
+	assert.Equal(t, []string{"pending-a", "committed-b", "pending-d", "pending-g", "committed-h", "pending-z"}, values)
+
+	// stop in the middle
+	keys = nil
+	err = batch.Ascend(func(k []byte, v []byte) (bool, error) {
+		keys = append(keys, string(k))
+		return len(keys) < 3, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "d"}, keys)
+
+	// nothing is committed by iterating
+	assert.Nil(t, batch.Rollback())
+	value, err := db.Get([]byte("f"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("committed-f"), value)
+	_, err = db.Get([]byte("a"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestBatch_Concurrent(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("v0")))
+	batchOptions := DefaultBatchOptions
+	batchOptions.Concurrent = true
+
+	// the batches writing different keys coexist, and both are committed
+	b1 := db.NewBatch(batchOptions)
+	b2 := db.NewBatch(batchOptions)
+	assert.Nil(t, b1.Put([]byte("b"), []byte("v1")))
+	assert.Nil(t, b2.Put([]byte("c"), []byte("v2")))
+	// the other writers are not blocked by the running batches
+	assert.Nil(t, db.Put([]byte("d"), []byte("v3")))
+	assert.Nil(t, b2.Commit())
+	assert.Nil(t, b1.Commit())
+	for _, key := range []string{"b", "c", "d"} {
+		ok, err := db.Exist([]byte(key))
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+
+	// the first committed one of the batches writing the same key wins
+	b1 = db.NewBatch(batchOptions)
+	b2 = db.NewBatch(batchOptions)
+	assert.Nil(t, b1.Put([]byte("a"), []byte("v1")))
+	assert.Nil(t, b2.Put([]byte("a"), []byte("v2")))
+	assert.Nil(t, b2.Commit())
+	assert.Equal(t, ErrConflict, b1.Commit())
+	value, err := db.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), value)
+
+	// the key read by the batch is written by another one
+	b1 = db.NewBatch(batchOptions)
+	value, err = b1.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Nil(t, b1.Put([]byte("b"), value))
+	assert.Nil(t, db.Delete([]byte("a")))
+	assert.Equal(t, ErrConflict, b1.Commit())
+	assert.Equal(t, ErrBatchFailed, b1.Commit())
+
+	// the keys written before the batch is created do not conflict
+	assert.Nil(t, db.Put([]byte("a"), []byte("v4")))
+	b1 = db.NewBatch(batchOptions)
+	assert.Nil(t, b1.Put([]byte("a"), []byte("v5")))
+	assert.Nil(t, b1.Commit())
+
+	// the rolled back batches are unregistered
+	b1 = db.NewBatch(batchOptions)
+	assert.Nil(t, b1.Rollback())
+	db.NewBatch(batchOptions).Discard()
+	assert.Equal(t, 0, db.versions.concurrent)
+	assert.Nil(t, db.versions.written)
+}
+
+func BenchmarkBatch_Concurrent(b *testing.B) {
+	options := DefaultOptions
+	options.Sync = false
+	db, err := Open(options)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer destroyDB(db)
+
+	// each batch reads and updates 10 keys of its own, so the batches never conflict
+	run := func(b *testing.B, batchOptions BatchOptions) {
+		var id int64
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			base := int(atomic.AddInt64(&id, 1)) * 1e6
+			for i := 0; pb.Next(); i++ {
+				batch := db.NewBatch(batchOptions)
+				for j := 0; j < 10; j++ {
+					key := utils.GetTestKey(base + (i*10+j)%1000)
+					_, _ = batch.Get(key)
+					if err := batch.Put(key, utils.RandomValue(128)); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := batch.Commit(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+
+	batchOptions := DefaultBatchOptions
+	batchOptions.Sync = false
+	b.Run("locked", func(b *testing.B) {
+		run(b, batchOptions)
+	})
+	batchOptions.Concurrent = true
+	b.Run("concurrent", func(b *testing.B) {
+		run(b, batchOptions)
+	})
+}
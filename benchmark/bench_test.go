@@ -3,6 +3,7 @@ package benchmark
 import (
 	"math/rand"
 	"os"
+	"strconv"
 	"testing"
 
 	"github.com/rosedblabs/rosedb/v2"
@@ -62,3 +63,55 @@ func bencharkGet(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkOpen(b *testing.B) {
+	options := rosedb.DefaultOptions
+	options.DirPath = "/tmp/rosedbv2-open"
+	options.SegmentSize = 64 * rosedb.MB
+	defer func() {
+		_ = os.RemoveAll(options.DirPath)
+	}()
+
+	db, err := rosedb.Open(options)
+	assert.Nil(b, err)
+	for i := 0; i < 500000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(b, err)
+	}
+	assert.Nil(b, db.Close())
+
+	for _, concurrency := range []int{1, 4} {
+		b.Run("concurrency-"+strconv.Itoa(concurrency), func(b *testing.B) {
+			options.RecoveryConcurrency = concurrency
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				db, err := rosedb.Open(options)
+				assert.Nil(b, err)
+				assert.Nil(b, db.Close())
+			}
+		})
+	}
+}
+
+func BenchmarkCommit(b *testing.B) {
+	closer := openDB()
+	defer closer()
+
+	for _, ordered := range []bool{false, true} {
+		b.Run("ordered-"+strconv.FormatBool(ordered), func(b *testing.B) {
+			options := rosedb.DefaultBatchOptions
+			options.Sync = false
+			options.OrderedCommit = ordered
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				batch := db.NewBatch(options)
+				for j := 0; j < 100000; j++ {
+					assert.Nil(b, batch.Put(utils.GetTestKey(rand.Int()), utils.RandomValue(16)))
+				}
+				b.StartTimer()
+				assert.Nil(b, batch.Commit())
+			}
+		})
+	}
+}
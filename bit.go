@@ -0,0 +1,117 @@
+package rosedb
+
+import "math/bits"
+
+// SetBit sets or clears the bit at offset of the value of the key in the batch,
+// the value is regarded as a bit array, and the bit 0 is the most significant bit of the first byte.
+// The value is extended with zero bytes if offset exceeds it, and it is regarded as empty
+// if the key does not exist. The ttl of the key is kept.
+// It returns ErrNegativeOffset if offset is negative, or ErrValueTooLarge if the extended value
+// exceeds Options.MaxValueSize, or Options.SegmentSize if it is not set.
+func (b *Batch) SetBit(key []byte, offset int, value bool) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if b.readCommitted() {
+		b.db.mu.RLock()
+		defer b.db.mu.RUnlock()
+	}
+	if b.db.closed {
+		return ErrDBClosed
+	}
+	if b.options.ReadOnly {
+		return ErrReadOnlyBatch
+	}
+	if offset < 0 {
+		return ErrNegativeOffset
+	}
+
+	mask := byte(0x80) >> (offset % 8)
+	_, err := b.modifyValue(key, offset/8+1, func(data []byte) {
+		if value {
+			data[offset/8] |= mask
+		} else {
+			data[offset/8] &^= mask
+		}
+	})
+	return err
+}
+
+// GetBit returns the bit at offset of the value of the key in the batch, see SetBit.
+// It returns false if the key does not exist, or offset exceeds the value.
+func (b *Batch) GetBit(key []byte, offset int) (bool, error) {
+	if offset < 0 {
+		return false, ErrNegativeOffset
+	}
+	value, err := b.Get(key)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if offset/8 >= len(value) {
+		return false, nil
+	}
+	return value[offset/8]&(byte(0x80)>>(offset%8)) != 0, nil
+}
+
+// BitCount returns the number of the set bits of the value of the key in the batch,
+// it returns 0 if the key does not exist.
+func (b *Batch) BitCount(key []byte) (int, error) {
+	value, err := b.Get(key)
+	if err == ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, v := range value {
+		count += bits.OnesCount8(v)
+	}
+	return count, nil
+}
+
+// SetBit sets or clears the bit at offset of the value of the key, see Batch.SetBit.
+// Actually, it will open a new batch and commit it, the value is read and written
+// with the database locked, so the concurrent updates of the bits will not be lost.
+func (db *DB) SetBit(key []byte, offset int, value bool) error {
+	batch := db.batchPool.Get().(*Batch)
+	defer func() {
+		batch.reset()
+		db.batchPool.Put(batch)
+	}()
+	batch.init(false, false, db).withPendingWrites()
+	if err := batch.SetBit(key, offset, value); err != nil {
+		_ = batch.Rollback()
+		return err
+	}
+	return batch.Commit()
+}
+
+// GetBit returns the bit at offset of the value of the key, see Batch.GetBit.
+// Actually, it will open a new batch and commit it.
+func (db *DB) GetBit(key []byte, offset int) (bool, error) {
+	batch := db.batchPool.Get().(*Batch)
+	batch.init(true, false, db)
+	defer func() {
+		_ = batch.Commit()
+		batch.reset()
+		db.batchPool.Put(batch)
+	}()
+	return batch.GetBit(key, offset)
+}
+
+// BitCount returns the number of the set bits of the value of the key, see Batch.BitCount.
+// Actually, it will open a new batch and commit it.
+func (db *DB) BitCount(key []byte) (int, error) {
+	batch := db.batchPool.Get().(*Batch)
+	batch.init(true, false, db)
+	defer func() {
+		_ = batch.Commit()
+		batch.reset()
+		db.batchPool.Put(batch)
+	}()
+	return batch.BitCount(key)
+}
@@ -0,0 +1,79 @@
+package rosedb
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_SetBit_GetBit(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	bit, err := db.GetBit([]byte("bits"), 10)
+	assert.Nil(t, err)
+	assert.False(t, bit)
+
+	// the value grows as needed, the bit 0 is the most significant bit
+	assert.Nil(t, db.SetBit([]byte("bits"), 0, true))
+	assert.Nil(t, db.SetBit([]byte("bits"), 10, true))
+	val, err := db.Get([]byte("bits"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x80, 0x20}, val)
+	for offset, expected := range map[int]bool{0: true, 1: false, 10: true, 11: false, 100: false} {
+		bit, err = db.GetBit([]byte("bits"), offset)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, bit)
+	}
+
+	// clearing a bit keeps the length of the value
+	assert.Nil(t, db.SetBit([]byte("bits"), 10, false))
+	val, err = db.Get([]byte("bits"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x80, 0}, val)
+
+	assert.Equal(t, ErrNegativeOffset, db.SetBit([]byte("bits"), -1, true))
+	_, err = db.GetBit([]byte("bits"), -1)
+	assert.Equal(t, ErrNegativeOffset, err)
+	// the huge offset is rejected without MaxValueSize
+	for _, offset := range []int{int(options.SegmentSize) * 8, math.MaxInt} {
+		assert.Equal(t, ErrValueTooLarge, db.SetBit([]byte("bits"), offset, true))
+	}
+
+	// the ttl is kept
+	assert.Nil(t, db.PutWithTTL([]byte("ttl"), []byte{0}, time.Hour))
+	assert.Nil(t, db.SetBit([]byte("ttl"), 20, true))
+	ttl, err := db.TTL([]byte("ttl"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 59*time.Minute)
+}
+
+func TestDB_BitCount(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	count, err := db.BitCount([]byte("bits"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+
+	// the concurrent updates of the bits are not lost
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(offset int) {
+			defer wg.Done()
+			assert.Nil(t, db.SetBit([]byte("bits"), offset*3, true))
+		}(i)
+	}
+	wg.Wait()
+	count, err = db.BitCount([]byte("bits"))
+	assert.Nil(t, err)
+	assert.Equal(t, 100, count)
+}
@@ -0,0 +1,208 @@
+package rosedb
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"math/bits"
+
+	"github.com/rosedblabs/wal"
+)
+
+// ChecksumType is the algorithm of the checksum appended to the records, see Options.ChecksumType.
+type ChecksumType = byte
+
+const (
+	// ChecksumNone appends no checksum, the records are only verified by the crc of the wal chunks.
+	ChecksumNone ChecksumType = iota
+	// ChecksumCRC32 appends the CRC-32 checksum with the IEEE polynomial.
+	ChecksumCRC32
+	// ChecksumCRC32C appends the CRC-32 checksum with the Castagnoli polynomial,
+	// which is computed by the hardware instructions on most CPUs.
+	ChecksumCRC32C
+	// ChecksumXXHash64 appends the 64 bits xxHash with the seed 0.
+	ChecksumXXHash64
+
+	// maxChecksumType is the largest valid ChecksumType.
+	maxChecksumType = ChecksumXXHash64
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumSize returns the size of the checksum of the algorithm.
+func checksumSize(checksumType ChecksumType) int {
+	if checksumType == ChecksumXXHash64 {
+		return 8
+	}
+	return 4
+}
+
+// appendChecksum appends the checksum of the encoded record in buf and the algorithm to buf.
+//
+// +----------------+--------------+-------------+
+// | encoded record |   checksum   |  algorithm  |
+// +----------------+--------------+-------------+
+//
+//	n bytes       4 or 8 bytes     1 byte
+//
+// The checksum is after the value, so it is ignored when decoding the record,
+// and the records without the checksum are still readable.
+func appendChecksum(buf []byte, checksumType ChecksumType) []byte {
+	switch checksumType {
+	case ChecksumCRC32:
+		buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+	case ChecksumCRC32C:
+		buf = binary.LittleEndian.AppendUint32(buf, crc32.Checksum(buf, crc32cTable))
+	case ChecksumXXHash64:
+		buf = binary.LittleEndian.AppendUint64(buf, xxHash64(buf))
+	}
+	return append(buf, checksumType)
+}
+
+// verifyChecksum verifies the checksum appended to the encoded record by appendChecksum,
+// it returns ErrInvalidChecksum if the checksum does not match, or the algorithm is unknown.
+// The record without the checksum is regarded as valid.
+func verifyChecksum(buf []byte) error {
+	header := decodeLogRecordHeader(buf)
+	end := int(header.size) + int(header.keySize) + int(header.valueSize)
+	if end >= len(buf) {
+		return nil
+	}
+
+	checksumType := buf[len(buf)-1]
+	if checksumType == ChecksumNone || checksumType > maxChecksumType ||
+		len(buf)-end != checksumSize(checksumType)+1 {
+		return ErrInvalidChecksum
+	}
+	record, checksum := buf[:end], buf[end:len(buf)-1]
+	var valid bool
+	switch checksumType {
+	case ChecksumCRC32:
+		valid = crc32.ChecksumIEEE(record) == binary.LittleEndian.Uint32(checksum)
+	case ChecksumCRC32C:
+		valid = crc32.Checksum(record, crc32cTable) == binary.LittleEndian.Uint32(checksum)
+	case ChecksumXXHash64:
+		valid = xxHash64(record) == binary.LittleEndian.Uint64(checksum)
+	}
+	if !valid {
+		return ErrInvalidChecksum
+	}
+	return nil
+}
+
+// checksumWAL appends the checksum of Options.ChecksumType to the records written to the data files,
+// and verifies the checksums of the records read from them.
+// The records are read with their checksums, which are ignored when decoding the records,
+// so the records replicated by ReplicationStream keep them.
+type checksumWAL struct {
+	WAL
+	checksumType ChecksumType
+}
+
+func (w *checksumWAL) Write(data []byte) (*wal.ChunkPosition, error) {
+	if w.checksumType == ChecksumNone {
+		return w.WAL.Write(data)
+	}
+	buf := encodeBufferPool.Get().(*[]byte)
+	*buf = appendChecksum(append((*buf)[:0], data...), w.checksumType)
+	pos, err := w.WAL.Write(*buf)
+	putEncodeBuffer(buf)
+	return pos, err
+}
+
+func (w *checksumWAL) Read(pos *wal.ChunkPosition) ([]byte, error) {
+	data, err := w.WAL.Read(pos)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifyChecksum(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (w *checksumWAL) NewReader() WALReader {
+	return checksumWALReader{WALReader: w.WAL.NewReader()}
+}
+
+func (w *checksumWAL) NewReaderWithMax(segId wal.SegmentID) WALReader {
+	return checksumWALReader{WALReader: w.WAL.NewReaderWithMax(segId)}
+}
+
+// checksumWALReader verifies the checksums of the records read by WALReader.
+type checksumWALReader struct {
+	WALReader
+}
+
+func (r checksumWALReader) Next() ([]byte, *wal.ChunkPosition, error) {
+	data, pos, err := r.WALReader.Next()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = verifyChecksum(data); err != nil {
+		return nil, nil, err
+	}
+	return data, pos, nil
+}
+
+// the primes of xxHash64, they are variables so the additions wrap around like the reference implementation.
+var (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// xxHash64 returns the 64 bits xxHash of b with the seed 0.
+func xxHash64(b []byte) uint64 {
+	n := len(b)
+	var h uint64
+	if n >= 32 {
+		v1, v2, v3, v4 := xxPrime1+xxPrime2, xxPrime2, uint64(0), -xxPrime1
+		for ; len(b) >= 32; b = b[32:] {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(b[0:8]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(b[8:16]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(b[16:24]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(b[24:32]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMergeRound(h, v1)
+		h = xxMergeRound(h, v2)
+		h = xxMergeRound(h, v3)
+		h = xxMergeRound(h, v4)
+	} else {
+		h = xxPrime5
+	}
+	h += uint64(n)
+
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(b[:8]))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b[:4])) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	return bits.RotateLeft64(acc, 31) * xxPrime1
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	acc ^= xxRound(0, val)
+	return acc*xxPrime1 + xxPrime4
+}
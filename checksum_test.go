@@ -0,0 +1,78 @@
+package rosedb
+
+import (
+	"testing"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/rosedblabs/wal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXXHash64(t *testing.T) {
+	assert.Equal(t, uint64(0xef46db3751d8e999), xxHash64(nil))
+	assert.Equal(t, uint64(0x44bc2cf5ad770999), xxHash64([]byte("abc")))
+	assert.Equal(t, uint64(0xfbcea83c8a378bf1), xxHash64([]byte("Nobody inspects the spammish repetition")))
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	record := encodeLogRecord(&LogRecord{Key: []byte("key"), Value: utils.RandomValue(100), BatchId: 1})
+	// the record without the checksum is valid
+	assert.Nil(t, verifyChecksum(record))
+
+	for _, checksumType := range []ChecksumType{ChecksumCRC32, ChecksumCRC32C, ChecksumXXHash64} {
+		buf := appendChecksum(append([]byte(nil), record...), checksumType)
+		assert.Equal(t, len(record)+checksumSize(checksumType)+1, len(buf))
+		assert.Nil(t, verifyChecksum(buf))
+		// the checksum is ignored when decoding
+		assert.Equal(t, decodeLogRecord(record), decodeLogRecord(buf))
+
+		// corrupt the value
+		buf[len(record)-1]++
+		assert.Equal(t, ErrInvalidChecksum, verifyChecksum(buf))
+		buf[len(record)-1]--
+
+		// unknown algorithm
+		buf[len(buf)-1] = maxChecksumType + 1
+		assert.Equal(t, ErrInvalidChecksum, verifyChecksum(buf))
+	}
+}
+
+func TestDB_ChecksumType(t *testing.T) {
+	options := DefaultOptions
+	memFiles := newMemWAL()
+	options.WALBackend = func(wal.Options) (WAL, error) {
+		return memFiles, nil
+	}
+
+	// the records written by each algorithm are still verified after it is changed
+	checksumTypes := []ChecksumType{ChecksumNone, ChecksumCRC32, ChecksumCRC32C, ChecksumXXHash64, ChecksumNone}
+	for i, checksumType := range checksumTypes {
+		options.ChecksumType = checksumType
+		db, err := Open(options)
+		assert.Nil(t, err)
+		for j := i * 100; j < (i+1)*100; j++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(j), utils.RandomValue(128)))
+		}
+		assert.Equal(t, (i+1)*100, db.Stat().KeysNum)
+		for j := 0; j < (i+1)*100; j++ {
+			_, err = db.Get(utils.GetTestKey(j))
+			assert.Nil(t, err)
+		}
+		if i < len(checksumTypes)-1 {
+			assert.Nil(t, db.Close())
+			continue
+		}
+
+		// corrupt the value of a record with the checksum
+		pos := db.index.Get(utils.GetTestKey(150))
+		chunk := memFiles.segments[pos.SegmentId-1][pos.ChunkOffset]
+		chunk[len(chunk)-checksumSize(ChecksumCRC32)-2]++
+		_, err = db.Get(utils.GetTestKey(150))
+		assert.Equal(t, ErrInvalidChecksum, err)
+		destroyDB(db)
+	}
+
+	options.ChecksumType = maxChecksumType + 1
+	_, err := Open(options)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,16 @@
+package rosedb
+
+import "time"
+
+// Clock provides the current time for the expiration of the keys, see Options.Clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// now returns the current time of Options.Clock, or the real time if it is not set.
+func (db *DB) now() time.Time {
+	if db.options.Clock != nil {
+		return db.options.Clock.Now()
+	}
+	return time.Now()
+}
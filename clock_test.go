@@ -0,0 +1,66 @@
+package rosedb
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a clock which only moves when advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Now()}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestDB_Clock(t *testing.T) {
+	clock := newFakeClock()
+	options := DefaultOptions
+	options.Clock = clock
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.PutWithTTL(utils.GetTestKey(1), []byte("value"), time.Hour))
+	assert.Nil(t, db.Put(utils.GetTestKey(2), []byte("value")))
+	assert.Nil(t, db.Expire(utils.GetTestKey(2), 2*time.Hour))
+	ttl, err := db.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Hour, ttl)
+
+	clock.Advance(time.Hour - time.Second)
+	ttl, err = db.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Second, ttl)
+
+	// the keys expire by the clock, not the real time
+	clock.Advance(time.Second)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assertKeyExistOrNot(t, db, utils.GetTestKey(2), true)
+
+	// the expired keys are not loaded when reopening
+	assert.Nil(t, db.Close())
+	clock.Advance(time.Hour)
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, db.Stat().KeysNum)
+}
@@ -0,0 +1,67 @@
+package rosedb
+
+const (
+	// maxValueCodecs is the maximum number of the codecs in Options.ValueCodec,
+	// one bit of the type byte of the record is used for each codec.
+	maxValueCodecs = 4
+	// recordTypeMask is the mask of the record type in the type byte of the record,
+	// the higher bits are the flags of the codecs applied to the value, see encodeLogRecord.
+	recordTypeMask = 0x0f
+	// codecFlagsShift is the offset of the codec flags in the type byte of the record.
+	codecFlagsShift = 4
+)
+
+// Codec transforms the values written to the database, such as compression and encryption,
+// see Options.ValueCodec.
+type Codec interface {
+	// Encode returns the transformed value, it must not modify the value.
+	Encode(value []byte) ([]byte, error)
+	// Decode reverses Encode, it must not modify the value.
+	Decode(value []byte) ([]byte, error)
+}
+
+// encodeValue applies the codecs of Options.ValueCodec to the value of the record in order,
+// and returns a new record with the encoded value and the flags of the applied codecs,
+// which should be written to the data files instead.
+// The record whose value has been encoded is returned as it is.
+func (db *DB) encodeValue(record *LogRecord) (*LogRecord, error) {
+	if record.Type != LogRecordNormal || record.codecs != 0 || len(db.options.ValueCodec) == 0 {
+		return record, nil
+	}
+
+	value := record.Value
+	var codecs byte
+	for i, codec := range db.options.ValueCodec {
+		var err error
+		if value, err = codec.Encode(value); err != nil {
+			return nil, err
+		}
+		codecs |= 1 << i
+	}
+	return &LogRecord{
+		Key:     record.Key,
+		Value:   value,
+		Type:    record.Type,
+		BatchId: record.BatchId,
+		Expire:  record.Expire,
+		codecs:  codecs,
+	}, nil
+}
+
+// decodeValue reverses the codecs recorded in the flags in the reverse order.
+// It returns ErrCodecNotFound if a codec is not in Options.ValueCodec any more.
+func (db *DB) decodeValue(value []byte, codecs byte) ([]byte, error) {
+	for i := maxValueCodecs - 1; i >= 0; i-- {
+		if codecs&(1<<i) == 0 {
+			continue
+		}
+		if i >= len(db.options.ValueCodec) {
+			return nil, ErrCodecNotFound
+		}
+		var err error
+		if value, err = db.options.ValueCodec[i].Decode(value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
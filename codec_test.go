@@ -0,0 +1,153 @@
+package rosedb
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// xorCodec flips the bits of the value.
+type xorCodec struct{}
+
+func (xorCodec) Encode(value []byte) ([]byte, error) {
+	return xorValue(value), nil
+}
+
+func (xorCodec) Decode(value []byte) ([]byte, error) {
+	return xorValue(value), nil
+}
+
+func xorValue(value []byte) []byte {
+	buf := make([]byte, len(value))
+	for i, b := range value {
+		buf[i] = b ^ 0xff
+	}
+	return buf
+}
+
+// prefixCodec prefixes the value, so the order of the codecs matters.
+type prefixCodec struct{}
+
+func (prefixCodec) Encode(value []byte) ([]byte, error) {
+	return append([]byte("prefix:"), value...), nil
+}
+
+func (prefixCodec) Decode(value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, []byte("prefix:")) {
+		return nil, ErrKeyNotFound
+	}
+	return value[len("prefix:"):], nil
+}
+
+func TestDB_ValueCodec(t *testing.T) {
+	options := DefaultOptions
+	options.ValueCodec = []Codec{prefixCodec{}, xorCodec{}}
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("value")))
+	}
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+
+	// the value is encoded in the data files
+	chunk, err := db.dataFiles.Read(db.index.Get(utils.GetTestKey(1)))
+	assert.Nil(t, err)
+	record := decodeLogRecord(chunk)
+	assert.Equal(t, LogRecordNormal, record.Type)
+	assert.Equal(t, byte(0b11), record.codecs)
+	assert.Equal(t, xorValue([]byte("prefix:value")), record.Value)
+
+	// the value rewritten with a new ttl is encoded once
+	assert.Nil(t, db.Expire(utils.GetTestKey(1), time.Hour))
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+
+	assert.Nil(t, db.Merge(true))
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		val, err = db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("value"), val)
+	}
+}
+
+func TestDB_ValueCodec_Mixed(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.Put([]byte("plain"), []byte("value-1")))
+	assert.Nil(t, db.Close())
+
+	// the values written before the codecs are added are still readable
+	options.ValueCodec = []Codec{xorCodec{}}
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("xor"), []byte("value-2")))
+	val, err := db.Get([]byte("plain"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-1"), val)
+	assert.Nil(t, db.Close())
+
+	// the codecs can be appended
+	options.ValueCodec = []Codec{xorCodec{}, prefixCodec{}}
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("both"), []byte("value-3")))
+	for key, value := range map[string]string{"plain": "value-1", "xor": "value-2", "both": "value-3"} {
+		val, err = db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(value), val)
+	}
+	assert.Nil(t, db.Close())
+
+	// the codec of the value is removed
+	options.ValueCodec = nil
+	db, err = Open(options)
+	assert.Nil(t, err)
+	_, err = db.Get([]byte("xor"))
+	assert.Equal(t, ErrCodecNotFound, err)
+	val, err = db.Get([]byte("plain"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-1"), val)
+}
+
+func TestDB_ValueCodec_ValueLog(t *testing.T) {
+	options := DefaultOptions
+	options.SeparateValues = true
+	options.ValueCodec = []Codec{prefixCodec{}}
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 100; i++ {
+		values[string(utils.GetTestKey(i))] = utils.RandomValue(KB)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[string(utils.GetTestKey(i))]))
+	}
+	assert.Nil(t, db.ValueLogGC())
+	assert.Nil(t, db.Merge(true))
+	for key, value := range values {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
+
+func TestDB_ValueCodec_TooMany(t *testing.T) {
+	options := DefaultOptions
+	options.ValueCodec = []Codec{xorCodec{}, xorCodec{}, xorCodec{}, xorCodec{}, xorCodec{}}
+	_, err := Open(options)
+	assert.NotNil(t, err)
+}
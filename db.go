@@ -1,13 +1,18 @@
 package rosedb
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bwmarrin/snowflake"
@@ -38,17 +43,45 @@ const (
 //
 // So if your memory can almost hold all the keys, ROSEDB is the perfect storage engine for you.
 type DB struct {
-	dataFiles    *wal.WAL // data files are a sets of segment files in WAL.
-	hintFile     *wal.WAL // hint file is used to store the key and the position for fast startup.
-	index        index.Indexer
-	options      Options
-	fileLock     *flock.Flock
-	mu           sync.RWMutex
-	closed       bool
-	mergeRunning uint32 // indicate if the database is merging
-	batchPool    sync.Pool
-	watchCh      chan *Event // user consume channel for watch events
-	watcher      *Watcher
+	dataFiles          WAL      // data files are a sets of segment files in WAL.
+	hintFile           *wal.WAL // hint file is used to store the key and the position for fast startup.
+	valueLogFiles      *wal.WAL // value log files store the large values separated from the data files.
+	index              index.Indexer
+	options            Options
+	fileLock           *flock.Flock
+	node               *snowflake.Node // generate the batch ids, see Options.NodeID
+	mu                 sync.RWMutex
+	closed             bool
+	mergeRunning       uint32 // indicate if the database is merging
+	batchPool          sync.Pool
+	watchCh            chan *Event // user consume channel for watch events
+	watcher            *Watcher
+	subscriptions      map[*Subscription]struct{} // the subscriptions created by Subscribe
+	unsyncedBytes      int64                      // the bytes committed since the last background sync
+	dirtyBytes         int64                      // the bytes committed but not synced yet, see Stat.UnsyncedBytes
+	lastSyncAt         int64                      // the unix nanoseconds of the last successful sync
+	syncCh             chan struct{}              // notify the background goroutine to sync the files
+	writtenSeq         uint64                     // the number of the batches committed, see WaitForSync
+	syncedSeq          uint64                     // the writtenSeq when the files are synced last time
+	waitSyncMu         sync.Mutex                 // serialize WaitForSync, so the waiters share one sync
+	dataBytes          int64                      // the size of the data files
+	garbageBytes       int64                      // the size of the records in the data files not referenced by the index
+	mergeCh            chan struct{}              // notify the background goroutine to merge, see Options.AutoMergeThreshold
+	closeCh            chan struct{}              // closed to notify the background goroutines and running tasks to stop
+	closeOnce          sync.Once                  // make sure closeCh is closed only once
+	bgWg               sync.WaitGroup             // wait for the background goroutines to exit
+	secondaryIndexes   map[string]*secondaryIndex // secondary indexes created by CreateIndex
+	keyLRU             *keyLRU                    // track the size and recency of keys if MaxTotalSize is set
+	accessTracker      *accessTracker             // track the access stats of keys if TrackAccess is true
+	slidingKeys        *slidingKeys               // the keys whose ttl is extended by Get if SlidingExpiration is set
+	replicationMu      sync.Mutex
+	replicationBatches map[uint64][]*LogRecord     // the uncommitted batches applied by Apply
+	versions           *keyVersions                // the versions of keys for the optimistic transactions
+	formatVersion      byte                        // the format version of the data files
+	recoveryRewrites   map[string]*recoveryRewrite // the records changed by Options.RecoveryTransform during Open
+	// the active data file and value log file when the directory was synced last time, see syncDirIfRotated
+	dirSyncedSegId         wal.SegmentID
+	dirSyncedValueLogSegId wal.SegmentID
 }
 
 // Stat represents the statistics of the database.
@@ -57,6 +90,16 @@ type Stat struct {
 	KeysNum int
 	// Total disk size of database directory
 	DiskSize int64
+	// The ratio of the size of the records in the data files which can be reclaimed by merge,
+	// such as the overwritten and deleted records, see Options.AutoMergeThreshold.
+	GarbageRatio float64
+	// The size of the data files, the writes are stalled if it exceeds Options.MaxWALSize.
+	WALSize int64
+	// The time of the last successful sync of the files, it is zero if they are not synced since opened.
+	LastSyncAt time.Time
+	// The bytes committed but not synced yet, which may be lost if the machine crashes,
+	// it is always 0 if Options.Sync is true.
+	UnsyncedBytes int64
 }
 
 // Open a database with the specified options.
@@ -89,64 +132,453 @@ func Open(options Options) (*DB, error) {
 	if !hold {
 		return nil, ErrDatabaseIsUsing
 	}
+	// release the file lock if the database is not opened, even by a panic,
+	// so the database can be opened again.
+	opened := false
+	defer func() {
+		if !opened {
+			_ = fileLock.Unlock()
+		}
+	}()
 
-	// load merge files if exists
-	if err = loadMergeFiles(options.DirPath); err != nil {
+	// remove the values of PutWriter which were not committed before the last close
+	if err = removeValueSpoolFiles(options.DirPath); err != nil {
+		return nil, err
+	}
+
+	// check the format version of the data files
+	formatVersion, err := loadFormatVersion(options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	if formatVersion, err = raiseFormatVersion(options.DirPath, formatVersion, options); err != nil {
 		return nil, err
 	}
 
 	// init DB instance
 	db := &DB{
-		index:     index.NewIndexer(),
-		options:   options,
-		fileLock:  fileLock,
-		batchPool: sync.Pool{New: makeBatch},
+		options:       options,
+		fileLock:      fileLock,
+		batchPool:     sync.Pool{New: makeBatch},
+		closeCh:       make(chan struct{}),
+		syncCh:        make(chan struct{}, 1),
+		mergeCh:       make(chan struct{}, 1),
+		versions:      newKeyVersions(),
+		formatVersion: formatVersion,
+	}
+	if options.RecoveryTransform != nil {
+		db.recoveryRewrites = make(map[string]*recoveryRewrite)
 	}
 
-	// open data files
-	if db.dataFiles, err = db.openWalFiles(); err != nil {
+	// all the batches share the snowflake node, so the batch ids are unique and increasing
+	if db.node, err = snowflake.NewNode(options.NodeID); err != nil {
 		return nil, err
 	}
 
-	// load index
-	if err = db.loadIndex(); err != nil {
+	// load merge files, open the data files and load the index
+	if err = db.load(); err != nil {
+		// release the opened files, so the database can be opened again
+		if db.dataFiles != nil {
+			_ = db.closeFiles()
+		}
 		return nil, err
 	}
+	if options.TrackAccess {
+		db.accessTracker = newAccessTracker()
+	}
+	if options.SlidingExpiration > 0 {
+		db.slidingKeys = newSlidingKeys()
+	}
 
 	// enable watch
 	if options.WatchQueueSize > 0 {
 		db.watchCh = make(chan *Event, 100)
 		db.watcher = NewWatcher(options.WatchQueueSize)
-		// run a goroutine to synchronize event information
-		go db.watcher.sendEvent(db.watchCh)
 	}
+	// write back the records changed by Options.RecoveryTransform
+	if err = db.applyRecoveryRewrites(); err != nil {
+		_ = db.closeFiles()
+		return nil, err
+	}
+	db.startBackground()
 
+	opened = true
 	return db, nil
 }
 
-func (db *DB) openWalFiles() (*wal.WAL, error) {
+// load loads the merge files if exists, opens the data files and the value log files,
+// and rebuilds the index from them.
+func (db *DB) load() error {
+	// load merge files if exists
+	if err := loadMergeFiles(db.options.DirPath); err != nil {
+		return err
+	}
+
+	var err error
+	db.index = db.newIndex()
+	// open data files
+	if db.dataFiles, err = db.openWalFiles(); err != nil {
+		return err
+	}
+
+	// open value log files
+	if db.valueLogFiles, err = db.openValueLogFiles(); err != nil {
+		return err
+	}
+
+	// load index
+	if err = db.loadIndex(); err != nil {
+		return err
+	}
+	if err = db.loadGarbage(); err != nil {
+		return err
+	}
+
+	// track the keys for eviction
+	if db.options.MaxTotalSize > 0 {
+		if err = db.loadKeyLRU(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The names of the background tasks passed to Options.OnBackgroundError.
+const (
+	BackgroundTaskWatch       = "watch"       // send the events to the watch channel
+	BackgroundTaskSync        = "sync"        // sync the files, see Options.BytesPerSync
+	BackgroundTaskMerge       = "merge"       // merge the data files, see Options.AutoMergeThreshold
+	BackgroundTaskReplication = "replication" // read the records for ReplicationStream
+	BackgroundTaskSubscribe   = "subscribe"   // send the events to a subscription, see Subscribe
+	BackgroundTaskKeys        = "keys"        // send the keys to the channel of KeysChan
+	BackgroundTaskSliding     = "sliding"     // extend the ttl of the keys read, see Options.SlidingExpiration
+	BackgroundTaskReplica     = "replica"     // refresh the replica, see OpenReadOnlyReplica
+	BackgroundTaskEvict       = "evict"       // evict the keys after a commit, see Options.MaxTotalSize
+)
+
+// startBackground starts the background goroutines, they will exit when closeCh is closed.
+func (db *DB) startBackground() {
+	if db.options.WatchQueueSize > 0 {
+		// run a goroutine to synchronize event information
+		closeCh := db.closeCh
+		db.goBackground(BackgroundTaskWatch, func() {
+			db.watcher.sendEvent(db.watchCh, closeCh)
+		})
+	}
+	if !db.options.Sync && db.options.BytesPerSync > 0 {
+		// run a goroutine to sync the files after BytesPerSync bytes are committed
+		closeCh := db.closeCh
+		db.goBackground(BackgroundTaskSync, func() {
+			db.syncInBackground(closeCh)
+		})
+	}
+	if db.options.AutoMergeThreshold > 0 {
+		// run a goroutine to merge the data files when the garbage ratio exceeds AutoMergeThreshold
+		closeCh := db.closeCh
+		db.goBackground(BackgroundTaskMerge, func() {
+			db.autoMergeInBackground(closeCh)
+		})
+	}
+	if db.options.SlidingExpiration > 0 {
+		// run a goroutine to rewrite the expiry times extended by Get
+		closeCh := db.closeCh
+		db.goBackground(BackgroundTaskSliding, func() {
+			db.slideInBackground(closeCh)
+		})
+	}
+}
+
+// goBackground runs fn in a goroutine tracked by bgWg, a panic of fn is recovered
+// and reported to Options.OnBackgroundError if it is set.
+func (db *DB) goBackground(task string, fn func()) {
+	db.bgWg.Add(1)
+	go func() {
+		defer db.bgWg.Done()
+		if db.options.OnBackgroundError != nil {
+			defer func() {
+				if r := recover(); r != nil {
+					db.backgroundError(task, fmt.Errorf("rosedb: background task %s panicked: %v", task, r))
+				}
+			}()
+		}
+		fn()
+	}()
+}
+
+// backgroundError reports the error of the background task to Options.OnBackgroundError.
+func (db *DB) backgroundError(task string, err error) {
+	if err != nil && db.options.OnBackgroundError != nil {
+		db.options.OnBackgroundError(task, err)
+	}
+}
+
+// syncInBackground syncs the files when notified by addUnsyncedBytes, until closeCh is closed.
+func (db *DB) syncInBackground(closeCh <-chan struct{}) {
+	for {
+		select {
+		case <-closeCh:
+			return
+		case <-db.syncCh:
+			db.backgroundError(BackgroundTaskSync, db.syncInBackgroundOnce())
+		}
+	}
+}
+
+// syncInBackgroundOnce syncs the files for syncInBackground, the database is not locked during syncing,
+// so only the writes to the files being synced wait for the wal.
+func (db *DB) syncInBackgroundOnce() error {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return nil
+	}
+	dataFiles, valueLogFiles := db.dataFiles, db.valueLogFiles
+	db.mu.RUnlock()
+
+	if err := db.syncWalFiles(dataFiles, valueLogFiles); err != nil {
+		// the files may be closed and replaced by Vacuum or Close while syncing, they are synced before closing.
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		if db.closed || db.dataFiles != dataFiles || db.valueLogFiles != valueLogFiles {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// addUnsyncedBytes adds the committed bytes, and notifies the background goroutine
+// to sync the files if they reach Options.BytesPerSync, the counter is reset then.
+func (db *DB) addUnsyncedBytes(n int64) {
+	seq := atomic.AddUint64(&db.writtenSeq, 1)
+	// every write is synced by the wal
+	if db.options.Sync {
+		atomic.StoreUint64(&db.syncedSeq, seq)
+		atomic.StoreInt64(&db.lastSyncAt, time.Now().UnixNano())
+		return
+	}
+	atomic.AddInt64(&db.dirtyBytes, n)
+	if db.options.BytesPerSync == 0 {
+		return
+	}
+	if atomic.AddInt64(&db.unsyncedBytes, n) < int64(db.options.BytesPerSync) {
+		return
+	}
+	atomic.StoreInt64(&db.unsyncedBytes, 0)
+	select {
+	case db.syncCh <- struct{}{}:
+	default:
+		// a sync is pending, the bytes will be synced by it
+	}
+}
+
+// restartBackground replaces closeCh closed by stopBackground, and starts the background goroutines again.
+// It must be called with the database locked, the stopped tasks only read the closeCh captured when they started.
+func (db *DB) restartBackground() {
+	db.closeCh = make(chan struct{})
+	db.closeOnce = sync.Once{}
+	db.startBackground()
+}
+
+// stopBackground notifies the background goroutines and the running tasks to stop,
+// and waits at most timeout for them to finish, it waits until they finish if timeout is less than or equal to 0.
+func (db *DB) stopBackground(timeout time.Duration) error {
+	// closeCh is replaced by Reopen with the database locked, and the tasks are started after checking it
+	// with the database locked, so no task starts after it is closed.
+	db.mu.Lock()
+	db.closeOnce.Do(func() {
+		close(db.closeCh)
+	})
+	db.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		db.bgWg.Wait()
+		for atomic.LoadUint32(&db.mergeRunning) == 1 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		close(done)
+	}()
+	if timeout > 0 {
+		select {
+		case <-done:
+		case <-time.After(timeout):
+			return ErrCloseTimeout
+		}
+	} else {
+		<-done
+	}
+	return nil
+}
+
+// Reopen closes the database and opens the same directory again with the same options,
+// without releasing the file lock, so no other process can open the database in between.
+//
+// The background goroutines and the running Merge or ValueLogGC will be stopped,
+// and all data files will be synced before reopening, the merge files will be loaded if exist.
+// The replication streams and the subscriptions are closed, and the watch channel is kept.
+// If the database fails to be reopened, the error will be returned and
+// the current data files and index are kept, so the database can still be used.
+// The merge files may have been loaded into the directory then, the current data files
+// still read the replaced files by their open handles, and they are loaded by the next Reopen or Open.
+//
+// The state not loaded from the files is kept, such as the versions of the keys and the batch id generator.
+func (db *DB) Reopen() error {
+	db.mu.RLock()
+	if db.closed || db.isClosing() {
+		db.mu.RUnlock()
+		return ErrDBClosed
+	}
+	db.mu.RUnlock()
+
+	_ = db.stopBackground(0)
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// restart the background goroutines whether reopening succeeds or not,
+	// closeCh is replaced with the database locked after the tasks reading it without the lock exited.
+	defer db.restartBackground()
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	if db.options.PersistIndex {
+		if err := db.saveIndexSnapshot(); err != nil {
+			return err
+		}
+	}
+	if err := db.syncFiles(); err != nil {
+		return err
+	}
+
+	// load the files by the same path as Open, the current ones are restored if it fails.
+	current := db.loadedState()
+	db.dataFiles, db.hintFile, db.valueLogFiles, db.keyLRU = nil, nil, nil, nil
+	if err := db.load(); err != nil {
+		if db.dataFiles != nil {
+			_ = db.closeFiles()
+		}
+		db.restoreState(current)
+		return err
+	}
+	current.closeFiles()
+	return nil
+}
+
+// loadedState is the state of the database loaded from the files by load.
+type loadedState struct {
+	dataFiles     WAL
+	hintFile      *wal.WAL
+	valueLogFiles *wal.WAL
+	index         index.Indexer
+	keyLRU        *keyLRU
+	dataBytes     int64
+	garbageBytes  int64
+}
+
+// loadedState returns the state of the database loaded from the files,
+// it must be called with the database locked.
+func (db *DB) loadedState() *loadedState {
+	return &loadedState{
+		dataFiles:     db.dataFiles,
+		hintFile:      db.hintFile,
+		valueLogFiles: db.valueLogFiles,
+		index:         db.index,
+		keyLRU:        db.keyLRU,
+		dataBytes:     db.dataBytes,
+		garbageBytes:  db.garbageBytes,
+	}
+}
+
+// restoreState restores the state returned by loadedState, it must be called with the database locked.
+func (db *DB) restoreState(state *loadedState) {
+	db.dataFiles = state.dataFiles
+	db.hintFile = state.hintFile
+	db.valueLogFiles = state.valueLogFiles
+	db.index = state.index
+	db.keyLRU = state.keyLRU
+	db.dataBytes = state.dataBytes
+	db.garbageBytes = state.garbageBytes
+}
+
+// closeFiles closes the files of the state replaced by the reloaded ones.
+func (state *loadedState) closeFiles() {
+	_ = state.dataFiles.Close()
+	if state.hintFile != nil {
+		_ = state.hintFile.Close()
+	}
+	if state.valueLogFiles != nil {
+		_ = state.valueLogFiles.Close()
+	}
+}
+
+func (db *DB) openWalFiles() (WAL, error) {
+	open := openWAL
+	if db.options.WALBackend != nil {
+		open = db.options.WALBackend
+	}
 	// open data files from WAL
-	walFiles, err := wal.Open(wal.Options{
+	walFiles, err := open(wal.Options{
 		DirPath:        db.options.DirPath,
 		SegmentSize:    db.options.SegmentSize,
 		SegmentFileExt: dataFileNameSuffix,
 		BlockCache:     db.options.BlockCache,
 		Sync:           db.options.Sync,
-		BytesPerSync:   db.options.BytesPerSync,
+		// the data files are synced by the background goroutine if BytesPerSync is set.
+		BytesPerSync: 0,
 	})
 	if err != nil {
 		return nil, err
 	}
-	return walFiles, nil
+	// the checksums are verified even if ChecksumType is ChecksumNone, since the older records may have them.
+	return &checksumWAL{WAL: walFiles, checksumType: db.options.ChecksumType}, nil
+}
+
+// newIndex returns an empty index, which stores the hashes of the keys if Options.KeyHasher is set.
+func (db *DB) newIndex() index.Indexer {
+	if db.options.KeyHasher == nil {
+		return index.NewIndexer()
+	}
+	return index.NewHashIndexer(index.NewIndexer(), db.options.KeyHasher, db.readKey)
+}
+
+// readKey returns the key of the record at the position, only the header and the key are decoded.
+func (db *DB) readKey(position *wal.ChunkPosition) ([]byte, error) {
+	chunk, err := db.dataFiles.Read(position)
+	if err != nil {
+		return nil, err
+	}
+	header := decodeLogRecordHeader(chunk)
+	key := make([]byte, header.keySize)
+	copy(key, header.key(chunk))
+	return key, nil
 }
 
 func (db *DB) loadIndex() error {
-	// load index frm hint file
-	if err := db.loadIndexFromHintFile(); err != nil {
-		return err
+	// load index from the index snapshot saved when closing
+	var lastSegId wal.SegmentID
+	var loaded bool
+	// the index snapshot is not used if the records are transformed, since its records are not read
+	if db.options.PersistIndex && db.recoveryRewrites == nil {
+		var err error
+		if lastSegId, loaded, err = db.loadIndexSnapshot(); err != nil {
+			return err
+		}
+	}
+	if !loaded {
+		// load index frm hint file
+		if err := db.loadIndexFromHintFile(); err != nil {
+			return err
+		}
+		mergeFinSegmentId, err := getMergeFinSegmentId(db.options.DirPath)
+		if err != nil {
+			return err
+		}
+		lastSegId = mergeFinSegmentId
 	}
 	// load index from data files
-	if err := db.loadIndexFromWAL(); err != nil {
+	if err := db.loadIndexFromWAL(lastSegId); err != nil {
 		return err
 	}
 	return nil
@@ -154,27 +586,76 @@ func (db *DB) loadIndex() error {
 
 // Close the database, close all data files and release file lock.
 // Set the closed flag to true.
-// The DB instance cannot be used after closing.
+// The DB instance cannot be used after closing, the operations will return ErrDBClosed,
+// and closing it again does nothing.
+//
+// It will notify the background goroutines and the running Merge or ValueLogGC to stop,
+// and wait for them to finish, the in-flight commits will also be finished before closing.
 func (db *DB) Close() error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	return db.CloseWithTimeout(0)
+}
 
-	if err := db.closeFiles(); err != nil {
+// CloseWithTimeout is like Close, but it waits at most timeout for
+// the background goroutines and the running Merge or ValueLogGC to finish.
+// If they don't finish in time, ErrCloseTimeout will be returned and the database will not be closed,
+// the running tasks are still stopped, and the background goroutines are started again,
+// you can call Close again to wait for them.
+// If timeout is less than or equal to 0, it will wait until they finish.
+//
+// All data files will be synced before closing, so no acknowledged data will be lost.
+// If syncing fails, the error is returned and the database is not closed like the timeout.
+// Closing a closed database does nothing and returns nil.
+func (db *DB) CloseWithTimeout(timeout time.Duration) error {
+	db.mu.RLock()
+	closed := db.closed
+	db.mu.RUnlock()
+	if closed {
+		return nil
+	}
+
+	// notify the background goroutines and the running tasks to stop
+	if err := db.stopBackground(timeout); err != nil {
+		db.mu.Lock()
+		if !db.closed {
+			db.restartBackground()
+		}
+		db.mu.Unlock()
 		return err
 	}
 
-	// release file lock
-	if err := db.fileLock.Unlock(); err != nil {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// the database has been closed by a concurrent Close
+	if db.closed {
+		return nil
+	}
+
+	// save the index snapshot, the database will still be closed if it fails,
+	// and the index will be rebuilt from the data files when opening.
+	var snapshotErr error
+	if db.options.PersistIndex {
+		snapshotErr = db.saveIndexSnapshot()
+	}
+	// sync all data files before closing, the database is kept open if it fails.
+	if err := db.syncFiles(); err != nil {
+		db.restartBackground()
 		return err
 	}
 
+	// the files may be closed partially, so the database is closed even if it fails.
+	err := db.closeFiles()
+	if unlockErr := db.fileLock.Unlock(); err == nil {
+		err = unlockErr
+	}
 	// close watch channel
 	if db.options.WatchQueueSize > 0 {
 		close(db.watchCh)
 	}
-
 	db.closed = true
-	return nil
+	if err != nil {
+		return err
+	}
+	return snapshotErr
 }
 
 // closeFiles close all data files and hint file
@@ -189,101 +670,521 @@ func (db *DB) closeFiles() error {
 			return err
 		}
 	}
-	return nil
+	// close value log files if exists
+	if db.valueLogFiles != nil {
+		if err := db.valueLogFiles.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isClosing reports whether the database is closing or closed, it must be called with the database locked,
+// since closeCh is replaced by Reopen.
+func (db *DB) isClosing() bool {
+	return closing(db.closeCh)
+}
+
+// closing reports whether closeCh of the database is closed, the running tasks capture closeCh
+// with the database locked when they start, and check it without the lock.
+func closing(closeCh <-chan struct{}) bool {
+	select {
+	case <-closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// syncFiles sync the data files and value log files,
+// and records the time of the sync, see Stat.LastSyncAt.
+// It must be called with the database locked, so no batch is committed during syncing.
+func (db *DB) syncFiles() error {
+	return db.syncWalFiles(db.dataFiles, db.valueLogFiles)
+}
+
+// syncWalFiles syncs the data files and value log files like syncFiles, the batches may be committed during syncing,
+// they are only regarded as synced by the next sync.
+func (db *DB) syncWalFiles(dataFiles WAL, valueLogFiles *wal.WAL) error {
+	seq := atomic.LoadUint64(&db.writtenSeq)
+	dirtyBytes := atomic.LoadInt64(&db.dirtyBytes)
+	if valueLogFiles != nil {
+		if err := valueLogFiles.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := dataFiles.Sync(); err != nil {
+		return err
+	}
+	if err := db.syncDirIfRotated(dataFiles, valueLogFiles); err != nil {
+		return err
+	}
+	atomic.AddInt64(&db.dirtyBytes, -dirtyBytes)
+	atomic.StoreUint64(&db.syncedSeq, seq)
+	atomic.StoreInt64(&db.lastSyncAt, time.Now().UnixNano())
+	return nil
+}
+
+// syncDirIfRotated syncs the database directory if the active data file or value log file
+// has changed since the directory was synced last time, see Options.SyncDirOnRotate.
+//
+// A new segment file is created by the wal when the active one is full or rotated,
+// and the entry of the file in the directory is not durable until the directory is synced,
+// so the records synced to the file can be lost with the whole file if the machine crashes.
+// It is called after the files are synced, so the synced records are durable once it returns.
+func (db *DB) syncDirIfRotated(dataFiles WAL, valueLogFiles *wal.WAL) error {
+	if !db.options.SyncDirOnRotate {
+		return nil
+	}
+	dataSegId := dataFiles.ActiveSegmentID()
+	var valueLogSegId wal.SegmentID
+	if valueLogFiles != nil {
+		valueLogSegId = valueLogFiles.ActiveSegmentID()
+	}
+	if dataSegId == atomic.LoadUint32(&db.dirSyncedSegId) &&
+		valueLogSegId == atomic.LoadUint32(&db.dirSyncedValueLogSegId) {
+		return nil
+	}
+	if err := syncDir(db.options.DirPath); err != nil {
+		return err
+	}
+	atomic.StoreUint32(&db.dirSyncedSegId, dataSegId)
+	atomic.StoreUint32(&db.dirSyncedValueLogSegId, valueLogSegId)
+	return nil
+}
+
+// syncDir syncs the directory, so the entries of the files created, renamed or removed in it are durable.
+// The directory can not be synced on Windows, where the entries are durable once the files are synced.
+func syncDir(dirPath string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return err
+	}
+	if err = dir.Sync(); err != nil {
+		_ = dir.Close()
+		return err
+	}
+	return dir.Close()
+}
+
+// openNewActiveFiles rotates the data files and the value log files,
+// the new records will be written to the new active files.
+func (db *DB) openNewActiveFiles() error {
+	if db.valueLogFiles != nil {
+		if err := db.valueLogFiles.OpenNewActiveSegment(); err != nil {
+			return err
+		}
+	}
+	return db.dataFiles.OpenNewActiveSegment()
+}
+
+// Sync all data files to the underlying storage.
+func (db *DB) Sync() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	return db.syncFiles()
+}
+
+// WaitForSync blocks until all the batches committed before the call are synced to the underlying storage,
+// it is a durability barrier when Options.Sync is false, such as before telling another system the data is safe.
+//
+// It returns immediately if the batches are already synced, by Options.Sync, BatchOptions.Sync,
+// the background sync of Options.BytesPerSync or a previous call, otherwise it syncs the files itself.
+// The concurrent callers share one sync, and the writers are only blocked during syncing, like the background sync.
+func (db *DB) WaitForSync() error {
+	target := atomic.LoadUint64(&db.writtenSeq)
+	if atomic.LoadUint64(&db.syncedSeq) >= target {
+		return nil
+	}
+
+	db.waitSyncMu.Lock()
+	defer db.waitSyncMu.Unlock()
+	// synced by another caller or the background goroutine while waiting
+	if atomic.LoadUint64(&db.syncedSeq) >= target {
+		return nil
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return ErrDBClosed
+	}
+	return db.syncFiles()
+}
+
+// Ping checks whether the database is alive, it returns ErrDBClosed if the database is closed.
+// It only reads the index and writes nothing, so it is cheap enough for the liveness probes.
+func (db *DB) Ping() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return ErrDBClosed
+	}
+	_ = db.index.Get(nil)
+	return nil
+}
+
+// PingWrite is like Ping, but it also writes a scratch record to the data files and reads it back,
+// so the readiness probes can check the storage is writable.
+// The scratch record is a batch finished record without any record in the batch,
+// so no key is changed, and it is reclaimed by merge like the other batch finished records.
+func (db *DB) PingWrite() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	batchId := db.node.Generate().Bytes()
+	pos, err := db.dataFiles.Write(encodeLogRecord(&LogRecord{Key: batchId, Type: LogRecordBatchFinished}))
+	if err != nil {
+		return err
+	}
+	db.addUnsyncedBytes(int64(pos.ChunkSize))
+	db.dataBytes += int64(pos.ChunkSize)
+	db.addGarbage(pos)
+
+	chunk, err := db.dataFiles.Read(pos)
+	if err != nil {
+		return err
+	}
+	if header := decodeLogRecordHeader(chunk); header.recordType != LogRecordBatchFinished ||
+		!bytes.Equal(header.key(chunk), batchId) {
+		return ErrPingMismatch
+	}
+	return nil
+}
+
+// Stat returns the statistics of the database.
+func (db *DB) Stat() *Stat {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	diskSize, err := utils.DirSize(db.options.DirPath)
+	if err != nil {
+		panic(fmt.Sprintf("rosedb: get database directory size error: %v", err))
+	}
+
+	stat := &Stat{
+		KeysNum:       db.index.Size(),
+		DiskSize:      diskSize,
+		GarbageRatio:  db.garbageRatio(),
+		WALSize:       db.dataBytes,
+		UnsyncedBytes: atomic.LoadInt64(&db.dirtyBytes),
+	}
+	if lastSyncAt := atomic.LoadInt64(&db.lastSyncAt); lastSyncAt > 0 {
+		stat.LastSyncAt = time.Unix(0, lastSyncAt)
+	}
+	return stat
+}
+
+// PrefixCount returns the number of keys with the given prefix, it counts all keys if prefix is empty.
+// The keys are counted from the index in order, the values are not read.
+//
+// The expired keys are counted until they are removed from the index,
+// which happens when they are read, or the data files are merged and the database is reopened,
+// so the count may be larger than the number of live keys if some keys have expired.
+func (db *DB) PrefixCount(prefix []byte) (int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return 0, ErrDBClosed
+	}
+	// the records of the data structures are not counted
+	if len(prefix) == 0 {
+		return db.index.Size() - db.structKeyCount(), nil
+	}
+
+	var count int
+	db.index.AscendGreaterOrEqual(prefix, func(key []byte, _ *wal.ChunkPosition) (bool, error) {
+		if !bytes.HasPrefix(key, prefix) {
+			return false, nil
+		}
+		if isStructKey(key) {
+			return true, nil
+		}
+		count++
+		return true, nil
+	})
+	return count, nil
+}
+
+// Put a key-value pair into the database.
+// Actually, it will open a new batch and commit it.
+// You can think the batch has only one Put operation.
+func (db *DB) Put(key []byte, value []byte) error {
+	batch := db.batchPool.Get().(*Batch)
+	defer func() {
+		batch.reset()
+		db.batchPool.Put(batch)
+	}()
+	// This is a single put operation, we can set Sync to false.
+	// Because the data will be written to the WAL,
+	// and the WAL file will be synced to disk according to the DB options.
+	batch.init(false, false, db).withPendingWrites()
+	if err := batch.Put(key, value); err != nil {
+		_ = batch.Rollback()
+		return err
+	}
+	return batch.Commit()
+}
+
+// PutWithTTL a key-value pair into the database, with a ttl.
+// Actually, it will open a new batch and commit it.
+// You can think the batch has only one PutWithTTL operation.
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	batch := db.batchPool.Get().(*Batch)
+	defer func() {
+		batch.reset()
+		db.batchPool.Put(batch)
+	}()
+	// This is a single put operation, we can set Sync to false.
+	// Because the data will be written to the WAL,
+	// and the WAL file will be synced to disk according to the DB options.
+	batch.init(false, false, db).withPendingWrites()
+	if err := batch.PutWithTTL(key, value, ttl); err != nil {
+		_ = batch.Rollback()
+		return err
+	}
+	return batch.Commit()
+}
+
+// PutAndGetOld puts a key-value pair into the database, and returns the previous value of the key,
+// which is nil if the key does not exist, or it is deleted or expired.
+// The read and the write are in the same batch, so no other writes can interleave them.
+func (db *DB) PutAndGetOld(key []byte, value []byte) ([]byte, error) {
+	batch := db.batchPool.Get().(*Batch)
+	defer func() {
+		batch.reset()
+		db.batchPool.Put(batch)
+	}()
+	batch.init(false, false, db).withPendingWrites()
+
+	old, err := batch.Get(key)
+	if err != nil && err != ErrKeyNotFound {
+		_ = batch.Rollback()
+		return nil, err
+	}
+	if err = batch.Put(key, value); err != nil {
+		_ = batch.Rollback()
+		return nil, err
+	}
+	if err = batch.Commit(); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+// keyTooLarge reports whether the key exceeds Options.MaxKeySize.
+func (db *DB) keyTooLarge(key []byte) bool {
+	return db.options.MaxKeySize > 0 && len(key) > db.options.MaxKeySize
+}
+
+// valueSizeLimit returns the max size of a value, it is Options.MaxValueSize if set,
+// otherwise Options.SegmentSize, since a larger value can not be written by the wal anyway.
+func (db *DB) valueSizeLimit() int64 {
+	if db.options.MaxValueSize > 0 {
+		return db.options.MaxValueSize
+	}
+	return db.options.SegmentSize
+}
+
+// defaultExpire returns the expiry time of the value put without a ttl, see Options.DefaultTTL,
+// it returns 0 if the value never expires.
+func (db *DB) defaultExpire() int64 {
+	if db.options.DefaultTTL <= 0 {
+		return 0
+	}
+	return db.now().Add(db.options.DefaultTTL).UnixNano()
+}
+
+// SetMany puts all the key-value pairs of the map into the database atomically.
+// Actually, it will open a new batch and commit it, so the pairs are written with one batch id,
+// and either all or none of them are seen after a crash.
+// Nothing is written if any key is empty.
+func (db *DB) SetMany(m map[string][]byte) error {
+	batch := db.batchPool.Get().(*Batch)
+	defer func() {
+		batch.reset()
+		db.batchPool.Put(batch)
+	}()
+	batch.init(false, false, db).withPendingWrites()
+	for key, value := range m {
+		if err := batch.Put([]byte(key), value); err != nil {
+			_ = batch.Rollback()
+			return err
+		}
+	}
+	return batch.Commit()
+}
+
+// Get the value of the specified key from the database.
+// Actually, it will open a new batch and commit it.
+// You can think the batch has only one Get operation.
+func (db *DB) Get(key []byte) ([]byte, error) {
+	batch := db.batchPool.Get().(*Batch)
+	batch.init(true, false, db)
+	defer func() {
+		_ = batch.Commit()
+		batch.reset()
+		db.batchPool.Put(batch)
+	}()
+	return batch.Get(key)
+}
+
+// GetWithChecksum is like Get, but also returns the CRC-32 checksum(IEEE polynomial) of the value,
+// so the caller can verify the value end-to-end after it leaves the database.
+//
+// The checksum of the data files is verified when reading the value,
+// so the returned checksum is computed from the verified value.
+func (db *DB) GetWithChecksum(key []byte) ([]byte, uint32, error) {
+	value, err := db.Get(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, crc32.ChecksumIEEE(value), nil
 }
 
-// Sync all data files to the underlying storage.
-func (db *DB) Sync() error {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
-	return db.dataFiles.Sync()
+// GetRange returns the bytes of the value of the key in [start, end), see Batch.GetRange.
+// Actually, it will open a new batch and commit it.
+//
+// The whole record is still read from the data files, since the value may be encoded
+// by Options.ValueCodec, only the range is copied to the caller.
+func (db *DB) GetRange(key []byte, start, end int) ([]byte, error) {
+	batch := db.batchPool.Get().(*Batch)
+	batch.init(true, false, db)
+	defer func() {
+		_ = batch.Commit()
+		batch.reset()
+		db.batchPool.Put(batch)
+	}()
+	return batch.GetRange(key, start, end)
 }
 
-// Stat returns the statistics of the database.
-func (db *DB) Stat() *Stat {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-
-	diskSize, err := utils.DirSize(db.options.DirPath)
+// SetRange overwrites the value of the key with data from offset, and returns the new length of the value,
+// see Batch.SetRange.
+// Actually, it will open a new batch and commit it, the value is read and written
+// with the database locked, so it will not race with the other writers.
+func (db *DB) SetRange(key []byte, offset int, data []byte) (int, error) {
+	batch := db.batchPool.Get().(*Batch)
+	defer func() {
+		batch.reset()
+		db.batchPool.Put(batch)
+	}()
+	batch.init(false, false, db).withPendingWrites()
+	size, err := batch.SetRange(key, offset, data)
 	if err != nil {
-		panic(fmt.Sprintf("rosedb: get database directory size error: %v", err))
+		_ = batch.Rollback()
+		return 0, err
 	}
-
-	return &Stat{
-		KeysNum:  db.index.Size(),
-		DiskSize: diskSize,
+	if err = batch.Commit(); err != nil {
+		return 0, err
 	}
+	return size, nil
 }
 
-// Put a key-value pair into the database.
+// Delete the specified key from the database.
 // Actually, it will open a new batch and commit it.
-// You can think the batch has only one Put operation.
-func (db *DB) Put(key []byte, value []byte) error {
+// You can think the batch has only one Delete operation.
+func (db *DB) Delete(key []byte) error {
 	batch := db.batchPool.Get().(*Batch)
 	defer func() {
 		batch.reset()
 		db.batchPool.Put(batch)
 	}()
-	// This is a single put operation, we can set Sync to false.
+	// This is a single delete operation, we can set Sync to false.
 	// Because the data will be written to the WAL,
 	// and the WAL file will be synced to disk according to the DB options.
 	batch.init(false, false, db).withPendingWrites()
-	if err := batch.Put(key, value); err != nil {
+	if err := batch.Delete(key); err != nil {
 		_ = batch.Rollback()
 		return err
 	}
 	return batch.Commit()
 }
 
-// PutWithTTL a key-value pair into the database, with a ttl.
+// DeleteAndReport deletes the specified key from the database, and reports whether a live key is deleted,
+// it is false if the key does not exist, or it is already deleted or expired.
+// The existence is checked in the same batch as the delete, so no other writes can interleave them.
+func (db *DB) DeleteAndReport(key []byte) (bool, error) {
+	batch := db.batchPool.Get().(*Batch)
+	defer func() {
+		batch.reset()
+		db.batchPool.Put(batch)
+	}()
+	batch.init(false, false, db).withPendingWrites()
+
+	existed, err := batch.Exist(key)
+	if err != nil {
+		_ = batch.Rollback()
+		return false, err
+	}
+	if err = batch.Delete(key); err != nil {
+		_ = batch.Rollback()
+		return false, err
+	}
+	if err = batch.Commit(); err != nil {
+		return false, err
+	}
+	return existed, nil
+}
+
+// RenameKey renames oldKey to newKey atomically, the value and ttl of oldKey will be kept.
+// It returns ErrKeyNotFound if oldKey does not exist,
+// and ErrKeyExists if newKey exists and overwrite is false.
 // Actually, it will open a new batch and commit it.
-// You can think the batch has only one PutWithTTL operation.
-func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+func (db *DB) RenameKey(oldKey, newKey []byte, overwrite bool) error {
 	batch := db.batchPool.Get().(*Batch)
 	defer func() {
 		batch.reset()
 		db.batchPool.Put(batch)
 	}()
-	// This is a single put operation, we can set Sync to false.
-	// Because the data will be written to the WAL,
-	// and the WAL file will be synced to disk according to the DB options.
+	// The new key and the deletion of the old key are written in the same batch,
+	// so only one of the two states will be seen after a crash.
 	batch.init(false, false, db).withPendingWrites()
-	if err := batch.PutWithTTL(key, value, ttl); err != nil {
+	if err := batch.RenameKey(oldKey, newKey, overwrite); err != nil {
 		_ = batch.Rollback()
 		return err
 	}
 	return batch.Commit()
 }
 
-// Get the value of the specified key from the database.
+// Copy copies the value and the remaining ttl of src to dst atomically.
+// It returns ErrKeyNotFound if src does not exist,
+// and ErrKeyExists if dst exists and overwrite is false.
 // Actually, it will open a new batch and commit it.
-// You can think the batch has only one Get operation.
-func (db *DB) Get(key []byte) ([]byte, error) {
+func (db *DB) Copy(src, dst []byte, overwrite bool) error {
 	batch := db.batchPool.Get().(*Batch)
-	batch.init(true, false, db)
 	defer func() {
-		_ = batch.Commit()
 		batch.reset()
 		db.batchPool.Put(batch)
 	}()
-	return batch.Get(key)
+	batch.init(false, false, db).withPendingWrites()
+	if err := batch.Copy(src, dst, overwrite); err != nil {
+		_ = batch.Rollback()
+		return err
+	}
+	return batch.Commit()
 }
 
-// Delete the specified key from the database.
+// Move moves the key to destPrefix+key atomically, the value and ttl of the key will be kept.
+// It returns ErrKeyNotFound if the key does not exist,
+// and ErrKeyExists if the destination key exists.
 // Actually, it will open a new batch and commit it.
-// You can think the batch has only one Delete operation.
-func (db *DB) Delete(key []byte) error {
+func (db *DB) Move(key, destPrefix []byte) error {
 	batch := db.batchPool.Get().(*Batch)
 	defer func() {
 		batch.reset()
 		db.batchPool.Put(batch)
 	}()
-	// This is a single delete operation, we can set Sync to false.
-	// Because the data will be written to the WAL,
-	// and the WAL file will be synced to disk according to the DB options.
 	batch.init(false, false, db).withPendingWrites()
-	if err := batch.Delete(key); err != nil {
+	if err := batch.Move(key, destPrefix); err != nil {
 		_ = batch.Rollback()
 		return err
 	}
@@ -293,6 +1194,7 @@ func (db *DB) Delete(key []byte) error {
 // Exist checks if the specified key exists in the database.
 // Actually, it will open a new batch and commit it.
 // You can think the batch has only one Exist operation.
+// Only the header of the record is decoded, so it is cheaper than Get for the large values.
 func (db *DB) Exist(key []byte) (bool, error) {
 	batch := db.batchPool.Get().(*Batch)
 	batch.init(true, false, db)
@@ -322,6 +1224,23 @@ func (db *DB) Expire(key []byte, ttl time.Duration) error {
 	return batch.Commit()
 }
 
+// Touch marks the key as recently accessed, and if extend is greater than 0,
+// resets the ttl of the key to extend from now.
+// See Batch.Touch for more details.
+func (db *DB) Touch(key []byte, extend time.Duration) error {
+	batch := db.batchPool.Get().(*Batch)
+	defer func() {
+		batch.reset()
+		db.batchPool.Put(batch)
+	}()
+	batch.init(false, false, db).withPendingWrites()
+	if err := batch.Touch(key, extend); err != nil {
+		_ = batch.Rollback()
+		return err
+	}
+	return batch.Commit()
+}
+
 // TTL get the ttl of the key.
 func (db *DB) TTL(key []byte) (time.Duration, error) {
 	batch := db.batchPool.Get().(*Batch)
@@ -343,157 +1262,263 @@ func (db *DB) Watch() (chan *Event, error) {
 
 // Ascend calls handleFn for each key/value pair in the db in ascending order.
 func (db *DB) Ascend(handleFn func(k []byte, v []byte) (bool, error)) {
+	_ = db.AscendContext(context.Background(), handleFn)
+}
+
+// AscendContext is like Ascend, but it stops iterating and returns ctx.Err() if ctx is done.
+func (db *DB) AscendContext(ctx context.Context, handleFn func(k []byte, v []byte) (bool, error)) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	if db.closed {
+		return ErrDBClosed
+	}
 
-	db.index.Ascend(func(key []byte, pos *wal.ChunkPosition) (bool, error) {
-		chunk, err := db.dataFiles.Read(pos)
-		if err != nil {
-			return false, err
-		}
-		value, err := db.checkValue(chunk)
-		if err != nil {
-			return false, err
-		}
-		return handleFn(key, value)
-	})
+	var ctxErr error
+	db.index.Ascend(db.valueHandler(ctx, handleFn, &ctxErr, true))
+	return ctxErr
 }
 
 // AscendRange calls handleFn for each key/value pair in the db within the range [startKey, endKey] in ascending order.
 func (db *DB) AscendRange(startKey, endKey []byte, handleFn func(k []byte, v []byte) (bool, error)) {
+	_ = db.AscendRangeContext(context.Background(), startKey, endKey, handleFn)
+}
+
+// AscendRangeContext is like AscendRange, but it stops iterating and returns ctx.Err() if ctx is done.
+func (db *DB) AscendRangeContext(ctx context.Context, startKey, endKey []byte, handleFn func(k []byte, v []byte) (bool, error)) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	if db.closed {
+		return ErrDBClosed
+	}
 
-	db.index.AscendRange(startKey, endKey, func(key []byte, pos *wal.ChunkPosition) (bool, error) {
-		chunk, err := db.dataFiles.Read(pos)
-		if err != nil {
-			return false, nil
-		}
-		value, err := db.checkValue(chunk)
-		if err != nil {
-			return false, err
-		}
-		return handleFn(key, value)
-	})
+	var ctxErr error
+	db.index.AscendRange(startKey, endKey, db.valueHandler(ctx, handleFn, &ctxErr, false))
+	return ctxErr
 }
 
 // AscendGreaterOrEqual calls handleFn for each key/value pair in the db with keys greater than or equal to the given key.
 func (db *DB) AscendGreaterOrEqual(key []byte, handleFn func(k []byte, v []byte) (bool, error)) {
+	_ = db.AscendGreaterOrEqualContext(context.Background(), key, handleFn)
+}
+
+// AscendGreaterOrEqualContext is like AscendGreaterOrEqual, but it stops iterating and returns ctx.Err() if ctx is done.
+func (db *DB) AscendGreaterOrEqualContext(ctx context.Context, key []byte, handleFn func(k []byte, v []byte) (bool, error)) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	if db.closed {
+		return ErrDBClosed
+	}
 
-	db.index.AscendGreaterOrEqual(key, func(key []byte, pos *wal.ChunkPosition) (bool, error) {
-		chunk, err := db.dataFiles.Read(pos)
-		if err != nil {
-			return false, nil
-		}
-		value, err := db.checkValue(chunk)
-		if err != nil {
-			return false, err
-		}
-		return handleFn(key, value)
-	})
+	var ctxErr error
+	db.index.AscendGreaterOrEqual(key, db.valueHandler(ctx, handleFn, &ctxErr, false))
+	return ctxErr
 }
 
 // AscendKeys calls handleFn for each key in the db in ascending order.
 func (db *DB) AscendKeys(pattern []byte, handleFn func(k []byte) (bool, error)) {
+	_ = db.AscendKeysContext(context.Background(), pattern, handleFn)
+}
+
+// AscendKeysContext is like AscendKeys, but it stops iterating and returns ctx.Err() if ctx is done.
+func (db *DB) AscendKeysContext(ctx context.Context, pattern []byte, handleFn func(k []byte) (bool, error)) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-
-	var reg *regexp.Regexp
-	if len(pattern) > 0 {
-		reg = regexp.MustCompile(string(pattern))
+	if db.closed {
+		return ErrDBClosed
 	}
 
-	db.index.Ascend(func(key []byte, _ *wal.ChunkPosition) (bool, error) {
-		if reg == nil || reg.Match(key) {
-			return handleFn(key)
-		}
-		return true, nil
-	})
+	var ctxErr error
+	db.index.Ascend(keyHandler(ctx, pattern, handleFn, &ctxErr))
+	return ctxErr
 }
 
 // Descend calls handleFn for each key/value pair in the db in descending order.
 func (db *DB) Descend(handleFn func(k []byte, v []byte) (bool, error)) {
+	_ = db.DescendContext(context.Background(), handleFn)
+}
+
+// DescendContext is like Descend, but it stops iterating and returns ctx.Err() if ctx is done.
+func (db *DB) DescendContext(ctx context.Context, handleFn func(k []byte, v []byte) (bool, error)) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	if db.closed {
+		return ErrDBClosed
+	}
 
-	db.index.Descend(func(key []byte, pos *wal.ChunkPosition) (bool, error) {
-		chunk, err := db.dataFiles.Read(pos)
-		if err != nil {
-			return false, nil
-		}
-		value, err := db.checkValue(chunk)
-		if err != nil {
-			return false, err
-		}
-		return handleFn(key, value)
-	})
+	var ctxErr error
+	db.index.Descend(db.valueHandler(ctx, handleFn, &ctxErr, false))
+	return ctxErr
 }
 
 // DescendRange calls handleFn for each key/value pair in the db within the range [startKey, endKey] in descending order.
 func (db *DB) DescendRange(startKey, endKey []byte, handleFn func(k []byte, v []byte) (bool, error)) {
+	_ = db.DescendRangeContext(context.Background(), startKey, endKey, handleFn)
+}
+
+// DescendRangeContext is like DescendRange, but it stops iterating and returns ctx.Err() if ctx is done.
+func (db *DB) DescendRangeContext(ctx context.Context, startKey, endKey []byte, handleFn func(k []byte, v []byte) (bool, error)) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	if db.closed {
+		return ErrDBClosed
+	}
 
-	db.index.DescendRange(startKey, endKey, func(key []byte, pos *wal.ChunkPosition) (bool, error) {
-		chunk, err := db.dataFiles.Read(pos)
-		if err != nil {
-			return false, nil
-		}
-		value, err := db.checkValue(chunk)
-		if err != nil {
-			return false, err
-		}
-		return handleFn(key, value)
-	})
+	var ctxErr error
+	db.index.DescendRange(startKey, endKey, db.valueHandler(ctx, handleFn, &ctxErr, false))
+	return ctxErr
 }
 
 // DescendLessOrEqual calls handleFn for each key/value pair in the db with keys less than or equal to the given key.
 func (db *DB) DescendLessOrEqual(key []byte, handleFn func(k []byte, v []byte) (bool, error)) {
+	_ = db.DescendLessOrEqualContext(context.Background(), key, handleFn)
+}
+
+// DescendLessOrEqualContext is like DescendLessOrEqual, but it stops iterating and returns ctx.Err() if ctx is done.
+func (db *DB) DescendLessOrEqualContext(ctx context.Context, key []byte, handleFn func(k []byte, v []byte) (bool, error)) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	var ctxErr error
+	db.index.DescendLessOrEqual(key, db.valueHandler(ctx, handleFn, &ctxErr, false))
+	return ctxErr
+}
+
+// DescendKeys calls handleFn for each key in the db in descending order.
+func (db *DB) DescendKeys(pattern []byte, handleFn func(k []byte) (bool, error)) {
+	_ = db.DescendKeysContext(context.Background(), pattern, handleFn)
+}
+
+// DescendKeysContext is like DescendKeys, but it stops iterating and returns ctx.Err() if ctx is done.
+func (db *DB) DescendKeysContext(ctx context.Context, pattern []byte, handleFn func(k []byte) (bool, error)) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	var ctxErr error
+	db.index.Descend(keyHandler(ctx, pattern, handleFn, &ctxErr))
+	return ctxErr
+}
 
-	db.index.DescendLessOrEqual(key, func(key []byte, pos *wal.ChunkPosition) (bool, error) {
+// valueHandler returns an index handler which reads the value of each key and calls handleFn.
+// The iteration will be stopped if ctx is done, and the ctx error will be stored in ctxErr.
+// It is also stopped if a value can not be read, the error is passed to the index if passReadErr is true,
+// as Ascend does, while the other scans stop without an error.
+func (db *DB) valueHandler(ctx context.Context, handleFn func(k []byte, v []byte) (bool, error),
+	ctxErr *error, passReadErr bool) func(key []byte, pos *wal.ChunkPosition) (bool, error) {
+	return func(key []byte, pos *wal.ChunkPosition) (bool, error) {
+		if *ctxErr = ctx.Err(); *ctxErr != nil {
+			return false, *ctxErr
+		}
+		// the records of the data structures are hidden
+		if isStructKey(key) {
+			return true, nil
+		}
 		chunk, err := db.dataFiles.Read(pos)
 		if err != nil {
-			return false, nil
+			if !passReadErr {
+				return false, nil
+			}
+			return false, err
 		}
 		value, err := db.checkValue(chunk)
+		if err == ErrKeyNotFound {
+			// skip the expired key
+			return true, nil
+		}
 		if err != nil {
 			return false, err
 		}
 		return handleFn(key, value)
-	})
+	}
 }
 
-// DescendKeys calls handleFn for each key in the db in descending order.
-func (db *DB) DescendKeys(pattern []byte, handleFn func(k []byte) (bool, error)) {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-
+// keyHandler returns an index handler which calls handleFn for each key matches the pattern.
+// The iteration will be stopped if ctx is done, and the ctx error will be stored in ctxErr.
+func keyHandler(ctx context.Context, pattern []byte, handleFn func(k []byte) (bool, error),
+	ctxErr *error) func(key []byte, pos *wal.ChunkPosition) (bool, error) {
 	var reg *regexp.Regexp
 	if len(pattern) > 0 {
 		reg = regexp.MustCompile(string(pattern))
 	}
 
-	db.index.Descend(func(key []byte, _ *wal.ChunkPosition) (bool, error) {
+	return func(key []byte, _ *wal.ChunkPosition) (bool, error) {
+		if *ctxErr = ctx.Err(); *ctxErr != nil {
+			return false, *ctxErr
+		}
+		if isStructKey(key) {
+			return true, nil
+		}
 		if reg == nil || reg.Match(key) {
 			return handleFn(key)
 		}
 		return true, nil
-	})
+	}
 }
 
 func (db *DB) checkValue(chunk []byte) ([]byte, error) {
 	record := decodeLogRecord(chunk)
-	now := time.Now().UnixNano()
+	now := db.now().UnixNano()
+	// the expired key is not removed from the index, since only the read lock is held.
 	if record.Type == LogRecordDeleted || record.IsExpired(now) {
-		// delete from index if the key is expired.
-		db.index.Delete(record.Key)
 		return nil, ErrKeyNotFound
 	}
-	return record.Value, nil
+	return db.loadValue(record)
+}
+
+// rewriteRecords writes the records to the data files as a batch and updates the index,
+// the values will be separated to the value log if necessary.
+// It is used to write the records generated by the database itself, such as ValueLogGC and eviction,
+// so the watch events and hooks will not be triggered.
+// It must be called with the database locked.
+func (db *DB) rewriteRecords(records []*LogRecord, batchId uint64) error {
+	if len(records) == 0 {
+		return nil
+	}
+	positions := make([]*wal.ChunkPosition, len(records))
+	for i, record := range records {
+		record.BatchId = batchId
+		dataRecord, err := db.encodeValue(record)
+		if err != nil {
+			return err
+		}
+		if dataRecord, err = db.separateValue(dataRecord); err != nil {
+			return err
+		}
+		buf, encRecord := encodeLogRecordPooled(dataRecord)
+		positions[i], err = db.dataFiles.Write(encRecord)
+		putEncodeBuffer(buf)
+		if err != nil {
+			return err
+		}
+	}
+
+	// write a record to indicate the end of the batch
+	endRecord := encodeLogRecord(&LogRecord{
+		Key:  snowflake.ID(batchId).Bytes(),
+		Type: LogRecordBatchFinished,
+	})
+	endPos, err := db.dataFiles.Write(endRecord)
+	if err != nil {
+		return err
+	}
+
+	db.dataBytes += int64(endPos.ChunkSize)
+	db.addGarbage(endPos)
+	for i, record := range records {
+		db.dataBytes += int64(positions[i].ChunkSize)
+		if record.Type == LogRecordDeleted {
+			db.deleteIndex(record.Key)
+			db.addGarbage(positions[i])
+		} else {
+			db.putIndex(record.Key, positions[i])
+		}
+	}
+	return nil
 }
 
 func checkOptions(options Options) error {
@@ -503,26 +1528,83 @@ func checkOptions(options Options) error {
 	if options.SegmentSize <= 0 {
 		return errors.New("database data file size must be greater than 0")
 	}
+	if options.MaxKeySize < 0 {
+		return errors.New("database max key size must not be negative")
+	}
+	if options.MaxValueSize < 0 {
+		return errors.New("database max value size must not be negative")
+	}
+	if options.LargeValueThreshold < 0 {
+		return errors.New("database large value threshold must not be negative")
+	}
+	if len(options.ValueCodec) > maxValueCodecs {
+		return errors.New("database value codecs must not be more than 4")
+	}
+	if options.ChecksumType > maxChecksumType {
+		return errors.New("database checksum type is unknown")
+	}
+	if options.DefaultTTL < 0 {
+		return errors.New("database default ttl must not be negative")
+	}
+	if options.SlidingExpiration < 0 {
+		return errors.New("database sliding expiration must not be negative")
+	}
+	if options.MaxTotalSize < 0 {
+		return errors.New("database max total size must not be negative")
+	}
+	if options.MergeRateLimit < 0 {
+		return errors.New("database merge rate limit must not be negative")
+	}
+	if options.AutoMergeThreshold < 0 || options.AutoMergeThreshold >= 1 {
+		return errors.New("database auto merge threshold must be in [0, 1)")
+	}
+	if options.AutoMergeCooldown < 0 {
+		return errors.New("database auto merge cooldown must not be negative")
+	}
+	if options.TombstoneRetention < 0 {
+		return errors.New("database tombstone retention must not be negative")
+	}
+	if options.MaxWALSize < 0 {
+		return errors.New("database max wal size must not be negative")
+	}
+	if options.WriteStallTimeout < 0 {
+		return errors.New("database write stall timeout must not be negative")
+	}
+	if options.WarmupRateLimit < 0 {
+		return errors.New("database warmup rate limit must not be negative")
+	}
+	if options.RecoveryConcurrency < 0 {
+		return errors.New("database recovery concurrency must not be negative")
+	}
+	if options.NodeID < 0 || options.NodeID > 1023 {
+		return errors.New("database node id must be in [0, 1023]")
+	}
 	return nil
 }
 
 // loadIndexFromWAL loads index from WAL.
 // It will iterate over all the WAL files and read data
 // from them to rebuild the index.
-func (db *DB) loadIndexFromWAL() error {
-	mergeFinSegmentId, err := getMergeFinSegmentId(db.options.DirPath)
+// The segment files whose ids are less than or equal to skipSegmentId are skipped,
+// they have been loaded from the hint file or the index snapshot.
+func (db *DB) loadIndexFromWAL(skipSegmentId wal.SegmentID) error {
+	progress, err := db.newRecoveryProgress(skipSegmentId)
 	if err != nil {
 		return err
 	}
+	if db.options.RecoveryConcurrency > 1 {
+		return db.loadIndexFromWALConcurrently(skipSegmentId, progress)
+	}
+
 	indexRecords := make(map[uint64][]*IndexRecord)
-	now := time.Now().UnixNano()
+	now := db.now().UnixNano()
 	// get a reader for WAL
 	reader := db.dataFiles.NewReader()
 	for {
-		// if the current segment id is less than the mergeFinSegmentId,
-		// we can skip this segment because it has been merged,
-		// and we can load index from the hint file directly.
-		if reader.CurrentSegmentId() <= mergeFinSegmentId {
+		// if the current segment id is less than the skipSegmentId,
+		// we can skip this segment because it has been merged or saved in the index snapshot,
+		// and we can load index from the hint file or the index snapshot directly.
+		if reader.CurrentSegmentId() <= skipSegmentId {
 			reader.SkipCurrentSegment()
 			continue
 		}
@@ -536,43 +1618,58 @@ func (db *DB) loadIndexFromWAL() error {
 		}
 		// decode and get log record
 		record := decodeLogRecord(chunk)
+		if err = db.transformRecord(record, position, now); err != nil {
+			return err
+		}
+		if err = db.indexLogRecord(record, position, indexRecords, now); err != nil {
+			return err
+		}
+		progress.add(position)
+	}
+	progress.done()
+	return nil
+}
 
-		// if we get the end of a batch,
-		// all records in this batch are ready to be indexed.
-		if record.Type == LogRecordBatchFinished {
-			batchId, err := snowflake.ParseBytes(record.Key)
-			if err != nil {
-				return err
-			}
-			for _, idxRecord := range indexRecords[uint64(batchId)] {
-				if idxRecord.recordType == LogRecordNormal {
-					db.index.Put(idxRecord.key, idxRecord.position)
-				}
-				if idxRecord.recordType == LogRecordDeleted {
-					db.index.Delete(idxRecord.key)
-				}
+// indexLogRecord updates the index by the record read from the data files in order,
+// the records of a batch are kept in indexRecords until the batch finished record is read.
+func (db *DB) indexLogRecord(record *LogRecord, position *wal.ChunkPosition,
+	indexRecords map[uint64][]*IndexRecord, now int64) error {
+	// if we get the end of a batch,
+	// all records in this batch are ready to be indexed.
+	if record.Type == LogRecordBatchFinished {
+		batchId, err := snowflake.ParseBytes(record.Key)
+		if err != nil {
+			return err
+		}
+		for _, idxRecord := range indexRecords[uint64(batchId)] {
+			if idxRecord.recordType == LogRecordNormal || idxRecord.recordType == LogRecordValuePointer {
+				db.index.Put(idxRecord.key, idxRecord.position)
 			}
-			// delete indexRecords according to batchId after indexing
-			delete(indexRecords, uint64(batchId))
-		} else if record.Type == LogRecordNormal && record.BatchId == mergeFinishedBatchID {
-			// if the record is a normal record and the batch id is 0,
-			// it means that the record is involved in the merge operation.
-			// so put the record into index directly.
-			db.index.Put(record.Key, position)
-		} else {
-			// expired records should not be indexed
-			if record.IsExpired(now) {
-				db.index.Delete(record.Key)
-				continue
+			if idxRecord.recordType == LogRecordDeleted {
+				db.index.Delete(idxRecord.key)
 			}
-			// put the record into the temporary indexRecords
-			indexRecords[record.BatchId] = append(indexRecords[record.BatchId],
-				&IndexRecord{
-					key:        record.Key,
-					recordType: record.Type,
-					position:   position,
-				})
 		}
+		// delete indexRecords according to batchId after indexing
+		delete(indexRecords, uint64(batchId))
+	} else if record.IsValue() && record.BatchId == mergeFinishedBatchID {
+		// if the record is a normal record and the batch id is 0,
+		// it means that the record is involved in the merge operation.
+		// so put the record into index directly.
+		db.index.Put(record.Key, position)
+	} else {
+		// expired records should not be indexed,
+		// the expiration time of the delete record is its retention, see Options.TombstoneRetention.
+		if record.Type != LogRecordDeleted && record.IsExpired(now) {
+			db.index.Delete(record.Key)
+			return nil
+		}
+		// put the record into the temporary indexRecords
+		indexRecords[record.BatchId] = append(indexRecords[record.BatchId],
+			&IndexRecord{
+				key:        record.Key,
+				recordType: record.Type,
+				position:   position,
+			})
 	}
 	return nil
 }
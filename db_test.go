@@ -1,12 +1,23 @@
 package rosedb
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"math"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/bwmarrin/snowflake"
 	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/rosedblabs/wal"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -497,8 +508,88 @@ func TestDB_PutWithTTL_Merge(t *testing.T) {
 	}
 }
 
+func TestDB_PutAndGetOld(t *testing.T) {
+	clock := newFakeClock()
+	options := DefaultOptions
+	options.Clock = clock
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	old, err := db.PutAndGetOld([]byte("key"), []byte("value-1"))
+	assert.Nil(t, err)
+	assert.Nil(t, old)
+	old, err = db.PutAndGetOld([]byte("key"), []byte("value-2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-1"), old)
+	val, err := db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-2"), val)
+
+	// the expired value is regarded as absent
+	assert.Nil(t, db.PutWithTTL([]byte("ttl"), []byte("value"), time.Second))
+	clock.Advance(time.Second)
+	old, err = db.PutAndGetOld([]byte("ttl"), []byte("value-2"))
+	assert.Nil(t, err)
+	assert.Nil(t, old)
+
+	// the concurrent writers see each other's values exactly once
+	var wg sync.WaitGroup
+	olds := make(chan string, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			old, err := db.PutAndGetOld([]byte("counter"), []byte(strconv.Itoa(i)))
+			assert.Nil(t, err)
+			olds <- string(old)
+		}(i)
+	}
+	wg.Wait()
+	close(olds)
+	seen := make(map[string]bool)
+	for old := range olds {
+		assert.False(t, seen[old])
+		seen[old] = true
+	}
+	assert.Equal(t, 100, len(seen))
+
+	_, err = db.PutAndGetOld(nil, []byte("value"))
+	assert.Equal(t, ErrKeyIsEmpty, err)
+}
+
+func TestDB_DeleteAndReport(t *testing.T) {
+	clock := newFakeClock()
+	options := DefaultOptions
+	options.Clock = clock
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	assert.Nil(t, db.PutWithTTL([]byte("ttl"), []byte("value"), time.Second))
+	clock.Advance(time.Second)
+
+	for _, c := range []struct {
+		key     string
+		existed bool
+	}{{"key", true}, {"key", false}, {"ttl", false}, {"missing", false}} {
+		existed, err := db.DeleteAndReport([]byte(c.key))
+		assert.Nil(t, err)
+		assert.Equal(t, c.existed, existed, c.key)
+	}
+	exist, err := db.Exist([]byte("key"))
+	assert.Nil(t, err)
+	assert.False(t, exist)
+
+	_, err = db.DeleteAndReport(nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+}
+
 func TestDB_Expire(t *testing.T) {
+	clock := newFakeClock()
 	options := DefaultOptions
+	options.Clock = clock
 	db, err := Open(options)
 	assert.Nil(t, err)
 	defer destroyDB(db)
@@ -532,14 +623,16 @@ func TestDB_Expire(t *testing.T) {
 	assert.Nil(t, err)
 	assert.True(t, tt3.Seconds() > 90)
 
-	time.Sleep(time.Second)
+	clock.Advance(time.Second)
 	tt4, err := db2.TTL(utils.GetTestKey(2))
 	assert.Equal(t, tt4, time.Duration(-1))
 	assert.Equal(t, err, ErrKeyNotFound)
 }
 
 func TestDB_Expire2(t *testing.T) {
+	clock := newFakeClock()
 	options := DefaultOptions
+	options.Clock = clock
 	db, err := Open(options)
 	assert.Nil(t, err)
 	defer destroyDB(db)
@@ -550,7 +643,7 @@ func TestDB_Expire2(t *testing.T) {
 	err = db.Expire(utils.GetTestKey(2), time.Second*2)
 	assert.Nil(t, err)
 
-	time.Sleep(time.Second * 2)
+	clock.Advance(time.Second * 2)
 	_ = db.Close()
 
 	db2, err := Open(options)
@@ -563,3 +656,1179 @@ func TestDB_Expire2(t *testing.T) {
 	err = db2.Expire(utils.GetTestKey(2), time.Second)
 	assert.Equal(t, err, ErrKeyNotFound)
 }
+
+func TestDB_Touch(t *testing.T) {
+	options := DefaultOptions
+	options.TrackAccess = true
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(10), time.Second)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(10))
+	assert.Nil(t, err)
+
+	// extend the ttl
+	err = db.Touch(utils.GetTestKey(1), time.Second*100)
+	assert.Nil(t, err)
+	ttl, err := db.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, ttl.Seconds() > 90)
+	_, hits, err := db.AccessStats(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), hits)
+
+	// the key without ttl will not expire
+	err = db.Touch(utils.GetTestKey(2), time.Second)
+	assert.Nil(t, err)
+	ttl, err = db.TTL(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	// only mark the key as accessed
+	err = db.Touch(utils.GetTestKey(2), 0)
+	assert.Nil(t, err)
+	_, hits, err = db.AccessStats(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), hits)
+
+	// touch the absent and expired keys
+	err = db.Touch(utils.GetTestKey(3), time.Second)
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = db.PutWithTTL(utils.GetTestKey(4), utils.RandomValue(10), time.Millisecond*100)
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 200)
+	err = db.Touch(utils.GetTestKey(4), time.Second)
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_AscendContext(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	generateData(t, db, 1, 100, 128)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var count int
+	err = db.AscendContext(ctx, func(k []byte, v []byte) (bool, error) {
+		count++
+		if count == 10 {
+			cancel()
+		}
+		return true, nil
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 10, count)
+
+	err = db.DescendKeysContext(ctx, nil, func(k []byte) (bool, error) {
+		count++
+		return true, nil
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 10, count)
+
+	err = db.AscendRangeContext(context.Background(), utils.GetTestKey(10), utils.GetTestKey(20),
+		func(k []byte, v []byte) (bool, error) {
+			count++
+			return true, nil
+		})
+	assert.Nil(t, err)
+	assert.Equal(t, 20, count)
+}
+
+func TestDB_CloseWithTimeout(t *testing.T) {
+	options := DefaultOptions
+	options.WatchQueueSize = 1000
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 100000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	mergeErr := make(chan error)
+	go func() {
+		mergeErr <- db.Merge(true)
+	}()
+	time.Sleep(time.Millisecond * 10)
+
+	// the running merge will be aborted
+	err = db.CloseWithTimeout(time.Second * 5)
+	assert.Nil(t, err)
+	err = <-mergeErr
+	assert.True(t, err == nil || err == ErrDBClosed)
+
+	db2, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Equal(t, 100000, db2.Stat().KeysNum)
+}
+
+func TestDB_CloseWithTimeout_Expired(t *testing.T) {
+	options := DefaultOptions
+	options.WatchQueueSize = 10
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// a task not finishing in time keeps the database open and running
+	release := make(chan struct{})
+	db.goBackground(BackgroundTaskMerge, func() {
+		<-release
+	})
+	assert.Equal(t, ErrCloseTimeout, db.CloseWithTimeout(10*time.Millisecond))
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	val, err := db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	db.mu.RLock()
+	assert.False(t, db.isClosing())
+	db.mu.RUnlock()
+
+	close(release)
+	assert.Nil(t, db.Close())
+	assert.Equal(t, ErrDBClosed, db.Put([]byte("key"), []byte("value")))
+}
+
+func TestDB_Close_Twice(t *testing.T) {
+	options := DefaultOptions
+	options.WatchQueueSize = 10
+	options.BytesPerSync = 1
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	assert.Nil(t, db.Close())
+	assert.Nil(t, db.Close())
+
+	// the operations after closing return ErrDBClosed
+	assert.Equal(t, ErrDBClosed, db.Put([]byte("key"), []byte("value")))
+	_, err = db.Get([]byte("key"))
+	assert.Equal(t, ErrDBClosed, err)
+	assert.Equal(t, ErrDBClosed, db.Delete([]byte("key")))
+	assert.Equal(t, ErrDBClosed, db.Sync())
+	assert.Equal(t, ErrDBClosed, db.Merge(true))
+	assert.Equal(t, ErrDBClosed, db.AscendContext(context.Background(), func(k []byte, v []byte) (bool, error) {
+		return true, nil
+	}))
+	assert.Equal(t, ErrDBClosed, db.AscendKeysContext(context.Background(), nil, func(k []byte) (bool, error) {
+		return true, nil
+	}))
+	batch := db.NewBatch(DefaultBatchOptions)
+	assert.Equal(t, ErrDBClosed, batch.Put([]byte("key"), []byte("value")))
+	assert.Equal(t, ErrDBClosed, batch.Commit())
+}
+
+func TestDB_Close_Concurrent(t *testing.T) {
+	options := DefaultOptions
+	options.SegmentSize = 64 * KB
+	options.WatchQueueSize = 10
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+
+	// the operations racing with Close either succeed or return ErrDBClosed
+	expected := func(err error) bool {
+		return err == nil || err == ErrDBClosed || err == ErrKeyNotFound
+	}
+	operations := []func(i int) error{
+		func(i int) error { return db.Put(utils.GetTestKey(i), utils.RandomValue(128)) },
+		func(i int) error {
+			_, err := db.Get(utils.GetTestKey(i))
+			return err
+		},
+		func(i int) error { return db.Delete(utils.GetTestKey(i)) },
+		func(i int) error { return db.Sync() },
+		func(i int) error {
+			return db.AscendContext(context.Background(), func(k []byte, v []byte) (bool, error) {
+				return true, nil
+			})
+		},
+		func(i int) error {
+			err := db.Merge(false)
+			if err == ErrMergeRunning {
+				return nil
+			}
+			return err
+		},
+	}
+	var wg sync.WaitGroup
+	for _, op := range operations {
+		wg.Add(1)
+		go func(op func(i int) error) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				if err := op(i); !expected(err) {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+			}
+		}(op)
+	}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			time.Sleep(5 * time.Millisecond)
+			assert.Nil(t, db.Close())
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, ErrDBClosed, db.Put([]byte("key"), []byte("value")))
+}
+
+func TestDB_RenameKey(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	err = db.RenameKey([]byte("not-exist"), []byte("new"), false)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = db.PutWithTTL([]byte("staging:x"), []byte("val-1"), time.Hour)
+	assert.Nil(t, err)
+	err = db.Put([]byte("x"), []byte("val-2"))
+	assert.Nil(t, err)
+
+	err = db.RenameKey([]byte("staging:x"), []byte("x"), false)
+	assert.Equal(t, ErrKeyExists, err)
+	assertKeyExistOrNot(t, db, []byte("staging:x"), true)
+
+	err = db.RenameKey([]byte("staging:x"), []byte("x"), true)
+	assert.Nil(t, err)
+	assertKeyExistOrNot(t, db, []byte("staging:x"), false)
+	val, err := db.Get([]byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("val-1"), val)
+	ttl, err := db.TTL([]byte("x"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Hour)
+
+	// reopen
+	_ = db.Close()
+	db2, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assertKeyExistOrNot(t, db2, []byte("staging:x"), false)
+	val, err = db2.Get([]byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("val-1"), val)
+}
+
+func TestDB_SetMany(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	m := make(map[string][]byte)
+	for i := 0; i < 100; i++ {
+		m[string(utils.GetTestKey(i))] = utils.RandomValue(10)
+	}
+	assert.Nil(t, db.SetMany(m))
+	for key, value := range m {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+
+	// nothing is written if any key is empty
+	err = db.SetMany(map[string][]byte{"a": []byte("val-1"), "": []byte("val-2")})
+	assert.Equal(t, ErrKeyIsEmpty, err)
+	assertKeyExistOrNot(t, db, []byte("a"), false)
+
+	// the pairs are recovered after reopening
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	for key, value := range m {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
+
+func TestDB_KeyHasher(t *testing.T) {
+	options := DefaultOptions
+	// the keys of the same length collide
+	options.KeyHasher = func(key []byte) []byte {
+		return []byte{byte(len(key))}
+	}
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 100; i += 2 {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("new")))
+	}
+	for i := 0; i < 100; i += 3 {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	check := func() {
+		for i := 0; i < 100; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			switch {
+			case i%3 == 0:
+				assert.Equal(t, ErrKeyNotFound, err)
+			case i%2 == 0:
+				assert.Nil(t, err)
+				assert.Equal(t, []byte("new"), val)
+			default:
+				assert.Nil(t, err)
+				assert.Equal(t, utils.GetTestKey(i), val)
+			}
+		}
+		assert.Equal(t, 66, db.Stat().KeysNum)
+
+		// the keys are iterated in order
+		var prev []byte
+		db.Ascend(func(k []byte, v []byte) (bool, error) {
+			assert.True(t, bytes.Compare(prev, k) < 0)
+			prev = k
+			return true, nil
+		})
+	}
+	check()
+
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	check()
+
+	assert.Nil(t, db.Merge(true))
+	check()
+}
+
+func TestDB_Move(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	err = db.Move([]byte("not-exist"), []byte("db1:"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.Nil(t, db.PutWithTTL([]byte("x"), []byte("val-1"), time.Hour))
+	assert.Nil(t, db.Put([]byte("y"), []byte("val-2")))
+	assert.Nil(t, db.Put([]byte("db1:y"), []byte("val-3")))
+
+	assert.Nil(t, db.Move([]byte("x"), []byte("db1:")))
+	assertKeyExistOrNot(t, db, []byte("x"), false)
+	val, err := db.Get([]byte("db1:x"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("val-1"), val)
+	ttl, err := db.TTL([]byte("db1:x"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Hour)
+
+	// the destination exists
+	err = db.Move([]byte("y"), []byte("db1:"))
+	assert.Equal(t, ErrKeyExists, err)
+	val, err = db.Get([]byte("y"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("val-2"), val)
+	val, err = db.Get([]byte("db1:y"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("val-3"), val)
+}
+
+func TestDB_Copy(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	err = db.Copy([]byte("not-exist"), []byte("dst"), false)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = db.PutWithTTL([]byte("config"), []byte("val-1"), time.Hour)
+	assert.Nil(t, err)
+	err = db.Put([]byte("config-bak"), []byte("val-2"))
+	assert.Nil(t, err)
+
+	err = db.Copy([]byte("config"), []byte("config-bak"), false)
+	assert.Equal(t, ErrKeyExists, err)
+
+	err = db.Copy([]byte("config"), []byte("config-bak"), true)
+	assert.Nil(t, err)
+	for _, key := range [][]byte{[]byte("config"), []byte("config-bak")} {
+		val, err := db.Get(key)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("val-1"), val)
+		ttl, err := db.TTL(key)
+		assert.Nil(t, err)
+		assert.True(t, ttl > 0 && ttl <= time.Hour)
+	}
+}
+
+func TestDB_GetWithChecksum(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	_, _, err = db.GetWithChecksum([]byte("not-exist"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	value := utils.RandomValue(KB)
+	err = db.Put([]byte("key"), value)
+	assert.Nil(t, err)
+	val, crc, err := db.GetWithChecksum([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+	assert.Equal(t, crc32.ChecksumIEEE(value), crc)
+}
+
+func TestDB_DefaultTTL(t *testing.T) {
+	options := DefaultOptions
+	options.DefaultTTL = time.Hour
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// explicit ttl > default ttl > no expiry
+	assert.Nil(t, db.Put([]byte("default"), []byte("value")))
+	assert.Nil(t, db.PutWithTTL([]byte("explicit"), []byte("value"), time.Minute))
+	assert.Nil(t, db.PutWithTTL([]byte("never"), []byte("value"), NoTTL))
+	assert.Nil(t, db.Transact(func(tx *Tx) error {
+		return tx.Put([]byte("tx"), []byte("value"))
+	}))
+	for key, expected := range map[string]time.Duration{
+		"default": time.Hour, "explicit": time.Minute, "tx": time.Hour, "never": NoTTL,
+	} {
+		ttl, err := db.TTL([]byte(key))
+		assert.Nil(t, err)
+		if expected == NoTTL {
+			assert.Equal(t, NoTTL, ttl)
+		} else {
+			assert.True(t, ttl > expected-time.Minute/2 && ttl <= expected, key)
+		}
+	}
+
+	// the rewrites keep the ttl
+	_, err = db.SetRange([]byte("never"), 0, []byte("V"))
+	assert.Nil(t, err)
+	ttl, err := db.TTL([]byte("never"))
+	assert.Nil(t, err)
+	assert.Equal(t, NoTTL, ttl)
+
+	options.DefaultTTL = -time.Second
+	_, err = Open(options)
+	assert.NotNil(t, err)
+}
+
+func TestDB_GetRange(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	_, err = db.GetRange([]byte("not-exist"), 0, 1)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.Nil(t, db.Put([]byte("key"), []byte("0123456789")))
+	tests := []struct {
+		start, end int
+		expected   string
+	}{
+		{0, 10, "0123456789"},
+		{2, 5, "234"},
+		{-3, -1, "78"},
+		{-3, 10, "789"},
+		{5, 100, "56789"},
+		{-100, 2, "01"},
+		{5, 5, ""},
+		{7, 3, ""},
+		{20, 30, ""},
+	}
+	for _, tt := range tests {
+		val, err := db.GetRange([]byte("key"), tt.start, tt.end)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(tt.expected), val)
+	}
+
+	// the range of the pending value in the batch
+	batch := db.NewBatch(DefaultBatchOptions)
+	assert.Nil(t, batch.Put([]byte("key"), []byte("abcdef")))
+	val, err := batch.GetRange([]byte("key"), 1, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bcde"), val)
+	assert.Nil(t, batch.Rollback())
+}
+
+func TestDB_SetRange(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.PutWithTTL([]byte("key"), []byte("0123456789"), time.Hour))
+	size, err := db.SetRange([]byte("key"), 2, []byte("ab"))
+	assert.Nil(t, err)
+	assert.Equal(t, 10, size)
+	val, err := db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("01ab456789"), val)
+
+	// the value is extended, and the ttl is kept
+	size, err = db.SetRange([]byte("key"), 8, []byte("xyz"))
+	assert.Nil(t, err)
+	assert.Equal(t, 11, size)
+	val, err = db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("01ab4567xyz"), val)
+	ttl, err := db.TTL([]byte("key"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 59*time.Minute)
+
+	// the gap is filled with zero bytes
+	size, err = db.SetRange([]byte("new"), 3, []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, size)
+	val, err = db.Get([]byte("new"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 'a'}, val)
+
+	_, err = db.SetRange([]byte("key"), -1, []byte("a"))
+	assert.Equal(t, ErrNegativeOffset, err)
+	// the huge offset is rejected without MaxValueSize
+	for _, offset := range []int{int(options.SegmentSize), math.MaxInt} {
+		_, err = db.SetRange([]byte("key"), offset, []byte("a"))
+		assert.Equal(t, ErrValueTooLarge, err)
+	}
+
+	// the pending value in the batch is not modified in place
+	value := []byte("abc")
+	batch := db.NewBatch(DefaultBatchOptions)
+	assert.Nil(t, batch.Put([]byte("batch"), value))
+	size, err = batch.SetRange([]byte("batch"), 1, []byte("X"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, size)
+	assert.Equal(t, []byte("abc"), value)
+	assert.Nil(t, batch.Commit())
+	val, err = db.Get([]byte("batch"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("aXc"), val)
+}
+
+func TestDB_Reopen(t *testing.T) {
+	options := DefaultOptions
+	options.SegmentSize = 64 * KB
+	options.WatchQueueSize = 100
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	// the merge files are loaded when reopening
+	assert.Nil(t, db.Merge(false))
+	assert.Nil(t, db.Reopen())
+	assert.Equal(t, 400, db.Stat().KeysNum)
+	_, err = os.Stat(mergeDirPath(options.DirPath))
+	assert.True(t, os.IsNotExist(err))
+
+	// the database is still usable, and the watch channel is kept
+	watchCh, err := db.Watch()
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	// skip the events of the writes before reopening
+	for received := false; !received; {
+		select {
+		case event := <-watchCh:
+			received = string(event.Key) == "key"
+		case <-time.After(time.Second):
+			t.Fatal("watch event not received")
+		}
+	}
+	val, err := db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	assert.Nil(t, db.Reopen())
+	assert.Equal(t, 401, db.Stat().KeysNum)
+
+	assert.Nil(t, db.Close())
+	assert.Equal(t, ErrDBClosed, db.Reopen())
+}
+
+func TestDB_PrefixCount(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	count, err := db.PrefixCount([]byte("tenant1:"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("tenant1:%03d", i)), []byte("v")))
+	}
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("tenant2:%03d", i)), []byte("v")))
+	}
+	assert.Nil(t, db.Put([]byte("tenant"), []byte("v")))
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Delete([]byte(fmt.Sprintf("tenant1:%03d", i))))
+	}
+
+	count, err = db.PrefixCount([]byte("tenant1:"))
+	assert.Nil(t, err)
+	assert.Equal(t, 90, count)
+	count, err = db.PrefixCount([]byte("tenant2:"))
+	assert.Nil(t, err)
+	assert.Equal(t, 50, count)
+	count, err = db.PrefixCount([]byte("tenant"))
+	assert.Nil(t, err)
+	assert.Equal(t, 141, count)
+	count, err = db.PrefixCount(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 141, count)
+}
+
+func TestDB_BytesPerSync(t *testing.T) {
+	options := DefaultOptions
+	options.Sync = false
+	options.BytesPerSync = 4 * KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+		// the counter is reset once it reaches BytesPerSync
+		assert.True(t, atomic.LoadInt64(&db.unsyncedBytes) < int64(options.BytesPerSync))
+	}
+	// the sync is triggered in the background
+	assert.Nil(t, db.Put([]byte("key"), utils.RandomValue(4*KB)))
+	assert.Equal(t, int64(0), atomic.LoadInt64(&db.unsyncedBytes))
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, len(db.syncCh))
+
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, 101, db.Stat().KeysNum)
+}
+
+func TestDB_WaitForSync(t *testing.T) {
+	options := DefaultOptions
+	options.Sync = false
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// nothing to sync
+	assert.Nil(t, db.WaitForSync())
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+	}
+	assert.True(t, db.Stat().UnsyncedBytes > 0)
+	assert.Nil(t, db.WaitForSync())
+	assert.Equal(t, int64(0), db.Stat().UnsyncedBytes)
+	assert.Equal(t, atomic.LoadUint64(&db.writtenSeq), atomic.LoadUint64(&db.syncedSeq))
+
+	// the concurrent writers and waiters
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				assert.Nil(t, db.Put(utils.GetTestKey(i*100+j), utils.RandomValue(KB)))
+				seq := atomic.LoadUint64(&db.writtenSeq)
+				assert.Nil(t, db.WaitForSync())
+				assert.True(t, atomic.LoadUint64(&db.syncedSeq) >= seq)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// every batch is synced by the wal
+	assert.Nil(t, db.Close())
+	options.Sync = true
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	assert.Equal(t, atomic.LoadUint64(&db.writtenSeq), atomic.LoadUint64(&db.syncedSeq))
+	assert.Nil(t, db.WaitForSync())
+}
+
+func TestDB_Open_FileLock(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+
+	// the directory is locked by the opened database
+	_, err = Open(options)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+	assert.Nil(t, db.Close())
+
+	// the lock is released when Open fails
+	segmentFile := wal.SegmentFileName(options.DirPath, dataFileNameSuffix, 1)
+	data, err := os.ReadFile(segmentFile)
+	assert.Nil(t, err)
+	// the checksum of the first record mismatches
+	corrupted := append([]byte(nil), data...)
+	corrupted[10] ^= 0xff
+	assert.Nil(t, os.WriteFile(segmentFile, corrupted, 0644))
+	_, err = Open(options)
+	assert.NotNil(t, err)
+	assert.NotEqual(t, ErrDatabaseIsUsing, err)
+
+	assert.Nil(t, os.WriteFile(segmentFile, data, 0644))
+	db, err = Open(options)
+	assert.Nil(t, err)
+	val, err := db.Get([]byte("k1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+}
+
+func TestDB_Open_ReleaseLock(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, db.Close())
+
+	// the file lock is released when opening fails
+	formatFile := filepath.Join(options.DirPath, formatFileName)
+	buf, err := os.ReadFile(formatFile)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(formatFile, []byte{currentFormatVersion + 1}, 0644))
+	_, err = Open(options)
+	assert.Equal(t, ErrUnsupportedFormat, err)
+	assert.Nil(t, os.WriteFile(formatFile, buf, 0644))
+	db, err = Open(options)
+	assert.Nil(t, err)
+}
+
+func TestDB_Open_NodeID(t *testing.T) {
+	options := DefaultOptions
+	options.NodeID = 1024
+	_, err := Open(options)
+	assert.NotNil(t, err)
+
+	options.NodeID = 5
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+	batch := db.NewBatch(DefaultBatchOptions)
+	assert.Nil(t, batch.Put([]byte("k2"), []byte("v2")))
+	assert.Nil(t, batch.Commit())
+
+	// the batch ids are generated by the node of NodeID
+	reader := db.dataFiles.NewReader()
+	var records int
+	for {
+		chunk, _, err := reader.Next()
+		if err != nil {
+			break
+		}
+		record := decodeLogRecord(chunk)
+		if record.Type != LogRecordBatchFinished {
+			assert.Equal(t, int64(5), snowflake.ID(record.BatchId).Node())
+			records++
+		}
+	}
+	assert.Equal(t, 2, records)
+}
+
+func TestDB_Exist(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.Put([]byte("large"), utils.RandomValue(1<<20)))
+	assert.Nil(t, db.Put([]byte("deleted"), []byte("v")))
+	assert.Nil(t, db.Delete([]byte("deleted")))
+	assert.Nil(t, db.PutWithTTL([]byte("expired"), []byte("v"), time.Millisecond*50))
+	assert.Nil(t, db.PutWithTTL([]byte("ttl"), []byte("v"), time.Hour))
+
+	time.Sleep(time.Millisecond * 100)
+	for key, expected := range map[string]bool{
+		"large": true, "deleted": false, "expired": false, "ttl": true, "none": false,
+	} {
+		exist, err := db.Exist([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, exist, key)
+	}
+
+	// the header is decoded the same as the whole record.
+	record := &LogRecord{Key: []byte("key"), Value: []byte("value"), Type: LogRecordNormal,
+		BatchId: 1234, Expire: time.Now().UnixNano()}
+	buf := encodeLogRecord(record)
+	header := decodeLogRecordHeader(buf)
+	assert.Equal(t, record.Type, header.recordType)
+	assert.Equal(t, record.BatchId, header.batchId)
+	assert.Equal(t, record.Expire, header.expire)
+	assert.Equal(t, int64(3), header.keySize)
+	assert.Equal(t, int64(5), header.valueSize)
+	assert.Equal(t, []byte("key"), header.key(buf))
+	assert.Equal(t, []byte("value"), header.value(buf))
+	assert.Equal(t, record, decodeLogRecord(buf))
+}
+
+func TestEncodeLogRecordPooled(t *testing.T) {
+	records := []*LogRecord{
+		{Key: []byte("key"), Value: utils.RandomValue(KB), Type: LogRecordNormal, BatchId: 1, Expire: 100},
+		{Key: []byte("k"), Value: []byte{}, Type: LogRecordDeleted, BatchId: 2},
+		{Key: []byte("large"), Value: utils.RandomValue(maxPooledEncodeBufferSize), BatchId: 3},
+	}
+	for _, record := range records {
+		buf, encRecord := encodeLogRecordPooled(record)
+		assert.Equal(t, encodeLogRecord(record), encRecord)
+		assert.Equal(t, record, decodeLogRecord(encRecord))
+		putEncodeBuffer(buf)
+	}
+}
+
+func BenchmarkEncodeLogRecord(b *testing.B) {
+	record := &LogRecord{Key: utils.GetTestKey(0), Value: utils.RandomValue(KB), BatchId: 1234}
+	b.Run("alloc", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = encodeLogRecord(record)
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf, _ := encodeLogRecordPooled(record)
+			putEncodeBuffer(buf)
+		}
+	})
+}
+
+func TestDB_Ping(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+	}
+	assert.Nil(t, db.Ping())
+	walSize := db.Stat().WALSize
+	assert.Nil(t, db.PingWrite())
+	// the scratch record is written but no key is changed
+	stat := db.Stat()
+	assert.Equal(t, 10, stat.KeysNum)
+	assert.True(t, stat.WALSize > walSize)
+
+	assert.Nil(t, db.Close())
+	assert.Equal(t, ErrDBClosed, db.Ping())
+	assert.Equal(t, ErrDBClosed, db.PingWrite())
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, db.Stat().KeysNum)
+	for i := 0; i < 10; i++ {
+		_, err = db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	_ = db.Close()
+}
+
+func TestDB_MaxKeySize(t *testing.T) {
+	options := DefaultOptions
+	options.MaxKeySize = 16
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	key, largeKey := bytes.Repeat([]byte("k"), 16), bytes.Repeat([]byte("k"), 17)
+	assert.Nil(t, db.Put(key, []byte("value")))
+	assert.Equal(t, ErrKeyTooLarge, db.Put(largeKey, []byte("value")))
+	assert.Equal(t, ErrKeyTooLarge, db.PutWithTTL(largeKey, []byte("value"), time.Hour))
+	_, err = db.SetRange(largeKey, 0, []byte("value"))
+	assert.Equal(t, ErrKeyTooLarge, err)
+	assert.Equal(t, ErrKeyTooLarge, db.SetBit(largeKey, 7, true))
+	assert.Equal(t, ErrKeyTooLarge, db.Copy(key, largeKey, false))
+
+	batch := db.NewBatch(DefaultBatchOptions)
+	assert.Nil(t, batch.Put([]byte("k"), []byte("value")))
+	assert.Equal(t, ErrKeyTooLarge, batch.Put(largeKey, []byte("value")))
+	assert.Nil(t, batch.Commit())
+
+	// nothing is written for the large keys
+	assert.Equal(t, 2, db.Stat().KeysNum)
+	assertKeyExistOrNot(t, db, largeKey, false)
+
+	options.MaxKeySize = -1
+	_, err = Open(options)
+	assert.NotNil(t, err)
+}
+
+func TestDB_Stat_LastSyncAt(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	stat := db.Stat()
+	assert.True(t, stat.LastSyncAt.IsZero())
+	assert.Equal(t, int64(0), stat.UnsyncedBytes)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+	}
+	stat = db.Stat()
+	assert.True(t, stat.LastSyncAt.IsZero())
+	assert.True(t, stat.UnsyncedBytes > 10*KB)
+
+	start := time.Now()
+	assert.Nil(t, db.Sync())
+	stat = db.Stat()
+	assert.False(t, stat.LastSyncAt.Before(start))
+	assert.Equal(t, int64(0), stat.UnsyncedBytes)
+
+	// the batch synced when committing
+	assert.Nil(t, db.Put([]byte("key"), utils.RandomValue(KB)))
+	assert.True(t, db.Stat().UnsyncedBytes > KB)
+	batch := db.NewBatch(BatchOptions{Sync: true})
+	assert.Nil(t, batch.Put([]byte("key"), utils.RandomValue(KB)))
+	assert.Nil(t, batch.Commit())
+	assert.Equal(t, int64(0), db.Stat().UnsyncedBytes)
+	assert.Nil(t, db.Close())
+
+	// every write is synced
+	options.Sync = true
+	db, err = Open(options)
+	assert.Nil(t, err)
+	start = time.Now()
+	assert.Nil(t, db.Put([]byte("key"), utils.RandomValue(KB)))
+	stat = db.Stat()
+	assert.False(t, stat.LastSyncAt.Before(start))
+	assert.Equal(t, int64(0), stat.UnsyncedBytes)
+}
+
+func TestDB_Stat_GarbageRatio(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Equal(t, float64(0), db.Stat().GarbageRatio)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	// only the batch finished records are garbage
+	assert.True(t, db.Stat().GarbageRatio < 0.2)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	ratio := db.Stat().GarbageRatio
+	assert.True(t, ratio > 0.7 && ratio < 0.9, ratio)
+
+	// the ratio is computed from the data files and the index after reopening
+	assert.Nil(t, db.Reopen())
+	assert.InDelta(t, ratio, db.Stat().GarbageRatio, 0.02)
+
+	assert.Nil(t, db.Merge(true))
+	assert.True(t, db.Stat().GarbageRatio < 0.1)
+}
+
+func TestDB_AutoMerge(t *testing.T) {
+	options := DefaultOptions
+	options.AutoMergeThreshold = 0.5
+	options.AutoMergeCooldown = 0
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+
+	// the data files are merged in the background
+	deadline := time.Now().Add(time.Second * 5)
+	for db.Stat().GarbageRatio >= 0.5 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.True(t, db.Stat().GarbageRatio < 0.5)
+	for i := 0; i < 1000; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	_, err = Open(Options{DirPath: options.DirPath + "-invalid", SegmentSize: GB, AutoMergeThreshold: 1})
+	assert.NotNil(t, err)
+}
+
+func TestDB_MaxWALSize(t *testing.T) {
+	options := DefaultOptions
+	options.MaxWALSize = 64 * KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// overwrite the same keys until the writes are stalled
+	var i int
+	for ; i < 10000; i++ {
+		if err = db.Put(utils.GetTestKey(i%10), utils.RandomValue(KB)); err != nil {
+			break
+		}
+	}
+	assert.Equal(t, ErrWriteStall, err)
+	assert.True(t, db.Stat().WALSize >= options.MaxWALSize)
+	// the deletes are not stalled
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	batch := db.NewBatch(DefaultBatchOptions)
+	assert.Nil(t, batch.Delete(utils.GetTestKey(2)))
+	assert.Nil(t, batch.Put(utils.GetTestKey(3), utils.RandomValue(KB)))
+	assert.Equal(t, ErrWriteStall, batch.Commit())
+	// the reads are not stalled
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+
+	// merge reclaims the space
+	assert.Nil(t, db.Merge(true))
+	assert.True(t, db.Stat().WALSize < options.MaxWALSize)
+	assert.Nil(t, db.Put(utils.GetTestKey(0), utils.RandomValue(KB)))
+}
+
+func TestDB_MaxWALSize_Block(t *testing.T) {
+	options := DefaultOptions
+	options.MaxWALSize = 64 * KB
+	options.WriteStallTimeout = time.Second * 5
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for db.Stat().WALSize < options.MaxWALSize {
+		assert.Nil(t, db.Put(utils.GetTestKey(0), utils.RandomValue(KB)))
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- db.Put(utils.GetTestKey(1), utils.RandomValue(KB))
+	}()
+	select {
+	case <-done:
+		t.Fatal("the write is not stalled")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	// the stalled write continues after merge
+	assert.Nil(t, db.Merge(true))
+	assert.Nil(t, <-done)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+}
+
+func TestDB_OnBackgroundError(t *testing.T) {
+	type report struct {
+		task string
+		err  error
+	}
+	reports := make(chan report, 10)
+	options := DefaultOptions
+	options.OnBackgroundError = func(task string, err error) {
+		reports <- report{task: task, err: err}
+	}
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// the panic is recovered and reported
+	db.goBackground("test", func() {
+		panic("boom")
+	})
+	r := <-reports
+	assert.Equal(t, "test", r.task)
+	assert.Contains(t, r.err.Error(), "boom")
+
+	// the replication stream fails if its position is merged
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+	}
+	ch, err := db.ReplicationStream(nil)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		<-ch
+	}
+	assert.Nil(t, db.Merge(true))
+	select {
+	case r = <-reports:
+		assert.Equal(t, BackgroundTaskReplication, r.task)
+		assert.Equal(t, ErrResyncRequired, r.err)
+	case <-time.After(time.Second):
+		t.Fatal("the replication error is not reported")
+	}
+	for range ch {
+	}
+
+	// the errors are not reported when closing
+	assert.Nil(t, db.Close())
+	assert.Equal(t, 0, len(reports))
+}
+
+func TestDB_SyncDirOnRotate(t *testing.T) {
+	for _, syncDir := range []bool{true, false} {
+		options := DefaultOptions
+		options.SegmentSize = 64 * KB
+		options.SyncDirOnRotate = syncDir
+		db, err := Open(options)
+		assert.Nil(t, err)
+
+		for i := 0; i < 200; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+		}
+		assert.True(t, db.dataFiles.ActiveSegmentID() > 1)
+		assert.Nil(t, db.Sync())
+		if syncDir {
+			assert.Equal(t, db.dataFiles.ActiveSegmentID(), atomic.LoadUint32(&db.dirSyncedSegId))
+		} else {
+			assert.Equal(t, uint32(0), atomic.LoadUint32(&db.dirSyncedSegId))
+		}
+
+		// every write is synced
+		db.options.Sync = true
+		for i := 200; i < 400; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+		}
+		if syncDir {
+			assert.Equal(t, db.dataFiles.ActiveSegmentID(), atomic.LoadUint32(&db.dirSyncedSegId))
+		}
+		destroyDB(db)
+	}
+}
@@ -0,0 +1,168 @@
+package rosedb
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+
+	"github.com/rosedblabs/wal"
+)
+
+const (
+	digestVersion = 1
+	// digestPrecision is the precision of the HyperLogLog, there are 2^14 registers,
+	// and the standard error of the cardinality is about 0.81%.
+	digestPrecision = 14
+	digestRegisters = 1 << digestPrecision
+	// the bloom filter is sized for about 1% false positive rate.
+	digestBloomHashes     = 7
+	digestBloomBitsPerKey = 10
+	digestBloomMinBits    = 64
+
+	// +---------+-----------+---------------+-------------+------------+
+	// | version | precision | hll registers | bloom k     | bloom bits |
+	// +---------+-----------+---------------+-------------+------------+
+	//   1 byte     1 byte      2^14 bytes      1 byte        variable
+	digestHeaderSize      = 2
+	digestBloomBitsOffset = digestHeaderSize + digestRegisters + 1
+)
+
+// KeyDigest returns a compact digest of all the keys in the database, which is built
+// from the index in one pass, without reading the data files.
+// It consists of a HyperLogLog for estimating the number of keys, and a bloom filter for
+// testing whether a key may exist, so two databases can compare their keys
+// without exchanging them, see DigestCardinality, DigestOverlap and DigestMayContain.
+//
+// The expired keys which have not been merged may be included.
+func (db *DB) KeyDigest() ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	bloomBits := db.index.Size() * digestBloomBitsPerKey
+	if bloomBits < digestBloomMinBits {
+		bloomBits = digestBloomMinBits
+	}
+	digest := make([]byte, digestBloomBitsOffset+(bloomBits+7)/8)
+	digest[0] = digestVersion
+	digest[1] = digestPrecision
+	registers := digest[digestHeaderSize : digestHeaderSize+digestRegisters]
+	digest[digestHeaderSize+digestRegisters] = digestBloomHashes
+	bloom := digest[digestBloomBitsOffset:]
+
+	db.index.Ascend(func(key []byte, _ *wal.ChunkPosition) (bool, error) {
+		h := digestHash(key)
+		// the first bits are the register index, and the leading zeros of the rest are counted.
+		idx := h >> (64 - digestPrecision)
+		rank := uint8(bits.LeadingZeros64(h<<digestPrecision|1<<(digestPrecision-1))) + 1
+		if rank > registers[idx] {
+			registers[idx] = rank
+		}
+		for _, bit := range bloomBitIndexes(h, uint64(len(bloom))*8) {
+			bloom[bit/8] |= 1 << (bit % 8)
+		}
+		return true, nil
+	})
+	return digest, nil
+}
+
+// DigestCardinality returns the estimated number of keys in the digest returned by KeyDigest.
+func DigestCardinality(digest []byte) (uint64, error) {
+	if err := checkDigest(digest); err != nil {
+		return 0, err
+	}
+	return hllEstimate(digest[digestHeaderSize : digestHeaderSize+digestRegisters]), nil
+}
+
+// DigestOverlap returns the estimated number of keys existing in both digests returned by KeyDigest,
+// which is computed from the cardinalities of the two digests and their union.
+// The error of the estimation is relative to the union, so it is not accurate for a small overlap.
+func DigestOverlap(a, b []byte) (uint64, error) {
+	if err := checkDigest(a); err != nil {
+		return 0, err
+	}
+	if err := checkDigest(b); err != nil {
+		return 0, err
+	}
+	registersA := a[digestHeaderSize : digestHeaderSize+digestRegisters]
+	registersB := b[digestHeaderSize : digestHeaderSize+digestRegisters]
+	union := make([]byte, digestRegisters)
+	for i := range union {
+		union[i] = registersA[i]
+		if registersB[i] > union[i] {
+			union[i] = registersB[i]
+		}
+	}
+	overlap := int64(hllEstimate(registersA)) + int64(hllEstimate(registersB)) - int64(hllEstimate(union))
+	if overlap < 0 {
+		return 0, nil
+	}
+	return uint64(overlap), nil
+}
+
+// DigestMayContain reports whether the key may exist in the digest returned by KeyDigest.
+// If it returns false, the key definitely does not exist,
+// otherwise the key exists with a false positive rate about 1%.
+func DigestMayContain(digest []byte, key []byte) (bool, error) {
+	if err := checkDigest(digest); err != nil {
+		return false, err
+	}
+	bloom := digest[digestBloomBitsOffset:]
+	for _, bit := range bloomBitIndexes(digestHash(key), uint64(len(bloom))*8) {
+		if bloom[bit/8]&(1<<(bit%8)) == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func checkDigest(digest []byte) error {
+	if len(digest) <= digestBloomBitsOffset || digest[0] != digestVersion ||
+		digest[1] != digestPrecision || digest[digestHeaderSize+digestRegisters] != digestBloomHashes {
+		return ErrInvalidDigest
+	}
+	return nil
+}
+
+// digestHash returns the 64-bit hash of the key, the fnv hash is mixed
+// by the finalizer of splitmix64, so all the bits are well distributed.
+func digestHash(key []byte) uint64 {
+	hash := fnv.New64a()
+	_, _ = hash.Write(key)
+	h := hash.Sum64()
+	h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+	h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+	return h ^ (h >> 31)
+}
+
+// bloomBitIndexes returns the bits of the key in the bloom filter with m bits,
+// the hashes are derived from the two halves of the key hash.
+func bloomBitIndexes(h uint64, m uint64) []uint64 {
+	h1, h2 := h&math.MaxUint32, h>>32|1
+	indexes := make([]uint64, digestBloomHashes)
+	for i := range indexes {
+		indexes[i] = (h1 + uint64(i)*h2) % m
+	}
+	return indexes
+}
+
+// hllEstimate returns the cardinality estimated from the HyperLogLog registers,
+// the small cardinality is corrected by linear counting.
+func hllEstimate(registers []byte) uint64 {
+	m := float64(len(registers))
+	var sum float64
+	var zeros int
+	for _, r := range registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
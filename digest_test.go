@@ -0,0 +1,72 @@
+package rosedb
+
+import (
+	"testing"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_KeyDigest(t *testing.T) {
+	options := DefaultOptions
+	db1, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db1)
+	options.DirPath = options.DirPath + "-2"
+	db2, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db2)
+
+	// db1 has keys [0, 20000), db2 has keys [10000, 40000)
+	for i := 0; i < 20000; i++ {
+		assert.Nil(t, db1.Put(utils.GetTestKey(i), []byte("v")))
+	}
+	for i := 10000; i < 40000; i++ {
+		assert.Nil(t, db2.Put(utils.GetTestKey(i), []byte("v")))
+	}
+	digest1, err := db1.KeyDigest()
+	assert.Nil(t, err)
+	digest2, err := db2.KeyDigest()
+	assert.Nil(t, err)
+
+	n, err := DigestCardinality(digest1)
+	assert.Nil(t, err)
+	assert.InDelta(t, 20000, n, 20000*0.05)
+	n, err = DigestCardinality(digest2)
+	assert.Nil(t, err)
+	assert.InDelta(t, 30000, n, 30000*0.05)
+	overlap, err := DigestOverlap(digest1, digest2)
+	assert.Nil(t, err)
+	assert.InDelta(t, 10000, overlap, 40000*0.05)
+
+	for i := 0; i < 20000; i++ {
+		ok, err := DigestMayContain(digest1, utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+	var falsePositives int
+	for i := 20000; i < 40000; i++ {
+		if ok, _ := DigestMayContain(digest1, utils.GetTestKey(i)); ok {
+			falsePositives++
+		}
+	}
+	assert.Less(t, falsePositives, 20000*3/100)
+
+	// the digest of an empty database
+	db3Options := DefaultOptions
+	db3Options.DirPath = options.DirPath + "-3"
+	db3, err := Open(db3Options)
+	assert.Nil(t, err)
+	defer destroyDB(db3)
+	digest3, err := db3.KeyDigest()
+	assert.Nil(t, err)
+	n, err = DigestCardinality(digest3)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), n)
+	ok, err := DigestMayContain(digest3, []byte("key"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, err = DigestCardinality([]byte("invalid"))
+	assert.Equal(t, ErrInvalidDigest, err)
+}
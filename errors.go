@@ -3,13 +3,39 @@ package rosedb
 import "errors"
 
 var (
-	ErrKeyIsEmpty      = errors.New("the key is empty")
-	ErrKeyNotFound     = errors.New("key not found in database")
-	ErrDatabaseIsUsing = errors.New("the database directory is used by another process")
-	ErrReadOnlyBatch   = errors.New("the batch is read only")
-	ErrBatchCommitted  = errors.New("the batch is committed")
-	ErrBatchRollbacked = errors.New("the batch is rollbacked")
-	ErrDBClosed        = errors.New("the database is closed")
-	ErrMergeRunning    = errors.New("the merge operation is running")
-	ErrWatchDisabled   = errors.New("the watch is disabled")
+	ErrKeyIsEmpty          = errors.New("the key is empty")
+	ErrKeyNotFound         = errors.New("key not found in database")
+	ErrDatabaseIsUsing     = errors.New("the database directory is used by another process")
+	ErrReadOnlyBatch       = errors.New("the batch is read only")
+	ErrBatchCommitted      = errors.New("the batch is committed")
+	ErrBatchRollbacked     = errors.New("the batch is rollbacked")
+	ErrBatchFailed         = errors.New("the batch failed to commit")
+	ErrDBClosed            = errors.New("the database is closed")
+	ErrMergeRunning        = errors.New("the merge operation is running")
+	ErrWatchDisabled       = errors.New("the watch is disabled")
+	ErrValueTooLarge       = errors.New("the value size exceeds the max value size")
+	ErrKeyTooLarge         = errors.New("the key size exceeds the max key size")
+	ErrValueLogNotFound    = errors.New("the value log is not found")
+	ErrCloseTimeout        = errors.New("timeout waiting for the background tasks to finish")
+	ErrKeyExists           = errors.New("the key already exists")
+	ErrIndexExists         = errors.New("the index already exists")
+	ErrIndexNotFound       = errors.New("the index is not found")
+	ErrTrackAccessDisabled = errors.New("the access tracking is disabled")
+	ErrResyncRequired      = errors.New("the replication position has been merged, a full resync is required")
+	ErrConflict            = errors.New("the transaction conflicts with other writes")
+	ErrInvalidDigest       = errors.New("the key digest is invalid")
+	ErrUnsupportedFormat   = errors.New("the data format version is newer than supported")
+	ErrInvalidFormat       = errors.New("the data format file is empty or invalid")
+	ErrCorruptedIndex      = errors.New("the index is corrupted")
+	ErrInvalidPattern      = errors.New("the glob pattern is invalid")
+	ErrInvalidScore        = errors.New("the score is not a number")
+	ErrWriteStall          = errors.New("the data files exceed the max wal size, the writes are stalled until merge")
+	ErrCodecNotFound       = errors.New("the codec of the value is not found in the options")
+	ErrNegativeOffset      = errors.New("the offset is negative")
+	ErrFamilyNameIsEmpty   = errors.New("the column family name is empty")
+	ErrValueWriterClosed   = errors.New("the value writer is closed")
+	ErrInvalidChecksum     = errors.New("the checksum of the record is invalid")
+	ErrPingMismatch        = errors.New("the scratch record read back does not match the written one")
+	ErrReadOnlyReplica     = errors.New("the replica is read only")
+	ErrReservedKey         = errors.New("the key starts with the reserved prefix of the data structures")
 )
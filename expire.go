@@ -0,0 +1,130 @@
+package rosedb
+
+import "time"
+
+// ExpireAt sets the expiry time of the key to t, the key expires immediately if t is in the past.
+func (b *Batch) ExpireAt(key []byte, t time.Time) error {
+	_, err := b.expireIf(key, t.UnixNano(), func(int64) bool { return true })
+	return err
+}
+
+// ExpireNX sets the ttl of the key only if the key has no ttl,
+// it returns whether the ttl is set.
+func (b *Batch) ExpireNX(key []byte, ttl time.Duration) (bool, error) {
+	return b.expireIf(key, b.db.now().Add(ttl).UnixNano(), func(current int64) bool {
+		return current == 0
+	})
+}
+
+// ExpireGT sets the ttl of the key only if the new expiry time is later than the current one,
+// it returns whether the ttl is set. The key without ttl is regarded as never expiring,
+// so its ttl is never set.
+func (b *Batch) ExpireGT(key []byte, ttl time.Duration) (bool, error) {
+	expire := b.db.now().Add(ttl).UnixNano()
+	return b.expireIf(key, expire, func(current int64) bool {
+		return current != 0 && expire > current
+	})
+}
+
+// ExpireLT sets the ttl of the key only if the new expiry time is earlier than the current one,
+// it returns whether the ttl is set. The key without ttl is regarded as never expiring,
+// so its ttl is always set.
+func (b *Batch) ExpireLT(key []byte, ttl time.Duration) (bool, error) {
+	expire := b.db.now().Add(ttl).UnixNano()
+	return b.expireIf(key, expire, func(current int64) bool {
+		return current == 0 || expire < current
+	})
+}
+
+// expireIf sets the expiry time of the key if cond returns true for the current expiry time,
+// which is 0 if the key has no ttl. It returns whether the expiry time is set.
+// It returns ErrKeyNotFound if the key does not exist or is expired.
+func (b *Batch) expireIf(key []byte, expire int64, cond func(current int64) bool) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	if b.readCommitted() {
+		b.db.mu.RLock()
+		defer b.db.mu.RUnlock()
+	}
+	if b.db.closed {
+		return false, ErrDBClosed
+	}
+	if b.options.ReadOnly {
+		return false, ErrReadOnlyBatch
+	}
+	if isStructKey(key) {
+		return false, ErrReservedKey
+	}
+
+	record, err := b.getRecord(key)
+	if err != nil {
+		return false, err
+	}
+	if !cond(record.Expire) {
+		return false, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// only the expiry time is changed, the record will be rewritten
+	record.Expire = expire
+	b.pendingWrites[string(key)] = record
+	return true, nil
+}
+
+// ExpireAt sets the expiry time of the key to t, see Batch.ExpireAt.
+func (db *DB) ExpireAt(key []byte, t time.Time) error {
+	batch := db.batchPool.Get().(*Batch)
+	defer func() {
+		batch.reset()
+		db.batchPool.Put(batch)
+	}()
+	batch.init(false, false, db).withPendingWrites()
+	if err := batch.ExpireAt(key, t); err != nil {
+		_ = batch.Rollback()
+		return err
+	}
+	return batch.Commit()
+}
+
+// ExpireNX sets the ttl of the key only if the key has no ttl, see Batch.ExpireNX.
+func (db *DB) ExpireNX(key []byte, ttl time.Duration) (bool, error) {
+	return db.expireIf(func(batch *Batch) (bool, error) {
+		return batch.ExpireNX(key, ttl)
+	})
+}
+
+// ExpireGT sets the ttl of the key only if it expires later than the current ttl, see Batch.ExpireGT.
+func (db *DB) ExpireGT(key []byte, ttl time.Duration) (bool, error) {
+	return db.expireIf(func(batch *Batch) (bool, error) {
+		return batch.ExpireGT(key, ttl)
+	})
+}
+
+// ExpireLT sets the ttl of the key only if it expires earlier than the current ttl, see Batch.ExpireLT.
+func (db *DB) ExpireLT(key []byte, ttl time.Duration) (bool, error) {
+	return db.expireIf(func(batch *Batch) (bool, error) {
+		return batch.ExpireLT(key, ttl)
+	})
+}
+
+// expireIf runs fn in a new batch and commits it if the expiry time is set by fn,
+// the current expiry time is read and the new one is written with the database locked.
+func (db *DB) expireIf(fn func(batch *Batch) (bool, error)) (bool, error) {
+	batch := db.batchPool.Get().(*Batch)
+	defer func() {
+		batch.reset()
+		db.batchPool.Put(batch)
+	}()
+	batch.init(false, false, db).withPendingWrites()
+	set, err := fn(batch)
+	if err != nil || !set {
+		_ = batch.Rollback()
+		return false, err
+	}
+	if err = batch.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,81 @@
+package rosedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ExpireAt(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Equal(t, ErrKeyNotFound, db.ExpireAt([]byte("key"), time.Now().Add(time.Hour)))
+
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	assert.Nil(t, db.ExpireAt([]byte("key"), time.Now().Add(time.Hour)))
+	ttl, err := db.TTL([]byte("key"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 59*time.Minute && ttl <= time.Hour)
+
+	// the key expires immediately if the time is in the past
+	assert.Nil(t, db.ExpireAt([]byte("key"), time.Now().Add(-time.Second)))
+	assertKeyExistOrNot(t, db, []byte("key"), false)
+}
+
+func TestDB_ExpireNX_GT_LT(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	_, err = db.ExpireNX([]byte("key"), time.Hour)
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+
+	// the key without ttl never expires, so GT does not set it, and LT does
+	set, err := db.ExpireGT([]byte("key"), time.Hour)
+	assert.Nil(t, err)
+	assert.False(t, set)
+	set, err = db.ExpireLT([]byte("key"), 2*time.Hour)
+	assert.Nil(t, err)
+	assert.True(t, set)
+
+	// NX only sets the key without ttl
+	set, err = db.ExpireNX([]byte("key"), time.Hour)
+	assert.Nil(t, err)
+	assert.False(t, set)
+
+	tests := []struct {
+		expire   func(key []byte, ttl time.Duration) (bool, error)
+		ttl      time.Duration
+		expected bool
+	}{
+		{db.ExpireGT, time.Hour, false},
+		{db.ExpireGT, 3 * time.Hour, true},
+		{db.ExpireLT, 4 * time.Hour, false},
+		{db.ExpireLT, time.Hour, true},
+	}
+	for _, tt := range tests {
+		set, err = tt.expire([]byte("key"), tt.ttl)
+		assert.Nil(t, err)
+		assert.Equal(t, tt.expected, set)
+	}
+	ttl, err := db.TTL([]byte("key"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 59*time.Minute && ttl <= time.Hour)
+
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	set, err = db.ExpireNX([]byte("key"), time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, set)
+	ttl, err = db.TTL([]byte("key"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Minute)
+	val, err := db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+}
@@ -0,0 +1,154 @@
+package rosedb
+
+import "time"
+
+// ColumnFamily is a logically separate keyspace of the database, like the column family of RocksDB,
+// the same key can be stored in different families with different values.
+//
+// The families share the data files, so a batch can write the keys of several families atomically,
+// see ColumnFamily.WithBatch. The keys of a family are the records of a data structure, see encodeStructKey,
+// whose key is the name of the family, so each record is tagged with its family, and is routed back
+// to the keyspace of the family when the index is rebuilt.
+// The keyspace of a family is a contiguous range of the index, so iterating a family does not scan the others.
+// The records of the families are hidden from the iterations and the watch events of the whole database,
+// like Ascend, Keys and PrefixCount, and can't be written through the keys of the default keyspace.
+type ColumnFamily struct {
+	db   *DB
+	name []byte
+}
+
+// ColumnFamily returns the handle of the column family with name, the family exists as long as it has keys,
+// so it is not necessary to create or drop it.
+// It returns ErrFamilyNameIsEmpty if name is empty.
+func (db *DB) ColumnFamily(name string) (*ColumnFamily, error) {
+	if len(name) == 0 {
+		return nil, ErrFamilyNameIsEmpty
+	}
+	return &ColumnFamily{db: db, name: []byte(name)}, nil
+}
+
+// Name returns the name of the column family.
+func (cf *ColumnFamily) Name() string {
+	return string(cf.name)
+}
+
+// encodeKey returns the key of the record storing the key of the family.
+func (cf *ColumnFamily) encodeKey(key []byte) []byte {
+	return encodeStructKey(familyDataType, cf.name, key)
+}
+
+// Put puts a key-value pair into the column family.
+func (cf *ColumnFamily) Put(key, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return cf.db.updateStruct(func(batch *Batch) error {
+		return batch.put(cf.encodeKey(key), value)
+	})
+}
+
+// PutWithTTL puts a key-value pair with ttl into the column family.
+func (cf *ColumnFamily) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return cf.db.updateStruct(func(batch *Batch) error {
+		return batch.putWithTTL(cf.encodeKey(key), value, ttl)
+	})
+}
+
+// Get returns the value of the key in the column family.
+func (cf *ColumnFamily) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	return cf.db.Get(cf.encodeKey(key))
+}
+
+// Delete deletes the key from the column family.
+func (cf *ColumnFamily) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return cf.db.updateStruct(func(batch *Batch) error {
+		return batch.delete(cf.encodeKey(key))
+	})
+}
+
+// Exist checks if the key exists in the column family.
+func (cf *ColumnFamily) Exist(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	return cf.db.Exist(cf.encodeKey(key))
+}
+
+// Ascend calls handleFn for each key/value pair in the column family in ascending order,
+// the deleted and expired keys are skipped.
+func (cf *ColumnFamily) Ascend(handleFn func(k []byte, v []byte) (bool, error)) error {
+	cf.db.mu.RLock()
+	defer cf.db.mu.RUnlock()
+	if cf.db.closed {
+		return ErrDBClosed
+	}
+	return cf.db.ascendStruct(familyDataType, cf.name, true, func(key []byte, record *LogRecord) (bool, error) {
+		return handleFn(key, record.Value)
+	})
+}
+
+// FamilyBatch writes and reads the keys of a column family in a batch, see ColumnFamily.WithBatch.
+type FamilyBatch struct {
+	batch *Batch
+	cf    *ColumnFamily
+}
+
+// WithBatch returns the view of the column family in the batch, the writes of the families
+// through the same batch are committed atomically by the batch.
+//
+//	batch := db.NewBatch(DefaultBatchOptions)
+//	_ = users.WithBatch(batch).Put(userKey, user)
+//	_ = orders.WithBatch(batch).Put(orderKey, order)
+//	err := batch.Commit()
+func (cf *ColumnFamily) WithBatch(batch *Batch) *FamilyBatch {
+	return &FamilyBatch{batch: batch, cf: cf}
+}
+
+// Put adds a key-value pair of the column family to the batch for writing.
+func (fb *FamilyBatch) Put(key, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return fb.batch.put(fb.cf.encodeKey(key), value)
+}
+
+// PutWithTTL adds a key-value pair with ttl of the column family to the batch for writing.
+func (fb *FamilyBatch) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return fb.batch.putWithTTL(fb.cf.encodeKey(key), value, ttl)
+}
+
+// Get retrieves the value of the key in the column family from the batch.
+func (fb *FamilyBatch) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	return fb.batch.Get(fb.cf.encodeKey(key))
+}
+
+// Delete marks the key of the column family for deletion in the batch.
+func (fb *FamilyBatch) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return fb.batch.delete(fb.cf.encodeKey(key))
+}
+
+// Exist checks if the key exists in the column family from the batch.
+func (fb *FamilyBatch) Exist(key []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	return fb.batch.Exist(fb.cf.encodeKey(key))
+}
@@ -0,0 +1,92 @@
+package rosedb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ColumnFamily(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	_, err = db.ColumnFamily("")
+	assert.Equal(t, ErrFamilyNameIsEmpty, err)
+	users, err := db.ColumnFamily("users")
+	assert.Nil(t, err)
+	assert.Equal(t, "users", users.Name())
+	orders, err := db.ColumnFamily("orders")
+	assert.Nil(t, err)
+
+	// the same key in the families and the default keyspace
+	assert.Nil(t, db.Put([]byte("key"), []byte("default")))
+	assert.Nil(t, users.Put([]byte("key"), []byte("user")))
+	assert.Nil(t, orders.Put([]byte("key"), []byte("order")))
+	for value, get := range map[string]func([]byte) ([]byte, error){
+		"default": db.Get, "user": users.Get, "order": orders.Get,
+	} {
+		v, err := get([]byte("key"))
+		assert.Nil(t, err)
+		assert.Equal(t, value, string(v))
+	}
+	assert.Nil(t, orders.Delete([]byte("key")))
+	exist, err := orders.Exist([]byte("key"))
+	assert.Nil(t, err)
+	assert.False(t, exist)
+	exist, err = users.Exist([]byte("key"))
+	assert.Nil(t, err)
+	assert.True(t, exist)
+	assert.Equal(t, ErrKeyIsEmpty, users.Put(nil, []byte("value")))
+
+	// the writes across the families are atomic
+	batch := db.NewBatch(DefaultBatchOptions)
+	assert.Nil(t, users.WithBatch(batch).Put([]byte("u1"), []byte("v1")))
+	assert.Nil(t, orders.WithBatch(batch).Put([]byte("o1"), []byte("v1")))
+	v, err := users.WithBatch(batch).Get([]byte("u1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), v)
+	assert.Nil(t, batch.Rollback())
+	_, err = users.Get([]byte("u1"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	batch = db.NewBatch(DefaultBatchOptions)
+	assert.Nil(t, users.WithBatch(batch).Put([]byte("u1"), []byte("v1")))
+	assert.Nil(t, orders.WithBatch(batch).Put([]byte("o1"), []byte("v1")))
+	assert.Nil(t, batch.Commit())
+
+	// the records are routed back to the families when the index is rebuilt
+	assert.Nil(t, db.Merge(true))
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	users, _ = db.ColumnFamily("users")
+	orders, _ = db.ColumnFamily("orders")
+
+	ascend := func(cf *ColumnFamily) map[string]string {
+		kvs := make(map[string]string)
+		assert.Nil(t, cf.Ascend(func(k []byte, v []byte) (bool, error) {
+			kvs[string(k)] = string(v)
+			return true, nil
+		}))
+		return kvs
+	}
+	assert.Equal(t, map[string]string{"key": "user", "u1": "v1"}, ascend(users))
+	assert.Equal(t, map[string]string{"o1": "v1"}, ascend(orders))
+
+	// the records of the families are hidden from the default keyspace
+	var keys []string
+	db.Ascend(func(k []byte, v []byte) (bool, error) {
+		keys = append(keys, string(k))
+		return true, nil
+	})
+	assert.Equal(t, []string{"key"}, keys)
+	count, err := db.PrefixCount(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, ErrReservedKey, db.Put(users.encodeKey([]byte("u1")), []byte("v2")))
+	v, err = db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), v)
+}
@@ -0,0 +1,142 @@
+package rosedb
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const (
+	// formatFileName is the file saving the format version of the data files.
+	// The version is saved once for the directory rather than in each segment, since the segment files
+	// are created by the wal, which has no room for a header of our own, and all the data files
+	// of a directory are in the same format anyway: MigrateFormat rewrites them by merging all of them.
+	formatFileName = "FORMAT"
+
+	// legacyFormatVersion is the format version of the data files
+	// written before the format version is saved.
+	legacyFormatVersion byte = 0
+	// initialFormatVersion is the first saved format version.
+	initialFormatVersion byte = 1
+	// codecFormatVersion saves the flags of the codecs in the high bits of the type byte
+	// of the records, see Options.ValueCodec, which the older versions read as unknown record types.
+	codecFormatVersion byte = 2
+	// checksumFormatVersion appends the checksum and its algorithm to the records, see Options.ChecksumType.
+	checksumFormatVersion byte = 3
+	// currentFormatVersion is the format version of the data files written by this version.
+	currentFormatVersion = checksumFormatVersion
+)
+
+// loadFormatVersion returns the format version of the data files in dirPath.
+// It returns ErrInvalidFormat if the format file is not a single version byte,
+// and ErrUnsupportedFormat if the version is unknown to this version.
+// If the format file does not exist, the data files are in the legacy format,
+// or it is a new database, and the current format version is saved.
+func loadFormatVersion(dirPath string) (byte, error) {
+	buf, err := os.ReadFile(filepath.Join(dirPath, formatFileName))
+	if err == nil {
+		if len(buf) != 1 {
+			return 0, ErrInvalidFormat
+		}
+		if buf[0] > currentFormatVersion {
+			return 0, ErrUnsupportedFormat
+		}
+		return buf[0], nil
+	}
+	if !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dirPath, "*"+dataFileNameSuffix))
+	if err != nil {
+		return 0, err
+	}
+	if len(files) > 0 {
+		return legacyFormatVersion, nil
+	}
+	if err = saveFormatVersion(dirPath, currentFormatVersion); err != nil {
+		return 0, err
+	}
+	return currentFormatVersion, nil
+}
+
+// requiredFormatVersion returns the lowest format version which knows the records written with the options,
+// the records with the codec flags or the checksums are misread by the versions before them.
+func requiredFormatVersion(options Options) byte {
+	if options.ChecksumType != ChecksumNone {
+		return checksumFormatVersion
+	}
+	if len(options.ValueCodec) > 0 {
+		return codecFormatVersion
+	}
+	return legacyFormatVersion
+}
+
+// raiseFormatVersion saves the format version required by the options if the data files are in an older one,
+// it must be called before writing anything, so the older versions refuse to open the database
+// instead of misreading the records written by this version. It returns the format version of the data files.
+func raiseFormatVersion(dirPath string, version byte, options Options) (byte, error) {
+	required := requiredFormatVersion(options)
+	if version >= required {
+		return version, nil
+	}
+	if err := saveFormatVersion(dirPath, required); err != nil {
+		return 0, err
+	}
+	return required, nil
+}
+
+// saveFormatVersion writes the format version to a temporary file and renames it,
+// so the format file is always complete.
+func saveFormatVersion(dirPath string, version byte) error {
+	fileName := filepath.Join(dirPath, formatFileName)
+	file, err := os.OpenFile(fileName+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write([]byte{version}); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(fileName+".tmp", fileName)
+}
+
+// MigrateFormat rewrites the data files in the older format to the current format,
+// it is done by merging all the data files, see Merge, and then the current format version is saved.
+// It does nothing if the data files are in the current format.
+//
+// Open validates the format version, and returns ErrUnsupportedFormat if the data files
+// are written by a newer version, or ErrInvalidFormat if the format file is damaged,
+// the older formats are always readable. If Options.ValueCodec or Options.ChecksumType is set
+// on the data files in an older format, Open raises the format version to the one writing them,
+// so the older versions refuse to open them, the older records are kept as they are, since they are readable.
+func (db *DB) MigrateFormat() error {
+	db.mu.RLock()
+	version := db.formatVersion
+	db.mu.RUnlock()
+	if version == currentFormatVersion {
+		return nil
+	}
+
+	// the records written after the merge starts are in the current format.
+	if err := db.Merge(true); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrDBClosed
+	}
+	if err := saveFormatVersion(db.options.DirPath, currentFormatVersion); err != nil {
+		return err
+	}
+	db.formatVersion = currentFormatVersion
+	return nil
+}
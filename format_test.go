@@ -0,0 +1,127 @@
+package rosedb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_FormatVersion(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Equal(t, currentFormatVersion, db.formatVersion)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Close())
+
+	// the database written before the format version is saved
+	formatFile := filepath.Join(options.DirPath, formatFileName)
+	assert.Nil(t, os.Remove(formatFile))
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, legacyFormatVersion, db.formatVersion)
+	assert.Nil(t, db.MigrateFormat())
+	assert.Equal(t, currentFormatVersion, db.formatVersion)
+	assert.Equal(t, 100, db.Stat().KeysNum)
+	assert.Nil(t, db.Close())
+
+	// the legacy database opened with the checksums is refused by the versions not knowing them
+	assert.Nil(t, os.Remove(formatFile))
+	checksumOptions := options
+	checksumOptions.ChecksumType = ChecksumCRC32C
+	db, err = Open(checksumOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, checksumFormatVersion, db.formatVersion)
+	assert.Nil(t, db.Put(utils.GetTestKey(0), []byte("value")))
+	assert.Nil(t, db.Close())
+	version, err := loadFormatVersion(options.DirPath)
+	assert.Nil(t, err)
+	assert.Equal(t, checksumFormatVersion, version)
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, currentFormatVersion, db.formatVersion)
+	assert.Nil(t, db.Close())
+
+	// the database written by a newer version
+	assert.Nil(t, saveFormatVersion(options.DirPath, currentFormatVersion+1))
+	_, err = Open(options)
+	assert.Equal(t, ErrUnsupportedFormat, err)
+
+	// the format file without the version
+	assert.Nil(t, os.WriteFile(formatFile, nil, 0644))
+	_, err = Open(options)
+	assert.Equal(t, ErrInvalidFormat, err)
+	assert.Nil(t, saveFormatVersion(options.DirPath, currentFormatVersion))
+}
+
+func TestDB_FormatVersion_ValueCodec(t *testing.T) {
+	options := DefaultOptions
+	options.ValueCodec = []Codec{xorCodec{}}
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("value")))
+	}
+	assert.Nil(t, db.Close())
+
+	// the data files with the codec flags are refused by the versions not knowing them
+	buf, err := os.ReadFile(filepath.Join(options.DirPath, formatFileName))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{currentFormatVersion}, buf)
+	assert.True(t, buf[0] >= codecFormatVersion)
+	assert.True(t, buf[0] > initialFormatVersion)
+
+	// the data files written before the codecs are readable, and migrated,
+	// the format version is raised when opening, since the records with the codec flags may be written
+	assert.Nil(t, saveFormatVersion(options.DirPath, initialFormatVersion))
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, codecFormatVersion, db.formatVersion)
+	buf, err = os.ReadFile(filepath.Join(options.DirPath, formatFileName))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{codecFormatVersion}, buf)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	assert.Nil(t, db.MigrateFormat())
+	assert.Equal(t, currentFormatVersion, db.formatVersion)
+}
+
+func TestDB_FormatVersion_Checksum(t *testing.T) {
+	options := DefaultOptions
+	options.ChecksumType = ChecksumCRC32C
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Equal(t, checksumFormatVersion, db.formatVersion)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("value")))
+	}
+	assert.Nil(t, db.Close())
+
+	// the data files with the checksums are refused by the versions not knowing them
+	assert.Nil(t, saveFormatVersion(options.DirPath, checksumFormatVersion+1))
+	_, err = Open(options)
+	assert.Equal(t, ErrUnsupportedFormat, err)
+
+	// the data files written before the checksums are readable, and migrated
+	assert.Nil(t, saveFormatVersion(options.DirPath, codecFormatVersion))
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, checksumFormatVersion, db.formatVersion)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	assert.Nil(t, db.MigrateFormat())
+	assert.Equal(t, checksumFormatVersion, db.formatVersion)
+}
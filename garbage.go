@@ -0,0 +1,177 @@
+package rosedb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rosedblabs/wal"
+)
+
+// loadGarbage computes the size of the data files and the size of the garbage in them,
+// the garbage is the records not referenced by the index, such as the overwritten and deleted records,
+// which will be reclaimed by merge.
+// It must be called after the index is loaded.
+func (db *DB) loadGarbage() error {
+	files, err := filepath.Glob(filepath.Join(db.options.DirPath, "*"+dataFileNameSuffix))
+	if err != nil {
+		return err
+	}
+	var dataBytes int64
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		dataBytes += info.Size()
+	}
+
+	var liveBytes int64
+	db.index.Ascend(func(_ []byte, pos *wal.ChunkPosition) (bool, error) {
+		liveBytes += int64(pos.ChunkSize)
+		return true, nil
+	})
+	db.dataBytes = dataBytes
+	db.garbageBytes = dataBytes - liveBytes
+	if db.garbageBytes < 0 {
+		db.garbageBytes = 0
+	}
+	return nil
+}
+
+// MergeEstimate returns the estimated number of bytes a full Merge would reclaim from the data files,
+// and the number of the data files it would rewrite, without merging anything.
+//
+// It is computed from the garbage tracked by the writes, see Stat.GarbageRatio,
+// so it only costs a listing of the data files, instead of reading them like Merge.
+// The expired records are not counted until they are removed from the index when they are read,
+// and the space of the value log is reclaimed by ValueLogGC, it is not counted either.
+func (db *DB) MergeEstimate() (int64, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return 0, 0, ErrDBClosed
+	}
+	// the empty database is not merged
+	if db.dataFiles.IsEmpty() {
+		return 0, 0, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(db.options.DirPath, "*"+dataFileNameSuffix))
+	if err != nil {
+		return 0, 0, err
+	}
+	return db.garbageBytes, len(files), nil
+}
+
+// garbageRatio returns the ratio of the garbage in the data files, it must be called with the database locked.
+func (db *DB) garbageRatio() float64 {
+	if db.dataBytes == 0 {
+		return 0
+	}
+	return float64(db.garbageBytes) / float64(db.dataBytes)
+}
+
+// addGarbage adds the size of the record at the position to the garbage,
+// and notifies the background goroutine to merge if the garbage ratio exceeds Options.AutoMergeThreshold.
+// It must be called with the database locked.
+func (db *DB) addGarbage(pos *wal.ChunkPosition) {
+	if pos == nil {
+		return
+	}
+	db.garbageBytes += int64(pos.ChunkSize)
+	if db.options.AutoMergeThreshold == 0 || db.garbageRatio() < db.options.AutoMergeThreshold {
+		return
+	}
+	select {
+	case db.mergeCh <- struct{}{}:
+	default:
+		// a merge is pending
+	}
+}
+
+// putIndex puts the position of the key into the index, the replaced record becomes garbage.
+func (db *DB) putIndex(key []byte, pos *wal.ChunkPosition) {
+	db.addGarbage(db.index.Put(key, pos))
+}
+
+// putIndexBatch puts the keys with their positions into the index in one call,
+// the replaced records become garbage.
+func (db *DB) putIndexBatch(keys [][]byte, positions []*wal.ChunkPosition) {
+	for _, pos := range db.index.PutBatch(keys, positions) {
+		db.addGarbage(pos)
+	}
+}
+
+// deleteIndex deletes the key from the index, the deleted record becomes garbage.
+func (db *DB) deleteIndex(key []byte) {
+	if pos, ok := db.index.Delete(key); ok {
+		db.addGarbage(pos)
+	}
+}
+
+// writeStalled reports whether the writes are stalled because the data files exceed Options.MaxWALSize,
+// it must be called with the database locked.
+func (db *DB) writeStalled() bool {
+	return db.options.MaxWALSize > 0 && db.dataBytes >= db.options.MaxWALSize
+}
+
+// waitWriteStall blocks until the data files are smaller than Options.MaxWALSize,
+// or Options.WriteStallTimeout passes, it must be called without the database locked.
+func (db *DB) waitWriteStall() {
+	if db.options.MaxWALSize == 0 || db.options.WriteStallTimeout == 0 {
+		return
+	}
+	deadline := time.Now().Add(db.options.WriteStallTimeout)
+	for {
+		db.mu.RLock()
+		stalled := !db.closed && db.writeStalled()
+		db.mu.RUnlock()
+		if !stalled || time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// autoMergeInBackground merges the data files when notified by addGarbage, until closeCh is closed.
+// The merges are at least Options.AutoMergeCooldown apart, so the database will not keep merging
+// under the heavy overwrites, and the ratio is checked again before merging,
+// since it may be reduced by the merge called by the user.
+func (db *DB) autoMergeInBackground(closeCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-closeCh
+		cancel()
+	}()
+
+	var lastMerge time.Time
+	for {
+		select {
+		case <-closeCh:
+			return
+		case <-db.mergeCh:
+		}
+		if wait := db.options.AutoMergeCooldown - time.Since(lastMerge); wait > 0 {
+			select {
+			case <-closeCh:
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		db.mu.RLock()
+		ratio := db.garbageRatio()
+		db.mu.RUnlock()
+		if ratio < db.options.AutoMergeThreshold {
+			continue
+		}
+		// the merge running concurrently or cancelled by closing is not a failure
+		if err := db.MergeContext(ctx, true); err != ErrMergeRunning && ctx.Err() == nil {
+			db.backgroundError(BackgroundTaskMerge, err)
+		}
+		lastMerge = time.Now()
+	}
+}
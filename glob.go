@@ -0,0 +1,190 @@
+package rosedb
+
+import (
+	"bytes"
+
+	"github.com/rosedblabs/wal"
+)
+
+// Keys returns all the live keys matching the glob pattern in ascending order, like the KEYS command of Redis.
+// The pattern supports:
+//
+//	'*' matches any sequence of bytes, including the empty one.
+//	'?' matches any single byte.
+//	'[abc]' matches one of the bytes in the brackets, '[a-z]' matches a range, '[^abc]' or '[!abc]' negates it.
+//	'\x' matches the byte x literally, so the special bytes can be escaped.
+//
+// The index is iterated from the literal prefix before the first wildcard,
+// and the iteration stops once the keys do not have the prefix,
+// but a pattern beginning with a wildcard, like "*", scans all the keys in the database,
+// so it should be used for debugging or admin tooling instead of the hot paths.
+// The records of the matched keys are read to skip the expired ones.
+//
+// It returns ErrInvalidPattern if the pattern has an unclosed bracket or ends with a backslash.
+func (db *DB) Keys(pattern string) ([][]byte, error) {
+	if err := checkGlob(pattern); err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	prefix := globPrefix(pattern)
+	now := db.now().UnixNano()
+	var keys [][]byte
+	var readErr error
+	handleFn := func(key []byte, pos *wal.ChunkPosition) (bool, error) {
+		if !bytes.HasPrefix(key, prefix) {
+			return false, nil
+		}
+		if isStructKey(key) || !globMatch(pattern, key) {
+			return true, nil
+		}
+		chunk, err := db.dataFiles.Read(pos)
+		if err != nil {
+			readErr = err
+			return false, err
+		}
+		header := decodeLogRecordHeader(chunk)
+		if header.recordType != LogRecordDeleted && !header.isExpired(now) {
+			keys = append(keys, key)
+		}
+		return true, nil
+	}
+	if len(prefix) == 0 {
+		db.index.Ascend(handleFn)
+	} else {
+		db.index.AscendGreaterOrEqual(prefix, handleFn)
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+	return keys, nil
+}
+
+// checkGlob checks that all the brackets of the pattern are closed,
+// and every backslash is followed by the escaped byte.
+func checkGlob(pattern string) error {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+			if i >= len(pattern) {
+				return ErrInvalidPattern
+			}
+		case '[':
+			i++
+			if i < len(pattern) && (pattern[i] == '^' || pattern[i] == '!') {
+				i++
+			}
+			for ; i < len(pattern) && pattern[i] != ']'; i++ {
+				if pattern[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(pattern) {
+				return ErrInvalidPattern
+			}
+		}
+	}
+	return nil
+}
+
+// globPrefix returns the literal prefix of the pattern before the first wildcard.
+func globPrefix(pattern string) []byte {
+	var prefix []byte
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?', '[':
+			return prefix
+		case '\\':
+			i++
+		}
+		prefix = append(prefix, pattern[i])
+	}
+	return prefix
+}
+
+// globMatch reports whether the key matches the pattern, which must be checked by checkGlob.
+func globMatch(pattern string, key []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(key); i++ {
+				if globMatch(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(key) == 0 {
+				return false
+			}
+			pattern, key = pattern[1:], key[1:]
+		case '[':
+			if len(key) == 0 {
+				return false
+			}
+			var matched bool
+			matched, pattern = globMatchClass(pattern[1:], key[0])
+			if !matched {
+				return false
+			}
+			key = key[1:]
+		case '\\':
+			pattern = pattern[1:]
+			fallthrough
+		default:
+			if len(key) == 0 || pattern[0] != key[0] {
+				return false
+			}
+			pattern, key = pattern[1:], key[1:]
+		}
+	}
+	return len(key) == 0
+}
+
+// globMatchClass reports whether c matches the bracket class at the beginning of the pattern,
+// which is after the '[', and returns the rest of the pattern after the closing ']'.
+func globMatchClass(pattern string, c byte) (bool, string) {
+	negate := false
+	if pattern[0] == '^' || pattern[0] == '!' {
+		negate = true
+		pattern = pattern[1:]
+	}
+
+	matched := false
+	for pattern[0] != ']' {
+		lo := pattern[0]
+		if lo == '\\' {
+			pattern = pattern[1:]
+			lo = pattern[0]
+		}
+		pattern = pattern[1:]
+		hi := lo
+		if len(pattern) > 1 && pattern[0] == '-' && pattern[1] != ']' {
+			hi = pattern[1]
+			if hi == '\\' {
+				hi = pattern[2]
+				pattern = pattern[1:]
+			}
+			pattern = pattern[2:]
+		}
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+	}
+	return matched != negate, pattern[1:]
+}
@@ -0,0 +1,80 @@
+package rosedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Keys(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for _, key := range []string{"user:1", "user:2", "user:10", "user:a", "users", "order:1", "h*llo", "hello"} {
+		assert.Nil(t, db.Put([]byte(key), []byte("v")))
+	}
+	assert.Nil(t, db.PutWithTTL([]byte("user:3"), []byte("v"), time.Millisecond*50))
+	assert.Nil(t, db.Put([]byte("user:4"), []byte("v")))
+	assert.Nil(t, db.Delete([]byte("user:4")))
+	time.Sleep(time.Millisecond * 100)
+
+	toStrings := func(keys [][]byte) []string {
+		var res []string
+		for _, key := range keys {
+			res = append(res, string(key))
+		}
+		return res
+	}
+	for pattern, expected := range map[string][]string{
+		"*":           {"h*llo", "hello", "order:1", "user:1", "user:10", "user:2", "user:a", "users"},
+		"user:*":      {"user:1", "user:10", "user:2", "user:a"},
+		"user:?":      {"user:1", "user:2", "user:a"},
+		"user:[0-9]*": {"user:1", "user:10", "user:2"},
+		"user:[^0-9]": {"user:a"},
+		"user:[!12]":  {"user:a"},
+		"*:1":         {"order:1", "user:1"},
+		"h\\*llo":     {"h*llo"},
+		"h*llo":       {"h*llo", "hello"},
+		"users":       {"users"},
+		"none*":       nil,
+	} {
+		keys, err := db.Keys(pattern)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, toStrings(keys), pattern)
+	}
+
+	for _, pattern := range []string{"user:[0-9", "user\\", "[\\"} {
+		_, err = db.Keys(pattern)
+		assert.Equal(t, ErrInvalidPattern, err, pattern)
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		matched bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"a**b", "ab", true},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "aXbY", false},
+		{"[a-c]", "b", true},
+		{"[c-a]", "b", true},
+		{"[a-]", "-", true},
+		{"[\\]]", "]", true},
+		{"[^a]", "a", false},
+		{"?", "", false},
+		{"\\?", "?", true},
+		{"\\?", "a", false},
+	}
+	for _, tt := range tests {
+		assert.Nil(t, checkGlob(tt.pattern))
+		assert.Equal(t, tt.matched, globMatch(tt.pattern, []byte(tt.key)), tt.pattern+" "+tt.key)
+	}
+	assert.Equal(t, []byte("user:*"), globPrefix("user:\\**"))
+}
@@ -0,0 +1,150 @@
+package rosedb
+
+import "time"
+
+// HSet sets the value of the field in the hash stored at key, the hash is created if it does not exist.
+//
+// Every field of the hash is stored in its own record, see encodeStructKey,
+// so a field can be read and written without rewriting the whole hash.
+// The field is written with the ttl of the whole hash set by HExpire, if any,
+// so the ttl of the field set by HSetWithTTL is removed.
+func (db *DB) HSet(key, field, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.updateStruct(func(batch *Batch) error {
+		expire, err := db.structExpire(hashMetaDataType, key)
+		if err != nil {
+			return err
+		}
+		return putStructRecord(batch, encodeStructKey(hashDataType, key, field), value, expire)
+	})
+}
+
+// HSetWithTTL is like HSet, but the field expires after the ttl independently of the other fields.
+// If the whole hash expires earlier, see HExpire, the field expires with it.
+//
+// The expired fields are skipped by HGet, HGetAll and HLen, and their records are removed by merge.
+func (db *DB) HSetWithTTL(key, field, value []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.updateStruct(func(batch *Batch) error {
+		expire := db.now().Add(ttl).UnixNano()
+		hashExpire, err := db.structExpire(hashMetaDataType, key)
+		if err != nil {
+			return err
+		}
+		if hashExpire > 0 && hashExpire < expire {
+			expire = hashExpire
+		}
+		return putStructRecord(batch, encodeStructKey(hashDataType, key, field), value, expire)
+	})
+}
+
+// HTTL returns the ttl of the field in the hash stored at key, it is -1 if the field never expires.
+// It returns ErrKeyNotFound if the hash or the field does not exist.
+func (db *DB) HTTL(key, field []byte) (time.Duration, error) {
+	if len(key) == 0 {
+		return -1, ErrKeyIsEmpty
+	}
+	return db.TTL(encodeStructKey(hashDataType, key, field))
+}
+
+// HGet returns the value of the field in the hash stored at key.
+// It returns ErrKeyNotFound if the hash or the field does not exist.
+func (db *DB) HGet(key, field []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	return db.Get(encodeStructKey(hashDataType, key, field))
+}
+
+// HDel removes the fields from the hash stored at key atomically,
+// the fields that do not exist are ignored.
+// The hash is removed when all the fields are removed, along with its ttl.
+func (db *DB) HDel(key []byte, fields ...[]byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.updateStruct(func(batch *Batch) error {
+		for _, field := range fields {
+			if err := batch.delete(encodeStructKey(hashDataType, key, field)); err != nil {
+				return err
+			}
+		}
+
+		// remove the ttl of the hash if no field is left
+		var left bool
+		err := db.ascendStruct(hashDataType, key, false, func(_ []byte, record *LogRecord) (bool, error) {
+			pending := batch.pendingWrites[string(record.Key)]
+			left = pending == nil || pending.Type != LogRecordDeleted
+			return !left, nil
+		})
+		if err != nil || left {
+			return err
+		}
+		return batch.delete(encodeStructKey(hashMetaDataType, key, nil))
+	})
+}
+
+// HGetAll returns all the fields and values of the hash stored at key.
+// It returns an empty map if the hash does not exist.
+func (db *DB) HGetAll(key []byte) (map[string][]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	fields := make(map[string][]byte)
+	err := db.ascendStruct(hashDataType, key, true, func(field []byte, record *LogRecord) (bool, error) {
+		fields[string(field)] = record.Value
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// HLen returns the number of the fields in the hash stored at key, it returns 0 if the hash does not exist.
+// The fields are counted from the index, and only the headers of their records are read.
+func (db *DB) HLen(key []byte) (int, error) {
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return 0, ErrDBClosed
+	}
+
+	var count int
+	err := db.ascendStruct(hashDataType, key, false, func([]byte, *LogRecord) (bool, error) {
+		count++
+		return true, nil
+	})
+	return count, err
+}
+
+// HExpire sets the ttl of the whole hash stored at key, all the fields are rewritten
+// with the new expiry time atomically, so it costs O(N) in the number of the fields.
+// The ttl of the fields set by HSetWithTTL is replaced, and the fields written later by HSet
+// share the ttl of the hash.
+// It returns ErrKeyNotFound if the hash does not exist.
+func (db *DB) HExpire(key []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.updateStruct(func(batch *Batch) error {
+		expire := db.now().Add(ttl).UnixNano()
+		if err := db.expireStruct(batch, hashDataType, key, expire); err != nil {
+			return err
+		}
+		return putStructRecord(batch, encodeStructKey(hashMetaDataType, key, nil), nil, expire)
+	})
+}
@@ -0,0 +1,199 @@
+package rosedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Hash(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	_, err = db.HGet([]byte("user"), []byte("name"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	fields, err := db.HGetAll([]byte("user"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(fields))
+
+	assert.Nil(t, db.HSet([]byte("user"), []byte("name"), []byte("rose")))
+	assert.Nil(t, db.HSet([]byte("user"), []byte("age"), []byte("18")))
+	assert.Nil(t, db.HSet([]byte("user"), []byte("age"), []byte("19")))
+	// the hashes whose keys have the same prefix are not mixed up
+	assert.Nil(t, db.HSet([]byte("users"), []byte("name"), []byte("jack")))
+	assert.Nil(t, db.HSet([]byte("use"), []byte("rname"), []byte("tom")))
+	assert.Nil(t, db.Put([]byte("user"), []byte("plain")))
+
+	value, err := db.HGet([]byte("user"), []byte("age"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("19"), value)
+	count, err := db.HLen([]byte("user"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	fields, err = db.HGetAll([]byte("user"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"name": []byte("rose"), "age": []byte("19")}, fields)
+	value, err = db.Get([]byte("user"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("plain"), value)
+
+	assert.Nil(t, db.HDel([]byte("user"), []byte("name"), []byte("none")))
+	_, err = db.HGet([]byte("user"), []byte("name"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	count, err = db.HLen([]byte("user"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	// the fields are kept after reopening
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	fields, err = db.HGetAll([]byte("users"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"name": []byte("jack")}, fields)
+}
+
+func TestDB_HExpire(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Equal(t, ErrKeyNotFound, db.HExpire([]byte("session"), time.Second))
+
+	assert.Nil(t, db.HSet([]byte("session"), []byte("user"), []byte("rose")))
+	assert.Nil(t, db.HExpire([]byte("session"), time.Millisecond*100))
+	// the new field shares the ttl of the hash
+	assert.Nil(t, db.HSet([]byte("session"), []byte("token"), []byte("abc")))
+	count, err := db.HLen([]byte("session"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	time.Sleep(time.Millisecond * 200)
+	count, err = db.HLen([]byte("session"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+	_, err = db.HGet([]byte("session"), []byte("token"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// a new hash is created without ttl
+	assert.Nil(t, db.HSet([]byte("session"), []byte("user"), []byte("jack")))
+	time.Sleep(time.Millisecond * 50)
+	value, err := db.HGet([]byte("session"), []byte("user"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("jack"), value)
+}
+
+func TestDB_HSetWithTTL(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	session := []byte("session")
+	assert.Nil(t, db.HSet(session, []byte("user"), []byte("rose")))
+	assert.Nil(t, db.HSetWithTTL(session, []byte("token"), []byte("abc"), time.Millisecond*100))
+	assert.Nil(t, db.HSetWithTTL(session, []byte("csrf"), []byte("xyz"), time.Hour))
+
+	ttl, err := db.HTTL(session, []byte("user"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+	ttl, err = db.HTTL(session, []byte("csrf"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > time.Minute*59 && ttl <= time.Hour)
+	_, err = db.HTTL(session, []byte("none"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	time.Sleep(time.Millisecond * 200)
+	_, err = db.HGet(session, []byte("token"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.HTTL(session, []byte("token"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	count, err := db.HLen(session)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	fields, err := db.HGetAll(session)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]byte{"user": []byte("rose"), "csrf": []byte("xyz")}, fields)
+
+	// HSet removes the ttl of the field
+	assert.Nil(t, db.HSet(session, []byte("csrf"), []byte("xyz")))
+	ttl, err = db.HTTL(session, []byte("csrf"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	// the field expires with the whole hash if it is earlier
+	assert.Nil(t, db.HExpire(session, time.Minute))
+	assert.Nil(t, db.HSetWithTTL(session, []byte("token"), []byte("def"), time.Hour))
+	ttl, err = db.HTTL(session, []byte("token"))
+	assert.Nil(t, err)
+	assert.True(t, ttl <= time.Minute)
+	assert.Nil(t, db.HSet(session, []byte("user"), []byte("jack")))
+	ttl, err = db.HTTL(session, []byte("user"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Minute)
+
+	// the ttl of the hash is removed with the last field
+	assert.Nil(t, db.HDel(session, []byte("user"), []byte("csrf"), []byte("token")))
+	assert.Nil(t, db.HSet(session, []byte("user"), []byte("tom")))
+	ttl, err = db.HTTL(session, []byte("user"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+}
+
+func TestDB_StructKeyReserved(t *testing.T) {
+	options := DefaultOptions
+	options.WatchQueueSize = 10
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.HSet([]byte("h"), []byte("f"), []byte("v")))
+	assert.Nil(t, db.Put([]byte("k"), []byte("v")))
+
+	// the records of the hash can not be written by the user writes
+	structKey := encodeStructKey(hashDataType, []byte("h"), []byte("f"))
+	assert.Equal(t, ErrReservedKey, db.Put(structKey, []byte("x")))
+	assert.Equal(t, ErrReservedKey, db.Delete(structKey))
+	_, err = db.SetRange(structKey, 0, []byte("x"))
+	assert.Equal(t, ErrReservedKey, err)
+	assert.Equal(t, ErrReservedKey, db.Copy([]byte("k"), structKey, true))
+	value, err := db.HGet([]byte("h"), []byte("f"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), value)
+
+	// and they are hidden from the iterations
+	var keys []string
+	db.Ascend(func(k []byte, v []byte) (bool, error) {
+		keys = append(keys, string(k))
+		return true, nil
+	})
+	assert.Equal(t, []string{"k"}, keys)
+	globKeys, err := db.Keys("*")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("k")}, globKeys)
+	count, err := db.PrefixCount(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	randomKey, err := db.RandomKey()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("k"), randomKey)
+
+	// and the watch events
+	watchCh, err := db.Watch()
+	assert.Nil(t, err)
+	select {
+	case e := <-watchCh:
+		assert.Equal(t, []byte("k"), e.Key)
+	case <-time.After(time.Second):
+		t.Fatal("the event of the key is not received")
+	}
+	select {
+	case e := <-watchCh:
+		t.Fatalf("unexpected event of key %q", e.Key)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
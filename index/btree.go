@@ -42,6 +42,19 @@ func (mt *MemoryBTree) Put(key []byte, position *wal.ChunkPosition) *wal.ChunkPo
 	return nil
 }
 
+func (mt *MemoryBTree) PutBatch(keys [][]byte, positions []*wal.ChunkPosition) []*wal.ChunkPosition {
+	mt.lock.Lock()
+	defer mt.lock.Unlock()
+
+	oldValues := make([]*wal.ChunkPosition, len(keys))
+	for i, key := range keys {
+		if oldValue := mt.tree.ReplaceOrInsert(&item{key: key, pos: positions[i]}); oldValue != nil {
+			oldValues[i] = oldValue.(*item).pos
+		}
+	}
+	return oldValues
+}
+
 func (mt *MemoryBTree) Get(key []byte) *wal.ChunkPosition {
 	value := mt.tree.Get(&item{key: key})
 	if value != nil {
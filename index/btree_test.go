@@ -28,6 +28,50 @@ func TestMemoryBTree_Put_Get(t *testing.T) {
 	}
 }
 
+func TestMemoryBTree_PutBatch(t *testing.T) {
+	mt := newBTree()
+
+	oldPos := &wal.ChunkPosition{ChunkOffset: 100}
+	mt.Put([]byte("key-1"), oldPos)
+
+	keys := [][]byte{[]byte("key-0"), []byte("key-1"), []byte("key-2")}
+	positions := []*wal.ChunkPosition{{ChunkOffset: 0}, {ChunkOffset: 1}, {ChunkOffset: 2}}
+	oldPositions := mt.PutBatch(keys, positions)
+	if len(oldPositions) != 3 || oldPositions[0] != nil || oldPositions[1] != oldPos || oldPositions[2] != nil {
+		t.Fatalf("unexpected replaced positions %+v", oldPositions)
+	}
+	if mt.Size() != 3 {
+		t.Fatalf("expected size to be 3, got %d", mt.Size())
+	}
+	for i, key := range keys {
+		if gotPos := mt.Get(key); gotPos != positions[i] {
+			t.Fatalf("expected %+v, got %+v", positions[i], gotPos)
+		}
+	}
+}
+
+func BenchmarkMemoryBTree_PutBatch(b *testing.B) {
+	keys := make([][]byte, 100000)
+	positions := make([]*wal.ChunkPosition, len(keys))
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key-%09d", i))
+		positions[i] = &wal.ChunkPosition{ChunkOffset: int64(i)}
+	}
+	b.Run("put", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			mt := newBTree()
+			for j, key := range keys {
+				mt.Put(key, positions[j])
+			}
+		}
+	})
+	b.Run("put-batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			newBTree().PutBatch(keys, positions)
+		}
+	})
+}
+
 func TestMemoryBTree_Delete(t *testing.T) {
 	mt := newBTree()
 	w, _ := wal.Open(wal.DefaultOptions)
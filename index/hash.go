@@ -0,0 +1,171 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+
+	"github.com/rosedblabs/wal"
+)
+
+// hashIndex is an indexer storing the fixed-size hashes of the keys instead of the keys,
+// which saves the memory of the index for the long keys.
+//
+// The full key is only kept in the record, so it is read by readKey to verify the key.
+// The keys with the same hash are chained by appending the probe number to the hash,
+// the probes of a chain are contiguous from 0, so Get stops at the first missing probe.
+type hashIndex struct {
+	index   Indexer
+	hasher  func(key []byte) []byte
+	readKey func(position *wal.ChunkPosition) ([]byte, error)
+}
+
+// NewHashIndexer returns an indexer which stores the hashes of the keys by hasher in indexer,
+// readKey returns the key of the record at the position.
+//
+// Every Get, Put and Delete reads the keys of the records with the same hash,
+// if a key can not be read, the record is skipped, so it is never taken for another key.
+// Each call of the iterations reads the keys of all the records and sorts them, even if only a range
+// or the first keys are iterated, so each page of a paged iteration costs O(N log N) of the whole index,
+// and they are much slower than the btree.
+func NewHashIndexer(indexer Indexer, hasher func(key []byte) []byte,
+	readKey func(position *wal.ChunkPosition) ([]byte, error)) Indexer {
+	return &hashIndex{index: indexer, hasher: hasher, readKey: readKey}
+}
+
+// probeKey returns the key in the underlying indexer of the probe in the chain of the hash.
+func probeKey(hash []byte, probe uint64) []byte {
+	buf := make([]byte, len(hash), len(hash)+binary.MaxVarintLen64)
+	copy(buf, hash)
+	return binary.AppendUvarint(buf, probe)
+}
+
+// find returns the hash of the key, and the probe and the position of the key in the chain,
+// if the key is not found, the position is nil and the probe is the end of the chain.
+// The records whose keys can not be read are skipped.
+func (hi *hashIndex) find(key []byte) ([]byte, uint64, *wal.ChunkPosition) {
+	hash := hi.hasher(key)
+	for probe := uint64(0); ; probe++ {
+		position := hi.index.Get(probeKey(hash, probe))
+		if position == nil {
+			return hash, probe, nil
+		}
+		k, err := hi.readKey(position)
+		if err == nil && bytes.Equal(k, key) {
+			return hash, probe, position
+		}
+	}
+}
+
+func (hi *hashIndex) Put(key []byte, position *wal.ChunkPosition) *wal.ChunkPosition {
+	hash, probe, _ := hi.find(key)
+	return hi.index.Put(probeKey(hash, probe), position)
+}
+
+// PutBatch puts the keys one by one, since the chain of each key is read to find its probe.
+func (hi *hashIndex) PutBatch(keys [][]byte, positions []*wal.ChunkPosition) []*wal.ChunkPosition {
+	oldValues := make([]*wal.ChunkPosition, len(keys))
+	for i, key := range keys {
+		oldValues[i] = hi.Put(key, positions[i])
+	}
+	return oldValues
+}
+
+func (hi *hashIndex) Get(key []byte) *wal.ChunkPosition {
+	_, _, position := hi.find(key)
+	return position
+}
+
+func (hi *hashIndex) Delete(key []byte) (*wal.ChunkPosition, bool) {
+	hash, probe, position := hi.find(key)
+	if position == nil {
+		return nil, false
+	}
+
+	// move the last probe of the chain to the deleted one, so the chain is still contiguous.
+	last := probe
+	for hi.index.Get(probeKey(hash, last+1)) != nil {
+		last++
+	}
+	if last != probe {
+		hi.index.Put(probeKey(hash, probe), hi.index.Get(probeKey(hash, last)))
+	}
+	hi.index.Delete(probeKey(hash, last))
+	return position, true
+}
+
+func (hi *hashIndex) Size() int {
+	return hi.index.Size()
+}
+
+// hashItem is the key read from the record and its position.
+type hashItem struct {
+	key []byte
+	pos *wal.ChunkPosition
+}
+
+// items returns the keys for which keep returns true in ascending order,
+// or descending order if reverse is true.
+// The items are collected first, so the handler of the iteration can modify the index.
+// The iteration stops at the record whose key can not be read, like the handler returning an error.
+func (hi *hashIndex) items(keep func(key []byte) bool, reverse bool) []*hashItem {
+	var items []*hashItem
+	hi.index.Ascend(func(_ []byte, position *wal.ChunkPosition) (bool, error) {
+		key, err := hi.readKey(position)
+		if err != nil {
+			return false, err
+		}
+		if keep(key) {
+			items = append(items, &hashItem{key: key, pos: position})
+		}
+		return true, nil
+	})
+	sort.Slice(items, func(i, j int) bool {
+		if reverse {
+			return bytes.Compare(items[i].key, items[j].key) > 0
+		}
+		return bytes.Compare(items[i].key, items[j].key) < 0
+	})
+	return items
+}
+
+// iterate calls handleFn for each item until it returns false or an error.
+func iterate(items []*hashItem, handleFn func(key []byte, position *wal.ChunkPosition) (bool, error)) {
+	for _, item := range items {
+		if cont, err := handleFn(item.key, item.pos); err != nil || !cont {
+			return
+		}
+	}
+}
+
+func (hi *hashIndex) Ascend(handleFn func(key []byte, position *wal.ChunkPosition) (bool, error)) {
+	iterate(hi.items(func([]byte) bool { return true }, false), handleFn)
+}
+
+func (hi *hashIndex) AscendRange(startKey, endKey []byte, handleFn func(key []byte, position *wal.ChunkPosition) (bool, error)) {
+	iterate(hi.items(func(key []byte) bool {
+		return bytes.Compare(key, startKey) >= 0 && bytes.Compare(key, endKey) < 0
+	}, false), handleFn)
+}
+
+func (hi *hashIndex) AscendGreaterOrEqual(key []byte, handleFn func(key []byte, position *wal.ChunkPosition) (bool, error)) {
+	iterate(hi.items(func(k []byte) bool {
+		return bytes.Compare(k, key) >= 0
+	}, false), handleFn)
+}
+
+func (hi *hashIndex) Descend(handleFn func(key []byte, pos *wal.ChunkPosition) (bool, error)) {
+	iterate(hi.items(func([]byte) bool { return true }, true), handleFn)
+}
+
+func (hi *hashIndex) DescendRange(startKey, endKey []byte, handleFn func(key []byte, position *wal.ChunkPosition) (bool, error)) {
+	iterate(hi.items(func(key []byte) bool {
+		return bytes.Compare(key, startKey) <= 0 && bytes.Compare(key, endKey) > 0
+	}, true), handleFn)
+}
+
+func (hi *hashIndex) DescendLessOrEqual(key []byte, handleFn func(key []byte, position *wal.ChunkPosition) (bool, error)) {
+	iterate(hi.items(func(k []byte) bool {
+		return bytes.Compare(k, key) <= 0
+	}, true), handleFn)
+}
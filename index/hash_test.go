@@ -0,0 +1,182 @@
+package index
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/rosedblabs/wal"
+)
+
+// testRecords maps the offsets of the positions to the keys of the records.
+type testRecords map[int64][]byte
+
+func (r testRecords) put(key []byte) *wal.ChunkPosition {
+	offset := int64(len(r))
+	r[offset] = key
+	return &wal.ChunkPosition{ChunkOffset: offset}
+}
+
+func (r testRecords) readKey(position *wal.ChunkPosition) ([]byte, error) {
+	key, ok := r[position.ChunkOffset]
+	if !ok {
+		return nil, errors.New("record not found")
+	}
+	return key, nil
+}
+
+// firstByteHash makes the keys with the same first byte collide.
+func firstByteHash(key []byte) []byte {
+	return key[:1]
+}
+
+func TestHashIndex_Put_Get(t *testing.T) {
+	records := make(testRecords)
+	hi := NewHashIndexer(NewIndexer(), firstByteHash, records.readKey)
+
+	positions := make(map[string]*wal.ChunkPosition)
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		positions[string(key)] = records.put(key)
+		if oldPos := hi.Put(key, positions[string(key)]); oldPos != nil {
+			t.Fatalf("expected nil, got %+v", oldPos)
+		}
+	}
+	if hi.Size() != 10 {
+		t.Fatalf("expected size to be 10, got %d", hi.Size())
+	}
+	for key, pos := range positions {
+		if gotPos := hi.Get([]byte(key)); gotPos != pos {
+			t.Fatalf("expected %+v, got %+v", pos, gotPos)
+		}
+	}
+	if gotPos := hi.Get([]byte("key-10")); gotPos != nil {
+		t.Fatalf("expected nil, got %+v", gotPos)
+	}
+
+	// overwrite the key in the middle of the chain
+	newPos := records.put([]byte("key-5"))
+	if oldPos := hi.Put([]byte("key-5"), newPos); oldPos != positions["key-5"] {
+		t.Fatalf("expected %+v, got %+v", positions["key-5"], oldPos)
+	}
+	if gotPos := hi.Get([]byte("key-5")); gotPos != newPos {
+		t.Fatalf("expected %+v, got %+v", newPos, gotPos)
+	}
+	if hi.Size() != 10 {
+		t.Fatalf("expected size to be 10, got %d", hi.Size())
+	}
+}
+
+func TestHashIndex_Delete(t *testing.T) {
+	records := make(testRecords)
+	hi := NewHashIndexer(NewIndexer(), firstByteHash, records.readKey)
+
+	positions := make(map[string]*wal.ChunkPosition)
+	for i := 0; i < 10; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		positions[string(key)] = records.put(key)
+		hi.Put(key, positions[string(key)])
+	}
+
+	// delete the keys in the middle, at the head and at the end of the chain
+	for _, key := range []string{"key-3", "key-0", "key-9"} {
+		delPos, ok := hi.Delete([]byte(key))
+		if !ok || delPos != positions[key] {
+			t.Fatalf("expected %+v to be deleted, got %+v", positions[key], delPos)
+		}
+		delete(positions, key)
+		if hi.Get([]byte(key)) != nil {
+			t.Fatal("expected nil, got value")
+		}
+	}
+	if _, ok := hi.Delete([]byte("key-3")); ok {
+		t.Fatal("expected the deleted key not to be found")
+	}
+
+	// the other keys in the chain are still found
+	if hi.Size() != len(positions) {
+		t.Fatalf("expected size to be %d, got %d", len(positions), hi.Size())
+	}
+	for key, pos := range positions {
+		if gotPos := hi.Get([]byte(key)); gotPos != pos {
+			t.Fatalf("expected %+v, got %+v", pos, gotPos)
+		}
+	}
+}
+
+func TestHashIndex_Iterate(t *testing.T) {
+	records := make(testRecords)
+	hi := NewHashIndexer(NewIndexer(), firstByteHash, records.readKey)
+	for _, key := range []string{"d", "b", "ba", "a", "c"} {
+		hi.Put([]byte(key), records.put([]byte(key)))
+	}
+
+	collect := func(iterate func(handleFn func(key []byte, position *wal.ChunkPosition) (bool, error))) string {
+		var keys [][]byte
+		iterate(func(key []byte, _ *wal.ChunkPosition) (bool, error) {
+			keys = append(keys, key)
+			return true, nil
+		})
+		return string(bytes.Join(keys, []byte(",")))
+	}
+	tests := []struct {
+		name     string
+		iterate  func(handleFn func(key []byte, position *wal.ChunkPosition) (bool, error))
+		expected string
+	}{
+		{"Ascend", hi.Ascend, "a,b,ba,c,d"},
+		{"Descend", hi.Descend, "d,c,ba,b,a"},
+		{"AscendRange", func(fn func([]byte, *wal.ChunkPosition) (bool, error)) {
+			hi.AscendRange([]byte("b"), []byte("c"), fn)
+		}, "b,ba"},
+		{"DescendRange", func(fn func([]byte, *wal.ChunkPosition) (bool, error)) {
+			hi.DescendRange([]byte("c"), []byte("b"), fn)
+		}, "c,ba"},
+		{"AscendGreaterOrEqual", func(fn func([]byte, *wal.ChunkPosition) (bool, error)) {
+			hi.AscendGreaterOrEqual([]byte("ba"), fn)
+		}, "ba,c,d"},
+		{"DescendLessOrEqual", func(fn func([]byte, *wal.ChunkPosition) (bool, error)) {
+			hi.DescendLessOrEqual([]byte("ba"), fn)
+		}, "ba,b,a"},
+	}
+	for _, tt := range tests {
+		if got := collect(tt.iterate); got != tt.expected {
+			t.Fatalf("%s: expected %s, got %s", tt.name, tt.expected, got)
+		}
+	}
+
+	// the iteration stops when the handler returns false
+	var count int
+	hi.Ascend(func([]byte, *wal.ChunkPosition) (bool, error) {
+		count++
+		return count < 2, nil
+	})
+	if count != 2 {
+		t.Fatalf("expected 2 keys to be iterated, got %d", count)
+	}
+}
+
+func TestHashIndex_ReadKeyError(t *testing.T) {
+	records := make(testRecords)
+	hi := NewHashIndexer(NewIndexer(), firstByteHash, records.readKey)
+	pos0 := records.put([]byte("key-0"))
+	hi.Put([]byte("key-0"), pos0)
+	pos1 := records.put([]byte("key-1"))
+	hi.Put([]byte("key-1"), pos1)
+
+	// the record which can not be read is not taken for another key in the chain
+	pos2 := records.put([]byte("key-2"))
+	delete(records, pos0.ChunkOffset)
+	if oldPos := hi.Put([]byte("key-2"), pos2); oldPos != nil {
+		t.Fatalf("expected nil, got %+v", oldPos)
+	}
+	if hi.Size() != 3 {
+		t.Fatalf("expected size to be 3, got %d", hi.Size())
+	}
+	for key, pos := range map[string]*wal.ChunkPosition{"key-1": pos1, "key-2": pos2} {
+		if gotPos := hi.Get([]byte(key)); gotPos != pos {
+			t.Fatalf("expected %+v, got %+v", pos, gotPos)
+		}
+	}
+}
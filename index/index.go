@@ -10,6 +10,11 @@ type Indexer interface {
 	// Put key and position into the index.
 	Put(key []byte, position *wal.ChunkPosition) *wal.ChunkPosition
 
+	// PutBatch puts the keys and their positions into the index in one call,
+	// and returns the replaced positions in the same order, nil for the new keys.
+	// The keys must be distinct.
+	PutBatch(keys [][]byte, positions []*wal.ChunkPosition) []*wal.ChunkPosition
+
 	// Get the position of the key in the index.
 	Get(key []byte) *wal.ChunkPosition
 
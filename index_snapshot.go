@@ -0,0 +1,146 @@
+package rosedb
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+
+	"github.com/rosedblabs/wal"
+)
+
+const (
+	indexSnapshotFileNameSuffix    = ".INDEX"
+	indexSnapshotTmpFileNameSuffix = ".INDEXTMP"
+)
+
+// saveIndexSnapshot saves all the index entries to the index snapshot file when closing,
+// see Options.PersistIndex.
+//
+// The active data file is rotated first if it is not empty, so the snapshot contains
+// the index of all the older data files, and only the newer data files need to be replayed
+// when opening. The merge finished segment id is saved too, the snapshot is stale
+// if the data files are replaced by merge.
+func (db *DB) saveIndexSnapshot() error {
+	activeSegId := db.dataFiles.ActiveSegmentID()
+	lastSegId := activeSegId - 1
+	if _, err := db.dataFiles.Read(&wal.ChunkPosition{SegmentId: activeSegId}); err != io.EOF {
+		if err != nil {
+			return err
+		}
+		if err = db.dataFiles.OpenNewActiveSegment(); err != nil {
+			return err
+		}
+		lastSegId = activeSegId
+	}
+	mergeFinSegmentId, err := getMergeFinSegmentId(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first, and rename it after all done,
+	// so the index snapshot file is always complete.
+	tmpFileName := wal.SegmentFileName(db.options.DirPath, indexSnapshotTmpFileNameSuffix, 1)
+	if err = os.Remove(tmpFileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	snapshotFile, err := wal.Open(wal.Options{
+		DirPath:        db.options.DirPath,
+		SegmentSize:    math.MaxInt64,
+		SegmentFileExt: indexSnapshotTmpFileNameSuffix,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = snapshotFile.Close()
+	}()
+
+	header := make([]byte, 8)
+	binary.LittleEndian.PutUint32(header, lastSegId)
+	binary.LittleEndian.PutUint32(header[4:], mergeFinSegmentId)
+	if _, err = snapshotFile.Write(header); err != nil {
+		return err
+	}
+	db.index.Ascend(func(key []byte, pos *wal.ChunkPosition) (bool, error) {
+		if _, err = snapshotFile.Write(encodeHintRecord(key, pos)); err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = snapshotFile.Sync(); err != nil {
+		return err
+	}
+	if err = snapshotFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, wal.SegmentFileName(db.options.DirPath, indexSnapshotFileNameSuffix, 1))
+}
+
+// loadIndexSnapshot loads the index from the index snapshot file,
+// and returns the last segment id of the data files contained in the snapshot.
+// It returns false if the snapshot does not exist or is stale, the index should be rebuilt from the data files.
+func (db *DB) loadIndexSnapshot() (wal.SegmentID, bool, error) {
+	fileName := wal.SegmentFileName(db.options.DirPath, indexSnapshotFileNameSuffix, 1)
+	if _, err := os.Stat(fileName); err != nil {
+		if os.IsNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	snapshotFile, err := wal.Open(wal.Options{
+		DirPath:        db.options.DirPath,
+		SegmentSize:    math.MaxInt64,
+		SegmentFileExt: indexSnapshotFileNameSuffix,
+		BlockCache:     32 * KB * 10,
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() {
+		_ = snapshotFile.Close()
+	}()
+
+	reader := snapshotFile.NewReader()
+	header, _, err := reader.Next()
+	if err == io.EOF {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	lastSegId := binary.LittleEndian.Uint32(header)
+	mergeFinSegmentId, err := getMergeFinSegmentId(db.options.DirPath)
+	if err != nil {
+		return 0, false, err
+	}
+	// the data files have been merged after the snapshot is saved
+	if binary.LittleEndian.Uint32(header[4:]) != mergeFinSegmentId || lastSegId >= db.dataFiles.ActiveSegmentID() {
+		return 0, false, nil
+	}
+
+	for {
+		chunk, _, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, false, err
+		}
+		key, position := decodeHintRecord(chunk)
+		db.index.Put(key, position)
+	}
+	return lastSegId, true, nil
+}
+
+// removeIndexSnapshot removes the index snapshot file, it is called when the data files are replaced by merge.
+func removeIndexSnapshot(dirPath string) error {
+	err := os.Remove(wal.SegmentFileName(dirPath, indexSnapshotFileNameSuffix, 1))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package rosedb
+
+import (
+	"os"
+	"testing"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/rosedblabs/wal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_PersistIndex(t *testing.T) {
+	options := DefaultOptions
+	options.SegmentSize = 64 * KB
+	options.PersistIndex = true
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+	}
+	assert.Nil(t, db.Close())
+	snapshotFile := wal.SegmentFileName(options.DirPath, indexSnapshotFileNameSuffix, 1)
+	_, err = os.Stat(snapshotFile)
+	assert.Nil(t, err)
+
+	// the data files written after the snapshot are replayed
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, db.Stat().KeysNum)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("updated")))
+	}
+	for i := 100; i < 200; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	for i := 500; i < 600; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+	}
+	// close without saving the snapshot, the old snapshot is still valid
+	db.options.PersistIndex = false
+	assert.Nil(t, db.Close())
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, 500, db.Stat().KeysNum)
+	for i := 0; i < 600; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		switch {
+		case i < 100:
+			assert.Equal(t, []byte("updated"), val)
+		case i < 200:
+			assert.Equal(t, ErrKeyNotFound, err)
+		default:
+			assert.Nil(t, err)
+		}
+	}
+
+	// the snapshot is removed after merge
+	assert.Nil(t, db.Merge(true))
+	_, err = os.Stat(snapshotFile)
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, db.Put(utils.GetTestKey(100), []byte("new")))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, 501, db.Stat().KeysNum)
+	val, err := db.Get(utils.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+	val, err = db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("updated"), val)
+}
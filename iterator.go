@@ -0,0 +1,320 @@
+package rosedb
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/rosedblabs/wal"
+)
+
+// Iterator iterates the key/value pairs of the database like a cursor, see DB.NewIterator.
+//
+//	it, err := db.NewIterator(IteratorOptions{Prefix: prefix})
+//	defer it.Close()
+//	for it.Rewind(); it.Valid(); it.Next() {
+//		key, value := it.Key(), it.Value()
+//	}
+//	err = it.Err()
+type Iterator struct {
+	db      *DB
+	options IteratorOptions
+	keys    [][]byte // the page of the keys read from the index in the order of the iteration
+	more    bool     // whether there may be more keys after the page
+	index   int      // the index of the current key in the page
+	value   []byte   // the value of the current key
+	count   int      // the number of the keys iterated since Rewind or Seek, see IteratorOptions.Limit
+	err     error
+	closed  bool
+}
+
+// iteratorPageSize is the number of the keys read from the index each time the read lock is held by Iterator.
+const iteratorPageSize = 256
+
+// NewIterator returns an iterator of the keys with options.Prefix, in descending order if options.Reverse is true,
+// it is positioned by Rewind or Seek before use, and should be closed by Close after use.
+//
+// The iterator does not hold the lock of the database between the steps, like KeysChan,
+// the keys are read from the index every 256 keys, and the value of each key is read when the iterator moves to it,
+// so the database can be written during the iteration, even by the same goroutine.
+// So the iterator does not see a snapshot: the keys written during the iteration may or may not be iterated,
+// and the keys deleted or expired before the iterator moves to them are skipped.
+func (db *DB) NewIterator(options IteratorOptions) (*Iterator, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+	return &Iterator{db: db, options: options}, nil
+}
+
+// Rewind moves the iterator to the first key.
+func (it *Iterator) Rewind() {
+	it.count = 0
+	it.seek(nil)
+}
+
+// Seek moves the iterator to the first key greater than or equal to key,
+// or less than or equal to key if the iterator is reversed.
+func (it *Iterator) Seek(key []byte) {
+	it.count = 0
+	it.seek(key)
+}
+
+// seek reads the page of the keys from key, or from the first key if key is nil, and moves to the first valid one.
+func (it *Iterator) seek(key []byte) {
+	if it.closed {
+		return
+	}
+	it.keys, it.index = nil, 0
+	if it.err = it.loadPage(key, false); it.err != nil {
+		return
+	}
+	it.moveTo(0)
+}
+
+// Next moves the iterator to the next key.
+func (it *Iterator) Next() {
+	if it.Valid() {
+		it.moveTo(it.index + 1)
+	}
+}
+
+// Valid reports whether the iterator is positioned at a key,
+// it is false after all the keys are iterated, an error occurs or the iterator is closed.
+func (it *Iterator) Valid() bool {
+	return !it.closed && it.err == nil && it.index < len(it.keys)
+}
+
+// Key returns the key at the current position, it must be called only if Valid returns true.
+func (it *Iterator) Key() []byte {
+	return it.keys[it.index]
+}
+
+// Value returns the value at the current position, it must be called only if Valid returns true.
+// It returns nil if IteratorOptions.KeyOnly is true.
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// Err returns the error that occurred when reading the keys or the values.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the keys read by the iterator, it is safe to be called multiple times.
+func (it *Iterator) Close() {
+	it.closed = true
+	it.keys, it.value = nil, nil
+}
+
+// moveTo moves the iterator to the first valid key from index, and reads its value,
+// the next page of the keys is read when the page is iterated.
+func (it *Iterator) moveTo(index int) {
+	it.err, it.value = nil, nil
+	if it.options.Limit > 0 && it.count >= it.options.Limit {
+		it.keys, it.index = nil, 0
+		return
+	}
+	for it.index = index; ; it.index++ {
+		if it.index >= len(it.keys) {
+			if !it.more {
+				return
+			}
+			if it.err = it.loadPage(it.keys[len(it.keys)-1], true); it.err != nil {
+				return
+			}
+			it.index = 0
+			if len(it.keys) == 0 {
+				return
+			}
+		}
+		valid, err := it.read(it.keys[it.index])
+		if err != nil {
+			it.err = err
+			return
+		}
+		if valid {
+			it.count++
+			return
+		}
+	}
+}
+
+// loadPage reads at most iteratorPageSize keys with IteratorOptions.Prefix from the index in the order of the iteration,
+// starting from start, or from the first key if start is nil, start itself is skipped if after is true.
+func (it *Iterator) loadPage(start []byte, after bool) error {
+	it.db.mu.RLock()
+	defer it.db.mu.RUnlock()
+	if it.db.closed {
+		return ErrDBClosed
+	}
+
+	prefix := it.options.Prefix
+	keys := make([][]byte, 0, iteratorPageSize)
+	handleFn := func(key []byte, _ *wal.ChunkPosition) (bool, error) {
+		if !bytes.HasPrefix(key, prefix) {
+			// only the upper bound of the prefix is before the keys with prefix in the reversed order
+			return it.options.Reverse && bytes.Compare(key, prefix) > 0, nil
+		}
+		if (after && bytes.Equal(key, start)) || isStructKey(key) {
+			return true, nil
+		}
+		keys = append(keys, key)
+		return len(keys) < iteratorPageSize, nil
+	}
+	if it.options.Reverse {
+		upper := prefixUpperBound(prefix)
+		if upper != nil && (start == nil || bytes.Compare(start, upper) > 0) {
+			start = upper
+		}
+		if start == nil {
+			it.db.index.Descend(handleFn)
+		} else {
+			it.db.index.DescendLessOrEqual(start, handleFn)
+		}
+	} else {
+		if start == nil || bytes.Compare(start, prefix) < 0 {
+			start = prefix
+		}
+		it.db.index.AscendGreaterOrEqual(start, handleFn)
+	}
+	it.keys, it.more = keys, len(keys) == iteratorPageSize
+	return nil
+}
+
+// prefixUpperBound returns the smallest key greater than all the keys with prefix,
+// it returns nil if there is no such key, that is the prefix is empty or all 0xff.
+func prefixUpperBound(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] < 0xff {
+			upper := make([]byte, i+1)
+			copy(upper, prefix)
+			upper[i]++
+			return upper
+		}
+	}
+	return nil
+}
+
+// read reads the value of the key unless IteratorOptions.KeyOnly is true,
+// it returns false if the key is deleted or expired.
+func (it *Iterator) read(key []byte) (bool, error) {
+	it.db.mu.RLock()
+	defer it.db.mu.RUnlock()
+	if it.db.closed {
+		return false, ErrDBClosed
+	}
+	// the key may be deleted since the page was read
+	pos := it.db.index.Get(key)
+	if pos == nil {
+		return false, nil
+	}
+	if it.options.KeyOnly && !it.options.SkipExpired {
+		return true, nil
+	}
+	chunk, err := it.db.dataFiles.Read(pos)
+	if err != nil {
+		return false, err
+	}
+	if it.options.KeyOnly {
+		header := decodeLogRecordHeader(chunk)
+		return header.recordType != LogRecordDeleted && !header.isExpired(it.db.now().UnixNano()), nil
+	}
+
+	value, err := it.db.checkValue(chunk)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	it.value = value
+	return true, nil
+}
+
+// keysChanPageSize is the number of the keys read from the index each time the read lock is held by KeysChan.
+const keysChanPageSize = 256
+
+// KeysChan returns a channel of the keys with prefix in ascending order, the deleted and expired keys are skipped,
+// so the keys can be processed by a pool of workers ranging over the channel.
+// The channel is closed when all the keys are sent, ctx is done or the database is closed.
+//
+// The keys are sent as the consumer receives them, and the read lock of the database is only held
+// while reading every 256 keys from the index, so the writers are not blocked by a slow consumer,
+// and the keys written during the iteration may or may not be sent.
+// The error of reading the data files ends the iteration, and it is reported to Options.OnBackgroundError.
+func (db *DB) KeysChan(ctx context.Context, prefix []byte) <-chan []byte {
+	ch := make(chan []byte)
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed || db.isClosing() {
+		close(ch)
+		return ch
+	}
+
+	closeCh := db.closeCh
+	db.goBackground(BackgroundTaskKeys, func() {
+		defer close(ch)
+		var after []byte
+		for {
+			keys, err := db.keysPage(prefix, after)
+			if err != nil {
+				if err != ErrDBClosed {
+					db.backgroundError(BackgroundTaskKeys, err)
+				}
+				return
+			}
+			for _, key := range keys {
+				select {
+				case <-ctx.Done():
+					return
+				case <-closeCh:
+					return
+				case ch <- key:
+				}
+			}
+			if len(keys) < keysChanPageSize {
+				return
+			}
+			after = keys[len(keys)-1]
+		}
+	})
+	return ch
+}
+
+// keysPage returns at most keysChanPageSize valid keys with prefix after the given key for KeysChan,
+// the keys are from the beginning of prefix if after is nil.
+func (db *DB) keysPage(prefix, after []byte) ([][]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	start := prefix
+	if after != nil {
+		start = after
+	}
+	now := db.now().UnixNano()
+	var keys [][]byte
+	var readErr error
+	db.index.AscendGreaterOrEqual(start, func(key []byte, pos *wal.ChunkPosition) (bool, error) {
+		if !bytes.HasPrefix(key, prefix) {
+			return false, nil
+		}
+		if (after != nil && bytes.Equal(key, after)) || isStructKey(key) {
+			return true, nil
+		}
+		chunk, err := db.dataFiles.Read(pos)
+		if err != nil {
+			readErr = err
+			return false, err
+		}
+		header := decodeLogRecordHeader(chunk)
+		if header.recordType != LogRecordDeleted && !header.isExpired(now) {
+			keys = append(keys, key)
+		}
+		return len(keys) < keysChanPageSize, nil
+	})
+	return keys, readErr
+}
@@ -0,0 +1,232 @@
+package rosedb
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_NewIterator(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for _, key := range []string{"a1", "a2", "a3", "a4", "b1", "c1"} {
+		assert.Nil(t, db.Put([]byte(key), []byte("value-"+key)))
+	}
+	assert.Nil(t, db.Delete([]byte("a2")))
+
+	iterate := func(it *Iterator) []string {
+		var keys []string
+		for ; it.Valid(); it.Next() {
+			assert.Equal(t, "value-"+string(it.Key()), string(it.Value()))
+			keys = append(keys, string(it.Key()))
+		}
+		assert.Nil(t, it.Err())
+		return keys
+	}
+
+	it, err := db.NewIterator(IteratorOptions{})
+	assert.Nil(t, err)
+	it.Rewind()
+	assert.Equal(t, []string{"a1", "a3", "a4", "b1", "c1"}, iterate(it))
+	it.Seek([]byte("a2"))
+	assert.Equal(t, []string{"a3", "a4", "b1", "c1"}, iterate(it))
+	it.Seek([]byte("d"))
+	assert.False(t, it.Valid())
+	it.Close()
+	it.Close()
+	assert.False(t, it.Valid())
+
+	it, err = db.NewIterator(IteratorOptions{Prefix: []byte("a"), Reverse: true})
+	assert.Nil(t, err)
+	it.Rewind()
+	assert.Equal(t, []string{"a4", "a3", "a1"}, iterate(it))
+	it.Seek([]byte("a2"))
+	assert.Equal(t, []string{"a1"}, iterate(it))
+	it.Close()
+}
+
+func TestDB_NewIterator_Paging(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+	}
+	assert.Nil(t, db.PutWithTTL(utils.GetTestKey(1000), utils.RandomValue(10), time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+
+	for _, reverse := range []bool{false, true} {
+		it, err := db.NewIterator(IteratorOptions{Reverse: reverse})
+		assert.Nil(t, err)
+		var keys [][]byte
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, it.Key())
+			// the database can be written during the iteration by the same goroutine,
+			// the key deleted before the iterator moves to it is skipped.
+			if len(keys) == 300 {
+				assert.Nil(t, db.Put([]byte("written"), []byte("v")))
+				assert.Nil(t, db.Delete(utils.GetTestKey(500)))
+			}
+		}
+		assert.Nil(t, it.Err())
+		it.Close()
+		assert.Nil(t, db.Put(utils.GetTestKey(500), utils.RandomValue(10)))
+		assert.Nil(t, db.Delete([]byte("written")))
+
+		assert.True(t, len(keys) >= 999 && len(keys) <= 1000)
+		for i := 1; i < len(keys); i++ {
+			if reverse {
+				assert.True(t, bytes.Compare(keys[i-1], keys[i]) > 0)
+			} else {
+				assert.True(t, bytes.Compare(keys[i-1], keys[i]) < 0)
+			}
+			assert.NotEqual(t, utils.GetTestKey(500), keys[i])
+		}
+	}
+
+	// the prefix is iterated in reverse from its upper bound
+	assert.Nil(t, db.Put([]byte{'a', 0xff}, []byte("v")))
+	assert.Nil(t, db.Put([]byte{'b'}, []byte("v")))
+	it, err := db.NewIterator(IteratorOptions{Prefix: []byte{'a'}, Reverse: true})
+	assert.Nil(t, err)
+	it.Rewind()
+	assert.True(t, it.Valid())
+	assert.Equal(t, []byte{'a', 0xff}, it.Key())
+	it.Close()
+	assert.Equal(t, []byte{'b'}, prefixUpperBound([]byte{'a', 0xff}))
+	assert.Nil(t, prefixUpperBound([]byte{0xff}))
+
+	it, err = db.NewIterator(IteratorOptions{})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	it.Rewind()
+	assert.Equal(t, ErrDBClosed, it.Err())
+	_, err = db.NewIterator(IteratorOptions{})
+	assert.Equal(t, ErrDBClosed, err)
+}
+
+func TestDB_NewIterator_Options(t *testing.T) {
+	clock := newFakeClock()
+	options := DefaultOptions
+	options.Clock = clock
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+	}
+	for i := 10; i < 15; i++ {
+		assert.Nil(t, db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(10), time.Second))
+	}
+	clock.Advance(time.Second)
+
+	count := func(options IteratorOptions) int {
+		it, err := db.NewIterator(options)
+		assert.Nil(t, err)
+		defer it.Close()
+		var n int
+		for it.Rewind(); it.Valid(); it.Next() {
+			if options.KeyOnly {
+				assert.Nil(t, it.Value())
+			} else {
+				assert.NotNil(t, it.Value())
+			}
+			n++
+		}
+		assert.Nil(t, it.Err())
+		return n
+	}
+	assert.Equal(t, 10, count(IteratorOptions{}))
+	assert.Equal(t, 15, count(IteratorOptions{KeyOnly: true}))
+	assert.Equal(t, 10, count(IteratorOptions{KeyOnly: true, SkipExpired: true}))
+	assert.Equal(t, 3, count(IteratorOptions{Limit: 3}))
+	assert.Equal(t, 10, count(IteratorOptions{Limit: 20, Reverse: true}))
+
+	// the limit is reset by Seek
+	it, err := db.NewIterator(IteratorOptions{KeyOnly: true, Limit: 2})
+	assert.Nil(t, err)
+	defer it.Close()
+	var keys [][]byte
+	for it.Seek(utils.GetTestKey(5)); it.Valid(); it.Next() {
+		keys = append(keys, it.Key())
+	}
+	assert.Equal(t, [][]byte{utils.GetTestKey(5), utils.GetTestKey(6)}, keys)
+}
+
+func TestDB_KeysChan(t *testing.T) {
+	clock := newFakeClock()
+	options := DefaultOptions
+	options.Clock = clock
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+	}
+	assert.Nil(t, db.Put([]byte("other"), []byte("value")))
+	assert.Nil(t, db.Delete(utils.GetTestKey(1)))
+	assert.Nil(t, db.PutWithTTL(utils.GetTestKey(2), utils.RandomValue(10), time.Second))
+	clock.Advance(time.Second)
+
+	// the keys span several pages and are received by the workers
+	ch := db.KeysChan(context.Background(), []byte("rosedb-test-key"))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	received := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range ch {
+				mu.Lock()
+				received[string(key)] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 998, len(received))
+	assert.False(t, received[string(utils.GetTestKey(1))])
+	assert.False(t, received[string(utils.GetTestKey(2))])
+
+	// the keys are in order, and the writers are not blocked by the consumer
+	var keys [][]byte
+	for key := range db.KeysChan(context.Background(), nil) {
+		if len(keys) == 500 {
+			assert.Nil(t, db.Put(utils.GetTestKey(1), utils.RandomValue(10)))
+		}
+		keys = append(keys, key)
+	}
+	assert.Equal(t, 999, len(keys))
+	assert.Equal(t, []byte("other"), keys[0])
+	for i := 1; i < len(keys); i++ {
+		assert.True(t, bytes.Compare(keys[i-1], keys[i]) < 0)
+	}
+
+	// the channel is closed when ctx is cancelled or the database is closed
+	ctx, cancel := context.WithCancel(context.Background())
+	ch = db.KeysChan(ctx, nil)
+	<-ch
+	cancel()
+	for range ch {
+	}
+	ch = db.KeysChan(context.Background(), nil)
+	<-ch
+	assert.Nil(t, db.Close())
+	for range ch {
+	}
+	_, ok := <-db.KeysChan(context.Background(), nil)
+	assert.False(t, ok)
+}
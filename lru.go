@@ -0,0 +1,163 @@
+package rosedb
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/rosedblabs/wal"
+)
+
+// keyLRU tracks the size and the access recency of all keys,
+// it is used to evict the least recently used keys when the total size
+// exceeds Options.MaxTotalSize.
+// The records of the data structures are not tracked, since a structure is stored in several records,
+// evicting some of them would corrupt it.
+type keyLRU struct {
+	mu        sync.Mutex
+	list      *list.List // front is the most recently used
+	items     map[string]*list.Element
+	totalSize int64
+}
+
+type lruEntry struct {
+	key  []byte
+	size int64
+}
+
+func newKeyLRU() *keyLRU {
+	return &keyLRU{
+		list:  list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// put sets the size of the key and marks it as the most recently used.
+// The records of the data structures are ignored.
+func (l *keyLRU) put(key []byte, size int64) {
+	if isStructKey(key) {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.items[string(key)]; ok {
+		entry := elem.Value.(*lruEntry)
+		l.totalSize += size - entry.size
+		entry.size = size
+		l.list.MoveToFront(elem)
+		return
+	}
+	l.items[string(key)] = l.list.PushFront(&lruEntry{key: key, size: size})
+	l.totalSize += size
+}
+
+// touch marks the key as the most recently used.
+func (l *keyLRU) touch(key []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.items[string(key)]; ok {
+		l.list.MoveToFront(elem)
+	}
+}
+
+func (l *keyLRU) remove(key []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.items[string(key)]; ok {
+		l.totalSize -= elem.Value.(*lruEntry).size
+		l.list.Remove(elem)
+		delete(l.items, string(key))
+	}
+}
+
+// victims returns the least recently used keys which should be evicted
+// to make the total size not exceed maxSize, the keys in exclude will not be evicted.
+func (l *keyLRU) victims(maxSize int64, exclude map[string]*LogRecord) [][]byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var keys [][]byte
+	size := l.totalSize
+	for elem := l.list.Back(); elem != nil && size > maxSize; elem = elem.Prev() {
+		entry := elem.Value.(*lruEntry)
+		if _, ok := exclude[string(entry.key)]; ok {
+			continue
+		}
+		keys = append(keys, entry.key)
+		size -= entry.size
+	}
+	return keys
+}
+
+// loadKeyLRU tracks all keys in the index,
+// the access recency is unknown when opening, so the keys are added in index order.
+func (db *DB) loadKeyLRU() error {
+	db.keyLRU = newKeyLRU()
+	var err error
+	db.index.Ascend(func(key []byte, pos *wal.ChunkPosition) (bool, error) {
+		if isStructKey(key) {
+			return true, nil
+		}
+		var size int64
+		if size, err = db.recordSize(pos); err != nil {
+			return false, err
+		}
+		db.keyLRU.put(key, size)
+		return true, nil
+	})
+	return err
+}
+
+// recordSize returns the disk size of the record at the position,
+// including the value in the value log if it is separated.
+func (db *DB) recordSize(pos *wal.ChunkPosition) (int64, error) {
+	size := int64(pos.ChunkSize)
+	if db.valueLogFiles == nil {
+		return size, nil
+	}
+	chunk, err := db.dataFiles.Read(pos)
+	if err != nil {
+		return 0, err
+	}
+	if record := decodeLogRecord(chunk); record.Type == LogRecordValuePointer {
+		size += int64(decodeValuePointer(record.Value).ChunkSize)
+	}
+	return size, nil
+}
+
+// evict deletes the least recently used keys until the total size does not exceed Options.MaxTotalSize,
+// the keys written by the current batch will not be evicted.
+// It must be called with the database locked, and returns the evicted keys.
+func (db *DB) evict(batchId uint64, pendingWrites map[string]*LogRecord) ([][]byte, error) {
+	keys := db.keyLRU.victims(db.options.MaxTotalSize, pendingWrites)
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	records := make([]*LogRecord, len(keys))
+	for i, key := range keys {
+		records[i] = &LogRecord{Key: key, Type: LogRecordDeleted}
+	}
+	if err := db.rewriteRecords(records, batchId); err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		db.keyLRU.remove(record.Key)
+		db.versions.remove(record.Key)
+		if db.accessTracker != nil {
+			db.accessTracker.remove(record.Key)
+		}
+		if len(db.secondaryIndexes) > 0 {
+			db.updateSecondaryIndexes(record, 0)
+		}
+		if db.options.WatchQueueSize > 0 {
+			e := &Event{Action: WatchActionDelete, Key: record.Key, BatchId: batchId, TTL: -1}
+			db.watcher.putEvent(e)
+			db.publish(e)
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,105 @@
+package rosedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_MaxTotalSize_Evict(t *testing.T) {
+	options := DefaultOptions
+	options.MaxTotalSize = 100 * KB
+	var evicted [][]byte
+	options.OnEvict = func(key []byte) {
+		evicted = append(evicted, key)
+	}
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 90; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(KB))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 0, len(evicted))
+
+	// key 0 is recently used, it will not be evicted
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	for i := 90; i < 150; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(KB))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(evicted) > 0)
+	assert.True(t, db.keyLRU.totalSize <= options.MaxTotalSize)
+	assertKeyExistOrNot(t, db, utils.GetTestKey(0), true)
+	assertKeyExistOrNot(t, db, utils.GetTestKey(1), false)
+	assertKeyExistOrNot(t, db, utils.GetTestKey(149), true)
+	for _, key := range evicted {
+		assertKeyExistOrNot(t, db, key, false)
+	}
+
+	// reopen, the evicted keys are deleted persistently
+	keysNum := db.Stat().KeysNum
+	_ = db.Close()
+	db2, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Equal(t, keysNum, db2.Stat().KeysNum)
+	assert.True(t, db2.keyLRU.totalSize <= options.MaxTotalSize)
+}
+
+func TestDB_MaxTotalSize_Structures(t *testing.T) {
+	options := DefaultOptions
+	options.MaxTotalSize = 100 * KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// the sorted set alone exceeds the limit, it is not evicted
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.ZAdd([]byte("zset"), float64(i), utils.RandomValue(KB)))
+	}
+	for i := 0; i < 150; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+	}
+	assertKeyExistOrNot(t, db, utils.GetTestKey(0), false)
+	assert.True(t, db.keyLRU.totalSize <= options.MaxTotalSize)
+
+	members, err := db.ZRange([]byte("zset"), 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, len(members))
+	for _, m := range members {
+		score, err := db.ZScore([]byte("zset"), m.Member)
+		assert.Nil(t, err)
+		assert.Equal(t, m.Score, score)
+	}
+}
+
+func TestDB_MaxTotalSize_Merge(t *testing.T) {
+	options := DefaultOptions
+	options.MaxTotalSize = 100 * KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(KB), time.Hour))
+	}
+	// the records rewritten by merge have the new sizes, the keys are tracked by the rebuilt index
+	assert.Nil(t, db.Merge(true))
+	lru := db.keyLRU
+	assert.Nil(t, db.loadKeyLRU())
+	assert.Equal(t, db.keyLRU.totalSize, lru.totalSize)
+	assert.Equal(t, len(db.keyLRU.items), len(lru.items))
+
+	for i := 50; i < 150; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+	}
+	assert.True(t, db.keyLRU.totalSize <= options.MaxTotalSize)
+	assertKeyExistOrNot(t, db, utils.GetTestKey(149), true)
+}
@@ -1,16 +1,15 @@
 package rosedb
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
-	"github.com/rosedblabs/rosedb/v2/index"
 	"github.com/rosedblabs/wal"
 	"io"
 	"math"
 	"os"
 	"path/filepath"
 	"sync/atomic"
-	"time"
 )
 
 const (
@@ -28,7 +27,46 @@ const (
 // If reopenAfterDone is true, the original file will be replaced by the merge file,
 // and db's index will be rebuilt after the merge completes.
 func (db *DB) Merge(reopenAfterDone bool) error {
-	if err := db.doMerge(); err != nil {
+	return db.MergeContext(context.Background(), reopenAfterDone)
+}
+
+// MergeContext is like Merge, but it can be cancelled by ctx.
+// If ctx is done before the merge completes, the merge will be aborted and ctx.Err() will be returned,
+// the incomplete merge files will be discarded, and the database is not affected.
+//
+// The merge saves its progress after each data file is merged, see mergeManifest,
+// so if it is interrupted by a crash or closing the database, the next Merge, MergeContext or MergeUpTo
+// resumes it from the last merged data file instead of starting over.
+// The resumed merge keeps its original range of the data files, the newer ones are merged by the next merge.
+func (db *DB) MergeContext(ctx context.Context, reopenAfterDone bool) error {
+	return db.merge(ctx, 0, false, reopenAfterDone)
+}
+
+// MergeSorted is like Merge, but the valid records are rewritten in the order of keys,
+// instead of the order they were written, so the range scans like AscendRange
+// read the data files sequentially after the merged files are loaded.
+//
+// It is more expensive than Merge, because the records are read randomly in the order of keys.
+// Like Merge, the disk usage is doubled temporarily until the merged files replace the original ones.
+func (db *DB) MergeSorted(reopenAfterDone bool) error {
+	return db.merge(context.Background(), 0, true, reopenAfterDone)
+}
+
+// MergeUpTo merges only the oldest n data files, instead of all the data files,
+// so the disk space can be reclaimed in smaller steps, and each merge is much lighter.
+// The active data file is never merged, if n is 0 or not less than the number of
+// the older data files, it is the same as Merge.
+//
+// Only the oldest data files can be merged, because the stale data in them
+// may be deleted by the delete records in the newer data files,
+// and merging the newer data files alone will bring the stale data back.
+// The values in the value log are not relocated, they are reclaimed by the next full Merge or ValueLogGC.
+func (db *DB) MergeUpTo(n int, reopenAfterDone bool) error {
+	return db.merge(context.Background(), n, false, reopenAfterDone)
+}
+
+func (db *DB) merge(ctx context.Context, n int, sorted, reopenAfterDone bool) error {
+	if err := db.doMerge(ctx, n, sorted); err != nil {
 		return err
 	}
 	if !reopenAfterDone {
@@ -38,37 +76,29 @@ func (db *DB) Merge(reopenAfterDone bool) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	// close current files
-	_ = db.closeFiles()
-
-	// replace original file
-	err := loadMergeFiles(db.options.DirPath)
-	if err != nil {
-		return err
-	}
-
-	// open data files
-	if db.dataFiles, err = db.openWalFiles(); err != nil {
-		return err
+	// the database may be closed when merging
+	if db.closed {
+		return ErrDBClosed
 	}
 
-	// discard the old index first.
-	db.index = index.NewIndexer()
-	// rebuild index
-	if err = db.loadIndex(); err != nil {
-		return err
-	}
+	// close current files
+	_ = db.closeFiles()
 
-	return nil
+	// replace the original files by the merged ones, open them and rebuild the index by the same path as Open
+	db.dataFiles, db.hintFile, db.valueLogFiles, db.keyLRU = nil, nil, nil, nil
+	return db.load()
 }
 
-func (db *DB) doMerge() error {
+// doMerge merges the oldest n data files, or all the older data files if n is 0.
+// If sorted is true, the valid records are rewritten in the order of keys.
+func (db *DB) doMerge(ctx context.Context, n int, sorted bool) (err error) {
 	db.mu.Lock()
-	// check if the database is closed
-	if db.closed {
+	// check if the database is closed or closing
+	if db.closed || db.isClosing() {
 		db.mu.Unlock()
 		return ErrDBClosed
 	}
+	closeCh := db.closeCh
 	// check if the data files is empty
 	if db.dataFiles.IsEmpty() {
 		db.mu.Unlock()
@@ -84,13 +114,23 @@ func (db *DB) doMerge() error {
 	// set the mergeRunning flag to false when the merge operation is completed
 	defer atomic.StoreUint32(&db.mergeRunning, 0)
 
-	prevActiveSegId := db.dataFiles.ActiveSegmentID()
-	// rotate the write-ahead log, create a new active segment file.
-	// so all the older segment files will be merged.
-	if err := db.dataFiles.OpenNewActiveSegment(); err != nil {
-		db.mu.Unlock()
-		return err
+	// resume the interrupted merge from its last checkpoint, the sorted merge is never resumed,
+	// since it does not read the data files one by one.
+	var manifest *mergeManifest
+	if !sorted {
+		manifest = readMergeManifest(mergeDirPath(db.options.DirPath))
+		if manifest != nil && manifest.segmentId >= db.dataFiles.ActiveSegmentID() {
+			manifest = nil
+		}
 	}
+	resume := manifest != nil
+	if !resume {
+		if manifest, err = db.startMerge(n); err != nil {
+			db.mu.Unlock()
+			return err
+		}
+	}
+	prevActiveSegId, prevValueLogSegId, relocateValues := manifest.segmentId, manifest.valueLogSegmentId, manifest.relocateValues
 
 	// we can unlock the mutex here, because the write-ahead log files has been rotated,
 	// and the new active segment file will be used for the subsequent writes.
@@ -98,30 +138,67 @@ func (db *DB) doMerge() error {
 	db.mu.Unlock()
 
 	// open a merge db to write the data to the new data file.
-	// delete the merge directory if it exists and create a new one.
-	mergeDB, err := db.openMergeDB()
+	// delete the merge directory if it exists and create a new one,
+	// or discard the data written after the checkpoint if the merge is resumed.
+	mergeDB, err := db.openMergeDB(manifest, resume)
 	if err != nil {
 		return err
 	}
+	// the incomplete merge files are discarded if the merge fails,
+	// but they are kept to be resumed if the merge is interrupted by closing the database.
+	defer func() {
+		if err != nil && err != ErrDBClosed {
+			_ = os.RemoveAll(mergeDirPath(db.options.DirPath))
+		}
+	}()
 	defer func() {
 		_ = mergeDB.Close()
 	}()
 
-	now := time.Now().UnixNano()
+	now := db.now().UnixNano()
+	// throttle the reading and writing of merge if MergeRateLimit is set.
+	limiter := newRateLimiter(db.options.MergeRateLimit)
 	// iterate all the data files, and write the valid data to the new data file.
-	reader := db.dataFiles.NewReaderWithMax(prevActiveSegId)
+	// If sorted is true, the records are read in the order of keys in the index.
+	next := db.mergeReader(prevActiveSegId, manifest.mergedSegmentId)
+	if sorted {
+		next = db.sortedMergeReader(prevActiveSegId)
+	}
+	// the data file being merged, the checkpoint is saved when all its records are merged.
+	var mergingSegId wal.SegmentID
 	for {
-		chunk, position, err := reader.Next()
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// stop merging if the database is closing
+		if closing(closeCh) {
+			return ErrDBClosed
+		}
+		chunk, position, err := next()
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
 			return err
 		}
+		if !sorted && position.SegmentId != mergingSegId {
+			if mergingSegId > 0 {
+				if err = checkpointMerge(mergeDB, manifest, mergingSegId); err != nil {
+					return err
+				}
+			}
+			mergingSegId = position.SegmentId
+		}
+		if err = limiter.wait(ctx, len(chunk)); err != nil {
+			return err
+		}
 		record := decodeLogRecord(chunk)
+		if err = db.retainTombstone(ctx, mergeDB, limiter, record, now); err != nil {
+			return err
+		}
 		// Only handle the normal log record, LogRecordDeleted and LogRecordBatchFinished
 		// will be ignored, because they are not valid data.
-		if record.Type == LogRecordNormal && (record.Expire == 0 || record.Expire > now) {
+		if record.IsValue() && (record.Expire == 0 || record.Expire > now) {
 			db.mu.RLock()
 			indexPos := db.index.Get(record.Key)
 			db.mu.RUnlock()
@@ -129,9 +206,32 @@ func (db *DB) doMerge() error {
 				// clear the batch id of the record,
 				// all data after merge will be valid data, so the batch id should be 0.
 				record.BatchId = mergeFinishedBatchID
+				// if the value is stored in the value log, relocate it to the value log of mergeDB,
+				// so the unreferenced values in the older value log files will be discarded.
+				// If all values are separated, only the key and the pointer will be rewritten.
+				if relocateValues {
+					if record.Type == LogRecordValuePointer {
+						if record.Value, err = db.loadValue(record); err != nil {
+							return err
+						}
+						record.Type, record.codecs = LogRecordNormal, 0
+						if record, err = mergeDB.encodeValue(record); err != nil {
+							return err
+						}
+					}
+					if record, err = mergeDB.separateValue(record); err != nil {
+						return err
+					}
+				}
 				// Since the mergeDB will never be used for any read or write operations,
 				// it is not necessary to update the index.
-				newPosition, err := mergeDB.dataFiles.Write(encodeLogRecord(record))
+				buf, encRecord := encodeLogRecordPooled(record)
+				if err = limiter.wait(ctx, len(encRecord)); err != nil {
+					putEncodeBuffer(buf)
+					return err
+				}
+				newPosition, err := mergeDB.dataFiles.Write(encRecord)
+				putEncodeBuffer(buf)
 				if err != nil {
 					return err
 				}
@@ -146,6 +246,38 @@ func (db *DB) doMerge() error {
 		}
 	}
 
+	// the delete records are not in the index, so they are read in another pass if sorted.
+	if sorted && db.options.TombstoneRetention > 0 {
+		reader := db.dataFiles.NewReaderWithMax(prevActiveSegId)
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if closing(closeCh) {
+				return ErrDBClosed
+			}
+			chunk, _, err := reader.Next()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+			if err = limiter.wait(ctx, len(chunk)); err != nil {
+				return err
+			}
+			if err = db.retainTombstone(ctx, mergeDB, limiter, decodeLogRecord(chunk), now); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the merged data files will replace the original ones with the same segment ids,
+	// so they must not exceed the last merged segment file.
+	if mergeDB.dataFiles.ActiveSegmentID() > prevActiveSegId {
+		return fmt.Errorf("merged data files exceed segment %d", prevActiveSegId)
+	}
+
 	// After rewrite all the data, we should add a file to indicate that the merge operation is completed.
 	// So when we restart the database, we can know that the merge is completed if the file exists,
 	// otherwise, we will delete the merge directory and redo the merge operation again.
@@ -153,7 +285,7 @@ func (db *DB) doMerge() error {
 	if err != nil {
 		return err
 	}
-	_, err = mergeFinFile.Write(encodeMergeFinRecord(prevActiveSegId))
+	_, err = mergeFinFile.Write(encodeMergeFinRecord(prevActiveSegId, prevValueLogSegId))
 	if err != nil {
 		return err
 	}
@@ -166,16 +298,149 @@ func (db *DB) doMerge() error {
 	return nil
 }
 
-func (db *DB) openMergeDB() (*DB, error) {
+// startMerge rotates the files to be merged, and returns the manifest of the new merge,
+// only the oldest n data files are merged if n is greater than 0.
+// It must be called with the database locked.
+func (db *DB) startMerge(n int) (*mergeManifest, error) {
+	// find the last segment file to be merged if only the oldest n data files are merged.
+	prevActiveSegId, partial, err := db.mergeUpToSegmentId(n)
+	if err != nil {
+		return nil, err
+	}
+	// rotate the write-ahead log, create a new active segment file.
+	// so all the older segment files will be merged.
+	if !partial {
+		prevActiveSegId = db.dataFiles.ActiveSegmentID()
+		if err := db.dataFiles.OpenNewActiveSegment(); err != nil {
+			return nil, err
+		}
+	}
+	// rotate the value log too, so the older value log segment files are only
+	// referenced by the older data files, and they can be replaced by the merged ones.
+	// If all values are separated, the value log is reclaimed by ValueLogGC, merge will not touch it.
+	// And the newer data files which are not merged may reference the older value log files too,
+	// so the value log will not be touched if only the oldest data files are merged.
+	relocateValues := !db.options.SeparateValues && !partial
+	var prevValueLogSegId wal.SegmentID
+	if db.valueLogFiles != nil && relocateValues {
+		prevValueLogSegId = db.valueLogFiles.ActiveSegmentID()
+		if err := db.valueLogFiles.OpenNewActiveSegment(); err != nil {
+			return nil, err
+		}
+	}
+	return &mergeManifest{
+		segmentId:         prevActiveSegId,
+		valueLogSegmentId: prevValueLogSegId,
+		relocateValues:    relocateValues,
+	}, nil
+}
+
+// mergeReader returns a function which reads the records in the data files not greater than maxSegId,
+// the data files not greater than mergedSegId are skipped, since they have been merged before resuming.
+func (db *DB) mergeReader(maxSegId, mergedSegId wal.SegmentID) func() ([]byte, *wal.ChunkPosition, error) {
+	reader := db.dataFiles.NewReaderWithMax(maxSegId)
+	return func() ([]byte, *wal.ChunkPosition, error) {
+		chunk, position, err := reader.Next()
+		for err == nil && position.SegmentId <= mergedSegId {
+			reader.SkipCurrentSegment()
+			chunk, position, err = reader.Next()
+		}
+		return chunk, position, err
+	}
+}
+
+// retainTombstone writes the delete record to mergeDB if it has not expired, see Options.TombstoneRetention.
+// The record is dropped if the key has been written again, since the newer record is kept by merge.
+func (db *DB) retainTombstone(ctx context.Context, mergeDB *DB, limiter *rateLimiter, record *LogRecord, now int64) error {
+	if db.options.TombstoneRetention == 0 || record.Type != LogRecordDeleted || record.Expire <= now {
+		return nil
+	}
+	db.mu.RLock()
+	indexPos := db.index.Get(record.Key)
+	db.mu.RUnlock()
+	if indexPos != nil {
+		return nil
+	}
+
+	// the merged delete record is replicated and applied directly like the other merged records.
+	record.BatchId = mergeFinishedBatchID
+	encRecord := encodeLogRecord(record)
+	if err := limiter.wait(ctx, len(encRecord)); err != nil {
+		return err
+	}
+	_, err := mergeDB.dataFiles.Write(encRecord)
+	return err
+}
+
+// sortedMergeReader returns a function which reads the records of the keys in the index in order,
+// only the records in the segment files not greater than maxSegId are read.
+// It returns io.EOF after all the records are read.
+func (db *DB) sortedMergeReader(maxSegId wal.SegmentID) func() ([]byte, *wal.ChunkPosition, error) {
+	db.mu.RLock()
+	positions := make([]*wal.ChunkPosition, 0, db.index.Size())
+	db.index.Ascend(func(_ []byte, pos *wal.ChunkPosition) (bool, error) {
+		if pos.SegmentId <= maxSegId {
+			positions = append(positions, pos)
+		}
+		return true, nil
+	})
+	db.mu.RUnlock()
+
+	return func() ([]byte, *wal.ChunkPosition, error) {
+		if len(positions) == 0 {
+			return nil, nil, io.EOF
+		}
+		pos := positions[0]
+		positions = positions[1:]
+		chunk, err := db.dataFiles.Read(pos)
+		if err != nil {
+			return nil, nil, err
+		}
+		return chunk, pos, nil
+	}
+}
+
+// mergeUpToSegmentId returns the segment id of the n-th oldest data file,
+// and false if all the older data files should be merged.
+func (db *DB) mergeUpToSegmentId(n int) (wal.SegmentID, bool, error) {
+	if n <= 0 {
+		return 0, false, nil
+	}
+	segIds, err := segmentFileIds(db.options.DirPath, dataFileNameSuffix)
+	if err != nil {
+		return 0, false, err
+	}
+	// the active segment file is the last one, it will never be merged
+	activeSegId := db.dataFiles.ActiveSegmentID()
+	for len(segIds) > 0 && segIds[len(segIds)-1] >= activeSegId {
+		segIds = segIds[:len(segIds)-1]
+	}
+	if n >= len(segIds) {
+		return 0, false, nil
+	}
+	return segIds[n-1], true, nil
+}
+
+// openMergeDB opens the database in the merge directory to write the merged data,
+// the merge directory is recreated, unless the merge is resumed from the checkpoint of manifest.
+func (db *DB) openMergeDB(manifest *mergeManifest, resume bool) (*DB, error) {
 	mergePath := mergeDirPath(db.options.DirPath)
-	// delete the merge directory if it exists
-	if err := os.RemoveAll(mergePath); err != nil {
+	if resume {
+		if err := truncateMergeFiles(mergePath, manifest); err != nil {
+			return nil, err
+		}
+	} else if err := os.RemoveAll(mergePath); err != nil {
+		// delete the merge directory if it exists
 		return nil, err
 	}
 	options := db.options
 	// we don't need to use the original sync policy,
 	// because we can sync the data file manually after the merge operation is completed.
 	options.Sync, options.BytesPerSync = false, 0
+	// the merge db is never reopened
+	options.PersistIndex = false
+	// the merged records are not transformed again
+	options.RecoveryTransform = nil
 	options.DirPath = mergePath
 	mergeDB, err := Open(options)
 	if err != nil {
@@ -222,6 +487,17 @@ func positionEquals(a, b *wal.ChunkPosition) bool {
 		a.ChunkOffset == b.ChunkOffset
 }
 
+// positionBefore reports whether the chunk at a is written before the chunk at b.
+func positionBefore(a, b *wal.ChunkPosition) bool {
+	if a.SegmentId != b.SegmentId {
+		return a.SegmentId < b.SegmentId
+	}
+	if a.BlockNumber != b.BlockNumber {
+		return a.BlockNumber < b.BlockNumber
+	}
+	return a.ChunkOffset < b.ChunkOffset
+}
+
 func encodeHintRecord(key []byte, pos *wal.ChunkPosition) []byte {
 	// SegmentId BlockNumber ChunkOffset ChunkSize
 	//    5          5           10          5      =    25
@@ -270,9 +546,10 @@ func decodeHintRecord(buf []byte) ([]byte, *wal.ChunkPosition) {
 	}
 }
 
-func encodeMergeFinRecord(segmentId wal.SegmentID) []byte {
-	buf := make([]byte, 4)
+func encodeMergeFinRecord(segmentId, valueLogSegmentId wal.SegmentID) []byte {
+	buf := make([]byte, 8)
 	binary.LittleEndian.PutUint32(buf, segmentId)
+	binary.LittleEndian.PutUint32(buf[4:], valueLogSegmentId)
 	return buf
 }
 
@@ -286,6 +563,16 @@ func loadMergeFiles(dirPath string) error {
 		return nil
 	}
 
+	// get the merge finished segment id
+	mergeFinSegmentId, err := getMergeFinSegmentId(mergeDirPath)
+	if err != nil {
+		return err
+	}
+	// the merge is interrupted, keep the merge directory to resume it, see mergeManifest.
+	if mergeFinSegmentId == 0 && readMergeManifest(mergeDirPath) != nil {
+		return nil
+	}
+
 	// remove the merge directory at last
 	defer func() {
 		_ = os.RemoveAll(mergeDirPath)
@@ -307,11 +594,13 @@ func loadMergeFiles(dirPath string) error {
 		_ = os.Rename(srcFile, destFile)
 	}
 
-	// get the merge finished segment id
-	mergeFinSegmentId, err := getMergeFinSegmentId(mergeDirPath)
-	if err != nil {
-		return err
+	// the index snapshot is stale after the data files are replaced
+	if mergeFinSegmentId > 0 {
+		if err = removeIndexSnapshot(dirPath); err != nil {
+			return err
+		}
 	}
+
 	// now we get the merge finished segment id, so all the segment id less than the merge finished segment id
 	// should be moved to the original data directory, and the original data files should be deleted.
 	for fileId := uint32(1); fileId <= mergeFinSegmentId; fileId++ {
@@ -335,35 +624,66 @@ func loadMergeFiles(dirPath string) error {
 		copyFile(dataFileNameSuffix, fileId, false)
 	}
 
+	// the same as data files, the value log files should be replaced by the merged ones.
+	mergeFinValueLogSegmentId, err := getMergeFinValueLogSegmentId(mergeDirPath)
+	if err != nil {
+		return err
+	}
+	for fileId := uint32(1); fileId <= mergeFinValueLogSegmentId; fileId++ {
+		destFile := wal.SegmentFileName(dirPath, valueLogFileNameSuffix, fileId)
+		if _, err = os.Stat(destFile); err == nil {
+			if err = os.Remove(destFile); err != nil {
+				return err
+			}
+		}
+		copyFile(valueLogFileNameSuffix, fileId, false)
+	}
+
 	// copy MERGEFINISHED and HINT files to the original data directory
 	// there is only one merge finished file, so the file id is always 1,
 	// the same as the hint file.
 	copyFile(mergeFinNameSuffix, 1, true)
 	copyFile(hintFileNameSuffix, 1, true)
 
-	return nil
+	// the merge directory is removed after the renames are durable, otherwise if the machine crashes,
+	// the original data files may be removed while the merged ones are lost with the merge directory.
+	return syncDir(dirPath)
 }
 
 func getMergeFinSegmentId(mergePath string) (wal.SegmentID, error) {
+	segmentId, _, err := readMergeFinRecord(mergePath)
+	return segmentId, err
+}
+
+func getMergeFinValueLogSegmentId(mergePath string) (wal.SegmentID, error) {
+	_, valueLogSegmentId, err := readMergeFinRecord(mergePath)
+	return valueLogSegmentId, err
+}
+
+// readMergeFinRecord returns the merge finished segment id of the data files and the value log files.
+func readMergeFinRecord(mergePath string) (wal.SegmentID, wal.SegmentID, error) {
 	// check if the merge operation is completed
 	mergeFinFile, err := os.Open(wal.SegmentFileName(mergePath, mergeFinNameSuffix, 1))
 	if err != nil {
 		// if the merge finished file does not exist, it means that the merge operation is not completed.
 		// so we should remove the merge directory and return nil.
-		return 0, nil
+		return 0, 0, nil
 	}
 	defer func() {
 		_ = mergeFinFile.Close()
 	}()
 
-	// Only 4 bytes are needed to store the segment id.
+	// 4 bytes are needed to store the segment id, and 4 bytes for the value log segment id.
 	// And the first 7 bytes are chunk header.
-	mergeFinBuf := make([]byte, 4)
-	if _, err := mergeFinFile.ReadAt(mergeFinBuf, 7); err != nil {
-		return 0, err
+	mergeFinBuf := make([]byte, 8)
+	n, err := mergeFinFile.ReadAt(mergeFinBuf, 7)
+	// the merge finished file written by the older version only has the segment id.
+	if err != nil && !(err == io.EOF && n == 4) {
+		return 0, 0, err
 	}
 	mergeFinSegmentId := binary.LittleEndian.Uint32(mergeFinBuf)
-	return mergeFinSegmentId, nil
+	mergeFinValueLogSegmentId := binary.LittleEndian.Uint32(mergeFinBuf[4:])
+	return mergeFinSegmentId, mergeFinValueLogSegmentId, nil
 }
 
 func (db *DB) loadIndexFromHintFile() error {
@@ -382,6 +702,7 @@ func (db *DB) loadIndexFromHintFile() error {
 	}()
 
 	// read all the hint records from the hint file
+	now := db.now().UnixNano()
 	reader := hintFile.NewReader()
 	for {
 		chunk, _, err := reader.Next()
@@ -396,6 +717,16 @@ func (db *DB) loadIndexFromHintFile() error {
 		// All the hint records are valid because it is generated by the merge operation.
 		// So just put them into the index without checking.
 		db.index.Put(key, position)
+		// the merged records are read by their positions to be transformed, instead of replaying the data files
+		if db.recoveryRewrites != nil {
+			chunk, err := db.dataFiles.Read(position)
+			if err != nil {
+				return err
+			}
+			if err = db.transformRecord(decodeLogRecord(chunk), position, now); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -0,0 +1,198 @@
+package rosedb
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+
+	"github.com/rosedblabs/wal"
+)
+
+const (
+	mergeManifestFileNameSuffix    = ".MANIFEST"
+	mergeManifestTmpFileNameSuffix = ".MANIFESTTMP"
+	mergeManifestSize              = 45
+)
+
+// mergeManifest is the progress of the merge saved in the merge directory after each data file is merged,
+// so the merge interrupted by a crash or closing the database can be resumed from the last checkpoint.
+//
+// The merged files are synced before the manifest is saved, so the data written before the checkpoint is durable.
+// The data written after the checkpoint is the half-merged output of the next data file,
+// it is identified by the sizes of the merged files saved in the manifest, and discarded by truncating
+// the merged files to the sizes when the merge is resumed, then the next data file is merged again.
+// The manifest is removed along with the merge directory when the merged files are loaded,
+// and it is ignored if the merge has finished, see readMergeFinRecord.
+type mergeManifest struct {
+	segmentId         wal.SegmentID // the last data file to be merged
+	valueLogSegmentId wal.SegmentID // the last value log file to be replaced by the merged one
+	relocateValues    bool          // whether the values in the value log are relocated to the merged value log
+	mergedSegmentId   wal.SegmentID // the last data file merged completely, 0 if none
+
+	// the active files of the merge database and their sizes at the checkpoint,
+	// the id of the value log file is 0 if the merge database has no value log.
+	dataSegmentId wal.SegmentID
+	dataSize      int64
+	hintSize      int64
+	valueLogSegId wal.SegmentID
+	valueLogSize  int64
+}
+
+func (m *mergeManifest) encode() []byte {
+	buf := make([]byte, mergeManifestSize)
+	binary.LittleEndian.PutUint32(buf, m.segmentId)
+	binary.LittleEndian.PutUint32(buf[4:], m.valueLogSegmentId)
+	if m.relocateValues {
+		buf[8] = 1
+	}
+	binary.LittleEndian.PutUint32(buf[9:], m.mergedSegmentId)
+	binary.LittleEndian.PutUint32(buf[13:], m.dataSegmentId)
+	binary.LittleEndian.PutUint64(buf[17:], uint64(m.dataSize))
+	binary.LittleEndian.PutUint64(buf[25:], uint64(m.hintSize))
+	binary.LittleEndian.PutUint32(buf[33:], m.valueLogSegId)
+	binary.LittleEndian.PutUint64(buf[37:], uint64(m.valueLogSize))
+	return buf
+}
+
+func decodeMergeManifest(buf []byte) *mergeManifest {
+	return &mergeManifest{
+		segmentId:         binary.LittleEndian.Uint32(buf),
+		valueLogSegmentId: binary.LittleEndian.Uint32(buf[4:]),
+		relocateValues:    buf[8] == 1,
+		mergedSegmentId:   binary.LittleEndian.Uint32(buf[9:]),
+		dataSegmentId:     binary.LittleEndian.Uint32(buf[13:]),
+		dataSize:          int64(binary.LittleEndian.Uint64(buf[17:])),
+		hintSize:          int64(binary.LittleEndian.Uint64(buf[25:])),
+		valueLogSegId:     binary.LittleEndian.Uint32(buf[33:]),
+		valueLogSize:      int64(binary.LittleEndian.Uint64(buf[37:])),
+	}
+}
+
+// checkpointMerge syncs the merged files, and saves the manifest after the data file mergedSegId is merged.
+func checkpointMerge(mergeDB *DB, manifest *mergeManifest, mergedSegId wal.SegmentID) error {
+	if err := mergeDB.syncFiles(); err != nil {
+		return err
+	}
+	if err := mergeDB.hintFile.Sync(); err != nil {
+		return err
+	}
+
+	mergePath := mergeDB.options.DirPath
+	manifest.mergedSegmentId = mergedSegId
+	manifest.dataSegmentId = mergeDB.dataFiles.ActiveSegmentID()
+	var err error
+	if manifest.dataSize, err = fileSize(wal.SegmentFileName(mergePath, dataFileNameSuffix, manifest.dataSegmentId)); err != nil {
+		return err
+	}
+	if manifest.hintSize, err = fileSize(wal.SegmentFileName(mergePath, hintFileNameSuffix, 1)); err != nil {
+		return err
+	}
+	if mergeDB.valueLogFiles != nil {
+		manifest.valueLogSegId = mergeDB.valueLogFiles.ActiveSegmentID()
+		fileName := wal.SegmentFileName(mergePath, valueLogFileNameSuffix, manifest.valueLogSegId)
+		if manifest.valueLogSize, err = fileSize(fileName); err != nil {
+			return err
+		}
+	}
+	return writeMergeManifest(mergePath, manifest)
+}
+
+// writeMergeManifest writes the manifest to a temporary file first, and renames it after it is synced,
+// so the manifest file is always complete, like the index snapshot.
+func writeMergeManifest(mergePath string, manifest *mergeManifest) error {
+	tmpFileName := wal.SegmentFileName(mergePath, mergeManifestTmpFileNameSuffix, 1)
+	if err := os.Remove(tmpFileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	manifestFile, err := wal.Open(wal.Options{
+		DirPath:        mergePath,
+		SegmentSize:    math.MaxInt64,
+		SegmentFileExt: mergeManifestTmpFileNameSuffix,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = manifestFile.Close()
+	}()
+
+	if _, err = manifestFile.Write(manifest.encode()); err != nil {
+		return err
+	}
+	if err = manifestFile.Sync(); err != nil {
+		return err
+	}
+	if err = manifestFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, wal.SegmentFileName(mergePath, mergeManifestFileNameSuffix, 1))
+}
+
+// readMergeManifest returns the manifest of the interrupted merge in the merge directory,
+// it returns nil if there is no manifest, the manifest is corrupted, or the merge has finished,
+// then the merge directory should be discarded.
+func readMergeManifest(mergePath string) *mergeManifest {
+	if _, err := os.Stat(wal.SegmentFileName(mergePath, mergeManifestFileNameSuffix, 1)); err != nil {
+		return nil
+	}
+	if mergeFinSegmentId, err := getMergeFinSegmentId(mergePath); err != nil || mergeFinSegmentId > 0 {
+		return nil
+	}
+	manifestFile, err := wal.Open(wal.Options{
+		DirPath:        mergePath,
+		SegmentSize:    math.MaxInt64,
+		SegmentFileExt: mergeManifestFileNameSuffix,
+	})
+	if err != nil {
+		return nil
+	}
+	defer func() {
+		_ = manifestFile.Close()
+	}()
+	// the checksum of the manifest is verified by the wal
+	buf, _, err := manifestFile.NewReader().Next()
+	if err != nil || len(buf) != mergeManifestSize {
+		return nil
+	}
+	return decodeMergeManifest(buf)
+}
+
+// truncateMergeFiles discards the data written to the merged files after the checkpoint of manifest,
+// the newer files are removed, and the active files are truncated to their sizes at the checkpoint.
+func truncateMergeFiles(mergePath string, manifest *mergeManifest) error {
+	for _, files := range []struct {
+		ext   string
+		segId wal.SegmentID
+		size  int64
+	}{
+		{dataFileNameSuffix, manifest.dataSegmentId, manifest.dataSize},
+		{valueLogFileNameSuffix, manifest.valueLogSegId, manifest.valueLogSize},
+		{hintFileNameSuffix, 1, manifest.hintSize},
+	} {
+		segIds, err := segmentFileIds(mergePath, files.ext)
+		if err != nil {
+			return err
+		}
+		for _, segId := range segIds {
+			fileName := wal.SegmentFileName(mergePath, files.ext, segId)
+			if segId > files.segId {
+				err = os.Remove(fileName)
+			} else if segId == files.segId {
+				err = os.Truncate(fileName, files.size)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fileSize returns the size of the file.
+func fileSize(fileName string) (int64, error) {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
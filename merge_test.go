@@ -1,12 +1,17 @@
 package rosedb
 
 import (
+	"context"
+	"io"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/rosedblabs/wal"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -303,3 +308,343 @@ func TestDB_Merge_Concurrent_Put(t *testing.T) {
 	assert.Equal(t, count, db.index.Size())
 
 }
+
+func TestDB_MergeContext_Cancel(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = db.MergeContext(ctx, true)
+	assert.Equal(t, context.Canceled, err)
+
+	// reopen, the incomplete merge will be discarded
+	_ = db.Close()
+	db2, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	_, err = os.Stat(mergeDirPath(options.DirPath))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 10000, db2.Stat().KeysNum)
+}
+
+func TestDB_Merge_RateLimit(t *testing.T) {
+	options := DefaultOptions
+	options.MergeRateLimit = MB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(KB))
+		assert.Nil(t, err)
+	}
+
+	// about 1MB read and 1MB written, the burst is 1MB
+	start := time.Now()
+	err = db.Merge(true)
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) > time.Millisecond*500)
+	assert.Equal(t, 1000, db.Stat().KeysNum)
+}
+
+func TestDB_MergeUpTo(t *testing.T) {
+	options := DefaultOptions
+	options.SegmentSize = 256 * KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(KB))
+		assert.Nil(t, err)
+	}
+	// the old values of the updated keys are stale data
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("updated"))
+		assert.Nil(t, err)
+	}
+	// the delete records are in the newer data files, the deleted keys must not come back
+	for i := 1000; i < 1100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	segments := func() int {
+		files, err := filepath.Glob(filepath.Join(options.DirPath, "*"+dataFileNameSuffix))
+		assert.Nil(t, err)
+		return len(files)
+	}
+	assertData := func(db *DB) {
+		assert.Equal(t, 1900, db.Stat().KeysNum)
+		for i := 0; i < 2000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			if i < 1000 {
+				assert.Nil(t, err)
+				assert.Equal(t, []byte("updated"), val)
+			} else if i < 1100 {
+				assert.Equal(t, ErrKeyNotFound, err)
+			} else {
+				assert.Nil(t, err)
+				assert.True(t, len(val) >= KB)
+			}
+		}
+	}
+
+	before := segments()
+	err = db.MergeUpTo(2, true)
+	assert.Nil(t, err)
+	assert.True(t, segments() < before)
+	assertData(db)
+
+	// write after merge
+	err = db.Put(utils.GetTestKey(3000), []byte("after"))
+	assert.Nil(t, err)
+
+	_ = db.Close()
+	db2, err := Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, 1901, db2.Stat().KeysNum)
+	err = db2.Delete(utils.GetTestKey(3000))
+	assert.Nil(t, err)
+	assertData(db2)
+
+	// merge all the data files
+	err = db2.MergeUpTo(0, true)
+	assert.Nil(t, err)
+	assertData(db2)
+	_ = db2.Close()
+}
+
+func TestDB_MergeSorted(t *testing.T) {
+	options := DefaultOptions
+	options.SegmentSize = 256 * KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// write the keys in random order
+	for _, i := range rand.Perm(2000) {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(KB))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	err = db.MergeSorted(true)
+	assert.Nil(t, err)
+	assert.Equal(t, 1900, db.Stat().KeysNum)
+
+	// the records are in the order of keys
+	var prev *wal.ChunkPosition
+	db.index.Ascend(func(key []byte, pos *wal.ChunkPosition) (bool, error) {
+		if prev != nil {
+			assert.True(t, pos.SegmentId > prev.SegmentId ||
+				(pos.SegmentId == prev.SegmentId && (pos.BlockNumber > prev.BlockNumber ||
+					pos.BlockNumber == prev.BlockNumber && pos.ChunkOffset > prev.ChunkOffset)))
+		}
+		prev = pos
+		return true, nil
+	})
+	for i := 0; i < 2000; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		if i < 100 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+
+	_ = db.Close()
+	db2, err := Open(options)
+	assert.Nil(t, err)
+	assert.Equal(t, 1900, db2.Stat().KeysNum)
+	_ = db2.Close()
+}
+
+func TestDB_MergeEstimate(t *testing.T) {
+	options := DefaultOptions
+	options.SegmentSize = 64 * KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	reclaimable, segments, err := db.MergeEstimate()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), reclaimable)
+	assert.Equal(t, 0, segments)
+
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i%100), utils.RandomValue(KB)))
+	}
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	before := db.Stat().WALSize
+	reclaimable, segments, err = db.MergeEstimate()
+	assert.Nil(t, err)
+	assert.True(t, segments > 1)
+	assert.True(t, reclaimable > before/2)
+
+	// nothing is written by the estimate
+	assert.Equal(t, before, db.Stat().WALSize)
+	assert.Nil(t, db.Merge(true))
+	assert.InDelta(t, float64(before-db.Stat().WALSize), float64(reclaimable), float64(before)*0.05)
+}
+
+func TestDB_Merge_TombstoneRetention(t *testing.T) {
+	// countTombstones returns the number of the delete records in the data files.
+	countTombstones := func(db *DB) int {
+		var count int
+		reader := db.dataFiles.NewReader()
+		for {
+			chunk, _, err := reader.Next()
+			if err != nil {
+				assert.Equal(t, io.EOF, err)
+				return count
+			}
+			if decodeLogRecord(chunk).Type == LogRecordDeleted {
+				count++
+			}
+		}
+	}
+
+	for _, merge := range []func(db *DB) error{
+		func(db *DB) error { return db.Merge(true) },
+		func(db *DB) error { return db.MergeSorted(true) },
+	} {
+		options := DefaultOptions
+		options.SegmentSize = 64 * KB
+		options.TombstoneRetention = time.Hour
+		db, err := Open(options)
+		assert.Nil(t, err)
+
+		for i := 0; i < 200; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+		}
+		for i := 0; i < 50; i++ {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		}
+		// the tombstone of the key written again is dropped
+		assert.Nil(t, db.Put(utils.GetTestKey(10), []byte("value")))
+
+		assert.Nil(t, merge(db))
+		assert.Equal(t, 49, countTombstones(db))
+		assert.Equal(t, 151, db.Stat().KeysNum)
+
+		// the replica resyncing into its current data deletes the keys too
+		followerOptions := DefaultOptions
+		followerOptions.DirPath, err = os.MkdirTemp("", "rosedb-follower")
+		assert.Nil(t, err)
+		follower, err := Open(followerOptions)
+		assert.Nil(t, err)
+		for i := 0; i < 200; i++ {
+			assert.Nil(t, follower.Put(utils.GetTestKey(i), []byte("stale")))
+		}
+		reader := db.dataFiles.NewReader()
+		for {
+			chunk, _, err := reader.Next()
+			if err != nil {
+				break
+			}
+			assert.Nil(t, follower.Apply(chunk))
+		}
+		assert.Equal(t, 151, follower.Stat().KeysNum)
+		assertKeyExistOrNot(t, follower, utils.GetTestKey(0), false)
+		assertKeyExistOrNot(t, follower, utils.GetTestKey(10), true)
+		destroyDB(follower)
+
+		// the tombstones are kept by the next merge, until the retention is disabled
+		assert.Nil(t, db.Delete(utils.GetTestKey(100)))
+		assert.Nil(t, merge(db))
+		assert.Equal(t, 50, countTombstones(db))
+		assert.Nil(t, db.Close())
+		options.TombstoneRetention = 0
+		db, err = Open(options)
+		assert.Nil(t, err)
+		assert.Equal(t, 150, db.Stat().KeysNum)
+		assert.Nil(t, merge(db))
+		assert.Equal(t, 0, countTombstones(db))
+		destroyDB(db)
+	}
+}
+
+func TestDB_Merge_Resume(t *testing.T) {
+	options := DefaultOptions
+	options.SegmentSize = 256 * KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(KB))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("updated"))
+		assert.Nil(t, err)
+	}
+
+	// simulate the merge interrupted in the middle of the last data file,
+	// the merge is not finished, and some data has been written after the last checkpoint.
+	err = db.Merge(false)
+	assert.Nil(t, err)
+	mergePath := mergeDirPath(options.DirPath)
+	err = os.Remove(wal.SegmentFileName(mergePath, mergeFinNameSuffix, 1))
+	assert.Nil(t, err)
+	segIds, err := segmentFileIds(mergePath, dataFileNameSuffix)
+	assert.Nil(t, err)
+	file, err := os.OpenFile(wal.SegmentFileName(mergePath, dataFileNameSuffix, segIds[len(segIds)-1]), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = file.Write(utils.RandomValue(KB))
+	assert.Nil(t, err)
+	_ = file.Close()
+
+	// write after the merge is interrupted
+	for i := 1000; i < 1100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// reopen, the interrupted merge is kept to be resumed
+	_ = db.Close()
+	db2, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	manifest := readMergeManifest(mergePath)
+	assert.NotNil(t, manifest)
+	assert.True(t, manifest.mergedSegmentId > 0)
+	assert.True(t, manifest.mergedSegmentId < manifest.segmentId)
+
+	err = db2.Merge(true)
+	assert.Nil(t, err)
+	_, err = os.Stat(mergePath)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Equal(t, 1900, db2.Stat().KeysNum)
+	for i := 0; i < 2000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		if i < 1000 {
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("updated"), val)
+		} else if i < 1100 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.True(t, len(val) >= KB)
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package rosedb
 
-import "os"
+import (
+	"os"
+	"time"
+
+	"github.com/rosedblabs/wal"
+)
 
 // Options specifies the options for opening a database.
 type Options struct {
@@ -26,11 +31,238 @@ type Options struct {
 	Sync bool
 
 	// BytesPerSync specifies the number of bytes to write before calling fsync.
+	// It only takes effect when Sync is false, the data files and the value log files
+	// are synced in the background after so many bytes are committed, so the commits do not wait for fsync,
+	// except the ones writing to the files while the wal is syncing them,
+	// and at most about BytesPerSync bytes of recent writes may be lost if the machine crashes.
 	BytesPerSync uint32
 
+	// SyncDirOnRotate specifies whether to sync the database directory when the data files are synced
+	// after a new data file or value log file is created, since on some file systems such as ext4 and xfs,
+	// the new file is not durable until its directory is synced, and it can be lost with the records synced to it
+	// if the machine crashes right after the rotation.
+	// It only costs a sync of the directory for each new file, disable it if the file system does not need it.
+	SyncDirOnRotate bool
+
 	// WatchQueueSize the cache length of the watch queue.
 	// if the size greater than 0, which means enable the watch.
 	WatchQueueSize uint64
+
+	// WatchIncludeValue specifies whether the put events of the watch carry the values,
+	// the delete events never carry the values.
+	// If the consumers only need the keys to invalidate, such as the caches,
+	// setting it to false saves the memory of the queued events for the large values.
+	WatchIncludeValue bool
+
+	// MaxKeySize specifies the maximum size of a key in bytes, which bounds the memory of the index.
+	// Writing a key larger than it will return ErrKeyTooLarge before anything is written,
+	// including the keys written by Copy, RenameKey and the data structures.
+	// If MaxKeySize is 0, the key size is not limited.
+	MaxKeySize int
+
+	// MaxValueSize specifies the maximum size of a value in bytes.
+	// Writing a value larger than it will return ErrValueTooLarge.
+	// If MaxValueSize is 0, the value size is not limited.
+	MaxValueSize int64
+
+	// LargeValueThreshold specifies the value size in bytes above which
+	// the value will be stored in the separate value log files instead of the data files,
+	// and the data files only store a pointer to it.
+	// This keeps the data files compact, which makes the index rebuilding and merge faster.
+	// The values will be read from the value log transparently.
+	// If LargeValueThreshold is 0, all values are stored in the data files.
+	LargeValueThreshold int64
+
+	// SeparateValues specifies whether to store all values in the value log,
+	// and the data files only store the keys and the pointers to the values(key-value separation, see WiscKey).
+	//
+	// The index rebuilding on startup only scans the data files, and Merge only rewrites the keys and pointers,
+	// so they are much faster when the values are large.
+	// The space of the stale values in the value log is reclaimed by DB.ValueLogGC separately.
+	SeparateValues bool
+
+	// ValueCodec specifies the codecs transforming the values, such as compression and encryption.
+	// The values are encoded by the codecs in order when written, and decoded in the reverse order when read,
+	// so the compression should be put before the encryption.
+	// At most 4 codecs are supported.
+	//
+	// Each record saves the flags of the codecs applied to its value, so the values written
+	// before the codecs are added are still readable, but the codecs can only be appended,
+	// reading a value encoded by a removed codec returns ErrCodecNotFound.
+	ValueCodec []Codec
+
+	// ChecksumType specifies the algorithm of the checksum appended to each record written to the data files,
+	// which is verified when the record is read, in addition to the crc of the wal chunks.
+	// The record saves its algorithm, so the records written before ChecksumType is changed are still verified,
+	// and the records without the checksum are only verified by the crc of the wal chunks.
+	// A record whose checksum does not match is read as ErrInvalidChecksum.
+	// If ChecksumType is ChecksumNone, no checksum is appended.
+	ChecksumType ChecksumType
+
+	// DefaultTTL specifies the ttl of the values put without a ttl, such as by Put and SetMany,
+	// which makes every key expire by default when the database is used as a cache.
+	// The ttl passed to PutWithTTL takes precedence over it, and the values put with the ttl NoTTL never expire.
+	// The rewrites of the existing keys keep their ttl, such as SetRange and Touch,
+	// and the members of the data structures are not affected.
+	// If DefaultTTL is 0, the values put without a ttl never expire.
+	DefaultTTL time.Duration
+
+	// SlidingExpiration enables the sliding ttl, the ttl of a key read by Get is extended to its own ttl,
+	// so the keys read often stay in the cache without calling Touch.
+	// The own ttl of a key is the time left to its expiry time when it was last written,
+	// like the ttl of PutWithTTL or Expire, and the extension is capped at SlidingExpiration.
+	// The keys without a ttl are not affected. The own ttls are kept in memory, since the record
+	// only saves the expiry time, so the keys not written since Open are not extended until written again.
+	//
+	// It turns the reads into writes: every extension rewrites the whole record of the key,
+	// including its value, which costs the disk space until merge like an overwrite.
+	// To bound the cost, a key is only extended when less than half of its extension is left,
+	// so each key is rewritten at most once in half of its extension, and the extended keys are rewritten
+	// in one batch by a background goroutine every quarter of SlidingExpiration, at most every second.
+	// So the key read right before it expires may still expire, and the extensions not rewritten yet are lost on Close.
+	// If SlidingExpiration is 0, the reads never change the ttl.
+	SlidingExpiration time.Duration
+
+	// Clock provides the current time to compute the expiry time of the keys and check whether they are expired,
+	// so the tests can inject a fake clock and advance it instead of sleeping.
+	// The other times are still the real time, such as the sync time in Stat and the rate limits.
+	// If Clock is nil, the real time is used.
+	Clock Clock
+
+	// MaxTotalSize specifies the maximum total size of all keys in bytes,
+	// which makes the database a size-capped cache with persistence.
+	// The size of a key is the disk size of its record, including the value in the value log.
+	// The hashes, sets, sorted sets and column families are not counted or evicted,
+	// since evicting a part of their records corrupts them.
+	//
+	// When a commit makes the total size exceed it, the least recently used keys will be evicted(deleted).
+	// Reading or writing a key marks it as recently used.
+	// The eviction runs after the batch is committed, so its failure does not fail the commit,
+	// it is reported to OnBackgroundError with BackgroundTaskEvict instead.
+	// If MaxTotalSize is 0, no keys will be evicted.
+	MaxTotalSize int64
+
+	// OnEvict is called with the key evicted because of MaxTotalSize.
+	// It is called after the database lock is released.
+	OnEvict func(key []byte)
+
+	// TrackAccess specifies whether to record the last access time and the access count of keys,
+	// see DB.AccessStats, DB.LeastRecentlyUsed and DB.LeastFrequentlyUsed.
+	// The stats are only kept in memory, and it costs extra memory for each key.
+	TrackAccess bool
+
+	// MergeRateLimit specifies the maximum bytes per second that Merge reads and writes,
+	// so the foreground operations will not be slowed down too much by the disk IO of merge.
+	// If MergeRateLimit is 0, the merge is not throttled.
+	MergeRateLimit int64
+
+	// AutoMergeThreshold specifies the garbage ratio of the data files to merge them automatically,
+	// the garbage is the overwritten, deleted and expired records, which can be reclaimed by merge.
+	// The garbage is tracked incrementally by the writes, and the ratio is reported by Stat.
+	// When the ratio reaches AutoMergeThreshold, the data files are merged and reopened in the background.
+	// It must be in [0, 1), if AutoMergeThreshold is 0, the data files are never merged automatically.
+	AutoMergeThreshold float64
+
+	// AutoMergeCooldown specifies the minimum interval between the automatic merges,
+	// so the database will not keep merging under the heavy overwrites, see AutoMergeThreshold.
+	AutoMergeCooldown time.Duration
+
+	// TombstoneRetention specifies how long the delete records are kept by merge after the keys are deleted,
+	// so the deletes are still replicated to the followers which resync from the beginning after merge,
+	// see ReplicationStream, the deleted keys which are written again are not kept.
+	// The retention is recorded in each delete record when it is written, changing it only affects the later deletes.
+	//
+	// A follower lagging behind the merged data files must resync from the beginning,
+	// and if it lags more than TombstoneRetention, the deletes may have been dropped,
+	// so it must be rebuilt from an empty database instead of resyncing into its current data.
+	// If TombstoneRetention is 0, all the delete records are dropped by the next merge.
+	TombstoneRetention time.Duration
+
+	// MaxWALSize specifies the maximum size of the data files in bytes,
+	// so the disk will not be filled up if merge can not keep up with the writes.
+	// When the size of the data files exceeds it, the writes are stalled until merge reclaims the space,
+	// see WriteStallTimeout, the current size is reported by Stat.
+	// The batches only deleting keys are not stalled, so the space can still be freed.
+	// If MaxWALSize is 0, the writes are never stalled.
+	MaxWALSize int64
+
+	// WriteStallTimeout specifies how long a new write batch waits for the stalled writes, see MaxWALSize.
+	// If the data files still exceed MaxWALSize after waiting, committing the batch returns ErrWriteStall.
+	// If WriteStallTimeout is 0, the batch does not wait, and ErrWriteStall is returned immediately.
+	WriteStallTimeout time.Duration
+
+	// WarmupRateLimit specifies the maximum bytes per second that Warmup reads,
+	// so the warmup will not saturate the disk IO when the database is serving.
+	// If WarmupRateLimit is 0, the warmup is not throttled.
+	WarmupRateLimit int64
+
+	// RecoveryConcurrency specifies the number of goroutines reading the data files
+	// to rebuild the index when opening the database, which makes the startup faster
+	// for a large database with many data files.
+	// If RecoveryConcurrency is 0 or 1, the data files are read one by one.
+	RecoveryConcurrency int
+
+	// RecoveryProgress is called periodically with the bytes of the data files read and the total bytes to read,
+	// when the index is rebuilt from the data files by Open, so the application can show the progress
+	// of a long startup. It is called about every 4MB read, and once more when all the data files are read.
+	// The data files loaded from the hint file or the index snapshot are not counted.
+	RecoveryProgress func(bytesRead, bytesTotal int64)
+
+	// RecoveryTransform is called with each record of a value replayed by Open before it is indexed,
+	// so the values can be migrated in one pass at startup without a separate tool.
+	// It returns the record to keep, whose key, value and expiration time may be changed, or nil to drop it.
+	// The record holds the decoded value, and its Type is always LogRecordNormal.
+	//
+	// The changes of the latest records of the keys are written back by a batch when the index is rebuilt,
+	// the old key is deleted if the key is changed, and the key is deleted if the record is dropped.
+	// It is called for the stale records of the keys too, whose changes are discarded.
+	// The records merged into the hint file are read by their positions instead of replaying the merged data files,
+	// and the index snapshot is not used when it is set, since its records are not read.
+	//
+	// It runs during the recovery of Open only, the records written later are not transformed,
+	// but it is called again at the next Open, so it must be idempotent, such as checking the version of the value.
+	RecoveryTransform func(record *LogRecord) (*LogRecord, error)
+
+	// WALBackend opens the write-ahead log of the data files with the options of rosedblabs/wal,
+	// so an alternative implementation of WAL can be plugged in, such as a writer based on io_uring,
+	// or a fake log in memory for testing. If it is nil, the wal of rosedblabs/wal is used.
+	//
+	// The records are read and written through WAL, including the recovery and the merge.
+	// But the merged data files and the vacuumed ones replace the segment files in the directory,
+	// and RecoveryConcurrency reads the sizes of the segment files directly,
+	// so they require the backend to keep the segment files in the directory in the format of rosedblabs/wal.
+	WALBackend func(options wal.Options) (WAL, error)
+
+	// PersistIndex specifies whether to save the index to the index snapshot file when closing,
+	// and load it when opening, so only the data files written after closing need to be replayed,
+	// which makes the startup much faster for a large database.
+	// The active data file is rotated when closing, and the snapshot will be ignored
+	// if the data files are merged after it is saved.
+	PersistIndex bool
+
+	// KeyHasher specifies the function hashing the keys to the fixed-size hashes stored in the index
+	// instead of the keys, which cuts the memory of the index for the long keys, see index.NewHashIndexer.
+	// The full keys are only kept in the records, and the keys with the same hash are verified by reading the records,
+	// so a hash of 8 bytes or more, like FNV-1a 64, is enough to keep the extra reads rare.
+	//
+	// Each iteration of the index reads the keys of all the records and sorts them, including the data structures,
+	// the prefix scans and each page of KeysChan and Iterator, so it should only be used
+	// when the keys are mostly read and written one by one.
+	// If KeyHasher is nil, the index stores the keys.
+	KeyHasher func(key []byte) []byte
+
+	// NodeID is the node id of the snowflake generating the batch ids, it must be in [0, 1023].
+	// The batch ids are unique only if each process writing to the shared storage,
+	// or replicating to the same database, has a different NodeID, it is not checked by the database.
+	NodeID int64
+
+	// OnBackgroundError is called when a background task fails, with the name of the task and the error,
+	// so the application can alert, retry or shut down the database, see the BackgroundTask constants.
+	// A panic in a background task is recovered and passed to it as an error, and the task stops then.
+	// It is called in the goroutine of the task without the database locked, so it can call the database,
+	// except Close, which waits for the task to exit.
+	// If OnBackgroundError is nil, the errors are ignored, and the panics crash the process.
+	OnBackgroundError func(task string, err error)
 }
 
 // BatchOptions specifies the options for creating a batch.
@@ -39,9 +271,79 @@ type BatchOptions struct {
 	Sync bool
 	// ReadOnly specifies whether the batch is read only.
 	ReadOnly bool
+	// ReadCommitted specifies whether the read only batch reads the latest committed data.
+	//
+	// By default, a read only batch holds the read lock of the database until it is committed,
+	// so all reads see the same data, but the writers are blocked during the lifetime of the batch.
+	// If ReadCommitted is true, the batch only holds the read lock during each read operation,
+	// so it will not block the writers, and each read sees the data committed before it,
+	// two reads of the same key may return different values.
+	//
+	// It only takes effect when ReadOnly is true.
+	ReadCommitted bool
+	// Concurrent specifies whether the batch for writing holds the lock of the database only when committing.
+	//
+	// By default, a batch for writing holds the write lock of the database until it is committed,
+	// so the batches are serialized, and the other writers and readers are blocked during the lifetime of the batch.
+	// If Concurrent is true, the batch only holds the read lock during each read operation like ReadCommitted,
+	// and the write lock during Commit, so the batches writing different keys can be built in parallel.
+	// Commit returns ErrConflict if any key read or written by the batch has been written by another batch
+	// committed since the batch was created, and the first committed batch wins.
+	// The caller can run the batch again with a new one when ErrConflict is returned.
+	//
+	// Only the point reads by Get, Exist and the like are validated, the ranges visited by Ascend are not,
+	// so a key inserted into the range by another batch (a phantom) is not detected as a conflict.
+	// The keys evicted by Options.MaxTotalSize are regarded as written.
+	//
+	// The database records the last write of each key while any concurrent batch is running,
+	// so the batch must be finished by Commit, Rollback or Discard, otherwise the records grow forever.
+	// It only takes effect when ReadOnly is false.
+	Concurrent bool
+	// OnBeforeCommit is called before the batch writes any data when committing,
+	// if it returns an error, the commit will be aborted and the error will be returned by Commit.
+	// It is called with the database locked, so it should be fast.
+	OnBeforeCommit func() error
+	// CommitRetries specifies how many times Commit retries writing the records
+	// if it fails because of the transient errors, such as running out of the disk space temporarily.
+	// The errors considered transient are ENOSPC, EDQUOT, EIO, EAGAIN and EINTR,
+	// the other errors fail the commit immediately.
+	//
+	// Each retry writes all the records again to the new active data files with a new batch id,
+	// the records written by the failed attempts are never visible, and will be reclaimed by merge.
+	// The database is locked during the retries, so the other writers are blocked.
+	CommitRetries int
+	// CommitRetryBackoff is the time to wait before the first retry, see CommitRetries,
+	// and the time doubles for each next retry.
+	CommitRetryBackoff time.Duration
+	// OnCommit is called with all the applied writes after the batch is committed successfully.
+	// The data is durable if Sync is true.
+	// It is called after the database lock is released, so it will not block other writers.
+	OnCommit func(committed []KV)
+	// SkipRedundantWrites specifies whether to omit the puts which would not change anything when committing,
+	// that is, the value and the expiration time are the same as the committed ones,
+	// so the idempotent rewrites will not grow the data files.
+	// The omitted puts are not passed to OnCommit, and no watch events are sent for them.
+	//
+	// Each put has to read the committed value to compare, so it is disabled by default.
+	SkipRedundantWrites bool
+	// DefaultTTL is the ttl of the values put to the batch without a ttl, it overrides Options.DefaultTTL,
+	// so the keys staged together expire together without calling PutWithTTL for each of them.
+	// The values put by PutWithTTL keep their own ttl, and the expiration time is counted from when the value is put.
+	// If it is 0, Options.DefaultTTL is used.
+	DefaultTTL time.Duration
+	// OrderedCommit specifies whether to write the records of the batch in the order of the keys when committing,
+	// so the order of the records is deterministic for the same writes, which makes the data files easier to diff
+	// and test, and the followers replicating the records, the index updates, the watch events and OnCommit
+	// see the same order. The data files still differ in the batch ids, which are unique for each batch.
+	//
+	// By default, the records are written in a random order, since the pending writes are kept in a map,
+	// but the order never matters to the database, because a batch is applied atomically.
+	// Sorting costs O(n log n) comparisons of the keys, it is a few milliseconds for a batch of 100k keys,
+	// about 1% of the time committing the batch, see BenchmarkCommit in the benchmark package.
+	OrderedCommit bool
 }
 
-// IteratorOptions is the options for the iterator.
+// IteratorOptions is the options for the iterator, see DB.NewIterator.
 type IteratorOptions struct {
 	// Prefix filters the keys by prefix.
 	Prefix []byte
@@ -49,8 +351,27 @@ type IteratorOptions struct {
 	// Reverse indicates whether the iterator is reversed.
 	// false is forward, true is backward.
 	Reverse bool
+
+	// KeyOnly specifies whether to iterate the keys only, Iterator.Value returns nil.
+	// The records are not read from the data files, so the iteration only costs the memory of the index,
+	// but the expired keys are not skipped unless SkipExpired is true.
+	KeyOnly bool
+
+	// SkipExpired specifies whether to skip the expired keys when KeyOnly is true,
+	// which reads the record of each key to check its expiry time, but the value is not decoded
+	// or loaded from the value log. The expired keys are always skipped if KeyOnly is false.
+	SkipExpired bool
+
+	// Limit specifies the maximum number of the keys iterated after Rewind or Seek,
+	// the iteration stops early without reading the remaining records.
+	// If Limit is 0, the number is not limited.
+	Limit int
 }
 
+// NoTTL is the ttl of the values which never expire, even if Options.DefaultTTL is set,
+// it is also the ttl returned by TTL for the key without ttl.
+const NoTTL time.Duration = -1
+
 const (
 	B  = 1
 	KB = 1024 * B
@@ -59,17 +380,52 @@ const (
 )
 
 var DefaultOptions = Options{
-	DirPath:        tempDBDir(),
-	SegmentSize:    1 * GB,
-	BlockCache:     0,
-	Sync:           false,
-	BytesPerSync:   0,
-	WatchQueueSize: 0,
+	DirPath:             tempDBDir(),
+	SegmentSize:         1 * GB,
+	BlockCache:          0,
+	Sync:                false,
+	BytesPerSync:        0,
+	SyncDirOnRotate:     true,
+	WatchQueueSize:      0,
+	WatchIncludeValue:   true,
+	MaxKeySize:          0,
+	MaxValueSize:        0,
+	LargeValueThreshold: 0,
+	SeparateValues:      false,
+	ValueCodec:          nil,
+	ChecksumType:        ChecksumNone,
+	DefaultTTL:          0,
+	SlidingExpiration:   0,
+	Clock:               nil,
+	MaxTotalSize:        0,
+	OnEvict:             nil,
+	TrackAccess:         false,
+	MergeRateLimit:      0,
+	AutoMergeThreshold:  0,
+	AutoMergeCooldown:   time.Minute,
+	TombstoneRetention:  0,
+	MaxWALSize:          0,
+	WriteStallTimeout:   0,
+	WarmupRateLimit:     0,
+	RecoveryConcurrency: 0,
+	RecoveryProgress:    nil,
+	RecoveryTransform:   nil,
+	WALBackend:          nil,
+	PersistIndex:        false,
+	KeyHasher:           nil,
+	NodeID:              1,
+	OnBackgroundError:   nil,
 }
 
 var DefaultBatchOptions = BatchOptions{
-	Sync:     true,
-	ReadOnly: false,
+	Sync:                true,
+	ReadOnly:            false,
+	ReadCommitted:       false,
+	Concurrent:          false,
+	CommitRetries:       0,
+	CommitRetryBackoff:  10 * time.Millisecond,
+	SkipRedundantWrites: false,
+	OrderedCommit:       false,
 }
 
 func tempDBDir() string {
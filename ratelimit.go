@@ -0,0 +1,52 @@
+package rosedb
+
+import (
+	"context"
+	"time"
+)
+
+// rateLimiter is a token bucket limiting the bytes processed per second,
+// it is used to throttle the merge and warmup, see Options.MergeRateLimit and Options.WarmupRateLimit.
+// It is not safe for concurrent use.
+type rateLimiter struct {
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a rate limiter allowing rate bytes per second,
+// the burst is one second of tokens. It returns nil if rate is 0, which means unlimited.
+func newRateLimiter(rate int64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// wait blocks until n bytes are allowed to be processed, or ctx is done.
+// n can be larger than the burst, the exceeded tokens will be paid by waiting.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(-l.tokens / l.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -2,6 +2,7 @@ package rosedb
 
 import (
 	"encoding/binary"
+	"sync"
 
 	"github.com/rosedblabs/wal"
 )
@@ -16,6 +17,9 @@ const (
 	LogRecordDeleted
 	// LogRecordBatchFinished is the batch finished log record type.
 	LogRecordBatchFinished
+	// LogRecordValuePointer is the normal log record type whose value is stored in the value log,
+	// the value of the record is the position of the real value in the value log.
+	LogRecordValuePointer
 )
 
 // type batchId keySize valueSize expire
@@ -32,6 +36,14 @@ type LogRecord struct {
 	Type    LogRecordType
 	BatchId uint64
 	Expire  int64
+	// codecs is the flags of the codecs applied to the value, see Options.ValueCodec.
+	codecs byte
+}
+
+// IsValue checks whether the log record holds a value of the key,
+// either in itself or in the value log.
+func (lr *LogRecord) IsValue() bool {
+	return lr.Type == LogRecordNormal || lr.Type == LogRecordValuePointer
 }
 
 // IsExpired checks whether the log record is expired.
@@ -48,69 +60,127 @@ type IndexRecord struct {
 	position   *wal.ChunkPosition
 }
 
+// The higher 4 bits of the type byte are the flags of the codecs applied to the value, see Options.ValueCodec.
+//
 // +-------------+-------------+-------------+--------------+---------------+---------+--------------+
 // |    type     |  batch id   |   key size  |   value size |     expire    |  key    |      value   |
 // +-------------+-------------+-------------+--------------+---------------+--------+--------------+
 //
 //	1 byte	      varint(max 10) varint(max 5)  varint(max 5) varint(max 10)  varint      varint
 func encodeLogRecord(logRecord *LogRecord) []byte {
-	header := make([]byte, maxLogRecordHeaderSize)
-
-	header[0] = logRecord.Type
-	var index = 1
+	return appendLogRecord(make([]byte, 0, maxLogRecordHeaderSize+len(logRecord.Key)+len(logRecord.Value)), logRecord)
+}
 
+// appendLogRecord appends the encoded log record to buf and returns the extended buffer,
+// see encodeLogRecord for the format.
+func appendLogRecord(buf []byte, logRecord *LogRecord) []byte {
+	buf = append(buf, logRecord.Type|logRecord.codecs<<codecFlagsShift)
 	// batch id
-	index += binary.PutUvarint(header[index:], logRecord.BatchId)
+	buf = binary.AppendUvarint(buf, logRecord.BatchId)
 	// key size
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
+	buf = binary.AppendVarint(buf, int64(len(logRecord.Key)))
 	// value size
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
+	buf = binary.AppendVarint(buf, int64(len(logRecord.Value)))
 	// expire
-	index += binary.PutVarint(header[index:], logRecord.Expire)
+	buf = binary.AppendVarint(buf, logRecord.Expire)
+	// key and value
+	buf = append(buf, logRecord.Key...)
+	return append(buf, logRecord.Value...)
+}
 
-	var size = index + len(logRecord.Key) + len(logRecord.Value)
-	encBytes := make([]byte, size)
+// maxPooledEncodeBufferSize is the max capacity of the buffers kept in encodeBufferPool,
+// the larger buffers are dropped so that a few large values do not pin the memory.
+const maxPooledEncodeBufferSize = 1 << 20
 
-	// copy header
-	copy(encBytes[:index], header[:index])
-	// copy key
-	copy(encBytes[index:], logRecord.Key)
-	// copy value
-	copy(encBytes[index+len(logRecord.Key):], logRecord.Value)
+// encodeBufferPool holds the buffers of the encoded log records on the write path.
+var encodeBufferPool = sync.Pool{New: func() any { return new([]byte) }}
 
-	return encBytes
+// encodeLogRecordPooled encodes the log record to a buffer taken from encodeBufferPool.
+// The encoded record is only valid until the buffer is put back by putEncodeBuffer,
+// which must be done after the record is written to the data files, since WAL.Write copies the data.
+func encodeLogRecordPooled(logRecord *LogRecord) (*[]byte, []byte) {
+	buf := encodeBufferPool.Get().(*[]byte)
+	*buf = appendLogRecord((*buf)[:0], logRecord)
+	return buf, *buf
 }
 
-// decodeLogRecord decodes the log record from the given byte slice.
-func decodeLogRecord(buf []byte) *LogRecord {
-	recordType := buf[0]
+// putEncodeBuffer puts the buffer returned by encodeLogRecordPooled back to encodeBufferPool.
+func putEncodeBuffer(buf *[]byte) {
+	if cap(*buf) > maxPooledEncodeBufferSize {
+		return
+	}
+	encodeBufferPool.Put(buf)
+}
+
+// logRecordHeader is the decoded header of the log record, see encodeLogRecord.
+type logRecordHeader struct {
+	recordType LogRecordType
+	codecs     byte
+	batchId    uint64
+	keySize    int64
+	valueSize  int64
+	expire     int64
+	// size is the length of the encoded header, the key starts here.
+	size uint32
+}
+
+// isExpired checks whether the log record of the header is expired.
+func (h *logRecordHeader) isExpired(now int64) bool {
+	return h.expire > 0 && h.expire <= now
+}
+
+// key returns the key of the log record in buf without copying, buf must be the record of the header.
+func (h *logRecordHeader) key(buf []byte) []byte {
+	return buf[h.size : h.size+uint32(h.keySize)]
+}
+
+// value returns the value of the log record in buf without copying, buf must be the record of the header.
+// The offset of the value in the record is the header size plus the key size,
+// so a part of the value can be read without decoding the whole record.
+func (h *logRecordHeader) value(buf []byte) []byte {
+	start := h.size + uint32(h.keySize)
+	return buf[start : start+uint32(h.valueSize)]
+}
+
+// decodeLogRecordHeader decodes only the header of the log record from the given byte slice,
+// so the type and the expire of the record can be checked without copying the key and value.
+func decodeLogRecordHeader(buf []byte) *logRecordHeader {
+	header := &logRecordHeader{recordType: buf[0] & recordTypeMask, codecs: buf[0] >> codecFlagsShift}
 
 	var index uint32 = 1
 	// batch id
 	batchId, n := binary.Uvarint(buf[index:])
+	header.batchId = batchId
 	index += uint32(n)
 
 	// key size
-	keySize, n := binary.Varint(buf[index:])
+	header.keySize, n = binary.Varint(buf[index:])
 	index += uint32(n)
 
 	// value size
-	valueSize, n := binary.Varint(buf[index:])
+	header.valueSize, n = binary.Varint(buf[index:])
 	index += uint32(n)
 
 	// expire
-	expire, n := binary.Varint(buf[index:])
+	header.expire, n = binary.Varint(buf[index:])
 	index += uint32(n)
 
+	header.size = index
+	return header
+}
+
+// decodeLogRecord decodes the log record from the given byte slice.
+func decodeLogRecord(buf []byte) *LogRecord {
+	header := decodeLogRecordHeader(buf)
+
 	// copy key
-	key := make([]byte, keySize)
-	copy(key[:], buf[index:index+uint32(keySize)])
-	index += uint32(keySize)
+	key := make([]byte, header.keySize)
+	copy(key, header.key(buf))
 
 	// copy value
-	value := make([]byte, valueSize)
-	copy(value[:], buf[index:index+uint32(valueSize)])
+	value := make([]byte, header.valueSize)
+	copy(value, header.value(buf))
 
-	return &LogRecord{Key: key, Value: value, Expire: expire,
-		BatchId: batchId, Type: recordType}
+	return &LogRecord{Key: key, Value: value, Expire: header.expire,
+		BatchId: header.batchId, Type: header.recordType, codecs: header.codecs}
 }
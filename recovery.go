@@ -0,0 +1,262 @@
+package rosedb
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/rosedblabs/wal"
+)
+
+// recoveryProgressInterval is the number of bytes read between two calls of Options.RecoveryProgress,
+// so reporting the progress will not slow down the index rebuilding.
+const recoveryProgressInterval = 4 * MB
+
+// recoveryProgress reports the progress of the index rebuilding to Options.RecoveryProgress.
+// The methods of a nil recoveryProgress do nothing.
+type recoveryProgress struct {
+	report   func(bytesRead, bytesTotal int64)
+	read     int64
+	total    int64
+	reported int64
+}
+
+// newRecoveryProgress returns a recoveryProgress of the data files to replay,
+// whose ids are greater than skipSegmentId, it returns nil if Options.RecoveryProgress is not set.
+func (db *DB) newRecoveryProgress(skipSegmentId wal.SegmentID) (*recoveryProgress, error) {
+	if db.options.RecoveryProgress == nil {
+		return nil, nil
+	}
+	segIds, err := segmentFileIds(db.options.DirPath, dataFileNameSuffix)
+	if err != nil {
+		return nil, err
+	}
+	progress := &recoveryProgress{report: db.options.RecoveryProgress}
+	for _, segId := range segIds {
+		if segId <= skipSegmentId {
+			continue
+		}
+		info, err := os.Stat(wal.SegmentFileName(db.options.DirPath, dataFileNameSuffix, segId))
+		if err != nil {
+			return nil, err
+		}
+		progress.total += info.Size()
+	}
+	return progress, nil
+}
+
+// add adds the size of the chunk read, the progress is reported every recoveryProgressInterval bytes.
+func (p *recoveryProgress) add(position *wal.ChunkPosition) {
+	if p == nil {
+		return
+	}
+	// the padding of the blocks is not counted, so the bytes read may be less than the total.
+	p.read += int64(position.ChunkSize)
+	if p.read > p.total {
+		p.read = p.total
+	}
+	if p.read-p.reported >= recoveryProgressInterval {
+		p.reported = p.read
+		p.report(p.read, p.total)
+	}
+}
+
+// done reports all the data files are read.
+func (p *recoveryProgress) done() {
+	if p == nil {
+		return
+	}
+	p.report(p.total, p.total)
+}
+
+// segmentRecords is the records read from a segment file by loadIndexFromWALConcurrently.
+type segmentRecords struct {
+	records   []*LogRecord
+	positions []*wal.ChunkPosition
+	err       error
+}
+
+// loadIndexFromWALConcurrently is like loadIndexFromWAL, but the segment files
+// are read and decoded by Options.RecoveryConcurrency goroutines.
+//
+// The records are still indexed in the order of the segment files,
+// so the latest write of each key wins, and the records of a batch across
+// segment files are indexed after the batch finished record is read.
+// At most RecoveryConcurrency segment files are read ahead, to limit the memory usage.
+func (db *DB) loadIndexFromWALConcurrently(skipSegmentId wal.SegmentID, progress *recoveryProgress) error {
+	segIds, err := segmentFileIds(db.options.DirPath, dataFileNameSuffix)
+	if err != nil {
+		return err
+	}
+	// the merged segment files are loaded from the hint file or the index snapshot
+	for len(segIds) > 0 && segIds[0] <= skipSegmentId {
+		segIds = segIds[1:]
+	}
+
+	results := make([]chan *segmentRecords, len(segIds))
+	for i := range results {
+		results[i] = make(chan *segmentRecords, 1)
+	}
+	tokens := make(chan struct{}, db.options.RecoveryConcurrency)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i, segId := range segIds {
+			select {
+			case tokens <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func(i int, segId wal.SegmentID) {
+				results[i] <- db.readSegmentRecords(segId)
+			}(i, segId)
+		}
+	}()
+
+	indexRecords := make(map[uint64][]*IndexRecord)
+	now := db.now().UnixNano()
+	for i := range segIds {
+		result := <-results[i]
+		// allow reading the next segment file
+		<-tokens
+		if result.err != nil {
+			return result.err
+		}
+		for j, record := range result.records {
+			if err = db.transformRecord(record, result.positions[j], now); err != nil {
+				return err
+			}
+			if err = db.indexLogRecord(record, result.positions[j], indexRecords, now); err != nil {
+				return err
+			}
+			progress.add(result.positions[j])
+		}
+	}
+	progress.done()
+	return nil
+}
+
+// readSegmentRecords reads all the records in the segment file,
+// the values are discarded since they are not needed by the index.
+func (db *DB) readSegmentRecords(segId wal.SegmentID) *segmentRecords {
+	result := &segmentRecords{}
+	reader := db.dataFiles.NewReaderWithMax(segId)
+	for reader.CurrentSegmentId() < segId {
+		reader.SkipCurrentSegment()
+	}
+	for {
+		chunk, position, err := reader.Next()
+		if err != nil {
+			if err != io.EOF {
+				result.err = err
+			}
+			return result
+		}
+		record := decodeLogRecord(chunk)
+		// the value is needed by Options.RecoveryTransform
+		if db.recoveryRewrites == nil {
+			record.Value = nil
+		}
+		result.records = append(result.records, record)
+		result.positions = append(result.positions, position)
+	}
+}
+
+// recoveryRewrite is a record changed by Options.RecoveryTransform, which is written back
+// if the transformed record at position is still the latest record of the key when the index is rebuilt.
+type recoveryRewrite struct {
+	position *wal.ChunkPosition
+	record   *LogRecord // nil if the record is dropped
+}
+
+// transformRecord calls Options.RecoveryTransform with the record of a value replayed from position
+// before it is indexed, and keeps the change until the index is rebuilt, see applyRecoveryRewrites.
+func (db *DB) transformRecord(record *LogRecord, position *wal.ChunkPosition, now int64) error {
+	if db.recoveryRewrites == nil || !record.IsValue() || record.IsExpired(now) {
+		return nil
+	}
+	value, err := db.loadValue(record)
+	if err != nil {
+		return err
+	}
+	// the record passed to the transform can be modified in place
+	transformed, err := db.options.RecoveryTransform(&LogRecord{
+		Key:     append([]byte(nil), record.Key...),
+		Value:   append([]byte(nil), value...),
+		Type:    LogRecordNormal,
+		BatchId: record.BatchId,
+		Expire:  record.Expire,
+	})
+	if err != nil {
+		return err
+	}
+	key := string(record.Key)
+	if transformed != nil && bytes.Equal(transformed.Key, record.Key) &&
+		bytes.Equal(transformed.Value, value) && transformed.Expire == record.Expire {
+		delete(db.recoveryRewrites, key)
+		return nil
+	}
+	if transformed != nil && len(transformed.Key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	db.recoveryRewrites[key] = &recoveryRewrite{position: position, record: transformed}
+	return nil
+}
+
+// applyRecoveryRewrites writes the records changed by Options.RecoveryTransform back by a batch,
+// the changes of the stale records are discarded.
+func (db *DB) applyRecoveryRewrites() error {
+	rewrites := db.recoveryRewrites
+	db.recoveryRewrites = nil
+	if len(rewrites) == 0 {
+		return nil
+	}
+
+	batch := db.NewBatch(DefaultBatchOptions)
+	var records []*LogRecord
+	for key, rewrite := range rewrites {
+		position := db.index.Get([]byte(key))
+		if position == nil || !positionEquals(position, rewrite.position) {
+			continue
+		}
+		if rewrite.record == nil || !bytes.Equal(rewrite.record.Key, []byte(key)) {
+			batch.pendingWrites[key] = &LogRecord{Key: []byte(key), Type: LogRecordDeleted}
+		}
+		if rewrite.record != nil {
+			records = append(records, rewrite.record)
+		}
+	}
+	// the records renamed to the keys deleted above are written
+	for _, record := range records {
+		batch.pendingWrites[string(record.Key)] = &LogRecord{
+			Key:    record.Key,
+			Value:  record.Value,
+			Type:   LogRecordNormal,
+			Expire: record.Expire,
+		}
+	}
+	return batch.Commit()
+}
+
+// segmentFileIds returns the ids of the segment files with the extension in the directory in order.
+func segmentFileIds(dirPath, ext string) ([]wal.SegmentID, error) {
+	files, err := filepath.Glob(filepath.Join(dirPath, "*"+ext))
+	if err != nil {
+		return nil, err
+	}
+	segIds := make([]wal.SegmentID, 0, len(files))
+	for _, file := range files {
+		var segId wal.SegmentID
+		if _, err = fmt.Sscanf(filepath.Base(file), "%d"+ext, &segId); err != nil {
+			return nil, err
+		}
+		segIds = append(segIds, segId)
+	}
+	sort.Slice(segIds, func(i, j int) bool {
+		return segIds[i] < segIds[j]
+	})
+	return segIds, nil
+}
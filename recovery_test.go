@@ -0,0 +1,157 @@
+package rosedb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Open_RecoveryConcurrency(t *testing.T) {
+	options := DefaultOptions
+	options.SegmentSize = 64 * KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+	}
+	// the merged data files are loaded from the hint file
+	assert.Nil(t, db.Merge(true))
+	for i := 1000; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+	}
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("updated")))
+	}
+	for i := 500; i < 600; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	// the batch across several segment files
+	batch := db.NewBatch(DefaultBatchOptions)
+	for i := 2000; i < 2200; i++ {
+		assert.Nil(t, batch.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+	}
+	assert.Nil(t, batch.Commit())
+	// the uncommitted batch is discarded
+	for i := 3000; i < 3010; i++ {
+		record := &LogRecord{Key: utils.GetTestKey(i), Value: []byte("v"), Type: LogRecordNormal, BatchId: 1}
+		_, err := db.dataFiles.Write(encodeLogRecord(record))
+		assert.Nil(t, err)
+	}
+	_ = db.Close()
+
+	for _, concurrency := range []int{0, 4} {
+		options.RecoveryConcurrency = concurrency
+		db, err = Open(options)
+		assert.Nil(t, err)
+		assert.Equal(t, 2100, db.Stat().KeysNum)
+		for i := 0; i < 3010; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			switch {
+			case i < 500:
+				assert.Equal(t, []byte("updated"), val)
+			case i < 600 || i >= 2200:
+				assert.Equal(t, ErrKeyNotFound, err)
+			default:
+				assert.Nil(t, err)
+			}
+		}
+		_ = db.Close()
+	}
+}
+
+func TestDB_Open_RecoveryProgress(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 10000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+	}
+	_ = db.Close()
+
+	for _, concurrency := range []int{0, 4} {
+		var calls int
+		var lastRead, lastTotal int64
+		options.RecoveryConcurrency = concurrency
+		options.RecoveryProgress = func(bytesRead, bytesTotal int64) {
+			calls++
+			assert.True(t, bytesRead > lastRead && bytesRead <= bytesTotal)
+			lastRead, lastTotal = bytesRead, bytesTotal
+		}
+		db, err = Open(options)
+		assert.Nil(t, err)
+		assert.Equal(t, 10000, db.Stat().KeysNum)
+		// about 10MB is read
+		assert.True(t, calls > 1 && calls < 10)
+		assert.Equal(t, lastTotal, lastRead)
+		assert.True(t, lastTotal > 10*MB)
+		_ = db.Close()
+	}
+}
+
+func TestDB_Open_RecoveryTransform(t *testing.T) {
+	options := DefaultOptions
+	options.SegmentSize = 64 * KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("v1")))
+	}
+	// the merged records are transformed too
+	assert.Nil(t, db.Merge(true))
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("v1-updated")))
+	}
+	_ = db.Close()
+
+	for _, concurrency := range []int{0, 4} {
+		var calls int
+		options.RecoveryConcurrency = concurrency
+		options.RecoveryTransform = func(record *LogRecord) (*LogRecord, error) {
+			calls++
+			switch {
+			case bytes.HasPrefix(record.Value, []byte("v2")), bytes.Equal(record.Key, []byte("renamed")):
+				// already migrated
+			case bytes.Equal(record.Key, utils.GetTestKey(0)):
+				return nil, nil
+			case bytes.Equal(record.Key, utils.GetTestKey(1)):
+				record.Key = []byte("renamed")
+			default:
+				record.Value = append([]byte("v2"), record.Value[2:]...)
+			}
+			return record, nil
+		}
+		db, err = Open(options)
+		assert.Nil(t, err)
+
+		assert.Equal(t, 99, db.Stat().KeysNum)
+		_, err = db.Get(utils.GetTestKey(0))
+		assert.Equal(t, ErrKeyNotFound, err)
+		_, err = db.Get(utils.GetTestKey(1))
+		assert.Equal(t, ErrKeyNotFound, err)
+		val, err := db.Get([]byte("renamed"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v1-updated"), val)
+		for i := 2; i < 100; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			if i < 50 {
+				assert.Equal(t, []byte("v2-updated"), val)
+			} else {
+				assert.Equal(t, []byte("v2"), val)
+			}
+		}
+		_ = db.Close()
+		// the stale records are transformed at the first open
+		if concurrency == 0 {
+			assert.Equal(t, 150, calls)
+		}
+	}
+}
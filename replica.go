@@ -0,0 +1,373 @@
+package rosedb
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rosedblabs/wal"
+)
+
+// replicaRebuildRetries is the max number of rebuilding the index of the replica
+// when the primary merges the data files during the rebuild.
+const replicaRebuildRetries = 3
+
+// Replica is a read only view of a database directory written by another process, the primary,
+// on the shared file system. It follows the primary by reading the records appended to the data files
+// since the last refresh into its index, without the replication of ReplicationStream.
+//
+// The reads only see the records refreshed, by Refresh or periodically, and the uncommitted batches
+// of the primary are never seen, like recovering the database after a crash.
+// When the primary merges the data files, the index is rebuilt from the merged files,
+// and the reads are served by the previous index until the rebuild finishes.
+type Replica struct {
+	mu        sync.RWMutex // guards state, it is replaced when the index is rebuilt, the reads hold it till done
+	state     *replicaState
+	refreshMu sync.Mutex // serialize Refresh
+	closed    bool
+	closeCh   chan struct{}
+	wg        sync.WaitGroup
+}
+
+// replicaState is the index of the replica and the position to read the following records from.
+// The db is only used to read, its data files are opened again by each refresh, see tail.
+type replicaState struct {
+	db            *DB
+	pos           *wal.ChunkPosition        // the position of the next record to read
+	indexRecords  map[uint64][]*IndexRecord // the records of the batches not finished yet
+	mergeFinSegId wal.SegmentID             // the merge finished segment id when the index was built
+}
+
+// OpenReadOnlyReplica opens the database directory of the primary as a read only replica,
+// the directory must exist, and it is not locked, so the primary can still open it.
+// If refreshInterval is greater than 0, the replica is refreshed by a background goroutine periodically,
+// otherwise it is only refreshed by Refresh.
+//
+// The options must be the same as the primary's to read the records, such as ValueCodec, KeyHasher
+// and SegmentSize, the options to write are ignored. Nothing is written to the directory,
+// except that the empty hint file and value log file are created if they do not exist, like Open.
+func OpenReadOnlyReplica(options Options, refreshInterval time.Duration) (*Replica, error) {
+	if err := checkOptions(options); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(options.DirPath); err != nil {
+		return nil, err
+	}
+	// the options replaying or changing the data files are not supported
+	options.RecoveryTransform = nil
+	options.RecoveryConcurrency = 0
+	options.PersistIndex = false
+	options.MaxTotalSize = 0
+	options.TrackAccess = false
+	options.SlidingExpiration = 0
+	options.WatchQueueSize = 0
+
+	state, err := buildReplicaState(options)
+	if err != nil {
+		return nil, err
+	}
+	r := &Replica{state: state, closeCh: make(chan struct{})}
+	if refreshInterval > 0 {
+		r.wg.Add(1)
+		go r.refreshInBackground(refreshInterval)
+	}
+	return r, nil
+}
+
+// buildReplicaState builds the index from the hint file and the data files,
+// it is built again if the primary merges the data files in the meantime.
+func buildReplicaState(options Options) (*replicaState, error) {
+	for retry := 0; ; retry++ {
+		mergeFinSegId, err := getMergeFinSegmentId(options.DirPath)
+		if err != nil {
+			return nil, err
+		}
+		state, err := openReplicaState(options, mergeFinSegId)
+		if err == nil {
+			if err = state.tail(); err == nil {
+				err = state.reopenValueLogFiles()
+			}
+		}
+		current, finErr := getMergeFinSegmentId(options.DirPath)
+		if finErr != nil {
+			err = finErr
+		}
+		if err == nil && current == mergeFinSegId {
+			return state, nil
+		}
+		if state != nil {
+			state.close()
+		}
+		if current == mergeFinSegId || retry >= replicaRebuildRetries {
+			if err == nil {
+				err = ErrMergeRunning
+			}
+			return nil, err
+		}
+	}
+}
+
+// openReplicaState opens the data files and loads the index from the hint file,
+// the data files after the merged ones are read by tail.
+func openReplicaState(options Options, mergeFinSegId wal.SegmentID) (*replicaState, error) {
+	db := &DB{
+		options:   options,
+		batchPool: sync.Pool{New: makeBatch},
+		closeCh:   make(chan struct{}),
+		versions:  newKeyVersions(),
+	}
+	dataFiles, err := db.openWalFiles()
+	if err != nil {
+		return nil, err
+	}
+	db.dataFiles = readOnlyWAL{WAL: dataFiles}
+	db.index = db.newIndex()
+	state := &replicaState{
+		db:            db,
+		pos:           &wal.ChunkPosition{SegmentId: mergeFinSegId + 1},
+		indexRecords:  make(map[uint64][]*IndexRecord),
+		mergeFinSegId: mergeFinSegId,
+	}
+	if err = db.loadIndexFromHintFile(); err != nil {
+		state.close()
+		return nil, err
+	}
+	return state, nil
+}
+
+// tail opens the data files again, since the wal opened before does not see the chunks appended by the primary,
+// and reads the records from pos into the index until the end of the data files.
+// The wal reader can not start in the middle of a segment, so the segment of pos is read from its beginning,
+// and the chunks before pos are skipped.
+// It must be called with the database of the state locked.
+func (s *replicaState) tail() error {
+	dataFiles, err := s.db.openWalFiles()
+	if err != nil {
+		return err
+	}
+	// the data files may be replaced by the merged ones since the index was built
+	mergeFinSegId, err := getMergeFinSegmentId(s.db.options.DirPath)
+	if err == nil && mergeFinSegId != s.mergeFinSegId {
+		err = ErrMergeRunning
+	}
+	if err != nil {
+		_ = dataFiles.Close()
+		return err
+	}
+	_ = s.db.dataFiles.Close()
+	s.db.dataFiles = readOnlyWAL{WAL: dataFiles}
+	if s.pos.SegmentId > dataFiles.ActiveSegmentID() {
+		return nil
+	}
+
+	now := s.db.now().UnixNano()
+	reader := dataFiles.NewReader()
+	for reader.CurrentSegmentId() < s.pos.SegmentId {
+		reader.SkipCurrentSegment()
+	}
+	for {
+		chunk, pos, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// the last chunk may be being written by the primary, it is read again at the next refresh
+			if (err == io.ErrUnexpectedEOF || err == wal.ErrInvalidCRC) &&
+				reader.CurrentSegmentId() == dataFiles.ActiveSegmentID() {
+				return nil
+			}
+			return err
+		}
+		if positionBefore(pos, s.pos) {
+			continue
+		}
+		if err = s.db.indexLogRecord(decodeLogRecord(chunk), pos, s.indexRecords, now); err != nil {
+			return err
+		}
+		s.pos = reader.CurrentChunkPosition()
+	}
+}
+
+// reopenValueLogFiles opens the value log files again, so the values appended since they were opened can be read,
+// it must be called after tail, with the database of the state locked.
+func (s *replicaState) reopenValueLogFiles() error {
+	valueLogFiles, err := s.db.openValueLogFiles()
+	if err != nil {
+		return err
+	}
+	if s.db.valueLogFiles != nil {
+		_ = s.db.valueLogFiles.Close()
+	}
+	s.db.valueLogFiles = valueLogFiles
+	return nil
+}
+
+// close closes the files of the state, the reads in progress are finished first.
+func (s *replicaState) close() {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.closeOnce.Do(func() {
+		close(s.db.closeCh)
+	})
+	_ = s.db.closeFiles()
+	s.db.closed = true
+}
+
+// Refresh reads the records appended to the data files by the primary since the last refresh,
+// the reads are blocked while the new records are being indexed.
+// If the primary has merged the data files, the index is rebuilt from the merged files,
+// and the previous one is closed after the reads in progress on it finish.
+//
+// The primary may be writing the last record, it is read at the next refresh if it is incomplete.
+// The segment read last is read again from its beginning to find the new records,
+// so a smaller Options.SegmentSize makes the refresh cheaper.
+func (r *Replica) Refresh() error {
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
+	r.mu.RLock()
+	state, closed := r.state, r.closed
+	r.mu.RUnlock()
+	if closed {
+		return ErrDBClosed
+	}
+
+	mergeFinSegId, err := getMergeFinSegmentId(state.db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	if mergeFinSegId == state.mergeFinSegId {
+		state.db.mu.Lock()
+		if err = state.tail(); err == nil {
+			err = state.reopenValueLogFiles()
+		}
+		state.db.mu.Unlock()
+		if err == nil {
+			return nil
+		}
+		// the segment being read may be merged away by the primary, rebuild the index then.
+		if mergeFinSegId, _ = getMergeFinSegmentId(state.db.options.DirPath); mergeFinSegId == state.mergeFinSegId {
+			return err
+		}
+	}
+
+	newState, err := buildReplicaState(state.db.options)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		newState.close()
+		return ErrDBClosed
+	}
+	r.state = newState
+	r.mu.Unlock()
+	state.close()
+	return nil
+}
+
+// refreshInBackground refreshes the replica every interval until it is closed,
+// the errors are reported to Options.OnBackgroundError.
+func (r *Replica) refreshInBackground(interval time.Duration) {
+	defer r.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.closeCh:
+			return
+		case <-ticker.C:
+			if err := r.Refresh(); err != nil && err != ErrDBClosed {
+				r.db().backgroundError(BackgroundTaskReplica, err)
+			}
+		}
+	}
+}
+
+// db returns the database serving the reads, it may be closed by Refresh once it is returned,
+// so the reads hold r.mu instead.
+func (r *Replica) db() *DB {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state.db
+}
+
+// Get returns the value of the key, see DB.Get.
+func (r *Replica) Get(key []byte) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state.db.Get(key)
+}
+
+// Exist checks if the key exists, see DB.Exist.
+func (r *Replica) Exist(key []byte) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state.db.Exist(key)
+}
+
+// TTL returns the ttl of the key, see DB.TTL.
+func (r *Replica) TTL(key []byte) (time.Duration, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state.db.TTL(key)
+}
+
+// Ascend calls handleFn for each key/value pair in ascending order, see DB.Ascend.
+func (r *Replica) Ascend(handleFn func(k []byte, v []byte) (bool, error)) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.state.db.Ascend(handleFn)
+}
+
+// AscendRange calls handleFn for each key/value pair within the range in ascending order, see DB.AscendRange.
+func (r *Replica) AscendRange(startKey, endKey []byte, handleFn func(k []byte, v []byte) (bool, error)) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.state.db.AscendRange(startKey, endKey, handleFn)
+}
+
+// Descend calls handleFn for each key/value pair in descending order, see DB.Descend.
+func (r *Replica) Descend(handleFn func(k []byte, v []byte) (bool, error)) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	r.state.db.Descend(handleFn)
+}
+
+// Close stops refreshing and closes the files of the replica, the primary is not affected.
+// The reads return ErrDBClosed after closing, and closing it again does nothing.
+func (r *Replica) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.closeCh)
+	r.mu.Unlock()
+
+	r.wg.Wait()
+	// wait for the running Refresh, it does not replace the state after closed
+	r.refreshMu.Lock()
+	defer r.refreshMu.Unlock()
+	r.state.close()
+	return nil
+}
+
+// readOnlyWAL is the data files of the replica, the writes return ErrReadOnlyReplica.
+type readOnlyWAL struct {
+	WAL
+}
+
+func (w readOnlyWAL) Write([]byte) (*wal.ChunkPosition, error) {
+	return nil, ErrReadOnlyReplica
+}
+
+func (w readOnlyWAL) Sync() error {
+	return nil
+}
+
+func (w readOnlyWAL) OpenNewActiveSegment() error {
+	return ErrReadOnlyReplica
+}
@@ -0,0 +1,120 @@
+package rosedb
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenReadOnlyReplica(t *testing.T) {
+	options := DefaultOptions
+	options.SegmentSize = 64 * KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	replica, err := OpenReadOnlyReplica(options, 0)
+	assert.Nil(t, err)
+	defer func() {
+		_ = replica.Close()
+	}()
+	value, err := replica.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	expected, _ := db.Get(utils.GetTestKey(10))
+	assert.Equal(t, expected, value)
+
+	// the writes are only seen after refreshing, including the new segments
+	for i := 100; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	_, err = replica.Get(utils.GetTestKey(500))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, replica.Refresh())
+	ok, err := replica.Exist(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = replica.Exist(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// the index is rebuilt after the primary merges the segments
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge(true))
+	assert.Nil(t, db.Put([]byte("after-merge"), []byte("v")))
+	assert.Nil(t, replica.Refresh())
+	count := 0
+	replica.Ascend(func(k []byte, v []byte) (bool, error) {
+		count++
+		return true, nil
+	})
+	assert.Equal(t, 501, count)
+	value, err = replica.Get([]byte("after-merge"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), value)
+
+	_, err = replica.state.db.dataFiles.Write([]byte("v"))
+	assert.Equal(t, ErrReadOnlyReplica, err)
+
+	assert.Nil(t, replica.Close())
+	_, err = replica.Get(utils.GetTestKey(600))
+	assert.Equal(t, ErrDBClosed, err)
+	assert.Equal(t, ErrDBClosed, replica.Refresh())
+}
+
+func TestReplica_Refresh_ConcurrentReads(t *testing.T) {
+	options := DefaultOptions
+	options.SegmentSize = 64 * KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	replica, err := OpenReadOnlyReplica(options, 0)
+	assert.Nil(t, err)
+	defer func() {
+		_ = replica.Close()
+	}()
+
+	// the reads on the state replaced by the rebuild are finished before it is closed
+	state := replica.state
+	stop := make(chan struct{})
+	errCh := make(chan error, 4)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, err := replica.Get([]byte("key")); err != nil {
+					errCh <- err
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 100; j++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(j), utils.RandomValue(128)))
+		}
+		assert.Nil(t, db.Merge(true))
+		assert.Nil(t, replica.Refresh())
+	}
+	close(stop)
+	wg.Wait()
+	close(errCh)
+	assert.Nil(t, <-errCh)
+	assert.NotEqual(t, state, replica.state)
+}
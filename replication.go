@@ -0,0 +1,371 @@
+package rosedb
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/snowflake"
+	"github.com/rosedblabs/wal"
+)
+
+const (
+	// replicationPollInterval is the interval to check the new records
+	// when the replication stream reaches the end of the data files.
+	replicationPollInterval = 100 * time.Millisecond
+
+	// replicationPosFileName is the file saving the applied position of Follower.
+	replicationPosFileName = "REPLPOS"
+)
+
+// ReplicationEntry is an encoded log record emitted by ReplicationStreamWithPosition.
+type ReplicationEntry struct {
+	// Record is the encoded log record.
+	Record []byte
+	// NextPosition is the position of the next record in the data files,
+	// the replication can be resumed from it.
+	NextPosition *wal.ChunkPosition
+}
+
+// ReplicationStream returns a channel which emits the encoded log records in the data files,
+// starting from fromPos, and then the new records as they are appended.
+// If fromPos is nil, all the records will be emitted from the beginning.
+//
+// The records can be applied to another database by DB.Apply in the same order,
+// which makes it a read replica of this database.
+// The values stored in the value log are loaded, so the records are self-contained.
+// The values are still encoded by Options.ValueCodec, so the replica must be opened with the same codecs.
+//
+// It returns ErrResyncRequired if the data files at fromPos have been merged,
+// the replica should be rebuilt from the beginning.
+// The merged data files only keep the delete records within Options.TombstoneRetention,
+// so the replica resyncing into its current data may keep the keys deleted before that.
+// The channel will be closed when the database is closed, or an error occurs when reading the data files.
+func (db *DB) ReplicationStream(fromPos *wal.ChunkPosition) (<-chan []byte, error) {
+	ch := make(chan []byte, 100)
+	err := db.startReplication(fromPos, func(closeCh <-chan struct{}, record []byte, _ *wal.ChunkPosition) bool {
+		select {
+		case <-closeCh:
+			return false
+		case ch <- record:
+			return true
+		}
+	}, func() {
+		close(ch)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// ReplicationStreamWithPosition is like ReplicationStream, but the position of the next record
+// is emitted along with each record, which is used by Follower to resume the replication.
+func (db *DB) ReplicationStreamWithPosition(fromPos *wal.ChunkPosition) (<-chan *ReplicationEntry, error) {
+	ch := make(chan *ReplicationEntry, 100)
+	err := db.startReplication(fromPos, func(closeCh <-chan struct{}, record []byte, next *wal.ChunkPosition) bool {
+		select {
+		case <-closeCh:
+			return false
+		case ch <- &ReplicationEntry{Record: record, NextPosition: next}:
+			return true
+		}
+	}, func() {
+		close(ch)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// startReplication starts a goroutine sending the records from fromPos by send,
+// until send returns false, the database is closed or an error occurs, then done is called.
+// The closeCh passed to send is the one of the goroutine, send must stop when it is closed.
+func (db *DB) startReplication(fromPos *wal.ChunkPosition,
+	send func(closeCh <-chan struct{}, record []byte, next *wal.ChunkPosition) bool, done func()) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed || db.isClosing() {
+		return ErrDBClosed
+	}
+
+	pos := &wal.ChunkPosition{SegmentId: 1}
+	if fromPos != nil {
+		// the data files at fromPos have been replaced by merge
+		if err := db.checkReplicationPosition(fromPos); err != nil {
+			return err
+		}
+		pos = &wal.ChunkPosition{
+			SegmentId:   fromPos.SegmentId,
+			BlockNumber: fromPos.BlockNumber,
+			ChunkOffset: fromPos.ChunkOffset,
+		}
+	}
+
+	r := &replicationReader{dataFiles: db.dataFiles, pos: pos}
+	closeCh := db.closeCh
+	db.goBackground(BackgroundTaskReplication, func() {
+		defer done()
+		for {
+			record, next, err := db.readReplicationRecord(r)
+			if err != nil {
+				// the stream ends silently when the database is closed
+				if err != ErrDBClosed {
+					db.backgroundError(BackgroundTaskReplication, err)
+				}
+				return
+			}
+			// reach the end of the data files, wait for the new records
+			if next == nil {
+				select {
+				case <-closeCh:
+					return
+				case <-time.After(replicationPollInterval):
+				}
+				continue
+			}
+			if record == nil {
+				continue
+			}
+			if !send(closeCh, record, next) {
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// replicationReader reads the chunks of the data files for the replication from pos,
+// the positions of the chunks are returned by the reader of the data files.
+type replicationReader struct {
+	dataFiles WAL                // the data files read by reader, they are replaced when the database is reopened
+	reader    WALReader          // it is created from the beginning of the segment of pos if nil
+	pos       *wal.ChunkPosition // the position of the next chunk to read
+}
+
+// checkReplicationPosition returns ErrResyncRequired if the segment file of pos has been merged.
+func (db *DB) checkReplicationPosition(pos *wal.ChunkPosition) error {
+	mergeFinSegmentId, err := getMergeFinSegmentId(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	if pos.SegmentId <= mergeFinSegmentId {
+		return ErrResyncRequired
+	}
+	return nil
+}
+
+// readReplicationRecord reads the chunk at the position of r, and returns the encoded record to be replicated
+// and the position of the next record.
+// The returned record is nil if it should be skipped, and the next position is nil
+// if there is no more record now.
+// The data files may be reopened after merge, the position is checked again if so.
+func (db *DB) readReplicationRecord(r *replicationReader) ([]byte, *wal.ChunkPosition, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, nil, ErrDBClosed
+	}
+	if r.dataFiles != db.dataFiles {
+		if err := db.checkReplicationPosition(r.pos); err != nil {
+			return nil, nil, err
+		}
+		r.dataFiles, r.reader = db.dataFiles, nil
+	}
+	activeSegId := db.dataFiles.ActiveSegmentID()
+	if r.pos.SegmentId > activeSegId {
+		return nil, nil, nil
+	}
+	if r.reader == nil {
+		r.reader = db.dataFiles.NewReader()
+		for r.reader.CurrentSegmentId() < r.pos.SegmentId {
+			r.reader.SkipCurrentSegment()
+		}
+	}
+	// the reader can not be used after it reaches the end of the data files,
+	// so it only reads the active segment if there is a chunk at pos.
+	if r.pos.SegmentId == activeSegId && !positionBefore(r.reader.CurrentChunkPosition(), r.pos) {
+		if _, err := db.dataFiles.Read(r.pos); err == io.EOF {
+			return nil, nil, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	chunk, pos, err := r.reader.Next()
+	if err == io.EOF {
+		// the segments after the ones of the reader are created later, read them by a new reader
+		r.reader = nil
+		if r.pos.SegmentId >= activeSegId {
+			return nil, nil, nil
+		}
+		r.pos = &wal.ChunkPosition{SegmentId: r.pos.SegmentId + 1}
+		return nil, r.pos, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// the new reader reads the chunks before pos in its segment again
+	if positionBefore(pos, r.pos) {
+		return nil, r.pos, nil
+	}
+	next := r.reader.CurrentChunkPosition()
+	r.pos = next
+
+	record := decodeLogRecord(chunk)
+	if record.Type != LogRecordValuePointer {
+		return chunk, next, nil
+	}
+	// load the value from the value log, the value of a stale record
+	// may have been reclaimed by ValueLogGC, it is skipped since it is overwritten later.
+	if record.Value, err = db.loadValue(record); err != nil {
+		if indexPos := db.index.Get(record.Key); indexPos != nil && positionEquals(indexPos, pos) {
+			return nil, nil, err
+		}
+		return nil, next, nil
+	}
+	record.Type, record.codecs = LogRecordNormal, 0
+	return encodeLogRecord(record), next, nil
+}
+
+// Apply applies an encoded log record emitted by ReplicationStream of another database.
+// The records of a batch are kept in memory until the batch finished record is applied,
+// then they will be committed atomically, the records of the uncommitted batches are never applied.
+func (db *DB) Apply(data []byte) error {
+	db.replicationMu.Lock()
+	defer db.replicationMu.Unlock()
+
+	if db.replicationBatches == nil {
+		db.replicationBatches = make(map[uint64][]*LogRecord)
+	}
+	_, err := db.applyRecord(db.replicationBatches, data)
+	return err
+}
+
+// applyRecord applies an encoded log record, the records of the uncommitted batches are kept in batches.
+// It returns true if the records are committed.
+func (db *DB) applyRecord(batches map[uint64][]*LogRecord, data []byte) (bool, error) {
+	record := decodeLogRecord(data)
+
+	var records []*LogRecord
+	switch {
+	case record.Type == LogRecordBatchFinished:
+		batchId, err := snowflake.ParseBytes(record.Key)
+		if err != nil {
+			return false, err
+		}
+		records = batches[uint64(batchId)]
+		delete(batches, uint64(batchId))
+	case record.BatchId == mergeFinishedBatchID:
+		// the merged records are valid, apply them directly
+		records = []*LogRecord{record}
+	default:
+		batches[record.BatchId] = append(batches[record.BatchId], record)
+		return false, nil
+	}
+	if len(records) == 0 {
+		return true, nil
+	}
+
+	batch := db.NewBatch(DefaultBatchOptions)
+	for _, record := range records {
+		// the value is decoded by the local codecs, and encoded again when committing.
+		value, err := db.decodeValue(record.Value, record.codecs)
+		if err != nil {
+			_ = batch.Rollback()
+			return false, err
+		}
+		batch.pendingWrites[string(record.Key)] = &LogRecord{
+			Key:    record.Key,
+			Value:  value,
+			Type:   record.Type,
+			Expire: record.Expire,
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Follower applies the records emitted by ReplicationStreamWithPosition of the primary database
+// to a local database, and tracks the applied position durably,
+// so the replication can be resumed from AppliedPosition after restart.
+type Follower struct {
+	db      *DB
+	mu      sync.Mutex
+	batches map[uint64][]*LogRecord
+	applied *wal.ChunkPosition
+}
+
+// NewFollower returns a follower applying the records to db,
+// the applied position saved before will be loaded.
+func NewFollower(db *DB) (*Follower, error) {
+	f := &Follower{db: db, batches: make(map[uint64][]*LogRecord)}
+	buf, err := os.ReadFile(filepath.Join(db.options.DirPath, replicationPosFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(buf) > 0 {
+		f.applied = decodeValuePointer(buf)
+	}
+	return f, nil
+}
+
+// Apply applies the entry emitted by ReplicationStreamWithPosition.
+// The records of a batch are committed atomically after the batch finished record is applied,
+// and then the applied position is saved.
+//
+// The applied position is saved after the records are committed, if the process crashes between them,
+// the last batch will be applied again after restart, which is harmless since the records are applied in order.
+func (f *Follower) Apply(entry *ReplicationEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	committed, err := f.db.applyRecord(f.batches, entry.Record)
+	if err != nil || !committed {
+		return err
+	}
+	if err = f.saveAppliedPosition(entry.NextPosition); err != nil {
+		return err
+	}
+	f.applied = entry.NextPosition
+	return nil
+}
+
+// AppliedPosition returns the position in the primary database to resume the replication from,
+// it returns nil if no records are applied, and the replication should start from the beginning.
+func (f *Follower) AppliedPosition() *wal.ChunkPosition {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.applied == nil {
+		return nil
+	}
+	pos := *f.applied
+	return &pos
+}
+
+// saveAppliedPosition writes the applied position to a temporary file and renames it,
+// so the position file is always complete.
+func (f *Follower) saveAppliedPosition(pos *wal.ChunkPosition) error {
+	fileName := filepath.Join(f.db.options.DirPath, replicationPosFileName)
+	file, err := os.OpenFile(fileName+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(encodeValuePointer(pos)); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(fileName+".tmp", fileName)
+}
@@ -0,0 +1,203 @@
+package rosedb
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/rosedblabs/wal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ReplicationStreamWithPosition(t *testing.T) {
+	options := DefaultOptions
+	options.SegmentSize = 256 * KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// the values in one block, across the blocks and the segments
+	sizes := []int{10, 32*KB - 40, 1, 32*KB - 50, 64 * KB, 100, 32*KB - 30, 5, 128*KB + 3, 32*KB - 45}
+	values := make([][]byte, len(sizes))
+	for i, size := range sizes {
+		values[i] = utils.RandomValue(size)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+	}
+	readEntries := func(fromPos *wal.ChunkPosition, n int) []*ReplicationEntry {
+		stream, err := db.ReplicationStreamWithPosition(fromPos)
+		assert.Nil(t, err)
+		entries := make([]*ReplicationEntry, 0, n)
+		for len(entries) < n {
+			select {
+			case entry := <-stream:
+				entries = append(entries, entry)
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout waiting for the replication")
+			}
+		}
+		return entries
+	}
+
+	// each put writes a record and a batch finished record
+	entries := readEntries(nil, len(sizes)*2)
+	for i := 0; i < len(entries); i += 2 {
+		record := decodeLogRecord(entries[i].Record)
+		assert.Equal(t, utils.GetTestKey(i/2), record.Key)
+		assert.Equal(t, values[i/2], record.Value)
+	}
+	// the replication resumed from the next position of an entry starts from the following entry
+	for i := 0; i < len(entries)-1; i++ {
+		resumed := readEntries(entries[i].NextPosition, 1)
+		assert.Equal(t, entries[i+1].Record, resumed[0].Record)
+		assert.Equal(t, entries[i+1].NextPosition, resumed[0].NextPosition)
+	}
+}
+
+func TestDB_ReplicationStream(t *testing.T) {
+	options := DefaultOptions
+	options.SegmentSize = 256 * KB
+	options.LargeValueThreshold = 512
+	primary, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(primary)
+
+	followerOptions := DefaultOptions
+	followerOptions.DirPath, err = os.MkdirTemp("", "rosedb-follower")
+	assert.Nil(t, err)
+	follower, err := Open(followerOptions)
+	assert.Nil(t, err)
+	defer destroyDB(follower)
+
+	for i := 0; i < 500; i++ {
+		err := primary.Put(utils.GetTestKey(i), utils.RandomValue(KB))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := primary.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	stream, err := primary.ReplicationStream(nil)
+	assert.Nil(t, err)
+	go func() {
+		for data := range stream {
+			assert.Nil(t, follower.Apply(data))
+		}
+	}()
+
+	// the new records are streamed too
+	batch := primary.NewBatch(DefaultBatchOptions)
+	for i := 500; i < 600; i++ {
+		err := batch.Put(utils.GetTestKey(i), utils.RandomValue(10))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, batch.Commit())
+
+	for i := 0; i < 100; i++ {
+		if follower.Stat().KeysNum == 500 {
+			if exist, _ := follower.Exist(utils.GetTestKey(599)); exist {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Equal(t, 500, follower.Stat().KeysNum)
+	for i := 0; i < 600; i++ {
+		val, err := follower.Get(utils.GetTestKey(i))
+		if i < 100 {
+			assert.Equal(t, ErrKeyNotFound, err)
+			continue
+		}
+		assert.Nil(t, err)
+		expected, err := primary.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, val)
+	}
+}
+
+func TestDB_Apply_Uncommitted(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// the records of the batch are applied only after the batch finished record
+	record := &LogRecord{Key: utils.GetTestKey(1), Value: []byte("v"), Type: LogRecordNormal, BatchId: 1234}
+	err = db.Apply(encodeLogRecord(record))
+	assert.Nil(t, err)
+	assertKeyExistOrNot(t, db, utils.GetTestKey(1), false)
+
+	finished := &LogRecord{Key: []byte("1234"), Type: LogRecordBatchFinished}
+	err = db.Apply(encodeLogRecord(finished))
+	assert.Nil(t, err)
+	assertKeyExistOrNot(t, db, utils.GetTestKey(1), true)
+
+	// the finished batch does not drop the other pending batches
+	for i, batchId := range []uint64{1235, 1236} {
+		record = &LogRecord{Key: utils.GetTestKey(i + 2), Value: []byte("v"), Type: LogRecordNormal, BatchId: batchId}
+		assert.Nil(t, db.Apply(encodeLogRecord(record)))
+	}
+	for i, batchId := range []string{"1236", "1235"} {
+		finished = &LogRecord{Key: []byte(batchId), Type: LogRecordBatchFinished}
+		assert.Nil(t, db.Apply(encodeLogRecord(finished)))
+		assertKeyExistOrNot(t, db, utils.GetTestKey(3-i), true)
+	}
+	assertKeyExistOrNot(t, db, utils.GetTestKey(2), true)
+}
+
+func TestFollower_Resume(t *testing.T) {
+	options := DefaultOptions
+	primary, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(primary)
+
+	followerOptions := DefaultOptions
+	followerOptions.DirPath, err = os.MkdirTemp("", "rosedb-follower")
+	assert.Nil(t, err)
+	followerDB, err := Open(followerOptions)
+	assert.Nil(t, err)
+	defer destroyDB(followerDB)
+
+	follow := func(db *DB, from, to int) *wal.ChunkPosition {
+		follower, err := NewFollower(db)
+		assert.Nil(t, err)
+		stream, err := primary.ReplicationStreamWithPosition(follower.AppliedPosition())
+		assert.Nil(t, err)
+		deadline := time.After(5 * time.Second)
+		for exist := false; !exist; exist, _ = db.Exist(utils.GetTestKey(to - 1)) {
+			select {
+			case entry := <-stream:
+				assert.Nil(t, follower.Apply(entry))
+			case <-deadline:
+				t.Fatal("timeout waiting for the replication")
+			}
+		}
+		for i := from; i < to; i++ {
+			assertKeyExistOrNot(t, db, utils.GetTestKey(i), true)
+		}
+		return follower.AppliedPosition()
+	}
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, primary.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+	}
+	pos := follow(followerDB, 0, 100)
+	assert.NotNil(t, pos)
+
+	// reopen the follower, it resumes from the applied position
+	_ = followerDB.Close()
+	followerDB, err = Open(followerOptions)
+	assert.Nil(t, err)
+	for i := 100; i < 200; i++ {
+		assert.Nil(t, primary.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+	}
+	pos2 := follow(followerDB, 0, 200)
+	assert.True(t, positionBefore(pos, pos2))
+	assert.Equal(t, 200, followerDB.Stat().KeysNum)
+
+	// the applied position has been merged
+	assert.Nil(t, primary.Merge(true))
+	_, err = primary.ReplicationStreamWithPosition(pos2)
+	assert.Equal(t, ErrResyncRequired, err)
+}
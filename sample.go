@@ -0,0 +1,86 @@
+package rosedb
+
+import (
+	"math/rand"
+
+	"github.com/rosedblabs/wal"
+)
+
+// randomKeyAttempts is the max number of samplings in RandomKey,
+// the sampled key may be expired, and then another key will be sampled.
+const randomKeyAttempts = 3
+
+// RandomKey returns a random live key in the database.
+// It is the same as RandomKeys(1), the key is sampled uniformly from all the keys in the index.
+// It returns ErrKeyNotFound if the database is empty.
+func (db *DB) RandomKey() ([]byte, error) {
+	for i := 0; i < randomKeyAttempts; i++ {
+		keys, err := db.RandomKeys(1)
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) > 0 {
+			return keys[0], nil
+		}
+	}
+	return nil, ErrKeyNotFound
+}
+
+// RandomKeys returns at most n distinct random live keys in the database, in random order.
+//
+// The keys are sampled uniformly from all the keys in the index by reservoir sampling,
+// so every key has the same probability to be returned.
+// The index is iterated once, which is O(N) in the number of keys,
+// and only the records of the sampled keys are read to skip the expired ones,
+// so less than n keys may be returned if some sampled keys are expired but not merged yet.
+// It returns ErrKeyNotFound if the database is empty.
+func (db *DB) RandomKeys(n int) ([][]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+	if db.index.Size() == 0 {
+		return nil, ErrKeyNotFound
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	type sample struct {
+		key []byte
+		pos *wal.ChunkPosition
+	}
+	samples := make([]sample, 0, n)
+	var seen int
+	db.index.Ascend(func(key []byte, pos *wal.ChunkPosition) (bool, error) {
+		// the records of the data structures are not sampled
+		if isStructKey(key) {
+			return true, nil
+		}
+		seen++
+		if len(samples) < n {
+			samples = append(samples, sample{key: key, pos: pos})
+		} else if i := rand.Intn(seen); i < n {
+			samples[i] = sample{key: key, pos: pos}
+		}
+		return true, nil
+	})
+
+	now := db.now().UnixNano()
+	keys := make([][]byte, 0, len(samples))
+	for _, s := range samples {
+		chunk, err := db.dataFiles.Read(s.pos)
+		if err != nil {
+			return nil, err
+		}
+		if decodeLogRecord(chunk).IsExpired(now) {
+			continue
+		}
+		keys = append(keys, s.key)
+	}
+	rand.Shuffle(len(keys), func(i, j int) {
+		keys[i], keys[j] = keys[j], keys[i]
+	})
+	return keys, nil
+}
@@ -0,0 +1,57 @@
+package rosedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_RandomKey(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	_, err = db.RandomKey()
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.RandomKeys(10)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("v")))
+	}
+	// the expired keys are never returned
+	for i := 100; i < 200; i++ {
+		assert.Nil(t, db.PutWithTTL(utils.GetTestKey(i), []byte("v"), time.Millisecond))
+	}
+	time.Sleep(time.Millisecond * 5)
+
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		key, err := db.RandomKey()
+		if err == ErrKeyNotFound {
+			continue
+		}
+		assert.Nil(t, err)
+		counts[string(key)]++
+	}
+	for key := range counts {
+		assert.True(t, key < string(utils.GetTestKey(100)))
+	}
+	assert.Greater(t, len(counts), 50)
+
+	keys, err := db.RandomKeys(500)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(keys))
+	distinct := make(map[string]struct{})
+	for _, key := range keys {
+		distinct[string(key)] = struct{}{}
+	}
+	assert.Equal(t, 100, len(distinct))
+
+	keys, err = db.RandomKeys(20)
+	assert.Nil(t, err)
+	assert.True(t, len(keys) <= 20)
+}
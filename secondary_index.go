@@ -0,0 +1,187 @@
+package rosedb
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/google/btree"
+	"github.com/rosedblabs/wal"
+)
+
+// IndexExtractor extracts the index key from the key/value pair.
+// If the key/value pair should not be indexed, it returns false.
+type IndexExtractor func(key, value []byte) ([]byte, bool)
+
+// secondaryIndex maps the index keys extracted from the values to the primary keys.
+// It is only maintained in memory, and will be rebuilt when it is created.
+type secondaryIndex struct {
+	extractor IndexExtractor
+	tree      *btree.BTree
+	items     map[string]*secondaryItem // primary key -> item, to remove the old index key
+}
+
+type secondaryItem struct {
+	indexKey   []byte
+	primaryKey []byte
+	expire     int64
+}
+
+func (it *secondaryItem) Less(bi btree.Item) bool {
+	other := bi.(*secondaryItem)
+	if c := bytes.Compare(it.indexKey, other.indexKey); c != 0 {
+		return c < 0
+	}
+	return bytes.Compare(it.primaryKey, other.primaryKey) < 0
+}
+
+func newSecondaryIndex(extractor IndexExtractor) *secondaryIndex {
+	return &secondaryIndex{
+		extractor: extractor,
+		tree:      btree.New(32),
+		items:     make(map[string]*secondaryItem),
+	}
+}
+
+func (si *secondaryIndex) put(key, value []byte, expire int64) {
+	si.delete(key)
+	indexKey, ok := si.extractor(key, value)
+	if !ok {
+		return
+	}
+	item := &secondaryItem{indexKey: indexKey, primaryKey: key, expire: expire}
+	si.tree.ReplaceOrInsert(item)
+	si.items[string(key)] = item
+}
+
+func (si *secondaryIndex) delete(key []byte) {
+	if item, ok := si.items[string(key)]; ok {
+		si.tree.Delete(item)
+		delete(si.items, string(key))
+	}
+}
+
+// CreateIndex creates a secondary index with the given name,
+// which maps the index keys extracted by extractor to the primary keys.
+// The index will be built from all the existing data, and updated on every write.
+//
+// The secondary index is only maintained in memory,
+// so it should be created again after the database is reopened.
+// It returns ErrIndexExists if the index with the same name exists.
+func (db *DB) CreateIndex(name string, extractor IndexExtractor) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+	if _, ok := db.secondaryIndexes[name]; ok {
+		return ErrIndexExists
+	}
+
+	// build the index from the existing data
+	si := newSecondaryIndex(extractor)
+	now := db.now().UnixNano()
+	var err error
+	db.index.Ascend(func(key []byte, pos *wal.ChunkPosition) (bool, error) {
+		// the records of the data structures are not indexed
+		if isStructKey(key) {
+			return true, nil
+		}
+		var chunk []byte
+		if chunk, err = db.dataFiles.Read(pos); err != nil {
+			return false, err
+		}
+		record := decodeLogRecord(chunk)
+		if record.IsExpired(now) {
+			return true, nil
+		}
+		var value []byte
+		if value, err = db.loadValue(record); err != nil {
+			return false, err
+		}
+		si.put(key, value, record.Expire)
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if db.secondaryIndexes == nil {
+		db.secondaryIndexes = make(map[string]*secondaryIndex)
+	}
+	db.secondaryIndexes[name] = si
+	return nil
+}
+
+// DropIndex removes the secondary index with the given name.
+func (db *DB) DropIndex(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	if _, ok := db.secondaryIndexes[name]; !ok {
+		return ErrIndexNotFound
+	}
+	delete(db.secondaryIndexes, name)
+	return nil
+}
+
+// IndexScan returns the primary keys whose index keys are within the range [min, max]
+// of the secondary index with the given name, ordered by the index keys.
+// If min is nil, the range starts from the first index key,
+// and if max is nil, the range ends at the last index key.
+func (db *DB) IndexScan(name string, min, max []byte) ([][]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+	si, ok := db.secondaryIndexes[name]
+	if !ok {
+		return nil, ErrIndexNotFound
+	}
+
+	var keys [][]byte
+	now := db.now().UnixNano()
+	si.tree.AscendGreaterOrEqual(&secondaryItem{indexKey: min}, func(i btree.Item) bool {
+		item := i.(*secondaryItem)
+		if max != nil && bytes.Compare(item.indexKey, max) > 0 {
+			return false
+		}
+		if item.expire == 0 || item.expire > now {
+			keys = append(keys, item.primaryKey)
+		}
+		return true
+	})
+	return keys, nil
+}
+
+// updateSecondaryIndexes updates all the secondary indexes by the committed record.
+func (db *DB) updateSecondaryIndexes(record *LogRecord, now int64) {
+	if isStructKey(record.Key) {
+		return
+	}
+	for _, si := range db.secondaryIndexes {
+		if record.Type == LogRecordDeleted || record.IsExpired(now) {
+			si.delete(record.Key)
+		} else {
+			si.put(record.Key, record.Value, record.Expire)
+		}
+	}
+}
+
+// IndexKeyUint64 encodes v to an index key, the order of the encoded keys is the same as the numbers.
+func IndexKeyUint64(v uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	return buf
+}
+
+// IndexKeyInt64 encodes v to an index key, the order of the encoded keys is the same as the numbers.
+func IndexKeyInt64(v int64) []byte {
+	// flip the sign bit, so the negative numbers are ordered before the positive ones
+	return IndexKeyUint64(uint64(v) ^ (1 << 63))
+}
@@ -0,0 +1,64 @@
+package rosedb
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_SecondaryIndex(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// index the users by age
+	ageExtractor := func(key, value []byte) ([]byte, bool) {
+		age, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		return IndexKeyInt64(age), true
+	}
+
+	err = db.Put([]byte("user-1"), []byte("20"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("user-2"), []byte("35"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("user-3"), []byte("not a number"))
+	assert.Nil(t, err)
+
+	err = db.CreateIndex("age", ageExtractor)
+	assert.Nil(t, err)
+	err = db.CreateIndex("age", ageExtractor)
+	assert.Equal(t, ErrIndexExists, err)
+
+	err = db.Put([]byte("user-4"), []byte("-5"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("user-5"), []byte("30"))
+	assert.Nil(t, err)
+	// update and delete
+	err = db.Put([]byte("user-2"), []byte("40"))
+	assert.Nil(t, err)
+	err = db.Delete([]byte("user-1"))
+	assert.Nil(t, err)
+
+	keys, err := db.IndexScan("age", nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("user-4"), []byte("user-5"), []byte("user-2")}, keys)
+
+	keys, err = db.IndexScan("age", IndexKeyInt64(0), IndexKeyInt64(30))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("user-5")}, keys)
+
+	err = db.DropIndex("age")
+	assert.Nil(t, err)
+	_, err = db.IndexScan("age", nil, nil)
+	assert.Equal(t, ErrIndexNotFound, err)
+
+	assert.Nil(t, db.Close())
+	assert.Equal(t, ErrDBClosed, db.DropIndex("age"))
+	_, err = db.IndexScan("age", nil, nil)
+	assert.Equal(t, ErrDBClosed, err)
+}
@@ -0,0 +1,226 @@
+package rosedb
+
+import (
+	"bytes"
+	"sort"
+	"time"
+)
+
+// SAdd adds the members to the set stored at key atomically, the set is created if it does not exist.
+// The members are deduplicated, adding an existing member does nothing.
+//
+// Every member of the set is stored in its own record with an empty value, see encodeStructKey.
+// The ttl applies to the whole set, see SExpire, a new member shares the ttl of the existing members.
+func (db *DB) SAdd(key []byte, members ...[]byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.updateStruct(func(batch *Batch) error {
+		for _, member := range members {
+			exist, err := batch.Exist(encodeStructKey(setDataType, key, member))
+			if err != nil {
+				return err
+			}
+			if exist {
+				continue
+			}
+			if err = db.putStructMember(batch, setDataType, key, member, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SRem removes the members from the set stored at key atomically,
+// the members that do not exist are ignored.
+// The set is removed when all the members are removed.
+func (db *DB) SRem(key []byte, members ...[]byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.updateStruct(func(batch *Batch) error {
+		for _, member := range members {
+			if err := batch.delete(encodeStructKey(setDataType, key, member)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SIsMember checks if the member is in the set stored at key.
+func (db *DB) SIsMember(key, member []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	return db.Exist(encodeStructKey(setDataType, key, member))
+}
+
+// SMembers returns all the members of the set stored at key in ascending order.
+// It returns nil if the set does not exist.
+func (db *DB) SMembers(key []byte) ([][]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+	return db.setMembers(key)
+}
+
+// SCard returns the number of the members in the set stored at key, it returns 0 if the set does not exist.
+func (db *DB) SCard(key []byte) (int, error) {
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return 0, ErrDBClosed
+	}
+
+	var count int
+	err := db.ascendStruct(setDataType, key, false, func([]byte, *LogRecord) (bool, error) {
+		count++
+		return true, nil
+	})
+	return count, err
+}
+
+// SExpire sets the ttl of the whole set stored at key, all the members are rewritten
+// with the new expiry time atomically, so it costs O(N) in the number of the members.
+// It returns ErrKeyNotFound if the set does not exist.
+func (db *DB) SExpire(key []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.updateStruct(func(batch *Batch) error {
+		return db.expireStruct(batch, setDataType, key, db.now().Add(ttl).UnixNano())
+	})
+}
+
+// SInter returns the members existing in all the sets stored at keys in ascending order,
+// the set that does not exist is empty.
+func (db *DB) SInter(keys ...[]byte) ([][]byte, error) {
+	return db.combineSets(keys, func(count, total int) bool {
+		return count == total
+	})
+}
+
+// SUnion returns the members existing in any of the sets stored at keys in ascending order,
+// the set that does not exist is empty.
+func (db *DB) SUnion(keys ...[]byte) ([][]byte, error) {
+	return db.combineSets(keys, func(int, int) bool {
+		return true
+	})
+}
+
+// SDiff returns the members of the set stored at the first key, which do not exist in the other sets,
+// in ascending order, the set that does not exist is empty.
+func (db *DB) SDiff(keys ...[]byte) ([][]byte, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	members, err := db.setMembers(keys[0])
+	if err != nil {
+		return nil, err
+	}
+	diff := members[:0]
+	for _, member := range members {
+		var found bool
+		for _, key := range keys[1:] {
+			if found, err = db.isSetMember(key, member); err != nil {
+				return nil, err
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			diff = append(diff, member)
+		}
+	}
+	return diff, nil
+}
+
+// combineSets returns the members of the sets stored at keys in ascending order,
+// which are kept if keep returns true for the number of the sets containing them,
+// and the total number of the distinct sets.
+func (db *DB) combineSets(keys [][]byte, keep func(count, total int) bool) ([][]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	counts := make(map[string]int)
+	distinct := make(map[string]struct{})
+	for _, key := range keys {
+		// the duplicate keys are counted once
+		if _, ok := distinct[string(key)]; ok {
+			continue
+		}
+		distinct[string(key)] = struct{}{}
+		members, err := db.setMembers(key)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			counts[string(member)]++
+		}
+	}
+
+	var members [][]byte
+	for member, count := range counts {
+		if keep(count, len(distinct)) {
+			members = append(members, []byte(member))
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return bytes.Compare(members[i], members[j]) < 0
+	})
+	return members, nil
+}
+
+// setMembers returns the members of the set in ascending order,
+// it must be called with the database locked.
+func (db *DB) setMembers(key []byte) ([][]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	var members [][]byte
+	err := db.ascendStruct(setDataType, key, false, func(member []byte, _ *LogRecord) (bool, error) {
+		members = append(members, member)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// isSetMember checks if the member is in the set, it must be called with the database locked.
+func (db *DB) isSetMember(key, member []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	position := db.index.Get(encodeStructKey(setDataType, key, member))
+	if position == nil {
+		return false, nil
+	}
+	chunk, err := db.dataFiles.Read(position)
+	if err != nil {
+		return false, err
+	}
+	header := decodeLogRecordHeader(chunk)
+	return header.recordType != LogRecordDeleted && !header.isExpired(db.now().UnixNano()), nil
+}
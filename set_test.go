@@ -0,0 +1,94 @@
+package rosedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Set(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.SAdd([]byte("tags"), []byte("go"), []byte("db"), []byte("go")))
+	assert.Nil(t, db.SAdd([]byte("tags"), []byte("kv")))
+	// the hash with the same key is a different structure
+	assert.Nil(t, db.HSet([]byte("tags"), []byte("go"), []byte("1")))
+
+	members, err := db.SMembers([]byte("tags"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("db"), []byte("go"), []byte("kv")}, members)
+	count, err := db.SCard([]byte("tags"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+	ok, err := db.SIsMember([]byte("tags"), []byte("go"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	assert.Nil(t, db.SRem([]byte("tags"), []byte("go"), []byte("none")))
+	ok, err = db.SIsMember([]byte("tags"), []byte("go"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	count, err = db.SCard([]byte("tags"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	members, err = db.SMembers([]byte("none"))
+	assert.Nil(t, err)
+	assert.Nil(t, members)
+}
+
+func TestDB_SInter_SUnion_SDiff(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.SAdd([]byte("a"), []byte("1"), []byte("2"), []byte("3")))
+	assert.Nil(t, db.SAdd([]byte("b"), []byte("2"), []byte("3"), []byte("4")))
+	assert.Nil(t, db.SAdd([]byte("c"), []byte("3"), []byte("5")))
+
+	members, err := db.SInter([]byte("a"), []byte("b"), []byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("3")}, members)
+	members, err = db.SInter([]byte("a"), []byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("1"), []byte("2"), []byte("3")}, members)
+	members, err = db.SInter([]byte("a"), []byte("none"))
+	assert.Nil(t, err)
+	assert.Nil(t, members)
+
+	members, err = db.SUnion([]byte("a"), []byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("1"), []byte("2"), []byte("3"), []byte("5")}, members)
+
+	members, err = db.SDiff([]byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("1")}, members)
+	members, err = db.SDiff([]byte("b"), []byte("a"), []byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("4")}, members)
+}
+
+func TestDB_SExpire(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Equal(t, ErrKeyNotFound, db.SExpire([]byte("online"), time.Second))
+	assert.Nil(t, db.SAdd([]byte("online"), []byte("rose")))
+	assert.Nil(t, db.SExpire([]byte("online"), time.Millisecond*100))
+	assert.Nil(t, db.SAdd([]byte("online"), []byte("jack")))
+
+	time.Sleep(time.Millisecond * 200)
+	count, err := db.SCard([]byte("online"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+	ok, err := db.SIsMember([]byte("online"), []byte("jack"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
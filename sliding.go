@@ -0,0 +1,165 @@
+package rosedb
+
+import (
+	"sync"
+	"time"
+)
+
+// maxSlidingFlushInterval is the max interval of rewriting the extended expiry times,
+// the interval is a quarter of Options.SlidingExpiration if it is shorter.
+const maxSlidingFlushInterval = time.Second
+
+// slidingKeys collects the keys read by Get whose ttl should be extended, see Options.SlidingExpiration,
+// the expiry times are rewritten in batches by the background goroutine.
+type slidingKeys struct {
+	mu        sync.Mutex
+	keys      map[string]int64      // the keys and their new expiry time
+	ttls      map[string]slidingTTL // the base ttls of the keys written with a ttl since Open
+	lastPrune int64                 // the time when the expired base ttls are removed
+}
+
+// slidingTTL is the base ttl of a key, which is the time left to its expiry time when it was written,
+// so the key is never extended past the ttl it was written with.
+type slidingTTL struct {
+	ttl    time.Duration
+	expire int64
+}
+
+func newSlidingKeys() *slidingKeys {
+	return &slidingKeys{keys: make(map[string]int64), ttls: make(map[string]slidingTTL)}
+}
+
+// add records the new expiry time of the key, the latest one is kept.
+func (s *slidingKeys) add(key []byte, expire int64) {
+	s.mu.Lock()
+	if expire > s.keys[string(key)] {
+		s.keys[string(key)] = expire
+	}
+	s.mu.Unlock()
+}
+
+// take returns the collected keys and clears them.
+func (s *slidingKeys) take() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := s.keys
+	s.keys = make(map[string]int64, len(keys))
+	return keys
+}
+
+// written records the base ttl of the key written by a committed batch, the key deleted
+// or written without a ttl is forgotten. If keepTTL is true, only the expiry time is updated,
+// so the rewrites of the extensions do not shorten the base ttl by the delay of the rewrite.
+func (s *slidingKeys) written(record *LogRecord, now int64, keepTTL bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := string(record.Key)
+	if record.Type == LogRecordDeleted || record.Expire == 0 || record.Expire <= now {
+		delete(s.ttls, key)
+		return
+	}
+	base, ok := s.ttls[key]
+	if !keepTTL || !ok {
+		base.ttl = time.Duration(record.Expire - now)
+	}
+	base.expire = record.Expire
+	s.ttls[key] = base
+}
+
+// baseTTL returns the base ttl of the key, ok is false if the key has not been written with a ttl since Open.
+func (s *slidingKeys) baseTTL(key []byte) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	base, ok := s.ttls[string(key)]
+	return base.ttl, ok
+}
+
+// prune removes the base ttls of the expired keys, at most once in interval,
+// since the keys expired without being deleted are never forgotten by written.
+func (s *slidingKeys) prune(now int64, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now-s.lastPrune < int64(interval) {
+		return
+	}
+	s.lastPrune = now
+	for key, base := range s.ttls {
+		if base.expire <= now {
+			delete(s.ttls, key)
+		}
+	}
+}
+
+// slideExpire extends the ttl of the key read by Get to its base ttl if Options.SlidingExpiration is enabled,
+// the extension is capped at SlidingExpiration, see slidingTTL.
+// The key without a ttl or whose base ttl is unknown is never extended, and the key is only extended
+// when less than half of the extension is left, so each key is rewritten at most once in half of the extension
+// however often it is read.
+func (db *DB) slideExpire(key []byte, expire int64) {
+	if db.slidingKeys == nil || expire == 0 {
+		return
+	}
+	window, ok := db.slidingKeys.baseTTL(key)
+	if !ok {
+		return
+	}
+	if window > db.options.SlidingExpiration {
+		window = db.options.SlidingExpiration
+	}
+	now := db.now()
+	if expire-now.UnixNano() > int64(window/2) {
+		return
+	}
+	db.slidingKeys.add(key, now.Add(window).UnixNano())
+}
+
+// slideInBackground rewrites the extended expiry times periodically, until closeCh is closed.
+func (db *DB) slideInBackground(closeCh <-chan struct{}) {
+	interval := db.options.SlidingExpiration / 4
+	if interval > maxSlidingFlushInterval || interval <= 0 {
+		interval = maxSlidingFlushInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closeCh:
+			return
+		case <-ticker.C:
+			db.backgroundError(BackgroundTaskSliding, db.slideOnce())
+		}
+	}
+}
+
+// slideOnce rewrites the collected expiry times in one batch, the keys deleted or expired since they were read,
+// and the keys whose ttl has been changed to a later time or removed are skipped.
+func (db *DB) slideOnce() error {
+	db.slidingKeys.prune(db.now().UnixNano(), db.options.SlidingExpiration)
+	keys := db.slidingKeys.take()
+	if len(keys) == 0 {
+		return nil
+	}
+
+	options := DefaultBatchOptions
+	options.Sync = false
+	batch := db.NewBatch(options)
+	batch.keepTTL = true
+	for key, expire := range keys {
+		expire := expire
+		_, err := batch.expireIf([]byte(key), expire, func(current int64) bool {
+			return current != 0 && expire > current
+		})
+		if err == ErrDBClosed {
+			_ = batch.Rollback()
+			return nil
+		}
+		if err != nil && err != ErrKeyNotFound {
+			_ = batch.Rollback()
+			return err
+		}
+	}
+	if err := batch.Commit(); err != nil && err != ErrDBClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package rosedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_SlidingExpiration(t *testing.T) {
+	clock := newFakeClock()
+	options := DefaultOptions
+	options.Clock = clock
+	options.SlidingExpiration = time.Hour
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.PutWithTTL([]byte("ttl"), []byte("v"), time.Hour))
+	assert.Nil(t, db.Put([]byte("no-ttl"), []byte("v")))
+
+	// more than half of the window is left, the key is not extended
+	clock.Advance(20 * time.Minute)
+	_, err = db.Get([]byte("ttl"))
+	assert.Nil(t, err)
+	assert.Empty(t, db.slidingKeys.take())
+
+	// the key is extended to a full window from the read,
+	// the extension may be rewritten by the background goroutine before slideOnce.
+	walSize := db.Stat().WALSize
+	clock.Advance(20 * time.Minute)
+	_, err = db.Get([]byte("ttl"))
+	assert.Nil(t, err)
+	_, err = db.Get([]byte("no-ttl"))
+	assert.Nil(t, err)
+	assert.Nil(t, db.slideOnce())
+	assert.True(t, db.Stat().WALSize > walSize)
+	ttl, err := db.TTL([]byte("ttl"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Hour, ttl)
+	ttl, err = db.TTL([]byte("no-ttl"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+
+	// the key deleted before the rewrite is skipped
+	clock.Advance(40 * time.Minute)
+	_, err = db.Get([]byte("ttl"))
+	assert.Nil(t, err)
+	assert.Nil(t, db.Delete([]byte("ttl")))
+	assert.Nil(t, db.slideOnce())
+	_, err = db.Get([]byte("ttl"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// the key is extended by its own ttl shorter than the window, and the rewrite keeps it
+	assert.Nil(t, db.PutWithTTL([]byte("short"), []byte("v"), 10*time.Minute))
+	for i := 0; i < 3; i++ {
+		clock.Advance(6 * time.Minute)
+		_, err = db.Get([]byte("short"))
+		assert.Nil(t, err)
+		assert.Nil(t, db.slideOnce())
+		ttl, err = db.TTL([]byte("short"))
+		assert.Nil(t, err)
+		assert.Equal(t, 10*time.Minute, ttl)
+	}
+
+	// the key is not read, so it expires
+	assert.Nil(t, db.PutWithTTL([]byte("unread"), []byte("v"), time.Hour))
+	clock.Advance(2 * time.Hour)
+	_, err = db.Get([]byte("unread"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// the own ttl of the key written before Open is unknown, so it is not extended
+	assert.Nil(t, db.PutWithTTL([]byte("reopened"), []byte("v"), time.Hour))
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	clock.Advance(40 * time.Minute)
+	_, err = db.Get([]byte("reopened"))
+	assert.Nil(t, err)
+	assert.Empty(t, db.slidingKeys.take())
+}
@@ -0,0 +1,113 @@
+package rosedb
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// valueSpoolFileNameSuffix is the suffix of the temporary files spooling the values written by PutWriter.
+const valueSpoolFileNameSuffix = ".VALUETMP"
+
+// ValueWriter writes the value of a key as a stream, the value is put into the database when it is closed,
+// see DB.PutWriter.
+type ValueWriter struct {
+	db     *DB
+	key    []byte
+	file   *os.File // the temporary file spooling the written data
+	size   int64
+	closed bool
+}
+
+// PutWriter returns a writer of the value of the key, so a large value can be written as a stream,
+// such as an upload, without buffering it in memory while it is being written.
+// The written data is spooled to a temporary file in Options.DirPath, and Close puts it as the value
+// of the key atomically, the value is not visible before Close, and Abort discards it without writing
+// anything to the database. The spool files left by a crash are removed when the database is opened.
+//
+// Note that it only saves the memory while the data is being written, not when it is committed:
+// the record of the value is written to the wal as one chunk, and the codecs and the checksum
+// need the whole value, so Close loads the whole value into memory, and the encoded record is another
+// copy of it, the peak memory of Close is about twice the size of the value, like Put.
+// The writer is not safe for concurrent use, and ErrValueTooLarge is returned by Write
+// once the data exceeds Options.MaxValueSize, the writer is aborted then.
+func (db *DB) PutWriter(key []byte) (*ValueWriter, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	if isStructKey(key) {
+		return nil, ErrReservedKey
+	}
+	if db.keyTooLarge(key) {
+		return nil, ErrKeyTooLarge
+	}
+	db.mu.RLock()
+	closed := db.closed
+	db.mu.RUnlock()
+	if closed {
+		return nil, ErrDBClosed
+	}
+
+	file, err := os.CreateTemp(db.options.DirPath, "*"+valueSpoolFileNameSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return &ValueWriter{db: db, key: key, file: file}, nil
+}
+
+// Write writes the data of the value.
+func (w *ValueWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrValueWriterClosed
+	}
+	if maxSize := w.db.options.MaxValueSize; maxSize > 0 && w.size+int64(len(p)) > maxSize {
+		w.Abort()
+		return 0, ErrValueTooLarge
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	if err != nil {
+		w.Abort()
+	}
+	return n, err
+}
+
+// Close puts the written data as the value of the key, it returns ErrValueWriterClosed
+// if the writer is already closed or aborted.
+func (w *ValueWriter) Close() error {
+	if w.closed {
+		return ErrValueWriterClosed
+	}
+	defer w.Abort()
+
+	value := make([]byte, w.size)
+	if _, err := w.file.ReadAt(value, 0); err != nil {
+		return err
+	}
+	return w.db.Put(w.key, value)
+}
+
+// Abort discards the written data, the value of the key is not changed.
+// It is safe to be called multiple times, and after Close.
+func (w *ValueWriter) Abort() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
+
+// removeValueSpoolFiles removes the spool files of PutWriter left in dirPath,
+// it must be called with the file lock of the directory held.
+func removeValueSpoolFiles(dirPath string) error {
+	files, err := filepath.Glob(filepath.Join(dirPath, "*"+valueSpoolFileNameSuffix))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err = os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package rosedb
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_PutWriter(t *testing.T) {
+	options := DefaultOptions
+	options.MaxValueSize = 2 * MB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	value := utils.RandomValue(MB)
+	w, err := db.PutWriter([]byte("key"))
+	assert.Nil(t, err)
+	_, err = io.Copy(w, bytes.NewReader(value))
+	assert.Nil(t, err)
+	// the value is not visible before Close
+	_, err = db.Get([]byte("key"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, w.Close())
+	val, err := db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(value, val))
+	assert.Equal(t, ErrValueWriterClosed, w.Close())
+	_, err = w.Write([]byte("more"))
+	assert.Equal(t, ErrValueWriterClosed, err)
+
+	// the aborted value is discarded
+	w, err = db.PutWriter([]byte("key"))
+	assert.Nil(t, err)
+	_, err = w.Write([]byte("partial"))
+	assert.Nil(t, err)
+	assert.Equal(t, options.DirPath, filepath.Dir(w.file.Name()))
+	w.Abort()
+	_, err = os.Stat(w.file.Name())
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, ErrValueWriterClosed, w.Close())
+	val, err = db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(value, val))
+
+	// the writer is aborted if the value is too large
+	w, err = db.PutWriter([]byte("large"))
+	assert.Nil(t, err)
+	_, err = w.Write(make([]byte, 2*MB))
+	assert.Nil(t, err)
+	_, err = w.Write([]byte("x"))
+	assert.Equal(t, ErrValueTooLarge, err)
+	assert.Equal(t, ErrValueWriterClosed, w.Close())
+	exist, err := db.Exist([]byte("large"))
+	assert.Nil(t, err)
+	assert.False(t, exist)
+
+	// the empty value
+	w, err = db.PutWriter([]byte("empty"))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+	val, err = db.Get([]byte("empty"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(val))
+
+	_, err = db.PutWriter(nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	// the spool file left by a crash is removed when opening
+	w, err = db.PutWriter([]byte("crash"))
+	assert.Nil(t, err)
+	_, err = w.Write([]byte("partial"))
+	assert.Nil(t, err)
+	assert.Nil(t, w.file.Close())
+	assert.Nil(t, db.Close())
+	db, err = Open(options)
+	assert.Nil(t, err)
+	_, err = os.Stat(w.file.Name())
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, db.Close())
+}
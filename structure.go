@@ -0,0 +1,186 @@
+package rosedb
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/rosedblabs/wal"
+)
+
+// structKeyPrefix is the reserved prefix of the keys of the records storing the data structures,
+// the user writes of the keys starting with it return ErrReservedKey, see isStructKey.
+const structKeyPrefix = "\x00\x00"
+
+const (
+	// hashDataType is the data type of the records storing the fields of the hashes.
+	hashDataType byte = 'h'
+	// hashMetaDataType is the data type of the record saving the ttl of the whole hash, see HExpire,
+	// the member of the record is empty.
+	hashMetaDataType byte = 'H'
+	// setDataType is the data type of the records storing the members of the sets.
+	setDataType byte = 's'
+	// zsetDataType is the data type of the records storing the scores of the members of the sorted sets.
+	zsetDataType byte = 'z'
+	// zsetScoreDataType is the data type of the records ordering the members of the sorted sets by scores,
+	// the member of the record is the encoded score followed by the member of the sorted set.
+	zsetScoreDataType byte = 'Z'
+	// familyDataType is the data type of the records storing the keys of the column families,
+	// the key of the structure is the name of the family, see ColumnFamily.
+	familyDataType byte = 'f'
+)
+
+// encodeStructKey returns the key of the record storing the member of the data structure,
+// the member is the field of the hash for example.
+//
+// The records of the data structures are the ordinary records in the data files and index,
+// so they are written atomically by batches, and expired and merged as the other records.
+// The key of the structure is prefixed by its size, so the records of a structure
+// are adjacent in the index, and not mixed up with the structures whose keys have the same prefix.
+//
+//	+--------+-----------+----------+-----+--------+
+//	| prefix | data type | key size | key | member |
+//	+--------+-----------+----------+-----+--------+
+//	 2 bytes    1 byte      uvarint
+//
+// They are hidden from the iterations of the keys, like Ascend and Keys, and the watch events and OnCommit,
+// and the user writes of them are rejected, so the data structures can only be changed by their methods.
+func encodeStructKey(dataType byte, key, member []byte) []byte {
+	buf := make([]byte, len(structKeyPrefix)+1+binary.MaxVarintLen32+len(key)+len(member))
+	index := copy(buf, structKeyPrefix)
+	buf[index] = dataType
+	index++
+	index += binary.PutUvarint(buf[index:], uint64(len(key)))
+	index += copy(buf[index:], key)
+	index += copy(buf[index:], member)
+	return buf[:index]
+}
+
+// isStructKey reports whether the key is the key of a record storing a data structure, see encodeStructKey.
+func isStructKey(key []byte) bool {
+	return len(key) >= len(structKeyPrefix) && string(key[:len(structKeyPrefix)]) == structKeyPrefix
+}
+
+// structKeyCount returns the number of the records of the data structures in the index,
+// it must be called with the database locked.
+func (db *DB) structKeyCount() int {
+	var count int
+	db.index.AscendGreaterOrEqual([]byte(structKeyPrefix), func(key []byte, _ *wal.ChunkPosition) (bool, error) {
+		if !isStructKey(key) {
+			return false, nil
+		}
+		count++
+		return true, nil
+	})
+	return count
+}
+
+// ascendStruct calls handleFn for each live record of the data structure in the order of the members,
+// the value of the record is loaded only if withValue is true.
+// It must be called with the database locked.
+func (db *DB) ascendStruct(dataType byte, key []byte, withValue bool,
+	handleFn func(member []byte, record *LogRecord) (bool, error)) error {
+	return db.ascendStructFrom(dataType, key, nil, withValue, handleFn)
+}
+
+// ascendStructFrom is like ascendStruct, but the members less than start are skipped.
+func (db *DB) ascendStructFrom(dataType byte, key, start []byte, withValue bool,
+	handleFn func(member []byte, record *LogRecord) (bool, error)) error {
+	prefix := encodeStructKey(dataType, key, nil)
+	now := db.now().UnixNano()
+	var err error
+	db.index.AscendGreaterOrEqual(encodeStructKey(dataType, key, start), func(k []byte, pos *wal.ChunkPosition) (bool, error) {
+		if !bytes.HasPrefix(k, prefix) {
+			return false, nil
+		}
+		var chunk []byte
+		if chunk, err = db.dataFiles.Read(pos); err != nil {
+			return false, err
+		}
+		header := decodeLogRecordHeader(chunk)
+		if header.recordType == LogRecordDeleted || header.isExpired(now) {
+			return true, nil
+		}
+
+		record := &LogRecord{Key: k, Type: header.recordType, BatchId: header.batchId, Expire: header.expire}
+		if withValue {
+			record = decodeLogRecord(chunk)
+			if record.Value, err = db.loadValue(record); err != nil {
+				return false, err
+			}
+			record.Type, record.codecs = LogRecordNormal, 0
+		}
+		var cont bool
+		cont, err = handleFn(k[len(prefix):], record)
+		return cont && err == nil, err
+	})
+	return err
+}
+
+// updateStruct calls fn with a batch holding the lock of the database, and commits the batch
+// if fn returns nil, so the members of the data structures are read and written atomically.
+func (db *DB) updateStruct(fn func(batch *Batch) error) error {
+	batch := db.batchPool.Get().(*Batch)
+	defer func() {
+		batch.reset()
+		db.batchPool.Put(batch)
+	}()
+	batch.init(false, false, db).withPendingWrites()
+	if db.closed {
+		_ = batch.Rollback()
+		return ErrDBClosed
+	}
+	if err := fn(batch); err != nil {
+		_ = batch.Rollback()
+		return err
+	}
+	return batch.Commit()
+}
+
+// putStructMember writes the member of the data structure in the batch,
+// the member shares the expiry time of the existing members, since the ttl applies to the whole structure.
+func (db *DB) putStructMember(batch *Batch, dataType byte, key, member, value []byte) error {
+	expire, err := db.structExpire(dataType, key)
+	if err != nil {
+		return err
+	}
+	return putStructRecord(batch, encodeStructKey(dataType, key, member), value, expire)
+}
+
+// structExpire returns the expiry time of the data structure, which is shared by all the members,
+// it returns 0 if the structure does not exist or never expires.
+func (db *DB) structExpire(dataType byte, key []byte) (int64, error) {
+	var expire int64
+	err := db.ascendStruct(dataType, key, false, func(_ []byte, record *LogRecord) (bool, error) {
+		expire = record.Expire
+		return false, nil
+	})
+	return expire, err
+}
+
+// putStructRecord writes the record of the data structure with the expiry time in the batch.
+func putStructRecord(batch *Batch, key, value []byte, expire int64) error {
+	if err := batch.put(key, value); err != nil {
+		return err
+	}
+	batch.pendingWrites[string(key)].Expire = expire
+	return nil
+}
+
+// expireStruct rewrites all the members of the data structure with the new expiry time in the batch.
+// It returns ErrKeyNotFound if the structure does not exist.
+func (db *DB) expireStruct(batch *Batch, dataType byte, key []byte, expire int64) error {
+	var found bool
+	err := db.ascendStruct(dataType, key, true, func(_ []byte, record *LogRecord) (bool, error) {
+		found = true
+		record.Expire = expire
+		batch.pendingWrites[string(record.Key)] = record
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrKeyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package rosedb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rosedblabs/wal"
+)
+
+// Subscription receives the events of the committed writes, see DB.Subscribe.
+type Subscription struct {
+	db        *DB
+	ch        chan *Event
+	mu        sync.Mutex
+	queue     []*Event      // the live events not sent yet
+	overflow  bool          // the live events are more than Options.WatchQueueSize
+	notify    chan struct{} // notify the goroutine that the live events are queued
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+// subscribedKey is the key and its version when the subscription is created.
+type subscribedKey struct {
+	key     []byte
+	version uint64
+}
+
+// Subscribe returns a subscription receiving the events of the writes committed after it is created,
+// every subscription has its own queue, unlike Watch whose events are shared by all the callers.
+//
+// If replay is true, the current state of all the keys is sent first as the put events
+// whose BatchId is 0, and then the live events, so a downstream cache can be bootstrapped
+// and kept in sync by one stream. The keys are collected with the database locked briefly,
+// and their values are read when they are sent. The key written after the subscription is created
+// is not replayed, its live events are sent instead, so no event is missed or duplicated.
+//
+// At most Options.WatchQueueSize live events are queued, including those queued during the replay,
+// if the subscriber falls behind, the queued events are sent and the channel is closed,
+// it should subscribe again with replay to resync.
+// The channel is also closed when the subscription or the database is closed, or the database is reopened.
+// It returns ErrWatchDisabled if Options.WatchQueueSize is 0.
+func (db *DB) Subscribe(replay bool) (*Subscription, error) {
+	if db.options.WatchQueueSize <= 0 {
+		return nil, ErrWatchDisabled
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed || db.isClosing() {
+		return nil, ErrDBClosed
+	}
+
+	sub := &Subscription{
+		db:      db,
+		ch:      make(chan *Event, 100),
+		notify:  make(chan struct{}, 1),
+		closeCh: make(chan struct{}),
+	}
+	var keys []*subscribedKey
+	if replay {
+		keys = make([]*subscribedKey, 0, db.index.Size())
+		db.index.Ascend(func(key []byte, _ *wal.ChunkPosition) (bool, error) {
+			if isStructKey(key) {
+				return true, nil
+			}
+			keys = append(keys, &subscribedKey{key: key, version: db.keyVersion(key)})
+			return true, nil
+		})
+	}
+	if db.subscriptions == nil {
+		db.subscriptions = make(map[*Subscription]struct{})
+	}
+	db.subscriptions[sub] = struct{}{}
+
+	closeCh := db.closeCh
+	db.goBackground(BackgroundTaskSubscribe, func() {
+		sub.run(keys, closeCh)
+	})
+	return sub, nil
+}
+
+// Events returns the channel of the events, it is closed when the subscription ends.
+func (s *Subscription) Events() <-chan *Event {
+	return s.ch
+}
+
+// Close closes the subscription, the channel will be closed.
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() {
+		close(s.closeCh)
+	})
+}
+
+// publish queues the event to all the subscriptions, it must be called with the database locked.
+func (db *DB) publish(e *Event) {
+	for sub := range db.subscriptions {
+		sub.push(e)
+	}
+}
+
+// push queues the live event, the subscription overflows if the queue is full.
+func (s *Subscription) push(e *Event) {
+	s.mu.Lock()
+	if s.overflow {
+		s.mu.Unlock()
+		return
+	}
+	if uint64(len(s.queue)) >= s.db.options.WatchQueueSize {
+		s.overflow = true
+	} else {
+		s.queue = append(s.queue, e)
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// run sends the replayed events of the keys, and then the live events,
+// until the subscription is closed or closeCh is closed.
+func (s *Subscription) run(keys []*subscribedKey, closeCh <-chan struct{}) {
+	defer close(s.ch)
+	defer func() {
+		s.db.mu.Lock()
+		delete(s.db.subscriptions, s)
+		s.db.mu.Unlock()
+	}()
+
+	for _, key := range keys {
+		e, err := s.db.replayEvent(key)
+		if err != nil {
+			return
+		}
+		if e != nil && !s.send(e, closeCh) {
+			return
+		}
+	}
+
+	for {
+		s.mu.Lock()
+		events, overflow := s.queue, s.overflow
+		s.queue = nil
+		s.mu.Unlock()
+		for _, e := range events {
+			if !s.send(e, closeCh) {
+				return
+			}
+		}
+		if overflow {
+			return
+		}
+
+		select {
+		case <-s.notify:
+		case <-s.closeCh:
+			return
+		case <-closeCh:
+			return
+		}
+	}
+}
+
+// send sends the event, it returns false if the subscription is closed or closeCh is closed.
+func (s *Subscription) send(e *Event, closeCh <-chan struct{}) bool {
+	select {
+	case s.ch <- e:
+		return true
+	case <-s.closeCh:
+		return false
+	case <-closeCh:
+		return false
+	}
+}
+
+// replayEvent returns the put event of the current value of the key,
+// it returns nil if the key is written since the subscription is created, or it has expired.
+func (db *DB) replayEvent(key *subscribedKey) (*Event, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+	if db.keyVersion(key.key) != key.version {
+		return nil, nil
+	}
+
+	chunk, err := db.dataFiles.Read(db.index.Get(key.key))
+	if err != nil {
+		return nil, err
+	}
+	record := decodeLogRecord(chunk)
+	now := db.now().UnixNano()
+	if record.Type == LogRecordDeleted || record.IsExpired(now) {
+		return nil, nil
+	}
+	e := &Event{Action: WatchActionPut, Key: key.key, TTL: -1}
+	if db.options.WatchIncludeValue {
+		if e.Value, err = db.loadValue(record); err != nil {
+			return nil, err
+		}
+	}
+	if record.Expire > 0 {
+		e.Expire = record.Expire
+		e.TTL = time.Duration(record.Expire - now)
+	}
+	return e, nil
+}
@@ -0,0 +1,163 @@
+package rosedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// receiveEvents receives n events from the subscription.
+func receiveEvents(t *testing.T, sub *Subscription, n int) []*Event {
+	var events []*Event
+	for len(events) < n {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				t.Fatalf("expected %d events, the channel is closed after %d", n, len(events))
+			}
+			events = append(events, e)
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d events, got %d", n, len(events))
+		}
+	}
+	return events
+}
+
+func TestDB_Subscribe_Replay(t *testing.T) {
+	options := DefaultOptions
+	options.WatchQueueSize = 1000
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+	}
+	assert.Nil(t, db.PutWithTTL(utils.GetTestKey(100), []byte("ttl"), time.Hour))
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+
+	sub, err := db.Subscribe(true)
+	assert.Nil(t, err)
+	defer sub.Close()
+
+	// all the keys are replayed as the put events
+	events := receiveEvents(t, sub, 100)
+	state := make(map[string][]byte)
+	for _, e := range events {
+		assert.Equal(t, WatchActionPut, e.Action)
+		assert.Equal(t, uint64(0), e.BatchId)
+		_, ok := state[string(e.Key)]
+		assert.False(t, ok)
+		state[string(e.Key)] = e.Value
+	}
+	assert.Equal(t, 100, len(state))
+	assert.Equal(t, []byte("ttl"), state[string(utils.GetTestKey(100))])
+	assert.Equal(t, utils.GetTestKey(100), events[len(events)-1].Key)
+	assert.NotEqual(t, int64(0), events[len(events)-1].Expire)
+	assert.Greater(t, events[len(events)-1].TTL, time.Duration(0))
+
+	// then the live events
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("live")))
+	assert.Nil(t, db.Delete(utils.GetTestKey(2)))
+	events = receiveEvents(t, sub, 2)
+	assert.Equal(t, WatchActionPut, events[0].Action)
+	assert.Equal(t, []byte("live"), events[0].Value)
+	assert.NotEqual(t, uint64(0), events[0].BatchId)
+	assert.Equal(t, WatchActionDelete, events[1].Action)
+	assert.Equal(t, utils.GetTestKey(2), events[1].Key)
+}
+
+func TestDB_Subscribe_Replay_Concurrent(t *testing.T) {
+	options := DefaultOptions
+	options.WatchQueueSize = 10000
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+	}
+	sub, err := db.Subscribe(true)
+	assert.Nil(t, err)
+	defer sub.Close()
+
+	// write the keys during the replay
+	for i := 0; i < 1000; i += 3 {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+		assert.Nil(t, db.Delete(utils.GetTestKey(i+1)))
+	}
+	assert.Nil(t, db.Put([]byte("end"), []byte("end")))
+
+	// the state built from the events is the same as the database
+	state := make(map[string][]byte)
+	for {
+		e := receiveEvents(t, sub, 1)[0]
+		if e.Action == WatchActionDelete {
+			delete(state, string(e.Key))
+		} else {
+			state[string(e.Key)] = e.Value
+		}
+		if string(e.Key) == "end" {
+			break
+		}
+	}
+	var count int
+	db.Ascend(func(k []byte, v []byte) (bool, error) {
+		count++
+		assert.Equal(t, v, state[string(k)])
+		return true, nil
+	})
+	assert.Equal(t, count, len(state))
+}
+
+func TestDB_Subscribe_Close(t *testing.T) {
+	options := DefaultOptions
+	options.WatchQueueSize = 10
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// the subscription without replay only receives the live events
+	assert.Nil(t, db.Put([]byte("key-1"), []byte("value")))
+	sub, err := db.Subscribe(false)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("key-2"), []byte("value")))
+	events := receiveEvents(t, sub, 1)
+	assert.Equal(t, []byte("key-2"), events[0].Key)
+
+	sub.Close()
+	_, ok := <-sub.Events()
+	assert.False(t, ok)
+
+	// the subscription overflows if the events are not received
+	sub, err = db.Subscribe(false)
+	assert.Nil(t, err)
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("value")))
+	}
+	var received int
+	for range sub.Events() {
+		received++
+	}
+	assert.Less(t, received, 200)
+
+	// the subscription is closed when the database is closed
+	sub, err = db.Subscribe(true)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+	for range sub.Events() {
+	}
+	_, err = db.Subscribe(false)
+	assert.Equal(t, ErrDBClosed, err)
+}
+
+func TestDB_Subscribe_Disabled(t *testing.T) {
+	db, err := Open(DefaultOptions)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	_, err = db.Subscribe(true)
+	assert.Equal(t, ErrWatchDisabled, err)
+}
@@ -0,0 +1,161 @@
+package rosedb
+
+import "time"
+
+// Tx is an optimistic transaction created by DB.Transact.
+// The writes are buffered in the transaction, and the reads are not blocked by other writers,
+// the transaction will be committed only if none of the keys read are modified by others.
+//
+// A Tx must only be used in the function passed to DB.Transact.
+type Tx struct {
+	db     *DB
+	reads  map[string]uint64 // key -> the version when it is read first
+	writes map[string]*LogRecord
+}
+
+// Transact runs fn in a transaction, and commits the writes of the transaction atomically
+// if fn returns nil and none of the keys read by the transaction have been modified
+// by other writers since they were read, otherwise ErrConflict will be returned.
+// The caller can run the transaction again when ErrConflict is returned.
+//
+// If fn returns an error, the transaction is discarded and the error is returned.
+// The writes are committed with DefaultBatchOptions, so each transaction syncs the data files,
+// see TransactWithOptions to commit them without syncing.
+func (db *DB) Transact(fn func(tx *Tx) error) error {
+	return db.TransactWithOptions(DefaultBatchOptions, fn)
+}
+
+// TransactWithOptions is like Transact, but the writes are committed with the batch options,
+// such as Sync. ReadOnly is ignored, and OnBeforeCommit is called after the transaction is validated.
+func (db *DB) TransactWithOptions(options BatchOptions, fn func(tx *Tx) error) error {
+	tx := &Tx{
+		db:     db,
+		reads:  make(map[string]uint64),
+		writes: make(map[string]*LogRecord),
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if len(tx.writes) == 0 {
+		return nil
+	}
+
+	options.ReadOnly = false
+	// the versions are checked with the database locked, before writing the data
+	onBeforeCommit := options.OnBeforeCommit
+	options.OnBeforeCommit = func() error {
+		if err := tx.validate(); err != nil {
+			return err
+		}
+		if onBeforeCommit != nil {
+			return onBeforeCommit()
+		}
+		return nil
+	}
+	batch := db.NewBatch(options)
+	for key, record := range tx.writes {
+		batch.pendingWrites[key] = record
+	}
+	return batch.Commit()
+}
+
+// validate returns ErrConflict if any key read by the transaction has been modified.
+func (tx *Tx) validate() error {
+	for key, version := range tx.reads {
+		current, err := tx.db.readVersion([]byte(key))
+		if err != nil {
+			return err
+		}
+		if current != version {
+			return ErrConflict
+		}
+	}
+	return nil
+}
+
+// Get returns the value of the key, the value written by the transaction will be returned first.
+func (tx *Tx) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	if record, ok := tx.writes[string(key)]; ok {
+		if record.Type == LogRecordDeleted || record.IsExpired(tx.db.now().UnixNano()) {
+			return nil, ErrKeyNotFound
+		}
+		return record.Value, nil
+	}
+
+	value, version, err := tx.db.GetWithVersion(key)
+	if err != nil && err != ErrKeyNotFound {
+		return nil, err
+	}
+	// only the version of the first read is kept, so the modification between reads is detected too
+	if _, ok := tx.reads[string(key)]; !ok {
+		tx.reads[string(key)] = version
+	}
+	return value, err
+}
+
+// Put adds a key-value pair to the transaction for writing.
+func (tx *Tx) Put(key []byte, value []byte) error {
+	return tx.PutWithTTL(key, value, 0)
+}
+
+// PutWithTTL adds a key-value pair with ttl to the transaction for writing.
+// If ttl is 0, the value is written with Options.DefaultTTL, and if ttl is NoTTL, it will never expire.
+func (tx *Tx) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if isStructKey(key) {
+		return ErrReservedKey
+	}
+	if tx.db.keyTooLarge(key) {
+		return ErrKeyTooLarge
+	}
+	if tx.db.options.MaxValueSize > 0 && int64(len(value)) > tx.db.options.MaxValueSize {
+		return ErrValueTooLarge
+	}
+	record := &LogRecord{Key: key, Value: value, Type: LogRecordNormal}
+	if ttl > 0 {
+		record.Expire = tx.db.now().Add(ttl).UnixNano()
+	} else if ttl != NoTTL {
+		record.Expire = tx.db.defaultExpire()
+	}
+	tx.writes[string(key)] = record
+	return nil
+}
+
+// Delete marks a key to be deleted in the transaction.
+// Nothing is written if the key does not exist, like Batch.Delete,
+// so the existence of the key is validated when committing, as it is read by Get.
+func (tx *Tx) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if isStructKey(key) {
+		return ErrReservedKey
+	}
+	version, ok := tx.reads[string(key)]
+	if !ok {
+		tx.db.mu.RLock()
+		if tx.db.closed {
+			tx.db.mu.RUnlock()
+			return ErrDBClosed
+		}
+		var err error
+		version, err = tx.db.readVersion(key)
+		tx.db.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		tx.reads[string(key)] = version
+	}
+	// the version is 0 if the key does not exist when it is read first
+	if version == 0 {
+		delete(tx.writes, string(key))
+		return nil
+	}
+	tx.writes[string(key)] = &LogRecord{Key: key, Type: LogRecordDeleted}
+	return nil
+}
@@ -0,0 +1,183 @@
+package rosedb
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Transact(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("100")))
+	assert.Nil(t, db.Put([]byte("b"), []byte("0")))
+
+	transfer := func(tx *Tx) error {
+		a, err := tx.Get([]byte("a"))
+		if err != nil {
+			return err
+		}
+		b, err := tx.Get([]byte("b"))
+		if err != nil {
+			return err
+		}
+		na, _ := strconv.Atoi(string(a))
+		nb, _ := strconv.Atoi(string(b))
+		if err = tx.Put([]byte("a"), []byte(strconv.Itoa(na-10))); err != nil {
+			return err
+		}
+		return tx.Put([]byte("b"), []byte(strconv.Itoa(nb+10)))
+	}
+	assert.Nil(t, db.Transact(transfer))
+
+	val, err := db.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("90"), val)
+	val, err = db.Get([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("10"), val)
+
+	// the writes of the transaction are visible to itself
+	err = db.Transact(func(tx *Tx) error {
+		assert.Nil(t, tx.Put(utils.GetTestKey(1), []byte("v1")))
+		val, err := tx.Get(utils.GetTestKey(1))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v1"), val)
+		assert.Nil(t, tx.Delete(utils.GetTestKey(1)))
+		_, err = tx.Get(utils.GetTestKey(1))
+		assert.Equal(t, ErrKeyNotFound, err)
+		return nil
+	})
+	assert.Nil(t, err)
+	assertKeyExistOrNot(t, db, utils.GetTestKey(1), false)
+
+	// the transaction is discarded if fn returns an error
+	errAbort := errors.New("abort")
+	err = db.Transact(func(tx *Tx) error {
+		assert.Nil(t, tx.Put(utils.GetTestKey(2), []byte("v2")))
+		return errAbort
+	})
+	assert.Equal(t, errAbort, err)
+	assertKeyExistOrNot(t, db, utils.GetTestKey(2), false)
+}
+
+func TestDB_Transact_Conflict(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("1")))
+
+	// the key read is modified by another writer
+	err = db.Transact(func(tx *Tx) error {
+		_, err := tx.Get([]byte("a"))
+		assert.Nil(t, err)
+		assert.Nil(t, db.Put([]byte("a"), []byte("2")))
+		return tx.Put([]byte("b"), []byte("1"))
+	})
+	assert.Equal(t, ErrConflict, err)
+	assertKeyExistOrNot(t, db, []byte("b"), false)
+
+	// the key read does not exist, and is created by another writer
+	err = db.Transact(func(tx *Tx) error {
+		_, err := tx.Get([]byte("c"))
+		assert.Equal(t, ErrKeyNotFound, err)
+		assert.Nil(t, db.Put([]byte("c"), []byte("1")))
+		return tx.Put([]byte("b"), []byte("1"))
+	})
+	assert.Equal(t, ErrConflict, err)
+	assertKeyExistOrNot(t, db, []byte("b"), false)
+
+	// the expired key read is not regarded as modified
+	assert.Nil(t, db.Close())
+	clock := newFakeClock()
+	options.Clock = clock
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assert.Nil(t, db.PutWithTTL([]byte("ttl"), []byte("1"), time.Second))
+	clock.Advance(time.Second)
+	err = db.Transact(func(tx *Tx) error {
+		_, err := tx.Get([]byte("ttl"))
+		assert.Equal(t, ErrKeyNotFound, err)
+		return tx.Put([]byte("b"), []byte("1"))
+	})
+	assert.Nil(t, err)
+	assertKeyExistOrNot(t, db, []byte("b"), true)
+
+	// concurrent increments, retry on conflict
+	assert.Nil(t, db.Put([]byte("counter"), []byte("0")))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				err := db.Transact(func(tx *Tx) error {
+					val, err := tx.Get([]byte("counter"))
+					if err != nil {
+						return err
+					}
+					n, _ := strconv.Atoi(string(val))
+					return tx.Put([]byte("counter"), []byte(strconv.Itoa(n+1)))
+				})
+				if err != ErrConflict {
+					assert.Nil(t, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	val, err := db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("10"), val)
+}
+
+func TestDB_TransactWithOptions_Delete(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	var commits int
+	batchOptions := DefaultBatchOptions
+	batchOptions.Sync = false
+	batchOptions.OnBeforeCommit = func() error {
+		commits++
+		return nil
+	}
+
+	// nothing is written when deleting the absent key
+	err = db.TransactWithOptions(batchOptions, func(tx *Tx) error {
+		return tx.Delete([]byte("missing"))
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, commits)
+
+	assert.Nil(t, db.Put([]byte("key"), []byte("value")))
+	err = db.TransactWithOptions(batchOptions, func(tx *Tx) error {
+		return tx.Delete([]byte("key"))
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, commits)
+	assertKeyExistOrNot(t, db, []byte("key"), false)
+
+	// the key written by others after it is deleted as absent conflicts
+	err = db.TransactWithOptions(batchOptions, func(tx *Tx) error {
+		assert.Nil(t, tx.Delete([]byte("key")))
+		assert.Nil(t, db.Put([]byte("key"), []byte("other")))
+		return tx.Put([]byte("another"), []byte("value"))
+	})
+	assert.Equal(t, ErrConflict, err)
+	assert.Equal(t, 1, commits)
+	assertKeyExistOrNot(t, db, []byte("another"), false)
+}
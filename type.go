@@ -0,0 +1,66 @@
+package rosedb
+
+// The types of the values stored at a key returned by DB.Type.
+const (
+	TypeString = "string" // the plain value written by Put
+	TypeHash   = "hash"   // the hash written by HSet
+	TypeSet    = "set"    // the set written by SAdd
+	TypeZSet   = "zset"   // the sorted set written by ZAdd
+	TypeFamily = "family" // the column family whose name is the key, see ColumnFamily
+)
+
+// typeDataTypes is the data types of the records checked by DB.Type for each type except TypeString,
+// the records of the ttl of the hashes and of the scores of the sorted sets are not checked,
+// since they exist only with the records of the fields and the members.
+var typeDataTypes = []struct {
+	name     string
+	dataType byte
+}{
+	{TypeHash, hashDataType},
+	{TypeSet, setDataType},
+	{TypeZSet, zsetDataType},
+	{TypeFamily, familyDataType},
+}
+
+// Type returns the type of the value stored at key, see the Type constants,
+// the type is known from the reserved prefix of the records, so nothing is tagged when writing.
+// The plain values and the data structures are in separate key spaces, so a key may hold several of them,
+// then the first one is returned in the order of TypeString, TypeHash, TypeSet, TypeZSet and TypeFamily.
+// It returns ErrKeyNotFound if the key holds nothing, or all of them are deleted or expired.
+func (db *DB) Type(key []byte) (string, error) {
+	if len(key) == 0 {
+		return "", ErrKeyIsEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return "", ErrDBClosed
+	}
+
+	// the records of the data structures are not the plain values
+	if position := db.index.Get(key); position != nil && !isStructKey(key) {
+		chunk, err := db.dataFiles.Read(position)
+		if err != nil {
+			return "", err
+		}
+		header := decodeLogRecordHeader(chunk)
+		if header.recordType != LogRecordDeleted && !header.isExpired(db.now().UnixNano()) {
+			return TypeString, nil
+		}
+	}
+
+	for _, t := range typeDataTypes {
+		var found bool
+		err := db.ascendStruct(t.dataType, key, false, func([]byte, *LogRecord) (bool, error) {
+			found = true
+			return false, nil
+		})
+		if err != nil {
+			return "", err
+		}
+		if found {
+			return t.name, nil
+		}
+	}
+	return "", ErrKeyNotFound
+}
@@ -0,0 +1,63 @@
+package rosedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Type(t *testing.T) {
+	clock := newFakeClock()
+	options := DefaultOptions
+	options.Clock = clock
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Nil(t, db.Put([]byte("string"), []byte("value")))
+	assert.Nil(t, db.HSet([]byte("hash"), []byte("field"), []byte("value")))
+	assert.Nil(t, db.SAdd([]byte("set"), []byte("member")))
+	assert.Nil(t, db.ZAdd([]byte("zset"), 1, []byte("member")))
+	cf, err := db.ColumnFamily("family")
+	assert.Nil(t, err)
+	assert.Nil(t, cf.Put([]byte("key"), []byte("value")))
+
+	for key, typ := range map[string]string{
+		"string": TypeString,
+		"hash":   TypeHash,
+		"set":    TypeSet,
+		"zset":   TypeZSet,
+		"family": TypeFamily,
+	} {
+		got, err := db.Type([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, typ, got, key)
+	}
+
+	// the plain value is returned before the structures of the same key
+	assert.Nil(t, db.Put([]byte("hash"), []byte("value")))
+	typ, err := db.Type([]byte("hash"))
+	assert.Nil(t, err)
+	assert.Equal(t, TypeString, typ)
+	assert.Nil(t, db.Delete([]byte("hash")))
+	typ, err = db.Type([]byte("hash"))
+	assert.Nil(t, err)
+	assert.Equal(t, TypeHash, typ)
+
+	// the deleted and expired ones
+	assert.Nil(t, db.Delete([]byte("string")))
+	assert.Nil(t, db.SRem([]byte("set"), []byte("member")))
+	assert.Nil(t, db.ZExpire([]byte("zset"), time.Second))
+	clock.Advance(time.Second)
+	for _, key := range []string{"string", "set", "zset", "missing"} {
+		_, err = db.Type([]byte(key))
+		assert.Equal(t, ErrKeyNotFound, err, key)
+	}
+	_, err = db.Type(nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	assert.Nil(t, db.Close())
+	_, err = db.Type([]byte("hash"))
+	assert.Equal(t, ErrDBClosed, err)
+}
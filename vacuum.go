@@ -0,0 +1,260 @@
+package rosedb
+
+import (
+	"io"
+	"math"
+	"os"
+	"sync/atomic"
+
+	"github.com/rosedblabs/wal"
+)
+
+const (
+	vacuumDirSuffixName = "-vacuum"
+	// vacuumRatio is the minimum ratio of the deleted and expired records in a data file to be vacuumed,
+	// the data files with less of them are left to Merge.
+	vacuumRatio = 0.5
+)
+
+// Vacuum reclaims the space of the deleted and expired records in the data files,
+// which is lighter than Merge for the common case of the TTL churn.
+//
+// Merge rewrites all the live records of the older data files to the new ones.
+// Vacuum reads the older data files one by one, and only rewrites the ones in which the deleted
+// and expired records take at least half of the space, the mostly live data files are left untouched.
+// Each of them is rewritten in place with the same segment id, the deleted and overwritten records
+// are dropped, and the expired records are kept without their values, since they still hide the older
+// records of the keys in the other data files, so are the delete records and the batch finished records.
+// The merged data files loaded from the hint file are not vacuumed, and the value log is not touched.
+//
+// It can not run with Merge at the same time, ErrMergeRunning will be returned.
+// Like Merge with reopenAfterDone, the positions of the records in the vacuumed data files are changed,
+// so the open iterators and replication streams should be restarted after it returns.
+func (db *DB) Vacuum() error {
+	db.mu.Lock()
+	if db.closed || db.isClosing() {
+		db.mu.Unlock()
+		return ErrDBClosed
+	}
+	if atomic.LoadUint32(&db.mergeRunning) == 1 {
+		db.mu.Unlock()
+		return ErrMergeRunning
+	}
+	atomic.StoreUint32(&db.mergeRunning, 1)
+	defer atomic.StoreUint32(&db.mergeRunning, 0)
+	activeSegId := db.dataFiles.ActiveSegmentID()
+	closeCh := db.closeCh
+	db.mu.Unlock()
+
+	mergeFinSegmentId, err := getMergeFinSegmentId(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	segIds, err := segmentFileIds(db.options.DirPath, dataFileNameSuffix)
+	if err != nil {
+		return err
+	}
+	for _, segId := range segIds {
+		// the merged data files are loaded from the hint file, and the active one is being written
+		if segId <= mergeFinSegmentId || segId >= activeSegId {
+			continue
+		}
+		vacuumable, err := db.vacuumable(segId)
+		if err != nil {
+			return err
+		}
+		if !vacuumable {
+			continue
+		}
+		if err = db.vacuumSegment(segId, closeCh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vacuumable reports whether the deleted and expired records take at least vacuumRatio of the data file,
+// the overwritten records are left to Merge, since they are usually spread over the data files.
+func (db *DB) vacuumable(segId wal.SegmentID) (bool, error) {
+	var total, reclaimable int64
+	now := db.now().UnixNano()
+	err := db.readSegment(segId, func(chunk []byte, position *wal.ChunkPosition) error {
+		total += int64(position.ChunkSize)
+		header := decodeLogRecordHeader(chunk)
+		if header.recordType != LogRecordNormal && header.recordType != LogRecordValuePointer {
+			return nil
+		}
+		if header.isExpired(now) {
+			reclaimable += header.valueSize
+			return nil
+		}
+		db.mu.RLock()
+		indexPos := db.index.Get(header.key(chunk))
+		db.mu.RUnlock()
+		if indexPos == nil {
+			reclaimable += int64(position.ChunkSize)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return total > 0 && float64(reclaimable) >= float64(total)*vacuumRatio, nil
+}
+
+// vacuumSegment rewrites the data file without the deleted and overwritten records and the values
+// of the expired records, then replaces the data file, and updates the positions of the live records in the index.
+// It stops when closeCh captured by Vacuum is closed.
+func (db *DB) vacuumSegment(segId wal.SegmentID, closeCh <-chan struct{}) error {
+	vacuumPath := db.options.DirPath + vacuumDirSuffixName
+	if err := os.RemoveAll(vacuumPath); err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(vacuumPath)
+	}()
+	vacuumFiles, err := wal.Open(wal.Options{
+		DirPath: vacuumPath,
+		// the data file is rewritten to a single file, which is not larger than the original one.
+		SegmentSize:    math.MaxInt64,
+		SegmentFileExt: dataFileNameSuffix,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = vacuumFiles.Close()
+	}()
+
+	// the keys of the rewritten records with their original and new positions,
+	// the index is updated if it still references the original ones when the data file is replaced.
+	var keys [][]byte
+	var positions, newPositions []*wal.ChunkPosition
+	now := db.now().UnixNano()
+	err = db.readSegment(segId, func(chunk []byte, position *wal.ChunkPosition) error {
+		if closing(closeCh) {
+			return ErrDBClosed
+		}
+		record := decodeLogRecord(chunk)
+		if record.IsValue() {
+			if record.IsExpired(now) {
+				// the expired record removes the key when the index is rebuilt, see indexLogRecord
+				record.Value, record.Type, record.codecs = nil, LogRecordNormal, 0
+				chunk = encodeLogRecord(record)
+			} else {
+				db.mu.RLock()
+				indexPos := db.index.Get(record.Key)
+				db.mu.RUnlock()
+				// the record is deleted or overwritten
+				if indexPos == nil || !positionEquals(indexPos, position) {
+					return nil
+				}
+			}
+		}
+		newPosition, err := vacuumFiles.Write(chunk)
+		if err != nil {
+			return err
+		}
+		if record.IsValue() {
+			newPosition.SegmentId = segId
+			keys = append(keys, record.Key)
+			positions = append(positions, position)
+			newPositions = append(newPositions, newPosition)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = vacuumFiles.Sync(); err != nil {
+		return err
+	}
+	if err = vacuumFiles.Close(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return ErrDBClosed
+	}
+	// the data file may be replaced by the merged one loaded after Vacuum started
+	mergeFinSegmentId, err := getMergeFinSegmentId(db.options.DirPath)
+	if err != nil || segId <= mergeFinSegmentId {
+		return err
+	}
+	// the records written or deleted after they are rewritten are not updated.
+	var updates []int
+	for i, key := range keys {
+		if indexPos := db.index.Get(key); indexPos != nil && positionEquals(indexPos, positions[i]) {
+			updates = append(updates, i)
+		}
+	}
+
+	// the index snapshot is stale after the data file is replaced
+	if err = removeIndexSnapshot(db.options.DirPath); err != nil {
+		return err
+	}
+	fileName := wal.SegmentFileName(db.options.DirPath, dataFileNameSuffix, segId)
+	size, err := fileSize(fileName)
+	if err != nil {
+		return err
+	}
+	if err = db.dataFiles.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(wal.SegmentFileName(vacuumPath, dataFileNameSuffix, 1), fileName); err != nil {
+		return err
+	}
+	if err = syncDir(db.options.DirPath); err != nil {
+		return err
+	}
+	if db.dataFiles, err = db.openWalFiles(); err != nil {
+		return err
+	}
+
+	// the index with Options.KeyHasher reads the keys of the other records in the chains of the hashes,
+	// which may be in the replaced data file, so it is rebuilt like Merge with reopenAfterDone.
+	if db.options.KeyHasher != nil {
+		db.index = db.newIndex()
+		if err = db.loadIndex(); err != nil {
+			return err
+		}
+		return db.loadGarbage()
+	}
+	for _, i := range updates {
+		db.index.Put(keys[i], newPositions[i])
+	}
+	newSize, err := fileSize(fileName)
+	if err != nil {
+		return err
+	}
+	db.dataBytes -= size - newSize
+	db.garbageBytes -= size - newSize
+	if db.garbageBytes < 0 {
+		db.garbageBytes = 0
+	}
+	return nil
+}
+
+// readSegment calls fn with each chunk in the data file and its position.
+func (db *DB) readSegment(segId wal.SegmentID, fn func(chunk []byte, position *wal.ChunkPosition) error) error {
+	db.mu.RLock()
+	reader := db.dataFiles.NewReaderWithMax(segId)
+	db.mu.RUnlock()
+	for reader.CurrentSegmentId() < segId {
+		reader.SkipCurrentSegment()
+	}
+	for {
+		chunk, position, err := reader.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err = fn(chunk, position); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package rosedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/rosedblabs/wal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Vacuum(t *testing.T) {
+	for _, hashed := range []bool{false, true} {
+		clock := newFakeClock()
+		options := DefaultOptions
+		options.SegmentSize = 64 * KB
+		options.Clock = clock
+		if hashed {
+			// the keys with the same last byte collide
+			options.KeyHasher = func(key []byte) []byte {
+				return key[len(key)-1:]
+			}
+		}
+		db, err := Open(options)
+		assert.Nil(t, err)
+
+		// the live keys are in the older data files, and the deleted keys and the expired keys
+		// are in the newer ones, some expired keys have the older values in the live data files.
+		for i := 200; i < 210; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+		}
+		for i := 400; i < 800; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+		}
+		for i := 0; i < 200; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+		}
+		for i := 200; i < 400; i++ {
+			assert.Nil(t, db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(KB), time.Minute))
+		}
+		for i := 0; i < 200; i++ {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		}
+		clock.Advance(time.Hour)
+
+		sizes := func() map[wal.SegmentID]int64 {
+			segIds, err := segmentFileIds(options.DirPath, dataFileNameSuffix)
+			assert.Nil(t, err)
+			sizes := make(map[wal.SegmentID]int64)
+			for _, segId := range segIds {
+				sizes[segId], err = fileSize(wal.SegmentFileName(options.DirPath, dataFileNameSuffix, segId))
+				assert.Nil(t, err)
+			}
+			return sizes
+		}
+		before := sizes()
+		garbageBefore, _, err := db.MergeEstimate()
+		assert.Nil(t, err)
+		assert.Nil(t, db.Vacuum())
+		after := sizes()
+		// the data files of the deleted and expired keys are vacuumed, the live ones are untouched
+		var vacuumed, untouched int
+		for segId, size := range before {
+			if after[segId] < size/2 {
+				vacuumed++
+			} else if after[segId] == size {
+				untouched++
+			}
+		}
+		assert.True(t, vacuumed >= 4)
+		assert.True(t, untouched >= 4)
+		garbageAfter, _, err := db.MergeEstimate()
+		assert.Nil(t, err)
+		assert.True(t, garbageAfter < garbageBefore)
+
+		assertData := func(db *DB) {
+			for i := 0; i < 800; i++ {
+				val, err := db.Get(utils.GetTestKey(i))
+				if i < 400 {
+					assert.Equal(t, ErrKeyNotFound, err)
+				} else {
+					assert.Nil(t, err)
+					assert.True(t, len(val) >= KB)
+				}
+			}
+		}
+		assertData(db)
+		// write after vacuum
+		assert.Nil(t, db.Put(utils.GetTestKey(1000), []byte("after")))
+
+		_ = db.Close()
+		db, err = Open(options)
+		assert.Nil(t, err)
+		assertData(db)
+		val, err := db.Get(utils.GetTestKey(1000))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("after"), val)
+		destroyDB(db)
+	}
+}
@@ -0,0 +1,167 @@
+package rosedb
+
+import "time"
+
+// keyVersions tracks the version of keys in memory, the version of a key increases on each write.
+// It must be accessed with the database locked.
+//
+// Only the keys written since the database is opened are tracked,
+// the other keys share the base version, which is the time when the database is opened,
+// so the versions still increase after the database is reopened.
+type keyVersions struct {
+	base     uint64
+	seq      uint64
+	versions map[string]uint64
+	// the sequence of the last write of the keys, including the deleted keys,
+	// they are only kept while there are running concurrent batches, see BatchOptions.Concurrent.
+	written    map[string]uint64
+	concurrent int // the number of the running concurrent batches
+}
+
+func newKeyVersions() *keyVersions {
+	base := uint64(time.Now().UnixNano())
+	return &keyVersions{
+		base:     base,
+		seq:      base,
+		versions: make(map[string]uint64),
+	}
+}
+
+// update increases the version of the key written by the record,
+// the version of a deleted key is removed.
+func (kv *keyVersions) update(record *LogRecord, now int64) {
+	kv.seq++
+	kv.markWritten(record.Key)
+	if record.Type == LogRecordDeleted || record.IsExpired(now) {
+		delete(kv.versions, string(record.Key))
+		return
+	}
+	kv.versions[string(record.Key)] = kv.seq
+}
+
+// remove removes the version of the key deleted by the database itself, such as the eviction.
+func (kv *keyVersions) remove(key []byte) {
+	kv.seq++
+	kv.markWritten(key)
+	delete(kv.versions, string(key))
+}
+
+// markWritten records the current sequence as the last write of the key if there are running concurrent batches.
+func (kv *keyVersions) markWritten(key []byte) {
+	if kv.concurrent > 0 {
+		kv.written[string(key)] = kv.seq
+	}
+}
+
+// begin registers a running concurrent batch, and returns the current sequence,
+// the keys written after it conflict with the batch.
+// The batch must call end when it is finished, or the last writes are kept forever.
+func (kv *keyVersions) begin() uint64 {
+	if kv.concurrent == 0 {
+		kv.written = make(map[string]uint64)
+	}
+	kv.concurrent++
+	return kv.seq
+}
+
+// end unregisters a concurrent batch committed or rolled back,
+// the last writes are dropped when there are no running concurrent batches.
+func (kv *keyVersions) end() {
+	kv.concurrent--
+	if kv.concurrent == 0 {
+		kv.written = nil
+	}
+}
+
+// writtenSince reports whether the key has been written after the sequence seq returned by begin.
+func (kv *keyVersions) writtenSince(key []byte, seq uint64) bool {
+	return kv.written[string(key)] > seq
+}
+
+// keyVersion returns the current version of the key, it is 0 if the key does not exist.
+// It must be called with the database locked.
+func (db *DB) keyVersion(key []byte) uint64 {
+	if db.index.Get(key) == nil {
+		return 0
+	}
+	if version, ok := db.versions.versions[string(key)]; ok {
+		return version
+	}
+	return db.versions.base
+}
+
+// readVersion returns the version of the key as GetWithVersion does, it is 0 if the key is deleted or expired
+// even if it is still in the index, so the keys read by Tx are validated by the same versions.
+// It must be called with the database locked.
+func (db *DB) readVersion(key []byte) (uint64, error) {
+	position := db.index.Get(key)
+	if position == nil {
+		return 0, nil
+	}
+	chunk, err := db.dataFiles.Read(position)
+	if err != nil {
+		return 0, err
+	}
+	header := decodeLogRecordHeader(chunk)
+	if header.recordType == LogRecordDeleted || header.isExpired(db.now().UnixNano()) {
+		return 0, nil
+	}
+	return db.keyVersion(key), nil
+}
+
+// GetWithVersion returns the value and the version of the key.
+// The version of a key increases on each write, it can be used by PutIfVersion
+// to write the key only if it has not been modified since it was read.
+//
+// The versions are only kept in memory, all the keys not written since the database is opened
+// share the same version, which is greater than the versions before the database is opened.
+// If the key does not exist, the version is 0 and ErrKeyNotFound is returned.
+func (db *DB) GetWithVersion(key []byte) ([]byte, uint64, error) {
+	batch := db.batchPool.Get().(*Batch)
+	batch.init(true, false, db)
+	defer func() {
+		_ = batch.Commit()
+		batch.reset()
+		db.batchPool.Put(batch)
+	}()
+	value, err := batch.Get(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, db.keyVersion(key), nil
+}
+
+// PutIfVersion writes the key-value pair only if the current version of the key equals expected,
+// and returns whether the value is written.
+// If expected is 0, the value is written only if the key does not exist.
+func (db *DB) PutIfVersion(key []byte, value []byte, expected uint64) (bool, error) {
+	batch := db.batchPool.Get().(*Batch)
+	defer func() {
+		batch.reset()
+		db.batchPool.Put(batch)
+	}()
+	batch.init(false, false, db).withPendingWrites()
+
+	exist, err := batch.Exist(key)
+	if err != nil {
+		_ = batch.Rollback()
+		return false, err
+	}
+	var version uint64
+	if exist {
+		version = db.keyVersion(key)
+	}
+	if version != expected {
+		_ = batch.Rollback()
+		return false, nil
+	}
+
+	if err = batch.Put(key, value); err != nil {
+		_ = batch.Rollback()
+		return false, err
+	}
+	if err = batch.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,84 @@
+package rosedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_GetWithVersion(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	_, version, err := db.GetWithVersion(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, uint64(0), version)
+
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("v1")))
+	val, v1, err := db.GetWithVersion(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+	assert.True(t, v1 > 0)
+
+	// the version increases on each write
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("v2")))
+	_, v2, err := db.GetWithVersion(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, v2 > v1)
+
+	// the version is not changed by other keys
+	assert.Nil(t, db.Put(utils.GetTestKey(2), []byte("v1")))
+	_, v3, err := db.GetWithVersion(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, v2, v3)
+
+	// the versions still increase after reopening
+	_ = db.Close()
+	db2, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	_, v4, err := db2.GetWithVersion(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, v4 > v2)
+}
+
+func TestDB_PutIfVersion(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// 0 means the key does not exist
+	ok, err := db.PutIfVersion(utils.GetTestKey(1), []byte("v1"), 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = db.PutIfVersion(utils.GetTestKey(1), []byte("v2"), 0)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, version, err := db.GetWithVersion(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	ok, err = db.PutIfVersion(utils.GetTestKey(1), []byte("v2"), version)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	// the version is stale now
+	ok, err = db.PutIfVersion(utils.GetTestKey(1), []byte("v3"), version)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+
+	// the expired key does not exist
+	assert.Nil(t, db.PutWithTTL(utils.GetTestKey(2), []byte("v1"), time.Millisecond*100))
+	time.Sleep(time.Millisecond * 200)
+	ok, err = db.PutIfVersion(utils.GetTestKey(2), []byte("v2"), 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
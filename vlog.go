@@ -0,0 +1,288 @@
+package rosedb
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/rosedblabs/wal"
+)
+
+const (
+	valueLogFileNameSuffix = ".VLOG"
+	// valueLogGCBatchSize is the number of values checked in one round of ValueLogGC,
+	// the database will be locked during each round.
+	valueLogGCBatchSize = 1000
+)
+
+// openValueLogFiles opens the value log files, which store the values
+// separated from the data files, see Options.LargeValueThreshold.
+//
+// The value log will only be opened if value separation is enabled,
+// or there are value log files left in the database directory,
+// so the values written before can still be read.
+func (db *DB) openValueLogFiles() (*wal.WAL, error) {
+	if !db.options.SeparateValues && db.options.LargeValueThreshold <= 0 {
+		files, err := filepath.Glob(filepath.Join(db.options.DirPath, "*"+valueLogFileNameSuffix))
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, nil
+		}
+	}
+	return wal.Open(wal.Options{
+		DirPath:        db.options.DirPath,
+		SegmentSize:    db.options.SegmentSize,
+		SegmentFileExt: valueLogFileNameSuffix,
+		BlockCache:     db.options.BlockCache,
+		Sync:           db.options.Sync,
+		// the value log files are synced by the background goroutine if BytesPerSync is set.
+		BytesPerSync: 0,
+	})
+}
+
+// separateValue writes the value of the record to the value log if Options.SeparateValues is true,
+// or it is larger than Options.LargeValueThreshold, and returns a LogRecordValuePointer record
+// which should be written to the data files instead.
+// Otherwise, the record itself will be returned.
+func (db *DB) separateValue(record *LogRecord) (*LogRecord, error) {
+	if record.Type != LogRecordNormal {
+		return record, nil
+	}
+	if !db.options.SeparateValues && (db.options.LargeValueThreshold <= 0 ||
+		int64(len(record.Value)) <= db.options.LargeValueThreshold) {
+		return record, nil
+	}
+
+	pos, err := db.valueLogFiles.Write(encodeValueLogRecord(record.Key, record.Value))
+	if err != nil {
+		return nil, err
+	}
+	return &LogRecord{
+		Key:     record.Key,
+		Value:   encodeValuePointer(pos),
+		Type:    LogRecordValuePointer,
+		BatchId: record.BatchId,
+		Expire:  record.Expire,
+		codecs:  record.codecs,
+	}, nil
+}
+
+// loadValue returns the value of the record decoded by the codecs, see Options.ValueCodec.
+// If the record is a LogRecordValuePointer, the value will be read from the value log.
+//
+// The record is not modified, the caller keeping the decoded value in the record
+// should clear its codec flags, so the value will be encoded again when it is written.
+func (db *DB) loadValue(record *LogRecord) ([]byte, error) {
+	if record.Type != LogRecordValuePointer {
+		return db.decodeValue(record.Value, record.codecs)
+	}
+	if db.valueLogFiles == nil {
+		return nil, ErrValueLogNotFound
+	}
+
+	chunk, err := db.valueLogFiles.Read(decodeValuePointer(record.Value))
+	if err != nil {
+		return nil, err
+	}
+	_, value := decodeValueLogRecord(chunk)
+	return db.decodeValue(value, record.codecs)
+}
+
+// ValueLogGC reclaims the disk space of the stale values in the value log.
+//
+// It will rotate the value log first, then iterate all the older value log files,
+// the values which are still referenced by the index will be rewritten to the active value log file
+// (along with the new pointers written to the data files), and the older value log files will be deleted.
+//
+// The database is only locked while rewriting the valid values, so it can be called when the database is running.
+// It can not be called concurrently with Merge, ErrMergeRunning will be returned.
+func (db *DB) ValueLogGC() error {
+	return db.ValueLogGCContext(context.Background())
+}
+
+// ValueLogGCContext is like ValueLogGC, but it can be cancelled by ctx.
+// If ctx is done before the gc completes, ctx.Err() will be returned,
+// the values rewritten so far are kept, and the older value log files will not be deleted.
+func (db *DB) ValueLogGCContext(ctx context.Context) error {
+	db.mu.Lock()
+	// check if the database is closed or closing
+	if db.closed || db.isClosing() {
+		db.mu.Unlock()
+		return ErrDBClosed
+	}
+	closeCh := db.closeCh
+	// check if there is no value log
+	if db.valueLogFiles == nil || db.valueLogFiles.IsEmpty() {
+		db.mu.Unlock()
+		return nil
+	}
+	// the value log gc and merge can not run at the same time.
+	if atomic.LoadUint32(&db.mergeRunning) == 1 {
+		db.mu.Unlock()
+		return ErrMergeRunning
+	}
+	atomic.StoreUint32(&db.mergeRunning, 1)
+	defer atomic.StoreUint32(&db.mergeRunning, 0)
+
+	// rotate the value log, all the older value log files will be reclaimed.
+	prevActiveSegId := db.valueLogFiles.ActiveSegmentID()
+	if err := db.valueLogFiles.OpenNewActiveSegment(); err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	db.mu.Unlock()
+
+	type valueEntry struct {
+		key      []byte
+		value    []byte
+		position *wal.ChunkPosition
+	}
+	entries := make([]*valueEntry, 0, valueLogGCBatchSize)
+	reader := db.valueLogFiles.NewReaderWithMax(prevActiveSegId)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// stop the gc if the database is closing
+		if closing(closeCh) {
+			return ErrDBClosed
+		}
+		chunk, position, err := reader.Next()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err == nil {
+			key, value := decodeValueLogRecord(chunk)
+			entries = append(entries, &valueEntry{key: key, value: value, position: position})
+			if len(entries) < valueLogGCBatchSize {
+				continue
+			}
+		}
+
+		// check and rewrite the valid values
+		records := make([]*LogRecord, 0, len(entries))
+		db.mu.Lock()
+		for _, entry := range entries {
+			record, err := db.valueLogReferrer(entry.key, entry.position)
+			if err != nil {
+				db.mu.Unlock()
+				return err
+			}
+			if record != nil {
+				// the value is rewritten as it is, along with the flags of its codecs.
+				records = append(records, &LogRecord{
+					Key:    entry.key,
+					Value:  entry.value,
+					Type:   LogRecordNormal,
+					Expire: record.Expire,
+					codecs: record.codecs,
+				})
+			}
+		}
+		if err := db.rewriteRecords(records, uint64(db.node.Generate())); err != nil {
+			db.mu.Unlock()
+			return err
+		}
+		db.mu.Unlock()
+
+		if len(entries) < valueLogGCBatchSize {
+			break
+		}
+		entries = entries[:0]
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// make sure all the rewritten values and pointers are durable before deleting the older value log files.
+	if err := db.syncFiles(); err != nil {
+		return err
+	}
+	if err := db.valueLogFiles.Close(); err != nil {
+		return err
+	}
+	for fileId := uint32(1); fileId <= prevActiveSegId; fileId++ {
+		fileName := wal.SegmentFileName(db.options.DirPath, valueLogFileNameSuffix, fileId)
+		if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	var err error
+	db.valueLogFiles, err = db.openValueLogFiles()
+	return err
+}
+
+// valueLogReferrer returns the data record of the key if it is valid,
+// and its value is stored in the value log at the given position, otherwise returns nil.
+func (db *DB) valueLogReferrer(key []byte, position *wal.ChunkPosition) (*LogRecord, error) {
+	indexPos := db.index.Get(key)
+	if indexPos == nil {
+		return nil, nil
+	}
+	chunk, err := db.dataFiles.Read(indexPos)
+	if err != nil {
+		return nil, err
+	}
+	record := decodeLogRecord(chunk)
+	if record.Type != LogRecordValuePointer || record.IsExpired(db.now().UnixNano()) {
+		return nil, nil
+	}
+	if !positionEquals(decodeValuePointer(record.Value), position) {
+		return nil, nil
+	}
+	return record, nil
+}
+
+// +-------------+-------------+-------------+
+// |  key size   |     key     |    value    |
+// +-------------+-------------+-------------+
+//
+//	varint(max 5)
+func encodeValueLogRecord(key, value []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen32+len(key)+len(value))
+	idx := binary.PutUvarint(buf, uint64(len(key)))
+	idx += copy(buf[idx:], key)
+	idx += copy(buf[idx:], value)
+	return buf[:idx]
+}
+
+func decodeValueLogRecord(buf []byte) ([]byte, []byte) {
+	keySize, n := binary.Uvarint(buf)
+	key := buf[n : n+int(keySize)]
+	value := buf[n+int(keySize):]
+	return key, value
+}
+
+func encodeValuePointer(pos *wal.ChunkPosition) []byte {
+	// SegmentId BlockNumber ChunkOffset ChunkSize
+	//    5          5           10          5      =    25
+	buf := make([]byte, 25)
+	var idx = 0
+	idx += binary.PutUvarint(buf[idx:], uint64(pos.SegmentId))
+	idx += binary.PutUvarint(buf[idx:], uint64(pos.BlockNumber))
+	idx += binary.PutUvarint(buf[idx:], uint64(pos.ChunkOffset))
+	idx += binary.PutUvarint(buf[idx:], uint64(pos.ChunkSize))
+	return buf[:idx]
+}
+
+func decodeValuePointer(buf []byte) *wal.ChunkPosition {
+	var idx = 0
+	segmentId, n := binary.Uvarint(buf[idx:])
+	idx += n
+	blockNumber, n := binary.Uvarint(buf[idx:])
+	idx += n
+	chunkOffset, n := binary.Uvarint(buf[idx:])
+	idx += n
+	chunkSize, _ := binary.Uvarint(buf[idx:])
+
+	return &wal.ChunkPosition{
+		SegmentId:   wal.SegmentID(segmentId),
+		BlockNumber: uint32(blockNumber),
+		ChunkOffset: int64(chunkOffset),
+		ChunkSize:   uint32(chunkSize),
+	}
+}
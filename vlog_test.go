@@ -0,0 +1,140 @@
+package rosedb
+
+import (
+	"os"
+	"testing"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/rosedblabs/wal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_MaxValueSize(t *testing.T) {
+	options := DefaultOptions
+	options.MaxValueSize = KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	err = db.Put(utils.GetTestKey(1), make([]byte, KB))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), make([]byte, KB+1))
+	assert.Equal(t, ErrValueTooLarge, err)
+	assertKeyExistOrNot(t, db, utils.GetTestKey(2), false)
+}
+
+func TestDB_LargeValueThreshold(t *testing.T) {
+	options := DefaultOptions
+	options.LargeValueThreshold = KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	kvs := make(map[string][]byte)
+	for i := 0; i < 1000; i++ {
+		key := utils.GetTestKey(i)
+		value := utils.RandomValue(128)
+		if i%2 == 0 {
+			value = utils.RandomValue(4 * KB)
+		}
+		kvs[string(key)] = value
+		err := db.Put(key, value)
+		assert.Nil(t, err)
+	}
+	// overwrite and delete some large values, they will be garbage in the value log
+	for i := 0; i < 500; i += 2 {
+		key := utils.GetTestKey(i)
+		if i%4 == 0 {
+			delete(kvs, string(key))
+			err := db.Delete(key)
+			assert.Nil(t, err)
+		} else {
+			value := utils.RandomValue(8 * KB)
+			kvs[string(key)] = value
+			err := db.Put(key, value)
+			assert.Nil(t, err)
+		}
+	}
+
+	checkValues := func(db *DB) {
+		for key, value := range kvs {
+			v, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, v)
+		}
+		assert.Equal(t, len(kvs), db.Stat().KeysNum)
+	}
+	checkValues(db)
+
+	err = db.Merge(true)
+	assert.Nil(t, err)
+	checkValues(db)
+
+	// reopen
+	_ = db.Close()
+	db2, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	checkValues(db2)
+}
+
+func TestDB_SeparateValues_ValueLogGC(t *testing.T) {
+	options := DefaultOptions
+	options.SeparateValues = true
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	kvs := make(map[string][]byte)
+	for i := 0; i < 5000; i++ {
+		key := utils.GetTestKey(i)
+		value := utils.RandomValue(KB)
+		kvs[string(key)] = value
+		err := db.Put(key, value)
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 2500; i++ {
+		key := utils.GetTestKey(i)
+		if i%2 == 0 {
+			delete(kvs, string(key))
+			err := db.Delete(key)
+			assert.Nil(t, err)
+		} else {
+			value := utils.RandomValue(128)
+			kvs[string(key)] = value
+			err := db.Put(key, value)
+			assert.Nil(t, err)
+		}
+	}
+
+	checkValues := func(db *DB) {
+		for key, value := range kvs {
+			v, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, v)
+		}
+		assert.Equal(t, len(kvs), db.Stat().KeysNum)
+	}
+	checkValues(db)
+
+	err = db.ValueLogGC()
+	assert.Nil(t, err)
+	_, err = os.Stat(wal.SegmentFileName(options.DirPath, valueLogFileNameSuffix, 1))
+	assert.True(t, os.IsNotExist(err))
+	checkValues(db)
+
+	err = db.Merge(true)
+	assert.Nil(t, err)
+	checkValues(db)
+
+	// reopen
+	_ = db.Close()
+	db2, err := Open(options)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	checkValues(db2)
+}
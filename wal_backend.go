@@ -0,0 +1,63 @@
+package rosedb
+
+import "github.com/rosedblabs/wal"
+
+// WAL is the write-ahead log storing the records of the data files, see Options.WALBackend.
+// The chunks are written to the active segment, and the segment ids only increase.
+type WAL interface {
+	// Write writes the data as a chunk to the active segment, and returns its position.
+	// The data must not be retained after Write returns, the caller may reuse it.
+	Write(data []byte) (*wal.ChunkPosition, error)
+	// Read returns the data of the chunk at the position, it returns io.EOF if there is no chunk at the position.
+	Read(pos *wal.ChunkPosition) ([]byte, error)
+	// Sync makes the written chunks durable.
+	Sync() error
+	// Close closes the log, it is not used after closing.
+	Close() error
+	// OpenNewActiveSegment rotates the active segment, the chunks are written to a new segment after it.
+	OpenNewActiveSegment() error
+	// ActiveSegmentID returns the id of the active segment.
+	ActiveSegmentID() wal.SegmentID
+	// IsEmpty reports whether no chunk is written to the log.
+	IsEmpty() bool
+	// NewReader returns a reader of all the chunks in the order they were written.
+	NewReader() WALReader
+	// NewReaderWithMax returns a reader of the chunks in the segments whose ids are not greater than segId,
+	// it reads all the chunks if segId is 0.
+	NewReaderWithMax(segId wal.SegmentID) WALReader
+}
+
+// WALReader reads the chunks of the WAL in order, it is used to rebuild the index, merge and replicate the data files.
+type WALReader interface {
+	// Next returns the data of the next chunk and its position, it returns io.EOF after the last chunk.
+	Next() ([]byte, *wal.ChunkPosition, error)
+	// SkipCurrentSegment skips the rest of the chunks in the current segment.
+	SkipCurrentSegment()
+	// CurrentSegmentId returns the id of the segment being read.
+	CurrentSegmentId() wal.SegmentID
+	// CurrentChunkPosition returns the position of the next chunk to read in the current segment,
+	// so the reading can be resumed from it. It is not called after Next returns io.EOF.
+	CurrentChunkPosition() *wal.ChunkPosition
+}
+
+// walFiles adapts the wal of rosedblabs/wal to WAL, it is the default backend of the data files.
+type walFiles struct {
+	*wal.WAL
+}
+
+// openWAL opens the data files by the wal of rosedblabs/wal.
+func openWAL(options wal.Options) (WAL, error) {
+	w, err := wal.Open(options)
+	if err != nil {
+		return nil, err
+	}
+	return walFiles{WAL: w}, nil
+}
+
+func (w walFiles) NewReader() WALReader {
+	return w.WAL.NewReader()
+}
+
+func (w walFiles) NewReaderWithMax(segId wal.SegmentID) WALReader {
+	return w.WAL.NewReaderWithMax(segId)
+}
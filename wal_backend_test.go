@@ -0,0 +1,173 @@
+package rosedb
+
+import (
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/rosedblabs/wal"
+	"github.com/stretchr/testify/assert"
+)
+
+// memWAL is a WAL in memory, the id of a segment is its index in segments plus 1.
+type memWAL struct {
+	mu       sync.Mutex
+	segments [][][]byte
+}
+
+func newMemWAL() *memWAL {
+	return &memWAL{segments: make([][][]byte, 1)}
+}
+
+func (w *memWAL) Write(data []byte) (*wal.ChunkPosition, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	active := len(w.segments) - 1
+	pos := &wal.ChunkPosition{
+		SegmentId:   wal.SegmentID(active + 1),
+		ChunkOffset: int64(len(w.segments[active])),
+		ChunkSize:   uint32(len(data)),
+	}
+	w.segments[active] = append(w.segments[active], append([]byte(nil), data...))
+	return pos, nil
+}
+
+func (w *memWAL) Read(pos *wal.ChunkPosition) ([]byte, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if pos.SegmentId == 0 || int(pos.SegmentId) > len(w.segments) {
+		return nil, io.EOF
+	}
+	chunks := w.segments[pos.SegmentId-1]
+	if pos.ChunkOffset >= int64(len(chunks)) {
+		return nil, io.EOF
+	}
+	return append([]byte(nil), chunks[pos.ChunkOffset]...), nil
+}
+
+func (w *memWAL) Sync() error { return nil }
+
+func (w *memWAL) Close() error { return nil }
+
+func (w *memWAL) OpenNewActiveSegment() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.segments = append(w.segments, nil)
+	return nil
+}
+
+func (w *memWAL) ActiveSegmentID() wal.SegmentID {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return wal.SegmentID(len(w.segments))
+}
+
+func (w *memWAL) IsEmpty() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.segments) == 1 && len(w.segments[0]) == 0
+}
+
+func (w *memWAL) NewReader() WALReader {
+	return w.NewReaderWithMax(0)
+}
+
+func (w *memWAL) NewReaderWithMax(segId wal.SegmentID) WALReader {
+	return &memWALReader{wal: w, maxSegId: segId, segId: 1}
+}
+
+type memWALReader struct {
+	wal      *memWAL
+	maxSegId wal.SegmentID
+	segId    wal.SegmentID
+	offset   int64
+}
+
+func (r *memWALReader) Next() ([]byte, *wal.ChunkPosition, error) {
+	for {
+		if r.maxSegId > 0 && r.segId > r.maxSegId {
+			return nil, nil, io.EOF
+		}
+		pos := &wal.ChunkPosition{SegmentId: r.segId, ChunkOffset: r.offset}
+		chunk, err := r.wal.Read(pos)
+		if err == io.EOF {
+			if r.segId >= r.wal.ActiveSegmentID() {
+				return nil, nil, io.EOF
+			}
+			r.SkipCurrentSegment()
+			continue
+		}
+		pos.ChunkSize = uint32(len(chunk))
+		r.offset++
+		return chunk, pos, err
+	}
+}
+
+func (r *memWALReader) SkipCurrentSegment() {
+	r.segId++
+	r.offset = 0
+}
+
+func (r *memWALReader) CurrentSegmentId() wal.SegmentID {
+	return r.segId
+}
+
+func (r *memWALReader) CurrentChunkPosition() *wal.ChunkPosition {
+	return &wal.ChunkPosition{SegmentId: r.segId, ChunkOffset: r.offset}
+}
+
+func TestDB_WALBackend(t *testing.T) {
+	options := DefaultOptions
+	// the data files are kept in memory across the opens
+	memFiles := newMemWAL()
+	options.WALBackend = func(walOptions wal.Options) (WAL, error) {
+		assert.Equal(t, options.DirPath, walOptions.DirPath)
+		return memFiles, nil
+	}
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, memFiles.OpenNewActiveSegment())
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	batch := db.NewBatch(DefaultBatchOptions)
+	for i := 1000; i < 1100; i++ {
+		assert.Nil(t, batch.Put(utils.GetTestKey(i), []byte("batch")))
+	}
+	assert.Nil(t, batch.Commit())
+
+	assertData := func(db *DB) {
+		assert.Equal(t, 1000, db.Stat().KeysNum)
+		for i := 0; i < 1100; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			switch {
+			case i < 100:
+				assert.Equal(t, ErrKeyNotFound, err)
+			case i < 1000:
+				assert.Nil(t, err)
+				assert.True(t, len(val) >= 128)
+			default:
+				assert.Equal(t, []byte("batch"), val)
+			}
+		}
+	}
+	assertData(db)
+	// nothing is written to the data files in the directory
+	files, err := filepath.Glob(filepath.Join(options.DirPath, "*"+dataFileNameSuffix))
+	assert.Nil(t, err)
+	assert.Empty(t, files)
+
+	// the index is rebuilt from the backend
+	_ = db.Close()
+	db, err = Open(options)
+	assert.Nil(t, err)
+	assertData(db)
+	_ = db.Close()
+}
@@ -0,0 +1,92 @@
+package rosedb
+
+import (
+	"bytes"
+	"context"
+	"sort"
+
+	"github.com/rosedblabs/wal"
+)
+
+// Warmup reads the records of the keys with the given prefix, or all the keys if prefix is empty,
+// so they are loaded into the OS page cache, and the block cache if Options.BlockCache is set,
+// then the first reads of them will not hit the disk, for example, after a failover.
+// The values stored in the value log are read too.
+//
+// The records are read in the order of their positions in the data files, so the disk is read sequentially,
+// and the read lock of the database is only held when reading each record, so it does not block the writers.
+// The keys written, deleted or moved by a merge after Warmup collects the positions are skipped,
+// since their records may be removed.
+// The reading is throttled by Options.WarmupRateLimit.
+func (db *DB) Warmup(prefix []byte) error {
+	return db.WarmupContext(context.Background(), prefix)
+}
+
+// WarmupContext is like Warmup, but it stops reading and returns ctx.Err() if ctx is done.
+func (db *DB) WarmupContext(ctx context.Context, prefix []byte) error {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return ErrDBClosed
+	}
+	var records []warmupEntry
+	db.index.AscendGreaterOrEqual(prefix, func(key []byte, pos *wal.ChunkPosition) (bool, error) {
+		if !bytes.HasPrefix(key, prefix) {
+			return false, nil
+		}
+		records = append(records, warmupEntry{key: key, pos: pos})
+		return true, nil
+	})
+	db.mu.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return positionBefore(records[i].pos, records[j].pos)
+	})
+
+	limiter := newRateLimiter(db.options.WarmupRateLimit)
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		size, err := db.warmupRecord(record)
+		if err != nil {
+			return err
+		}
+		if err = limiter.wait(ctx, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// warmupEntry is the key and the position of the record collected by Warmup.
+type warmupEntry struct {
+	key []byte
+	pos *wal.ChunkPosition
+}
+
+// warmupRecord reads the record and its value in the value log, and returns the number of bytes read.
+// It reads nothing if the key is not at the position anymore, since the data file may be removed by merge.
+func (db *DB) warmupRecord(record warmupEntry) (int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return 0, ErrDBClosed
+	}
+	if pos := db.index.Get(record.key); pos == nil || !positionEquals(pos, record.pos) {
+		return 0, nil
+	}
+
+	chunk, err := db.dataFiles.Read(record.pos)
+	if err != nil {
+		return 0, err
+	}
+	if decodeLogRecordHeader(chunk).recordType != LogRecordValuePointer {
+		return len(chunk), nil
+	}
+	value, err := db.loadValue(decodeLogRecord(chunk))
+	if err != nil {
+		return 0, err
+	}
+	return len(chunk) + len(value), nil
+}
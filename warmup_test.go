@@ -0,0 +1,69 @@
+package rosedb
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Warmup(t *testing.T) {
+	options := DefaultOptions
+	options.BlockCache = 32 * KB * 10
+	options.SeparateValues = true
+	options.LargeValueThreshold = 1024
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("a:%03d", i)), utils.RandomValue(10)))
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("b:%03d", i)), utils.RandomValue(2048)))
+	}
+	assert.Nil(t, db.Warmup([]byte("a:")))
+	assert.Nil(t, db.Warmup([]byte("b:")))
+	assert.Nil(t, db.Warmup(nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, db.WarmupContext(ctx, nil))
+	// nothing to read
+	assert.Nil(t, db.WarmupContext(ctx, []byte("c:")))
+
+	// the record moved by merge after its position is collected is skipped
+	key := []byte("a:001")
+	entry := warmupEntry{key: key, pos: db.index.Get(key)}
+	assert.Nil(t, db.Merge(true))
+	size, err := db.warmupRecord(entry)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, size)
+	size, err = db.warmupRecord(warmupEntry{key: key, pos: db.index.Get(key)})
+	assert.Nil(t, err)
+	assert.True(t, size > 0)
+
+	assert.Nil(t, db.Close())
+	assert.Equal(t, ErrDBClosed, db.Warmup(nil))
+}
+
+func TestDB_Warmup_RateLimit(t *testing.T) {
+	options := DefaultOptions
+	options.WarmupRateLimit = 100 * KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(10*KB)))
+	}
+	// about 200KB is read, the first 100KB is the burst
+	start := time.Now()
+	assert.Nil(t, db.Warmup(nil))
+	assert.True(t, time.Since(start) > time.Millisecond*500)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, db.WarmupContext(ctx, nil))
+}
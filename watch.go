@@ -15,10 +15,17 @@ const (
 // Event is the event that occurs when the database is modified.
 // It is used to synchronize the watch of the database.
 type Event struct {
-	Action  WatchActionType
-	Key     []byte
+	Action WatchActionType
+	Key    []byte
+	// Value is the value of the put event, it is nil for the delete event,
+	// or if Options.WatchIncludeValue is false.
 	Value   []byte
 	BatchId uint64
+	// Expire is the expiry time of the key in unix nanoseconds, it is 0 if the key never expires.
+	Expire int64
+	// TTL is the ttl of the key when it is committed, it is -1 if the key never expires or is deleted,
+	// the same as DB.TTL.
+	TTL time.Duration
 }
 
 // Watcher temporarily stores event information,
@@ -59,15 +66,23 @@ func (w *Watcher) getEvent() *Event {
 	return w.queue.pop()
 }
 
-// sendEvent send events to DB's watch
-func (w *Watcher) sendEvent(c chan *Event) {
+// sendEvent send events to DB's watch, until closeCh is closed.
+func (w *Watcher) sendEvent(c chan *Event, closeCh <-chan struct{}) {
 	for {
 		event := w.getEvent()
 		if event == nil {
-			time.Sleep(100 * time.Millisecond)
+			select {
+			case <-closeCh:
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
 			continue
 		}
-		c <- event
+		select {
+		case <-closeCh:
+			return
+		case c <- event:
+		}
 	}
 }
 
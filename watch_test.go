@@ -3,6 +3,7 @@ package rosedb
 import (
 	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/rosedblabs/rosedb/v2/utils"
 	"github.com/stretchr/testify/assert"
@@ -145,3 +146,68 @@ func TestWatch_Batch_Put_Watch(t *testing.T) {
 		assert.Equal(t, batchId, event.BatchId)
 	}
 }
+
+func TestWatch_TTL_Watch(t *testing.T) {
+	options := DefaultOptions
+	options.WatchQueueSize = 10
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	w, err := db.Watch()
+	assert.Nil(t, err)
+
+	key := utils.GetTestKey(rand.Int())
+	value := utils.RandomValue(128)
+	assert.Nil(t, db.Put(key, value))
+	event := <-w
+	assert.Equal(t, int64(0), event.Expire)
+	assert.Equal(t, time.Duration(-1), event.TTL)
+
+	assert.Nil(t, db.PutWithTTL(key, value, time.Hour))
+	event = <-w
+	assert.Equal(t, WatchActionPut, event.Action)
+	assert.True(t, event.Expire > time.Now().UnixNano())
+	assert.True(t, event.TTL > 0 && event.TTL <= time.Hour)
+
+	// the ttl changed by Expire
+	assert.Nil(t, db.Expire(key, time.Minute))
+	event = <-w
+	assert.Equal(t, WatchActionPut, event.Action)
+	assert.Equal(t, value, event.Value)
+	assert.True(t, event.TTL > 0 && event.TTL <= time.Minute)
+
+	assert.Nil(t, db.Delete(key))
+	event = <-w
+	assert.Equal(t, WatchActionDelete, event.Action)
+	assert.Equal(t, int64(0), event.Expire)
+	assert.Equal(t, time.Duration(-1), event.TTL)
+}
+
+func TestWatch_ExcludeValue_Watch(t *testing.T) {
+	options := DefaultOptions
+	options.WatchQueueSize = 10
+	options.WatchIncludeValue = false
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	w, err := db.Watch()
+	assert.Nil(t, err)
+	sub, err := db.Subscribe(false)
+	assert.Nil(t, err)
+	defer sub.Close()
+
+	key := utils.GetTestKey(rand.Int())
+	assert.Nil(t, db.Put(key, utils.RandomValue(128)))
+	assert.Nil(t, db.Delete(key))
+	for _, action := range []WatchActionType{WatchActionPut, WatchActionDelete} {
+		event := <-w
+		assert.Equal(t, action, event.Action)
+		assert.Equal(t, key, event.Key)
+		assert.Nil(t, event.Value)
+		event = <-sub.Events()
+		assert.Equal(t, action, event.Action)
+		assert.Nil(t, event.Value)
+	}
+}
@@ -0,0 +1,259 @@
+package rosedb
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// ZMember is a member of the sorted set with its score.
+type ZMember struct {
+	Member []byte
+	Score  float64
+}
+
+// ZAdd adds the member with the score to the sorted set stored at key,
+// or updates the score if the member exists, the sorted set is created if it does not exist.
+// The score can be math.Inf(1) or math.Inf(-1), and ErrInvalidScore is returned if it is NaN.
+//
+// Every member is stored in two records, see encodeStructKey, one maps the member to its score,
+// and the other is ordered by the score in the index, so the members can be retrieved by the scores
+// without sorting them, the members with the same score are ordered by their bytes.
+// The ttl applies to the whole sorted set, see ZExpire, a new member shares the ttl of the existing members.
+func (db *DB) ZAdd(key []byte, score float64, member []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if math.IsNaN(score) {
+		return ErrInvalidScore
+	}
+	// -0 and 0 are the same score
+	if score == 0 {
+		score = 0
+	}
+
+	return db.updateStruct(func(batch *Batch) error {
+		memberKey := encodeStructKey(zsetDataType, key, member)
+		record, err := batch.getRecord(memberKey)
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+		if err == nil {
+			oldScore := decodeScore(record.Value)
+			if oldScore == score {
+				return nil
+			}
+			if err = batch.delete(encodeStructKey(zsetScoreDataType, key, zsetScoreMember(oldScore, member))); err != nil {
+				return err
+			}
+		}
+
+		expire, err := db.structExpire(zsetDataType, key)
+		if err != nil {
+			return err
+		}
+		if err = putStructRecord(batch, memberKey, encodeScore(score), expire); err != nil {
+			return err
+		}
+		scoreKey := encodeStructKey(zsetScoreDataType, key, zsetScoreMember(score, member))
+		return putStructRecord(batch, scoreKey, nil, expire)
+	})
+}
+
+// ZScore returns the score of the member in the sorted set stored at key.
+// It returns ErrKeyNotFound if the sorted set or the member does not exist.
+func (db *DB) ZScore(key, member []byte) (float64, error) {
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+	value, err := db.Get(encodeStructKey(zsetDataType, key, member))
+	if err != nil {
+		return 0, err
+	}
+	return decodeScore(value), nil
+}
+
+// ZRem removes the members from the sorted set stored at key atomically,
+// the members that do not exist are ignored.
+// The sorted set is removed when all the members are removed.
+func (db *DB) ZRem(key []byte, members ...[]byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.updateStruct(func(batch *Batch) error {
+		for _, member := range members {
+			memberKey := encodeStructKey(zsetDataType, key, member)
+			record, err := batch.getRecord(memberKey)
+			if err == ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err = batch.delete(memberKey); err != nil {
+				return err
+			}
+			scoreKey := encodeStructKey(zsetScoreDataType, key, zsetScoreMember(decodeScore(record.Value), member))
+			if err = batch.delete(scoreKey); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ZCard returns the number of the members in the sorted set stored at key,
+// it returns 0 if the sorted set does not exist.
+func (db *DB) ZCard(key []byte) (int, error) {
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return 0, ErrDBClosed
+	}
+	return db.zsetCard(key)
+}
+
+// ZRange returns the members of the sorted set stored at key within the ranks [start, stop],
+// the members are ordered by the scores from low to high, and the rank starts from 0.
+// The negative rank is counted from the end, like Redis, -1 is the member with the highest score.
+// The ranks out of the sorted set are limited to it, and nil is returned if the range is empty.
+func (db *DB) ZRange(key []byte, start, stop int) ([]ZMember, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	if start < 0 || stop < 0 {
+		card, err := db.zsetCard(key)
+		if err != nil {
+			return nil, err
+		}
+		if start < 0 {
+			start += card
+		}
+		if stop < 0 {
+			stop += card
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > stop {
+		return nil, nil
+	}
+
+	var members []ZMember
+	var rank int
+	err := db.ascendStruct(zsetScoreDataType, key, false, func(member []byte, _ *LogRecord) (bool, error) {
+		if rank >= start {
+			members = append(members, ZMember{Member: member[8:], Score: decodeScore(member[:8])})
+		}
+		rank++
+		return rank <= stop, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// ZRangeByScore returns the members of the sorted set stored at key whose scores are within [min, max],
+// the members are ordered by the scores from low to high.
+// Use math.Inf(-1) and math.Inf(1) for the unbounded min and max, ErrInvalidScore is returned if any is NaN.
+func (db *DB) ZRangeByScore(key []byte, min, max float64) ([]ZMember, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	if math.IsNaN(min) || math.IsNaN(max) {
+		return nil, ErrInvalidScore
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+	if min > max {
+		return nil, nil
+	}
+
+	var members []ZMember
+	err := db.ascendStructFrom(zsetScoreDataType, key, encodeScore(min), false,
+		func(member []byte, _ *LogRecord) (bool, error) {
+			score := decodeScore(member[:8])
+			if score > max {
+				return false, nil
+			}
+			members = append(members, ZMember{Member: member[8:], Score: score})
+			return true, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// ZExpire sets the ttl of the whole sorted set stored at key, all the members are rewritten
+// with the new expiry time atomically, so it costs O(N) in the number of the members.
+// It returns ErrKeyNotFound if the sorted set does not exist.
+func (db *DB) ZExpire(key []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.updateStruct(func(batch *Batch) error {
+		expire := db.now().Add(ttl).UnixNano()
+		if err := db.expireStruct(batch, zsetDataType, key, expire); err != nil {
+			return err
+		}
+		return db.expireStruct(batch, zsetScoreDataType, key, expire)
+	})
+}
+
+// zsetCard returns the number of the members in the sorted set, it must be called with the database locked.
+func (db *DB) zsetCard(key []byte) (int, error) {
+	var count int
+	err := db.ascendStruct(zsetDataType, key, false, func([]byte, *LogRecord) (bool, error) {
+		count++
+		return true, nil
+	})
+	return count, err
+}
+
+// zsetScoreMember returns the member of the record ordering the member of the sorted set by the score.
+func zsetScoreMember(score float64, member []byte) []byte {
+	buf := make([]byte, 8+len(member))
+	copy(buf, encodeScore(score))
+	copy(buf[8:], member)
+	return buf
+}
+
+// encodeScore encodes the score to 8 bytes in big endian, whose byte order is the same as the order of the scores.
+// The sign bit of the positive scores is flipped, and all the bits of the negative scores are flipped.
+func encodeScore(score float64) []byte {
+	bits := math.Float64bits(score)
+	if bits>>63 == 0 {
+		bits |= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, bits)
+	return buf
+}
+
+// decodeScore decodes the score encoded by encodeScore.
+func decodeScore(buf []byte) float64 {
+	bits := binary.BigEndian.Uint64(buf)
+	if bits>>63 == 1 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
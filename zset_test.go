@@ -0,0 +1,165 @@
+package rosedb
+
+import (
+	"math"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/rosedblabs/rosedb/v2/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ZSet(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	board := []byte("board")
+	assert.Nil(t, db.ZAdd(board, 100, []byte("rose")))
+	assert.Nil(t, db.ZAdd(board, -5.5, []byte("jack")))
+	assert.Nil(t, db.ZAdd(board, 100, []byte("amy")))
+	assert.Nil(t, db.ZAdd(board, math.Inf(1), []byte("max")))
+	assert.Nil(t, db.ZAdd(board, 20, []byte("tom")))
+	// update the score
+	assert.Nil(t, db.ZAdd(board, 50, []byte("tom")))
+	assert.Equal(t, ErrInvalidScore, db.ZAdd(board, math.NaN(), []byte("nan")))
+
+	score, err := db.ZScore(board, []byte("tom"))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(50), score)
+	_, err = db.ZScore(board, []byte("none"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	card, err := db.ZCard(board)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, card)
+
+	all := []ZMember{
+		{Member: []byte("jack"), Score: -5.5},
+		{Member: []byte("tom"), Score: 50},
+		// the members with the same score are ordered by their bytes
+		{Member: []byte("amy"), Score: 100},
+		{Member: []byte("rose"), Score: 100},
+		{Member: []byte("max"), Score: math.Inf(1)},
+	}
+	members, err := db.ZRange(board, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, all, members)
+	members, err = db.ZRange(board, 1, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, all[1:3], members)
+	members, err = db.ZRange(board, -2, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, all[3:], members)
+	members, err = db.ZRange(board, 3, 1)
+	assert.Nil(t, err)
+	assert.Nil(t, members)
+
+	members, err = db.ZRangeByScore(board, math.Inf(-1), math.Inf(1))
+	assert.Nil(t, err)
+	assert.Equal(t, all, members)
+	members, err = db.ZRangeByScore(board, 0, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, all[1:4], members)
+	members, err = db.ZRangeByScore(board, -10, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, all[:1], members)
+	members, err = db.ZRangeByScore(board, 101, 200)
+	assert.Nil(t, err)
+	assert.Nil(t, members)
+
+	assert.Nil(t, db.ZRem(board, []byte("amy"), []byte("none")))
+	members, err = db.ZRangeByScore(board, 100, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, all[3:4], members)
+	card, err = db.ZCard(board)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, card)
+}
+
+func TestDB_ZExpire(t *testing.T) {
+	options := DefaultOptions
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	assert.Equal(t, ErrKeyNotFound, db.ZExpire([]byte("board"), time.Second))
+	assert.Nil(t, db.ZAdd([]byte("board"), 1, []byte("rose")))
+	assert.Nil(t, db.ZExpire([]byte("board"), time.Millisecond*100))
+	assert.Nil(t, db.ZAdd([]byte("board"), 2, []byte("jack")))
+
+	time.Sleep(time.Millisecond * 200)
+	card, err := db.ZCard([]byte("board"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, card)
+	members, err := db.ZRangeByScore([]byte("board"), math.Inf(-1), math.Inf(1))
+	assert.Nil(t, err)
+	assert.Nil(t, members)
+}
+
+func TestEncodeScore(t *testing.T) {
+	scores := []float64{math.Inf(1), 1e300, 3.5, 1, 0.25, 0, -0.25, -1, -3.5, -1e300, math.Inf(-1)}
+	encoded := make([]string, len(scores))
+	for i, score := range scores {
+		encoded[i] = string(encodeScore(score))
+		assert.Equal(t, score, decodeScore([]byte(encoded[i])))
+	}
+	assert.True(t, sort.SliceIsSorted(encoded, func(i, j int) bool {
+		return encoded[i] > encoded[j]
+	}))
+}
+
+func TestDB_ZSet_MaxTotalSize(t *testing.T) {
+	options := DefaultOptions
+	options.MaxTotalSize = 64 * KB
+	db, err := Open(options)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+
+	// the keys written between the changes of the structures are evicted,
+	// the records of the structures are kept together
+	board, user, tags := []byte("board"), []byte("user"), []byte("tags")
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.ZAdd(board, float64(i), utils.GetTestKey(i)))
+		assert.Nil(t, db.HSet(user, utils.GetTestKey(i), utils.RandomValue(KB)))
+		if i == 0 {
+			assert.Nil(t, db.HExpire(user, time.Hour))
+		}
+		assert.Nil(t, db.SAdd(tags, utils.GetTestKey(i)))
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(KB)))
+		if i%3 == 0 {
+			assert.Nil(t, db.ZAdd(board, float64(-i), utils.GetTestKey(i)))
+		}
+		if i%5 == 0 {
+			assert.Nil(t, db.ZRem(board, utils.GetTestKey(i)))
+		}
+	}
+	assertKeyExistOrNot(t, db, utils.GetTestKey(0), false)
+
+	members, err := db.ZRange(board, 0, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 80, len(members))
+	card, err := db.ZCard(board)
+	assert.Nil(t, err)
+	assert.Equal(t, len(members), card)
+	for _, m := range members {
+		score, err := db.ZScore(board, m.Member)
+		assert.Nil(t, err)
+		assert.Equal(t, m.Score, score)
+	}
+
+	fields, err := db.HGetAll(user)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(fields))
+	hlen, err := db.HLen(user)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, hlen)
+	ttl, err := db.HTTL(user, utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0)
+
+	scard, err := db.SCard(tags)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, scard)
+}